@@ -23,6 +23,7 @@ const (
 
 type ListNodesRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	Selector      string                 `protobuf:"bytes,1,opt,name=selector,proto3" json:"selector,omitempty"` // e.g. "region=eu,role=edge"; empty lists all nodes
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -57,6 +58,13 @@ func (*ListNodesRequest) Descriptor() ([]byte, []int) {
 	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{0}
 }
 
+func (x *ListNodesRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
 type ListNodesResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Nodes         []*NodeInfo            `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`
@@ -221,31 +229,33 @@ func (x *ExecuteCommandResponse) GetExitCode() int32 {
 	return 0
 }
 
-type NodeInfo struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
-	MachineId     string                 `protobuf:"bytes,2,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
-	Version       string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
-	Status        string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"` // "connected", "disconnected"
-	RemoteAddr    string                 `protobuf:"bytes,5,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+// ExecuteCommandStreamResponse is one message of ExecuteCommandStream: an
+// output chunk, or the result that ends the stream.
+type ExecuteCommandStreamResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*ExecuteCommandStreamResponse_Chunk
+	//	*ExecuteCommandStreamResponse_Result
+	Payload       isExecuteCommandStreamResponse_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *NodeInfo) Reset() {
-	*x = NodeInfo{}
+func (x *ExecuteCommandStreamResponse) Reset() {
+	*x = ExecuteCommandStreamResponse{}
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *NodeInfo) String() string {
+func (x *ExecuteCommandStreamResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*NodeInfo) ProtoMessage() {}
+func (*ExecuteCommandStreamResponse) ProtoMessage() {}
 
-func (x *NodeInfo) ProtoReflect() protoreflect.Message {
+func (x *ExecuteCommandStreamResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -257,75 +267,81 @@ func (x *NodeInfo) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use NodeInfo.ProtoReflect.Descriptor instead.
-func (*NodeInfo) Descriptor() ([]byte, []int) {
+// Deprecated: Use ExecuteCommandStreamResponse.ProtoReflect.Descriptor instead.
+func (*ExecuteCommandStreamResponse) Descriptor() ([]byte, []int) {
 	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *NodeInfo) GetNodeId() string {
+func (x *ExecuteCommandStreamResponse) GetPayload() isExecuteCommandStreamResponse_Payload {
 	if x != nil {
-		return x.NodeId
+		return x.Payload
 	}
-	return ""
+	return nil
 }
 
-func (x *NodeInfo) GetMachineId() string {
+func (x *ExecuteCommandStreamResponse) GetChunk() []byte {
 	if x != nil {
-		return x.MachineId
+		if x, ok := x.Payload.(*ExecuteCommandStreamResponse_Chunk); ok {
+			return x.Chunk
+		}
 	}
-	return ""
+	return nil
 }
 
-func (x *NodeInfo) GetVersion() string {
+func (x *ExecuteCommandStreamResponse) GetResult() *ExecuteCommandResponse {
 	if x != nil {
-		return x.Version
+		if x, ok := x.Payload.(*ExecuteCommandStreamResponse_Result); ok {
+			return x.Result
+		}
 	}
-	return ""
+	return nil
 }
 
-func (x *NodeInfo) GetStatus() string {
-	if x != nil {
-		return x.Status
-	}
-	return ""
+type isExecuteCommandStreamResponse_Payload interface {
+	isExecuteCommandStreamResponse_Payload()
 }
 
-func (x *NodeInfo) GetRemoteAddr() string {
-	if x != nil {
-		return x.RemoteAddr
-	}
-	return ""
+type ExecuteCommandStreamResponse_Chunk struct {
+	Chunk []byte `protobuf:"bytes,1,opt,name=chunk,proto3,oneof"`
 }
 
-type StreamPayload struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Oneof for different types of messages (commands, responses, heartbeats)
-	//
-	// Types that are valid to be assigned to Payload:
-	//
-	//	*StreamPayload_Handshake
-	//	*StreamPayload_Command
-	//	*StreamPayload_Result
-	//	*StreamPayload_Heartbeat
-	Payload       isStreamPayload_Payload `protobuf_oneof:"payload"`
+type ExecuteCommandStreamResponse_Result struct {
+	Result *ExecuteCommandResponse `protobuf:"bytes,2,opt,name=result,proto3,oneof"`
+}
+
+func (*ExecuteCommandStreamResponse_Chunk) isExecuteCommandStreamResponse_Payload() {}
+
+func (*ExecuteCommandStreamResponse_Result) isExecuteCommandStreamResponse_Payload() {}
+
+// ForwardCommandRequest carries a command to the replica holding the
+// agent's stream. The forwarding replica records the job; the owner records
+// it too if it is missing, with the original issuer.
+type ForwardCommandRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Command       *ExecuteCommandRequest `protobuf:"bytes,2,opt,name=command,proto3" json:"command,omitempty"`
+	Stream        bool                   `protobuf:"varint,3,opt,name=stream,proto3" json:"stream,omitempty"` // relay output chunks before the result
+	IssuedBy      string                 `protobuf:"bytes,4,opt,name=issued_by,json=issuedBy,proto3" json:"issued_by,omitempty"`
+	ParentJobId   string                 `protobuf:"bytes,5,opt,name=parent_job_id,json=parentJobId,proto3" json:"parent_job_id,omitempty"`
+	Unrecorded    bool                   `protobuf:"varint,6,opt,name=unrecorded,proto3" json:"unrecorded,omitempty"` // a probe kept out of the job history
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *StreamPayload) Reset() {
-	*x = StreamPayload{}
+func (x *ForwardCommandRequest) Reset() {
+	*x = ForwardCommandRequest{}
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *StreamPayload) String() string {
+func (x *ForwardCommandRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StreamPayload) ProtoMessage() {}
+func (*ForwardCommandRequest) ProtoMessage() {}
 
-func (x *StreamPayload) ProtoReflect() protoreflect.Message {
+func (x *ForwardCommandRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -337,105 +353,78 @@ func (x *StreamPayload) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StreamPayload.ProtoReflect.Descriptor instead.
-func (*StreamPayload) Descriptor() ([]byte, []int) {
+// Deprecated: Use ForwardCommandRequest.ProtoReflect.Descriptor instead.
+func (*ForwardCommandRequest) Descriptor() ([]byte, []int) {
 	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{5}
 }
 
-func (x *StreamPayload) GetPayload() isStreamPayload_Payload {
+func (x *ForwardCommandRequest) GetCommandId() string {
 	if x != nil {
-		return x.Payload
+		return x.CommandId
 	}
-	return nil
+	return ""
 }
 
-func (x *StreamPayload) GetHandshake() *Handshake {
+func (x *ForwardCommandRequest) GetCommand() *ExecuteCommandRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*StreamPayload_Handshake); ok {
-			return x.Handshake
-		}
+		return x.Command
 	}
 	return nil
 }
 
-func (x *StreamPayload) GetCommand() *Command {
+func (x *ForwardCommandRequest) GetStream() bool {
 	if x != nil {
-		if x, ok := x.Payload.(*StreamPayload_Command); ok {
-			return x.Command
-		}
+		return x.Stream
 	}
-	return nil
+	return false
 }
 
-func (x *StreamPayload) GetResult() *CommandResult {
+func (x *ForwardCommandRequest) GetIssuedBy() string {
 	if x != nil {
-		if x, ok := x.Payload.(*StreamPayload_Result); ok {
-			return x.Result
-		}
+		return x.IssuedBy
 	}
-	return nil
+	return ""
 }
 
-func (x *StreamPayload) GetHeartbeat() *Heartbeat {
+func (x *ForwardCommandRequest) GetParentJobId() string {
 	if x != nil {
-		if x, ok := x.Payload.(*StreamPayload_Heartbeat); ok {
-			return x.Heartbeat
-		}
+		return x.ParentJobId
 	}
-	return nil
-}
-
-type isStreamPayload_Payload interface {
-	isStreamPayload_Payload()
-}
-
-type StreamPayload_Handshake struct {
-	Handshake *Handshake `protobuf:"bytes,1,opt,name=handshake,proto3,oneof"`
-}
-
-type StreamPayload_Command struct {
-	Command *Command `protobuf:"bytes,2,opt,name=command,proto3,oneof"`
-}
-
-type StreamPayload_Result struct {
-	Result *CommandResult `protobuf:"bytes,3,opt,name=result,proto3,oneof"`
+	return ""
 }
 
-type StreamPayload_Heartbeat struct {
-	Heartbeat *Heartbeat `protobuf:"bytes,4,opt,name=heartbeat,proto3,oneof"`
+func (x *ForwardCommandRequest) GetUnrecorded() bool {
+	if x != nil {
+		return x.Unrecorded
+	}
+	return false
 }
 
-func (*StreamPayload_Handshake) isStreamPayload_Payload() {}
-
-func (*StreamPayload_Command) isStreamPayload_Payload() {}
-
-func (*StreamPayload_Result) isStreamPayload_Payload() {}
-
-func (*StreamPayload_Heartbeat) isStreamPayload_Payload() {}
-
-type Handshake struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
-	MachineId     string                 `protobuf:"bytes,2,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
-	Version       string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"` // potentially auth token here
+type ForwardCommandResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*ForwardCommandResponse_Chunk
+	//	*ForwardCommandResponse_Result
+	Payload       isForwardCommandResponse_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Handshake) Reset() {
-	*x = Handshake{}
+func (x *ForwardCommandResponse) Reset() {
+	*x = ForwardCommandResponse{}
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Handshake) String() string {
+func (x *ForwardCommandResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Handshake) ProtoMessage() {}
+func (*ForwardCommandResponse) ProtoMessage() {}
 
-func (x *Handshake) ProtoReflect() protoreflect.Message {
+func (x *ForwardCommandResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -447,55 +436,74 @@ func (x *Handshake) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Handshake.ProtoReflect.Descriptor instead.
-func (*Handshake) Descriptor() ([]byte, []int) {
+// Deprecated: Use ForwardCommandResponse.ProtoReflect.Descriptor instead.
+func (*ForwardCommandResponse) Descriptor() ([]byte, []int) {
 	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{6}
 }
 
-func (x *Handshake) GetNodeId() string {
+func (x *ForwardCommandResponse) GetPayload() isForwardCommandResponse_Payload {
 	if x != nil {
-		return x.NodeId
+		return x.Payload
 	}
-	return ""
+	return nil
 }
 
-func (x *Handshake) GetMachineId() string {
+func (x *ForwardCommandResponse) GetChunk() []byte {
 	if x != nil {
-		return x.MachineId
+		if x, ok := x.Payload.(*ForwardCommandResponse_Chunk); ok {
+			return x.Chunk
+		}
 	}
-	return ""
+	return nil
 }
 
-func (x *Handshake) GetVersion() string {
+func (x *ForwardCommandResponse) GetResult() *ExecuteCommandResponse {
 	if x != nil {
-		return x.Version
+		if x, ok := x.Payload.(*ForwardCommandResponse_Result); ok {
+			return x.Result
+		}
 	}
-	return ""
+	return nil
 }
 
-type Command struct {
+type isForwardCommandResponse_Payload interface {
+	isForwardCommandResponse_Payload()
+}
+
+type ForwardCommandResponse_Chunk struct {
+	Chunk []byte `protobuf:"bytes,1,opt,name=chunk,proto3,oneof"`
+}
+
+type ForwardCommandResponse_Result struct {
+	Result *ExecuteCommandResponse `protobuf:"bytes,2,opt,name=result,proto3,oneof"`
+}
+
+func (*ForwardCommandResponse_Chunk) isForwardCommandResponse_Payload() {}
+
+func (*ForwardCommandResponse_Result) isForwardCommandResponse_Payload() {}
+
+// RevokeNode revokes every certificate of a node and disconnects it.
+type RevokeNodeRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"` // e.g., "docker_ps", "docker_run"
-	Args          []string               `protobuf:"bytes,3,rep,name=args,proto3" json:"args,omitempty"`
+	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Command) Reset() {
-	*x = Command{}
+func (x *RevokeNodeRequest) Reset() {
+	*x = RevokeNodeRequest{}
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Command) String() string {
+func (x *RevokeNodeRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Command) ProtoMessage() {}
+func (*RevokeNodeRequest) ProtoMessage() {}
 
-func (x *Command) ProtoReflect() protoreflect.Message {
+func (x *RevokeNodeRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -507,56 +515,39 @@ func (x *Command) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Command.ProtoReflect.Descriptor instead.
-func (*Command) Descriptor() ([]byte, []int) {
+// Deprecated: Use RevokeNodeRequest.ProtoReflect.Descriptor instead.
+func (*RevokeNodeRequest) Descriptor() ([]byte, []int) {
 	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{7}
 }
 
-func (x *Command) GetId() string {
-	if x != nil {
-		return x.Id
-	}
-	return ""
-}
-
-func (x *Command) GetType() string {
+func (x *RevokeNodeRequest) GetNodeId() string {
 	if x != nil {
-		return x.Type
+		return x.NodeId
 	}
 	return ""
 }
 
-func (x *Command) GetArgs() []string {
-	if x != nil {
-		return x.Args
-	}
-	return nil
-}
-
-type CommandResult struct {
+type RevokeNodeResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
-	ExitCode      int32                  `protobuf:"varint,2,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`
-	Output        []byte                 `protobuf:"bytes,3,opt,name=output,proto3" json:"output,omitempty"`
-	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	Serials       []string               `protobuf:"bytes,1,rep,name=serials,proto3" json:"serials,omitempty"` // revoked certificates
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CommandResult) Reset() {
-	*x = CommandResult{}
+func (x *RevokeNodeResponse) Reset() {
+	*x = RevokeNodeResponse{}
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *CommandResult) String() string {
+func (x *RevokeNodeResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*CommandResult) ProtoMessage() {}
+func (*RevokeNodeResponse) ProtoMessage() {}
 
-func (x *CommandResult) ProtoReflect() protoreflect.Message {
+func (x *RevokeNodeResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_api_proto_v1_docklet_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -568,61 +559,87 @@ func (x *CommandResult) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use CommandResult.ProtoReflect.Descriptor instead.
-func (*CommandResult) Descriptor() ([]byte, []int) {
+// Deprecated: Use RevokeNodeResponse.ProtoReflect.Descriptor instead.
+func (*RevokeNodeResponse) Descriptor() ([]byte, []int) {
 	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{8}
 }
 
-func (x *CommandResult) GetCommandId() string {
+func (x *RevokeNodeResponse) GetSerials() []string {
 	if x != nil {
-		return x.CommandId
+		return x.Serials
 	}
-	return ""
+	return nil
 }
 
-func (x *CommandResult) GetExitCode() int32 {
-	if x != nil {
-		return x.ExitCode
-	}
-	return 0
+// RotateNodeCertificate has a connected agent generate a new key, signs a
+// certificate for it and revokes the node's previous certificates.
+type RotateNodeCertificateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CommandResult) GetOutput() []byte {
+func (x *RotateNodeCertificateRequest) Reset() {
+	*x = RotateNodeCertificateRequest{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RotateNodeCertificateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RotateNodeCertificateRequest) ProtoMessage() {}
+
+func (x *RotateNodeCertificateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[9]
 	if x != nil {
-		return x.Output
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
 	}
-	return nil
+	return mi.MessageOf(x)
 }
 
-func (x *CommandResult) GetError() string {
+// Deprecated: Use RotateNodeCertificateRequest.ProtoReflect.Descriptor instead.
+func (*RotateNodeCertificateRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RotateNodeCertificateRequest) GetNodeId() string {
 	if x != nil {
-		return x.Error
+		return x.NodeId
 	}
 	return ""
 }
 
-type Heartbeat struct {
+type RotateNodeCertificateResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Timestamp     int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Serial        string                 `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	ExpiresAt     int64                  `protobuf:"varint,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"` // unix seconds
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Heartbeat) Reset() {
-	*x = Heartbeat{}
-	mi := &file_api_proto_v1_docklet_proto_msgTypes[9]
+func (x *RotateNodeCertificateResponse) Reset() {
+	*x = RotateNodeCertificateResponse{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Heartbeat) String() string {
+func (x *RotateNodeCertificateResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Heartbeat) ProtoMessage() {}
+func (*RotateNodeCertificateResponse) ProtoMessage() {}
 
-func (x *Heartbeat) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_v1_docklet_proto_msgTypes[9]
+func (x *RotateNodeCertificateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -633,16 +650,1152 @@ func (x *Heartbeat) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
-func (*Heartbeat) Descriptor() ([]byte, []int) {
-	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{9}
+// Deprecated: Use RotateNodeCertificateResponse.ProtoReflect.Descriptor instead.
+func (*RotateNodeCertificateResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{10}
 }
 
-func (x *Heartbeat) GetTimestamp() int64 {
+func (x *RotateNodeCertificateResponse) GetSerial() string {
 	if x != nil {
-		return x.Timestamp
+		return x.Serial
 	}
-	return 0
+	return ""
+}
+
+func (x *RotateNodeCertificateResponse) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+// UpdateNodeLabelsRequest changes labels and taints of a node. Removals
+// are applied before additions.
+type UpdateNodeLabelsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	SetLabels     map[string]string      `protobuf:"bytes,2,rep,name=set_labels,json=setLabels,proto3" json:"set_labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	RemoveLabels  []string               `protobuf:"bytes,3,rep,name=remove_labels,json=removeLabels,proto3" json:"remove_labels,omitempty"` // keys
+	AddTaints     []string               `protobuf:"bytes,4,rep,name=add_taints,json=addTaints,proto3" json:"add_taints,omitempty"`          // "key" or "key=value"
+	RemoveTaints  []string               `protobuf:"bytes,5,rep,name=remove_taints,json=removeTaints,proto3" json:"remove_taints,omitempty"` // "key" removes every taint with that key
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateNodeLabelsRequest) Reset() {
+	*x = UpdateNodeLabelsRequest{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateNodeLabelsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateNodeLabelsRequest) ProtoMessage() {}
+
+func (x *UpdateNodeLabelsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateNodeLabelsRequest.ProtoReflect.Descriptor instead.
+func (*UpdateNodeLabelsRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *UpdateNodeLabelsRequest) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *UpdateNodeLabelsRequest) GetSetLabels() map[string]string {
+	if x != nil {
+		return x.SetLabels
+	}
+	return nil
+}
+
+func (x *UpdateNodeLabelsRequest) GetRemoveLabels() []string {
+	if x != nil {
+		return x.RemoveLabels
+	}
+	return nil
+}
+
+func (x *UpdateNodeLabelsRequest) GetAddTaints() []string {
+	if x != nil {
+		return x.AddTaints
+	}
+	return nil
+}
+
+func (x *UpdateNodeLabelsRequest) GetRemoveTaints() []string {
+	if x != nil {
+		return x.RemoveTaints
+	}
+	return nil
+}
+
+type UpdateNodeLabelsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Labels        map[string]string      `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Taints        []string               `protobuf:"bytes,2,rep,name=taints,proto3" json:"taints,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateNodeLabelsResponse) Reset() {
+	*x = UpdateNodeLabelsResponse{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateNodeLabelsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateNodeLabelsResponse) ProtoMessage() {}
+
+func (x *UpdateNodeLabelsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateNodeLabelsResponse.ProtoReflect.Descriptor instead.
+func (*UpdateNodeLabelsResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *UpdateNodeLabelsResponse) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *UpdateNodeLabelsResponse) GetTaints() []string {
+	if x != nil {
+		return x.Taints
+	}
+	return nil
+}
+
+type NodeInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	MachineId     string                 `protobuf:"bytes,2,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
+	Version       string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	Status        string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"` // "connected", "disconnected"
+	RemoteAddr    string                 `protobuf:"bytes,5,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+	Host          *HostInfo              `protobuf:"bytes,6,opt,name=host,proto3" json:"host,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,7,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Taints        []string               `protobuf:"bytes,8,rep,name=taints,proto3" json:"taints,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NodeInfo) Reset() {
+	*x = NodeInfo{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NodeInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodeInfo) ProtoMessage() {}
+
+func (x *NodeInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodeInfo.ProtoReflect.Descriptor instead.
+func (*NodeInfo) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *NodeInfo) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *NodeInfo) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
+func (x *NodeInfo) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *NodeInfo) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *NodeInfo) GetRemoteAddr() string {
+	if x != nil {
+		return x.RemoteAddr
+	}
+	return ""
+}
+
+func (x *NodeInfo) GetHost() *HostInfo {
+	if x != nil {
+		return x.Host
+	}
+	return nil
+}
+
+func (x *NodeInfo) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *NodeInfo) GetTaints() []string {
+	if x != nil {
+		return x.Taints
+	}
+	return nil
+}
+
+type StreamPayload struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Oneof for different types of messages (commands, responses, heartbeats)
+	//
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*StreamPayload_Handshake
+	//	*StreamPayload_Command
+	//	*StreamPayload_Result
+	//	*StreamPayload_Heartbeat
+	//	*StreamPayload_Chunk
+	//	*StreamPayload_Cancel
+	//	*StreamPayload_Exec
+	Payload       isStreamPayload_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamPayload) Reset() {
+	*x = StreamPayload{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamPayload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamPayload) ProtoMessage() {}
+
+func (x *StreamPayload) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamPayload.ProtoReflect.Descriptor instead.
+func (*StreamPayload) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *StreamPayload) GetPayload() isStreamPayload_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *StreamPayload) GetHandshake() *Handshake {
+	if x != nil {
+		if x, ok := x.Payload.(*StreamPayload_Handshake); ok {
+			return x.Handshake
+		}
+	}
+	return nil
+}
+
+func (x *StreamPayload) GetCommand() *Command {
+	if x != nil {
+		if x, ok := x.Payload.(*StreamPayload_Command); ok {
+			return x.Command
+		}
+	}
+	return nil
+}
+
+func (x *StreamPayload) GetResult() *CommandResult {
+	if x != nil {
+		if x, ok := x.Payload.(*StreamPayload_Result); ok {
+			return x.Result
+		}
+	}
+	return nil
+}
+
+func (x *StreamPayload) GetHeartbeat() *Heartbeat {
+	if x != nil {
+		if x, ok := x.Payload.(*StreamPayload_Heartbeat); ok {
+			return x.Heartbeat
+		}
+	}
+	return nil
+}
+
+func (x *StreamPayload) GetChunk() *CommandChunk {
+	if x != nil {
+		if x, ok := x.Payload.(*StreamPayload_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+func (x *StreamPayload) GetCancel() *CancelCommand {
+	if x != nil {
+		if x, ok := x.Payload.(*StreamPayload_Cancel); ok {
+			return x.Cancel
+		}
+	}
+	return nil
+}
+
+func (x *StreamPayload) GetExec() *ExecFrame {
+	if x != nil {
+		if x, ok := x.Payload.(*StreamPayload_Exec); ok {
+			return x.Exec
+		}
+	}
+	return nil
+}
+
+type isStreamPayload_Payload interface {
+	isStreamPayload_Payload()
+}
+
+type StreamPayload_Handshake struct {
+	Handshake *Handshake `protobuf:"bytes,1,opt,name=handshake,proto3,oneof"`
+}
+
+type StreamPayload_Command struct {
+	Command *Command `protobuf:"bytes,2,opt,name=command,proto3,oneof"`
+}
+
+type StreamPayload_Result struct {
+	Result *CommandResult `protobuf:"bytes,3,opt,name=result,proto3,oneof"`
+}
+
+type StreamPayload_Heartbeat struct {
+	Heartbeat *Heartbeat `protobuf:"bytes,4,opt,name=heartbeat,proto3,oneof"`
+}
+
+type StreamPayload_Chunk struct {
+	Chunk *CommandChunk `protobuf:"bytes,5,opt,name=chunk,proto3,oneof"`
+}
+
+type StreamPayload_Cancel struct {
+	Cancel *CancelCommand `protobuf:"bytes,6,opt,name=cancel,proto3,oneof"`
+}
+
+type StreamPayload_Exec struct {
+	Exec *ExecFrame `protobuf:"bytes,7,opt,name=exec,proto3,oneof"`
+}
+
+func (*StreamPayload_Handshake) isStreamPayload_Payload() {}
+
+func (*StreamPayload_Command) isStreamPayload_Payload() {}
+
+func (*StreamPayload_Result) isStreamPayload_Payload() {}
+
+func (*StreamPayload_Heartbeat) isStreamPayload_Payload() {}
+
+func (*StreamPayload_Chunk) isStreamPayload_Payload() {}
+
+func (*StreamPayload_Cancel) isStreamPayload_Payload() {}
+
+func (*StreamPayload_Exec) isStreamPayload_Payload() {}
+
+type Handshake struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	NodeId    string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	MachineId string                 `protobuf:"bytes,2,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
+	Version   string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	// potentially auth token here
+	Host *HostInfo `protobuf:"bytes,4,opt,name=host,proto3" json:"host,omitempty"`
+	// From the agent config; merged into the labels and taints kept by the hub.
+	Labels        map[string]string `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Taints        []string          `protobuf:"bytes,6,rep,name=taints,proto3" json:"taints,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Handshake) Reset() {
+	*x = Handshake{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Handshake) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Handshake) ProtoMessage() {}
+
+func (x *Handshake) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Handshake.ProtoReflect.Descriptor instead.
+func (*Handshake) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *Handshake) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *Handshake) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
+func (x *Handshake) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Handshake) GetHost() *HostInfo {
+	if x != nil {
+		return x.Host
+	}
+	return nil
+}
+
+func (x *Handshake) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Handshake) GetTaints() []string {
+	if x != nil {
+		return x.Taints
+	}
+	return nil
+}
+
+// HostInfo describes the machine an agent runs on. Collected once per connection.
+type HostInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hostname      string                 `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Os            string                 `protobuf:"bytes,2,opt,name=os,proto3" json:"os,omitempty"` // e.g. "Ubuntu 24.04.1 LTS"
+	Kernel        string                 `protobuf:"bytes,3,opt,name=kernel,proto3" json:"kernel,omitempty"`
+	Arch          string                 `protobuf:"bytes,4,opt,name=arch,proto3" json:"arch,omitempty"`
+	CpuCount      int32                  `protobuf:"varint,5,opt,name=cpu_count,json=cpuCount,proto3" json:"cpu_count,omitempty"`
+	MemoryBytes   int64                  `protobuf:"varint,6,opt,name=memory_bytes,json=memoryBytes,proto3" json:"memory_bytes,omitempty"`
+	DockerVersion string                 `protobuf:"bytes,7,opt,name=docker_version,json=dockerVersion,proto3" json:"docker_version,omitempty"`
+	StorageDriver string                 `protobuf:"bytes,8,opt,name=storage_driver,json=storageDriver,proto3" json:"storage_driver,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HostInfo) Reset() {
+	*x = HostInfo{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HostInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HostInfo) ProtoMessage() {}
+
+func (x *HostInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HostInfo.ProtoReflect.Descriptor instead.
+func (*HostInfo) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *HostInfo) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *HostInfo) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+func (x *HostInfo) GetKernel() string {
+	if x != nil {
+		return x.Kernel
+	}
+	return ""
+}
+
+func (x *HostInfo) GetArch() string {
+	if x != nil {
+		return x.Arch
+	}
+	return ""
+}
+
+func (x *HostInfo) GetCpuCount() int32 {
+	if x != nil {
+		return x.CpuCount
+	}
+	return 0
+}
+
+func (x *HostInfo) GetMemoryBytes() int64 {
+	if x != nil {
+		return x.MemoryBytes
+	}
+	return 0
+}
+
+func (x *HostInfo) GetDockerVersion() string {
+	if x != nil {
+		return x.DockerVersion
+	}
+	return ""
+}
+
+func (x *HostInfo) GetStorageDriver() string {
+	if x != nil {
+		return x.StorageDriver
+	}
+	return ""
+}
+
+type Command struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type  string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"` // e.g., "docker_ps", "docker_run"
+	Args  []string               `protobuf:"bytes,3,rep,name=args,proto3" json:"args,omitempty"`
+	// Credentials for the registries the command pulls from. Set by the hub,
+	// never stored with the job.
+	RegistryAuths []*RegistryAuth `protobuf:"bytes,4,rep,name=registry_auths,json=registryAuths,proto3" json:"registry_auths,omitempty"`
+	// The hub relays CommandChunks to the caller, e.g. image pull progress.
+	Stream        bool `protobuf:"varint,5,opt,name=stream,proto3" json:"stream,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Command) Reset() {
+	*x = Command{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Command) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Command) ProtoMessage() {}
+
+func (x *Command) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Command.ProtoReflect.Descriptor instead.
+func (*Command) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *Command) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Command) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Command) GetArgs() []string {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *Command) GetRegistryAuths() []*RegistryAuth {
+	if x != nil {
+		return x.RegistryAuths
+	}
+	return nil
+}
+
+func (x *Command) GetStream() bool {
+	if x != nil {
+		return x.Stream
+	}
+	return false
+}
+
+// RegistryAuth is a login for one image registry.
+type RegistryAuth struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServerAddress string                 `protobuf:"bytes,1,opt,name=server_address,json=serverAddress,proto3" json:"server_address,omitempty"` // e.g. "ghcr.io"
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Password      string                 `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"` // password or access token
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegistryAuth) Reset() {
+	*x = RegistryAuth{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegistryAuth) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegistryAuth) ProtoMessage() {}
+
+func (x *RegistryAuth) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegistryAuth.ProtoReflect.Descriptor instead.
+func (*RegistryAuth) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *RegistryAuth) GetServerAddress() string {
+	if x != nil {
+		return x.ServerAddress
+	}
+	return ""
+}
+
+func (x *RegistryAuth) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *RegistryAuth) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+type CommandResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	ExitCode      int32                  `protobuf:"varint,2,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`
+	Output        []byte                 `protobuf:"bytes,3,opt,name=output,proto3" json:"output,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandResult) Reset() {
+	*x = CommandResult{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandResult) ProtoMessage() {}
+
+func (x *CommandResult) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandResult.ProtoReflect.Descriptor instead.
+func (*CommandResult) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *CommandResult) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CommandResult) GetExitCode() int32 {
+	if x != nil {
+		return x.ExitCode
+	}
+	return 0
+}
+
+func (x *CommandResult) GetOutput() []byte {
+	if x != nil {
+		return x.Output
+	}
+	return nil
+}
+
+func (x *CommandResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// CommandChunk carries a piece of output for a streaming command (e.g. followed logs).
+// The stream is terminated by a regular CommandResult with the same command_id.
+type CommandChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Seq           int64                  `protobuf:"varint,3,opt,name=seq,proto3" json:"seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandChunk) Reset() {
+	*x = CommandChunk{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandChunk) ProtoMessage() {}
+
+func (x *CommandChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandChunk.ProtoReflect.Descriptor instead.
+func (*CommandChunk) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *CommandChunk) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CommandChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *CommandChunk) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+// CancelCommand asks the agent to abort a running command.
+type CancelCommand struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelCommand) Reset() {
+	*x = CancelCommand{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCommand) ProtoMessage() {}
+
+func (x *CancelCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCommand.ProtoReflect.Descriptor instead.
+func (*CancelCommand) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *CancelCommand) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+// ExecFrame is one frame of an interactive exec session opened by a
+// "docker_exec_session" command; session_id is that command's id.
+// Hub -> Agent: "stdin", "resize", "close". Agent -> Hub: "stdout", "exit".
+type ExecFrame struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SessionId     string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Kind          string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	Rows          uint32                 `protobuf:"varint,4,opt,name=rows,proto3" json:"rows,omitempty"`
+	Cols          uint32                 `protobuf:"varint,5,opt,name=cols,proto3" json:"cols,omitempty"`
+	ExitCode      int32                  `protobuf:"varint,6,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecFrame) Reset() {
+	*x = ExecFrame{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecFrame) ProtoMessage() {}
+
+func (x *ExecFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecFrame.ProtoReflect.Descriptor instead.
+func (*ExecFrame) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ExecFrame) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ExecFrame) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *ExecFrame) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ExecFrame) GetRows() uint32 {
+	if x != nil {
+		return x.Rows
+	}
+	return 0
+}
+
+func (x *ExecFrame) GetCols() uint32 {
+	if x != nil {
+		return x.Cols
+	}
+	return 0
+}
+
+func (x *ExecFrame) GetExitCode() int32 {
+	if x != nil {
+		return x.ExitCode
+	}
+	return 0
+}
+
+type Heartbeat struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// Set on heartbeats sent by agents.
+	Metrics       *NodeMetrics `protobuf:"bytes,2,opt,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Heartbeat) Reset() {
+	*x = Heartbeat{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Heartbeat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Heartbeat) ProtoMessage() {}
+
+func (x *Heartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
+func (*Heartbeat) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *Heartbeat) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Heartbeat) GetMetrics() *NodeMetrics {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+// NodeMetrics is a point-in-time sample of host load.
+type NodeMetrics struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	CpuPercent        float64                `protobuf:"fixed64,1,opt,name=cpu_percent,json=cpuPercent,proto3" json:"cpu_percent,omitempty"` // average over the heartbeat interval, all cores
+	MemoryUsedBytes   int64                  `protobuf:"varint,2,opt,name=memory_used_bytes,json=memoryUsedBytes,proto3" json:"memory_used_bytes,omitempty"`
+	MemoryTotalBytes  int64                  `protobuf:"varint,3,opt,name=memory_total_bytes,json=memoryTotalBytes,proto3" json:"memory_total_bytes,omitempty"`
+	DiskUsedBytes     int64                  `protobuf:"varint,4,opt,name=disk_used_bytes,json=diskUsedBytes,proto3" json:"disk_used_bytes,omitempty"` // filesystem of the Docker root dir
+	DiskTotalBytes    int64                  `protobuf:"varint,5,opt,name=disk_total_bytes,json=diskTotalBytes,proto3" json:"disk_total_bytes,omitempty"`
+	ContainersRunning int32                  `protobuf:"varint,6,opt,name=containers_running,json=containersRunning,proto3" json:"containers_running,omitempty"`
+	ContainersTotal   int32                  `protobuf:"varint,7,opt,name=containers_total,json=containersTotal,proto3" json:"containers_total,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *NodeMetrics) Reset() {
+	*x = NodeMetrics{}
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NodeMetrics) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodeMetrics) ProtoMessage() {}
+
+func (x *NodeMetrics) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_docklet_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodeMetrics.ProtoReflect.Descriptor instead.
+func (*NodeMetrics) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_docklet_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *NodeMetrics) GetCpuPercent() float64 {
+	if x != nil {
+		return x.CpuPercent
+	}
+	return 0
+}
+
+func (x *NodeMetrics) GetMemoryUsedBytes() int64 {
+	if x != nil {
+		return x.MemoryUsedBytes
+	}
+	return 0
+}
+
+func (x *NodeMetrics) GetMemoryTotalBytes() int64 {
+	if x != nil {
+		return x.MemoryTotalBytes
+	}
+	return 0
+}
+
+func (x *NodeMetrics) GetDiskUsedBytes() int64 {
+	if x != nil {
+		return x.DiskUsedBytes
+	}
+	return 0
+}
+
+func (x *NodeMetrics) GetDiskTotalBytes() int64 {
+	if x != nil {
+		return x.DiskTotalBytes
+	}
+	return 0
+}
+
+func (x *NodeMetrics) GetContainersRunning() int32 {
+	if x != nil {
+		return x.ContainersRunning
+	}
+	return 0
+}
+
+func (x *NodeMetrics) GetContainersTotal() int32 {
+	if x != nil {
+		return x.ContainersTotal
+	}
+	return 0
 }
 
 var File_api_proto_v1_docklet_proto protoreflect.FileDescriptor
@@ -650,8 +1803,9 @@ var File_api_proto_v1_docklet_proto protoreflect.FileDescriptor
 const file_api_proto_v1_docklet_proto_rawDesc = "" +
 	"\n" +
 	"\x1aapi/proto/v1/docklet.proto\x12\n" +
-	"docklet.v1\"\x12\n" +
-	"\x10ListNodesRequest\"?\n" +
+	"docklet.v1\".\n" +
+	"\x10ListNodesRequest\x12\x1a\n" +
+	"\bselector\x18\x01 \x01(\tR\bselector\"?\n" +
 	"\x11ListNodesResponse\x12*\n" +
 	"\x05nodes\x18\x01 \x03(\v2\x14.docklet.v1.NodeInfoR\x05nodes\"^\n" +
 	"\x15ExecuteCommandRequest\x12\x17\n" +
@@ -661,7 +1815,52 @@ const file_api_proto_v1_docklet_proto_rawDesc = "" +
 	"\x16ExecuteCommandResponse\x12\x16\n" +
 	"\x06output\x18\x01 \x01(\fR\x06output\x12\x14\n" +
 	"\x05error\x18\x02 \x01(\tR\x05error\x12\x1b\n" +
-	"\texit_code\x18\x03 \x01(\x05R\bexitCode\"\x95\x01\n" +
+	"\texit_code\x18\x03 \x01(\x05R\bexitCode\"\x7f\n" +
+	"\x1cExecuteCommandStreamResponse\x12\x16\n" +
+	"\x05chunk\x18\x01 \x01(\fH\x00R\x05chunk\x12<\n" +
+	"\x06result\x18\x02 \x01(\v2\".docklet.v1.ExecuteCommandResponseH\x00R\x06resultB\t\n" +
+	"\apayload\"\xec\x01\n" +
+	"\x15ForwardCommandRequest\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12;\n" +
+	"\acommand\x18\x02 \x01(\v2!.docklet.v1.ExecuteCommandRequestR\acommand\x12\x16\n" +
+	"\x06stream\x18\x03 \x01(\bR\x06stream\x12\x1b\n" +
+	"\tissued_by\x18\x04 \x01(\tR\bissuedBy\x12\"\n" +
+	"\rparent_job_id\x18\x05 \x01(\tR\vparentJobId\x12\x1e\n" +
+	"\n" +
+	"unrecorded\x18\x06 \x01(\bR\n" +
+	"unrecorded\"y\n" +
+	"\x16ForwardCommandResponse\x12\x16\n" +
+	"\x05chunk\x18\x01 \x01(\fH\x00R\x05chunk\x12<\n" +
+	"\x06result\x18\x02 \x01(\v2\".docklet.v1.ExecuteCommandResponseH\x00R\x06resultB\t\n" +
+	"\apayload\",\n" +
+	"\x11RevokeNodeRequest\x12\x17\n" +
+	"\anode_id\x18\x01 \x01(\tR\x06nodeId\".\n" +
+	"\x12RevokeNodeResponse\x12\x18\n" +
+	"\aserials\x18\x01 \x03(\tR\aserials\"7\n" +
+	"\x1cRotateNodeCertificateRequest\x12\x17\n" +
+	"\anode_id\x18\x01 \x01(\tR\x06nodeId\"V\n" +
+	"\x1dRotateNodeCertificateResponse\x12\x16\n" +
+	"\x06serial\x18\x01 \x01(\tR\x06serial\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x02 \x01(\x03R\texpiresAt\"\xac\x02\n" +
+	"\x17UpdateNodeLabelsRequest\x12\x17\n" +
+	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12Q\n" +
+	"\n" +
+	"set_labels\x18\x02 \x03(\v22.docklet.v1.UpdateNodeLabelsRequest.SetLabelsEntryR\tsetLabels\x12#\n" +
+	"\rremove_labels\x18\x03 \x03(\tR\fremoveLabels\x12\x1d\n" +
+	"\n" +
+	"add_taints\x18\x04 \x03(\tR\taddTaints\x12#\n" +
+	"\rremove_taints\x18\x05 \x03(\tR\fremoveTaints\x1a<\n" +
+	"\x0eSetLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xb7\x01\n" +
+	"\x18UpdateNodeLabelsResponse\x12H\n" +
+	"\x06labels\x18\x01 \x03(\v20.docklet.v1.UpdateNodeLabelsResponse.LabelsEntryR\x06labels\x12\x16\n" +
+	"\x06taints\x18\x02 \x03(\tR\x06taints\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xcc\x02\n" +
 	"\bNodeInfo\x12\x17\n" +
 	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x1d\n" +
 	"\n" +
@@ -669,34 +1868,96 @@ const file_api_proto_v1_docklet_proto_rawDesc = "" +
 	"\aversion\x18\x03 \x01(\tR\aversion\x12\x16\n" +
 	"\x06status\x18\x04 \x01(\tR\x06status\x12\x1f\n" +
 	"\vremote_addr\x18\x05 \x01(\tR\n" +
-	"remoteAddr\"\xee\x01\n" +
+	"remoteAddr\x12(\n" +
+	"\x04host\x18\x06 \x01(\v2\x14.docklet.v1.HostInfoR\x04host\x128\n" +
+	"\x06labels\x18\a \x03(\v2 .docklet.v1.NodeInfo.LabelsEntryR\x06labels\x12\x16\n" +
+	"\x06taints\x18\b \x03(\tR\x06taints\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x82\x03\n" +
 	"\rStreamPayload\x125\n" +
 	"\thandshake\x18\x01 \x01(\v2\x15.docklet.v1.HandshakeH\x00R\thandshake\x12/\n" +
 	"\acommand\x18\x02 \x01(\v2\x13.docklet.v1.CommandH\x00R\acommand\x123\n" +
 	"\x06result\x18\x03 \x01(\v2\x19.docklet.v1.CommandResultH\x00R\x06result\x125\n" +
-	"\theartbeat\x18\x04 \x01(\v2\x15.docklet.v1.HeartbeatH\x00R\theartbeatB\t\n" +
-	"\apayload\"]\n" +
+	"\theartbeat\x18\x04 \x01(\v2\x15.docklet.v1.HeartbeatH\x00R\theartbeat\x120\n" +
+	"\x05chunk\x18\x05 \x01(\v2\x18.docklet.v1.CommandChunkH\x00R\x05chunk\x123\n" +
+	"\x06cancel\x18\x06 \x01(\v2\x19.docklet.v1.CancelCommandH\x00R\x06cancel\x12+\n" +
+	"\x04exec\x18\a \x01(\v2\x15.docklet.v1.ExecFrameH\x00R\x04execB\t\n" +
+	"\apayload\"\x95\x02\n" +
 	"\tHandshake\x12\x17\n" +
 	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x1d\n" +
 	"\n" +
 	"machine_id\x18\x02 \x01(\tR\tmachineId\x12\x18\n" +
-	"\aversion\x18\x03 \x01(\tR\aversion\"A\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12(\n" +
+	"\x04host\x18\x04 \x01(\v2\x14.docklet.v1.HostInfoR\x04host\x129\n" +
+	"\x06labels\x18\x05 \x03(\v2!.docklet.v1.Handshake.LabelsEntryR\x06labels\x12\x16\n" +
+	"\x06taints\x18\x06 \x03(\tR\x06taints\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xf0\x01\n" +
+	"\bHostInfo\x12\x1a\n" +
+	"\bhostname\x18\x01 \x01(\tR\bhostname\x12\x0e\n" +
+	"\x02os\x18\x02 \x01(\tR\x02os\x12\x16\n" +
+	"\x06kernel\x18\x03 \x01(\tR\x06kernel\x12\x12\n" +
+	"\x04arch\x18\x04 \x01(\tR\x04arch\x12\x1b\n" +
+	"\tcpu_count\x18\x05 \x01(\x05R\bcpuCount\x12!\n" +
+	"\fmemory_bytes\x18\x06 \x01(\x03R\vmemoryBytes\x12%\n" +
+	"\x0edocker_version\x18\a \x01(\tR\rdockerVersion\x12%\n" +
+	"\x0estorage_driver\x18\b \x01(\tR\rstorageDriver\"\x9a\x01\n" +
 	"\aCommand\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
 	"\x04type\x18\x02 \x01(\tR\x04type\x12\x12\n" +
-	"\x04args\x18\x03 \x03(\tR\x04args\"y\n" +
+	"\x04args\x18\x03 \x03(\tR\x04args\x12?\n" +
+	"\x0eregistry_auths\x18\x04 \x03(\v2\x18.docklet.v1.RegistryAuthR\rregistryAuths\x12\x16\n" +
+	"\x06stream\x18\x05 \x01(\bR\x06stream\"m\n" +
+	"\fRegistryAuth\x12%\n" +
+	"\x0eserver_address\x18\x01 \x01(\tR\rserverAddress\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12\x1a\n" +
+	"\bpassword\x18\x03 \x01(\tR\bpassword\"y\n" +
 	"\rCommandResult\x12\x1d\n" +
 	"\n" +
 	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x1b\n" +
 	"\texit_code\x18\x02 \x01(\x05R\bexitCode\x12\x16\n" +
 	"\x06output\x18\x03 \x01(\fR\x06output\x12\x14\n" +
-	"\x05error\x18\x04 \x01(\tR\x05error\")\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"S\n" +
+	"\fCommandChunk\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x10\n" +
+	"\x03seq\x18\x03 \x01(\x03R\x03seq\".\n" +
+	"\rCancelCommand\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\"\x97\x01\n" +
+	"\tExecFrame\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12\x12\n" +
+	"\x04rows\x18\x04 \x01(\rR\x04rows\x12\x12\n" +
+	"\x04cols\x18\x05 \x01(\rR\x04cols\x12\x1b\n" +
+	"\texit_code\x18\x06 \x01(\x05R\bexitCode\"\\\n" +
 	"\tHeartbeat\x12\x1c\n" +
-	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp2\xff\x01\n" +
+	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp\x121\n" +
+	"\ametrics\x18\x02 \x01(\v2\x17.docklet.v1.NodeMetricsR\ametrics\"\xb4\x02\n" +
+	"\vNodeMetrics\x12\x1f\n" +
+	"\vcpu_percent\x18\x01 \x01(\x01R\n" +
+	"cpuPercent\x12*\n" +
+	"\x11memory_used_bytes\x18\x02 \x01(\x03R\x0fmemoryUsedBytes\x12,\n" +
+	"\x12memory_total_bytes\x18\x03 \x01(\x03R\x10memoryTotalBytes\x12&\n" +
+	"\x0fdisk_used_bytes\x18\x04 \x01(\x03R\rdiskUsedBytes\x12(\n" +
+	"\x10disk_total_bytes\x18\x05 \x01(\x03R\x0ediskTotalBytes\x12-\n" +
+	"\x12containers_running\x18\x06 \x01(\x05R\x11containersRunning\x12)\n" +
+	"\x10containers_total\x18\a \x01(\x05R\x0fcontainersTotal2\xdb\x05\n" +
 	"\x0eDockletService\x12J\n" +
 	"\x0eRegisterStream\x12\x19.docklet.v1.StreamPayload\x1a\x19.docklet.v1.StreamPayload(\x010\x01\x12H\n" +
 	"\tListNodes\x12\x1c.docklet.v1.ListNodesRequest\x1a\x1d.docklet.v1.ListNodesResponse\x12W\n" +
-	"\x0eExecuteCommand\x12!.docklet.v1.ExecuteCommandRequest\x1a\".docklet.v1.ExecuteCommandResponseB4Z2github.com/astracat/docklet/api/proto/v1;dockletv1b\x06proto3"
+	"\x0eExecuteCommand\x12!.docklet.v1.ExecuteCommandRequest\x1a\".docklet.v1.ExecuteCommandResponse\x12e\n" +
+	"\x14ExecuteCommandStream\x12!.docklet.v1.ExecuteCommandRequest\x1a(.docklet.v1.ExecuteCommandStreamResponse0\x01\x12K\n" +
+	"\n" +
+	"RevokeNode\x12\x1d.docklet.v1.RevokeNodeRequest\x1a\x1e.docklet.v1.RevokeNodeResponse\x12l\n" +
+	"\x15RotateNodeCertificate\x12(.docklet.v1.RotateNodeCertificateRequest\x1a).docklet.v1.RotateNodeCertificateResponse\x12]\n" +
+	"\x10UpdateNodeLabels\x12#.docklet.v1.UpdateNodeLabelsRequest\x1a$.docklet.v1.UpdateNodeLabelsResponse\x12Y\n" +
+	"\x0eForwardCommand\x12!.docklet.v1.ForwardCommandRequest\x1a\".docklet.v1.ForwardCommandResponse0\x01B4Z2github.com/astracat/docklet/api/proto/v1;dockletv1b\x06proto3"
 
 var (
 	file_api_proto_v1_docklet_proto_rawDescOnce sync.Once
@@ -710,36 +1971,79 @@ func file_api_proto_v1_docklet_proto_rawDescGZIP() []byte {
 	return file_api_proto_v1_docklet_proto_rawDescData
 }
 
-var file_api_proto_v1_docklet_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_api_proto_v1_docklet_proto_msgTypes = make([]protoimpl.MessageInfo, 29)
 var file_api_proto_v1_docklet_proto_goTypes = []any{
-	(*ListNodesRequest)(nil),       // 0: docklet.v1.ListNodesRequest
-	(*ListNodesResponse)(nil),      // 1: docklet.v1.ListNodesResponse
-	(*ExecuteCommandRequest)(nil),  // 2: docklet.v1.ExecuteCommandRequest
-	(*ExecuteCommandResponse)(nil), // 3: docklet.v1.ExecuteCommandResponse
-	(*NodeInfo)(nil),               // 4: docklet.v1.NodeInfo
-	(*StreamPayload)(nil),          // 5: docklet.v1.StreamPayload
-	(*Handshake)(nil),              // 6: docklet.v1.Handshake
-	(*Command)(nil),                // 7: docklet.v1.Command
-	(*CommandResult)(nil),          // 8: docklet.v1.CommandResult
-	(*Heartbeat)(nil),              // 9: docklet.v1.Heartbeat
+	(*ListNodesRequest)(nil),              // 0: docklet.v1.ListNodesRequest
+	(*ListNodesResponse)(nil),             // 1: docklet.v1.ListNodesResponse
+	(*ExecuteCommandRequest)(nil),         // 2: docklet.v1.ExecuteCommandRequest
+	(*ExecuteCommandResponse)(nil),        // 3: docklet.v1.ExecuteCommandResponse
+	(*ExecuteCommandStreamResponse)(nil),  // 4: docklet.v1.ExecuteCommandStreamResponse
+	(*ForwardCommandRequest)(nil),         // 5: docklet.v1.ForwardCommandRequest
+	(*ForwardCommandResponse)(nil),        // 6: docklet.v1.ForwardCommandResponse
+	(*RevokeNodeRequest)(nil),             // 7: docklet.v1.RevokeNodeRequest
+	(*RevokeNodeResponse)(nil),            // 8: docklet.v1.RevokeNodeResponse
+	(*RotateNodeCertificateRequest)(nil),  // 9: docklet.v1.RotateNodeCertificateRequest
+	(*RotateNodeCertificateResponse)(nil), // 10: docklet.v1.RotateNodeCertificateResponse
+	(*UpdateNodeLabelsRequest)(nil),       // 11: docklet.v1.UpdateNodeLabelsRequest
+	(*UpdateNodeLabelsResponse)(nil),      // 12: docklet.v1.UpdateNodeLabelsResponse
+	(*NodeInfo)(nil),                      // 13: docklet.v1.NodeInfo
+	(*StreamPayload)(nil),                 // 14: docklet.v1.StreamPayload
+	(*Handshake)(nil),                     // 15: docklet.v1.Handshake
+	(*HostInfo)(nil),                      // 16: docklet.v1.HostInfo
+	(*Command)(nil),                       // 17: docklet.v1.Command
+	(*RegistryAuth)(nil),                  // 18: docklet.v1.RegistryAuth
+	(*CommandResult)(nil),                 // 19: docklet.v1.CommandResult
+	(*CommandChunk)(nil),                  // 20: docklet.v1.CommandChunk
+	(*CancelCommand)(nil),                 // 21: docklet.v1.CancelCommand
+	(*ExecFrame)(nil),                     // 22: docklet.v1.ExecFrame
+	(*Heartbeat)(nil),                     // 23: docklet.v1.Heartbeat
+	(*NodeMetrics)(nil),                   // 24: docklet.v1.NodeMetrics
+	nil,                                   // 25: docklet.v1.UpdateNodeLabelsRequest.SetLabelsEntry
+	nil,                                   // 26: docklet.v1.UpdateNodeLabelsResponse.LabelsEntry
+	nil,                                   // 27: docklet.v1.NodeInfo.LabelsEntry
+	nil,                                   // 28: docklet.v1.Handshake.LabelsEntry
 }
 var file_api_proto_v1_docklet_proto_depIdxs = []int32{
-	4, // 0: docklet.v1.ListNodesResponse.nodes:type_name -> docklet.v1.NodeInfo
-	6, // 1: docklet.v1.StreamPayload.handshake:type_name -> docklet.v1.Handshake
-	7, // 2: docklet.v1.StreamPayload.command:type_name -> docklet.v1.Command
-	8, // 3: docklet.v1.StreamPayload.result:type_name -> docklet.v1.CommandResult
-	9, // 4: docklet.v1.StreamPayload.heartbeat:type_name -> docklet.v1.Heartbeat
-	5, // 5: docklet.v1.DockletService.RegisterStream:input_type -> docklet.v1.StreamPayload
-	0, // 6: docklet.v1.DockletService.ListNodes:input_type -> docklet.v1.ListNodesRequest
-	2, // 7: docklet.v1.DockletService.ExecuteCommand:input_type -> docklet.v1.ExecuteCommandRequest
-	5, // 8: docklet.v1.DockletService.RegisterStream:output_type -> docklet.v1.StreamPayload
-	1, // 9: docklet.v1.DockletService.ListNodes:output_type -> docklet.v1.ListNodesResponse
-	3, // 10: docklet.v1.DockletService.ExecuteCommand:output_type -> docklet.v1.ExecuteCommandResponse
-	8, // [8:11] is the sub-list for method output_type
-	5, // [5:8] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	13, // 0: docklet.v1.ListNodesResponse.nodes:type_name -> docklet.v1.NodeInfo
+	3,  // 1: docklet.v1.ExecuteCommandStreamResponse.result:type_name -> docklet.v1.ExecuteCommandResponse
+	2,  // 2: docklet.v1.ForwardCommandRequest.command:type_name -> docklet.v1.ExecuteCommandRequest
+	3,  // 3: docklet.v1.ForwardCommandResponse.result:type_name -> docklet.v1.ExecuteCommandResponse
+	25, // 4: docklet.v1.UpdateNodeLabelsRequest.set_labels:type_name -> docklet.v1.UpdateNodeLabelsRequest.SetLabelsEntry
+	26, // 5: docklet.v1.UpdateNodeLabelsResponse.labels:type_name -> docklet.v1.UpdateNodeLabelsResponse.LabelsEntry
+	16, // 6: docklet.v1.NodeInfo.host:type_name -> docklet.v1.HostInfo
+	27, // 7: docklet.v1.NodeInfo.labels:type_name -> docklet.v1.NodeInfo.LabelsEntry
+	15, // 8: docklet.v1.StreamPayload.handshake:type_name -> docklet.v1.Handshake
+	17, // 9: docklet.v1.StreamPayload.command:type_name -> docklet.v1.Command
+	19, // 10: docklet.v1.StreamPayload.result:type_name -> docklet.v1.CommandResult
+	23, // 11: docklet.v1.StreamPayload.heartbeat:type_name -> docklet.v1.Heartbeat
+	20, // 12: docklet.v1.StreamPayload.chunk:type_name -> docklet.v1.CommandChunk
+	21, // 13: docklet.v1.StreamPayload.cancel:type_name -> docklet.v1.CancelCommand
+	22, // 14: docklet.v1.StreamPayload.exec:type_name -> docklet.v1.ExecFrame
+	16, // 15: docklet.v1.Handshake.host:type_name -> docklet.v1.HostInfo
+	28, // 16: docklet.v1.Handshake.labels:type_name -> docklet.v1.Handshake.LabelsEntry
+	18, // 17: docklet.v1.Command.registry_auths:type_name -> docklet.v1.RegistryAuth
+	24, // 18: docklet.v1.Heartbeat.metrics:type_name -> docklet.v1.NodeMetrics
+	14, // 19: docklet.v1.DockletService.RegisterStream:input_type -> docklet.v1.StreamPayload
+	0,  // 20: docklet.v1.DockletService.ListNodes:input_type -> docklet.v1.ListNodesRequest
+	2,  // 21: docklet.v1.DockletService.ExecuteCommand:input_type -> docklet.v1.ExecuteCommandRequest
+	2,  // 22: docklet.v1.DockletService.ExecuteCommandStream:input_type -> docklet.v1.ExecuteCommandRequest
+	7,  // 23: docklet.v1.DockletService.RevokeNode:input_type -> docklet.v1.RevokeNodeRequest
+	9,  // 24: docklet.v1.DockletService.RotateNodeCertificate:input_type -> docklet.v1.RotateNodeCertificateRequest
+	11, // 25: docklet.v1.DockletService.UpdateNodeLabels:input_type -> docklet.v1.UpdateNodeLabelsRequest
+	5,  // 26: docklet.v1.DockletService.ForwardCommand:input_type -> docklet.v1.ForwardCommandRequest
+	14, // 27: docklet.v1.DockletService.RegisterStream:output_type -> docklet.v1.StreamPayload
+	1,  // 28: docklet.v1.DockletService.ListNodes:output_type -> docklet.v1.ListNodesResponse
+	3,  // 29: docklet.v1.DockletService.ExecuteCommand:output_type -> docklet.v1.ExecuteCommandResponse
+	4,  // 30: docklet.v1.DockletService.ExecuteCommandStream:output_type -> docklet.v1.ExecuteCommandStreamResponse
+	8,  // 31: docklet.v1.DockletService.RevokeNode:output_type -> docklet.v1.RevokeNodeResponse
+	10, // 32: docklet.v1.DockletService.RotateNodeCertificate:output_type -> docklet.v1.RotateNodeCertificateResponse
+	12, // 33: docklet.v1.DockletService.UpdateNodeLabels:output_type -> docklet.v1.UpdateNodeLabelsResponse
+	6,  // 34: docklet.v1.DockletService.ForwardCommand:output_type -> docklet.v1.ForwardCommandResponse
+	27, // [27:35] is the sub-list for method output_type
+	19, // [19:27] is the sub-list for method input_type
+	19, // [19:19] is the sub-list for extension type_name
+	19, // [19:19] is the sub-list for extension extendee
+	0,  // [0:19] is the sub-list for field type_name
 }
 
 func init() { file_api_proto_v1_docklet_proto_init() }
@@ -747,11 +2051,22 @@ func file_api_proto_v1_docklet_proto_init() {
 	if File_api_proto_v1_docklet_proto != nil {
 		return
 	}
-	file_api_proto_v1_docklet_proto_msgTypes[5].OneofWrappers = []any{
+	file_api_proto_v1_docklet_proto_msgTypes[4].OneofWrappers = []any{
+		(*ExecuteCommandStreamResponse_Chunk)(nil),
+		(*ExecuteCommandStreamResponse_Result)(nil),
+	}
+	file_api_proto_v1_docklet_proto_msgTypes[6].OneofWrappers = []any{
+		(*ForwardCommandResponse_Chunk)(nil),
+		(*ForwardCommandResponse_Result)(nil),
+	}
+	file_api_proto_v1_docklet_proto_msgTypes[14].OneofWrappers = []any{
 		(*StreamPayload_Handshake)(nil),
 		(*StreamPayload_Command)(nil),
 		(*StreamPayload_Result)(nil),
 		(*StreamPayload_Heartbeat)(nil),
+		(*StreamPayload_Chunk)(nil),
+		(*StreamPayload_Cancel)(nil),
+		(*StreamPayload_Exec)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -759,7 +2074,7 @@ func file_api_proto_v1_docklet_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_proto_v1_docklet_proto_rawDesc), len(file_api_proto_v1_docklet_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   10,
+			NumMessages:   29,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
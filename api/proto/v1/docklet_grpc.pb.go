@@ -19,9 +19,14 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	DockletService_RegisterStream_FullMethodName = "/docklet.v1.DockletService/RegisterStream"
-	DockletService_ListNodes_FullMethodName      = "/docklet.v1.DockletService/ListNodes"
-	DockletService_ExecuteCommand_FullMethodName = "/docklet.v1.DockletService/ExecuteCommand"
+	DockletService_RegisterStream_FullMethodName        = "/docklet.v1.DockletService/RegisterStream"
+	DockletService_ListNodes_FullMethodName             = "/docklet.v1.DockletService/ListNodes"
+	DockletService_ExecuteCommand_FullMethodName        = "/docklet.v1.DockletService/ExecuteCommand"
+	DockletService_ExecuteCommandStream_FullMethodName  = "/docklet.v1.DockletService/ExecuteCommandStream"
+	DockletService_RevokeNode_FullMethodName            = "/docklet.v1.DockletService/RevokeNode"
+	DockletService_RotateNodeCertificate_FullMethodName = "/docklet.v1.DockletService/RotateNodeCertificate"
+	DockletService_UpdateNodeLabels_FullMethodName      = "/docklet.v1.DockletService/UpdateNodeLabels"
+	DockletService_ForwardCommand_FullMethodName        = "/docklet.v1.DockletService/ForwardCommand"
 )
 
 // DockletServiceClient is the client API for DockletService service.
@@ -34,6 +39,17 @@ type DockletServiceClient interface {
 	// Admin API
 	ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error)
 	ExecuteCommand(ctx context.Context, in *ExecuteCommandRequest, opts ...grpc.CallOption) (*ExecuteCommandResponse, error)
+	// Like ExecuteCommand, with the command's output chunks (e.g. image pull
+	// progress) sent as they arrive, before the result.
+	ExecuteCommandStream(ctx context.Context, in *ExecuteCommandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExecuteCommandStreamResponse], error)
+	// Certificate management
+	RevokeNode(ctx context.Context, in *RevokeNodeRequest, opts ...grpc.CallOption) (*RevokeNodeResponse, error)
+	RotateNodeCertificate(ctx context.Context, in *RotateNodeCertificateRequest, opts ...grpc.CallOption) (*RotateNodeCertificateResponse, error)
+	// Labels and taints
+	UpdateNodeLabels(ctx context.Context, in *UpdateNodeLabelsRequest, opts ...grpc.CallOption) (*UpdateNodeLabelsResponse, error)
+	// Hub-to-hub: runs a command on an agent whose stream this replica holds,
+	// on behalf of the replica that received it.
+	ForwardCommand(ctx context.Context, in *ForwardCommandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ForwardCommandResponse], error)
 }
 
 type dockletServiceClient struct {
@@ -77,6 +93,74 @@ func (c *dockletServiceClient) ExecuteCommand(ctx context.Context, in *ExecuteCo
 	return out, nil
 }
 
+func (c *dockletServiceClient) ExecuteCommandStream(ctx context.Context, in *ExecuteCommandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExecuteCommandStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DockletService_ServiceDesc.Streams[1], DockletService_ExecuteCommandStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExecuteCommandRequest, ExecuteCommandStreamResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DockletService_ExecuteCommandStreamClient = grpc.ServerStreamingClient[ExecuteCommandStreamResponse]
+
+func (c *dockletServiceClient) RevokeNode(ctx context.Context, in *RevokeNodeRequest, opts ...grpc.CallOption) (*RevokeNodeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RevokeNodeResponse)
+	err := c.cc.Invoke(ctx, DockletService_RevokeNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dockletServiceClient) RotateNodeCertificate(ctx context.Context, in *RotateNodeCertificateRequest, opts ...grpc.CallOption) (*RotateNodeCertificateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RotateNodeCertificateResponse)
+	err := c.cc.Invoke(ctx, DockletService_RotateNodeCertificate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dockletServiceClient) UpdateNodeLabels(ctx context.Context, in *UpdateNodeLabelsRequest, opts ...grpc.CallOption) (*UpdateNodeLabelsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateNodeLabelsResponse)
+	err := c.cc.Invoke(ctx, DockletService_UpdateNodeLabels_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dockletServiceClient) ForwardCommand(ctx context.Context, in *ForwardCommandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ForwardCommandResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DockletService_ServiceDesc.Streams[2], DockletService_ForwardCommand_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ForwardCommandRequest, ForwardCommandResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DockletService_ForwardCommandClient = grpc.ServerStreamingClient[ForwardCommandResponse]
+
 // DockletServiceServer is the server API for DockletService service.
 // All implementations must embed UnimplementedDockletServiceServer
 // for forward compatibility.
@@ -87,6 +171,17 @@ type DockletServiceServer interface {
 	// Admin API
 	ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error)
 	ExecuteCommand(context.Context, *ExecuteCommandRequest) (*ExecuteCommandResponse, error)
+	// Like ExecuteCommand, with the command's output chunks (e.g. image pull
+	// progress) sent as they arrive, before the result.
+	ExecuteCommandStream(*ExecuteCommandRequest, grpc.ServerStreamingServer[ExecuteCommandStreamResponse]) error
+	// Certificate management
+	RevokeNode(context.Context, *RevokeNodeRequest) (*RevokeNodeResponse, error)
+	RotateNodeCertificate(context.Context, *RotateNodeCertificateRequest) (*RotateNodeCertificateResponse, error)
+	// Labels and taints
+	UpdateNodeLabels(context.Context, *UpdateNodeLabelsRequest) (*UpdateNodeLabelsResponse, error)
+	// Hub-to-hub: runs a command on an agent whose stream this replica holds,
+	// on behalf of the replica that received it.
+	ForwardCommand(*ForwardCommandRequest, grpc.ServerStreamingServer[ForwardCommandResponse]) error
 	mustEmbedUnimplementedDockletServiceServer()
 }
 
@@ -106,6 +201,21 @@ func (UnimplementedDockletServiceServer) ListNodes(context.Context, *ListNodesRe
 func (UnimplementedDockletServiceServer) ExecuteCommand(context.Context, *ExecuteCommandRequest) (*ExecuteCommandResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method ExecuteCommand not implemented")
 }
+func (UnimplementedDockletServiceServer) ExecuteCommandStream(*ExecuteCommandRequest, grpc.ServerStreamingServer[ExecuteCommandStreamResponse]) error {
+	return status.Error(codes.Unimplemented, "method ExecuteCommandStream not implemented")
+}
+func (UnimplementedDockletServiceServer) RevokeNode(context.Context, *RevokeNodeRequest) (*RevokeNodeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RevokeNode not implemented")
+}
+func (UnimplementedDockletServiceServer) RotateNodeCertificate(context.Context, *RotateNodeCertificateRequest) (*RotateNodeCertificateResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RotateNodeCertificate not implemented")
+}
+func (UnimplementedDockletServiceServer) UpdateNodeLabels(context.Context, *UpdateNodeLabelsRequest) (*UpdateNodeLabelsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method UpdateNodeLabels not implemented")
+}
+func (UnimplementedDockletServiceServer) ForwardCommand(*ForwardCommandRequest, grpc.ServerStreamingServer[ForwardCommandResponse]) error {
+	return status.Error(codes.Unimplemented, "method ForwardCommand not implemented")
+}
 func (UnimplementedDockletServiceServer) mustEmbedUnimplementedDockletServiceServer() {}
 func (UnimplementedDockletServiceServer) testEmbeddedByValue()                        {}
 
@@ -170,6 +280,82 @@ func _DockletService_ExecuteCommand_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DockletService_ExecuteCommandStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExecuteCommandRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DockletServiceServer).ExecuteCommandStream(m, &grpc.GenericServerStream[ExecuteCommandRequest, ExecuteCommandStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DockletService_ExecuteCommandStreamServer = grpc.ServerStreamingServer[ExecuteCommandStreamResponse]
+
+func _DockletService_RevokeNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DockletServiceServer).RevokeNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DockletService_RevokeNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DockletServiceServer).RevokeNode(ctx, req.(*RevokeNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DockletService_RotateNodeCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateNodeCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DockletServiceServer).RotateNodeCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DockletService_RotateNodeCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DockletServiceServer).RotateNodeCertificate(ctx, req.(*RotateNodeCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DockletService_UpdateNodeLabels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateNodeLabelsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DockletServiceServer).UpdateNodeLabels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DockletService_UpdateNodeLabels_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DockletServiceServer).UpdateNodeLabels(ctx, req.(*UpdateNodeLabelsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DockletService_ForwardCommand_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ForwardCommandRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DockletServiceServer).ForwardCommand(m, &grpc.GenericServerStream[ForwardCommandRequest, ForwardCommandResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DockletService_ForwardCommandServer = grpc.ServerStreamingServer[ForwardCommandResponse]
+
 // DockletService_ServiceDesc is the grpc.ServiceDesc for DockletService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -185,6 +371,18 @@ var DockletService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ExecuteCommand",
 			Handler:    _DockletService_ExecuteCommand_Handler,
 		},
+		{
+			MethodName: "RevokeNode",
+			Handler:    _DockletService_RevokeNode_Handler,
+		},
+		{
+			MethodName: "RotateNodeCertificate",
+			Handler:    _DockletService_RotateNodeCertificate_Handler,
+		},
+		{
+			MethodName: "UpdateNodeLabels",
+			Handler:    _DockletService_UpdateNodeLabels_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -193,6 +391,16 @@ var DockletService_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "ExecuteCommandStream",
+			Handler:       _DockletService_ExecuteCommandStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ForwardCommand",
+			Handler:       _DockletService_ForwardCommand_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "api/proto/v1/docklet.proto",
 }
@@ -1,16 +1,33 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/astracat/docklet/internal/agent"
 	"github.com/astracat/docklet/pkg/utils"
 )
 
+const (
+	caCertPath    = "certs/ca-cert.pem"
+	agentCertPath = "certs/agent-cert.pem"
+	agentKeyPath  = "certs/agent-key.pem"
+)
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "enroll" {
+		enroll(os.Args[2:])
+		return
+	}
+
 	hubAddrPtr := flag.String("hub", "localhost:50051", "Hub address (host:port)")
+	labelsFlag := flag.String("labels", os.Getenv("DOCKLET_NODE_LABELS"), "Node labels, e.g. region=eu,role=edge")
+	taintsFlag := flag.String("taints", os.Getenv("DOCKLET_NODE_TAINTS"), "Node taints, e.g. dedicated=db")
 	flag.Parse()
 
 	hubAddr := *hubAddrPtr
@@ -24,24 +41,84 @@ func main() {
 		log.Fatalf("Failed to get agent ID: %v", err)
 	}
 
-	log.Printf("Starting Docklet Agent (ID: %s)... connecting to %s", nodeID, hubAddr)
+	log.Printf("Starting Docklet Agent %s (ID: %s)... connecting to %s", agent.Version, nodeID, hubAddr)
 
 	// Check for certs locally
-	caCert := "certs/ca-cert.pem"
-	agentCert := "certs/agent-cert.pem"
-	agentKey := "certs/agent-key.pem"
+	caCert := caCertPath
 
 	// Check if files exist
 	if _, err := os.Stat(caCert); os.IsNotExist(err) {
-		log.Printf("Warning: Certs not found in certs/ directory. Using INSECURE mode.")
+		log.Printf("Warning: Certs not found in certs/ directory. Using INSECURE mode. Run `agent enroll` to get a certificate.")
 		caCert = ""
 	}
 
-	a := agent.NewAgent(hubAddr, nodeID, caCert, agentCert, agentKey)
-	if err := a.Start(); err != nil {
-		log.Printf("Agent crashed: %v. Retrying in 5 seconds...", err)
-        // Add a small delay/loop if needed, or let systemd restart it.
-        // But for better UX logs:
-		os.Exit(1)
+	a := agent.NewAgent(hubAddr, nodeID, caCert, agentCertPath, agentKeyPath)
+	a.Labels = parseLabels(*labelsFlag)
+	a.Taints = splitList(*taintsFlag)
+
+	// Reconnects on its own until SIGINT/SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := a.Run(ctx); err != nil {
+		log.Fatalf("Agent failed: %v", err)
+	}
+	log.Println("Agent stopped")
+}
+
+// parseLabels parses "key=value,key2=value2". The hub validates them.
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for _, item := range splitList(s) {
+		key, value, _ := strings.Cut(item, "=")
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// enroll gets this node its own certificate from the hub:
+//
+//	agent enroll --url https://<HUB_IP>:1499 --token <ENROLLMENT_TOKEN>
+func enroll(args []string) {
+	fs := flag.NewFlagSet("enroll", flag.ExitOnError)
+	hubURL := fs.String("url", "", "Hub dashboard URL (e.g. https://10.0.0.1:1499)")
+	token := fs.String("token", os.Getenv("DOCKLET_ENROLL_TOKEN"), "Single-use enrollment token")
+	insecure := fs.Bool("insecure", false, "Do not verify the dashboard TLS certificate (self-signed)")
+	force := fs.Bool("force", false, "Replace an existing certificate")
+	fs.Parse(args)
+
+	if *hubURL == "" || *token == "" {
+		log.Fatal("Usage: agent enroll --url <HUB_URL> --token <ENROLLMENT_TOKEN> [--insecure]")
+	}
+	if _, err := os.Stat(agentKeyPath); err == nil && !*force {
+		log.Fatalf("%s already exists; use --force to re-enroll", agentKeyPath)
+	}
+
+	nodeID, err := utils.GetOrGenerateID("agent.id")
+	if err != nil {
+		log.Fatalf("Failed to get agent ID: %v", err)
+	}
+
+	err = agent.Enroll(context.Background(), agent.EnrollOptions{
+		HubURL:   *hubURL,
+		Token:    *token,
+		NodeID:   nodeID,
+		Insecure: *insecure,
+		CACert:   caCertPath,
+		CertFile: agentCertPath,
+		KeyFile:  agentKeyPath,
+	})
+	if err != nil {
+		log.Fatalf("Enrollment failed: %v", err)
 	}
+	log.Printf("Enrolled node %s; certificate written to %s", nodeID, agentCertPath)
 }
@@ -101,6 +101,8 @@ func generateCert(name string, ca *x509.Certificate, caKey *rsa.PrivateKey, isSe
 	}
 
 	if isServer {
+		// Hub replicas forward commands to each other with this certificate
+		cert.Subject.OrganizationalUnit = []string{"docklet-hub"}
 		cert.IPAddresses = ips
 		if len(ips) == 0 {
 			cert.IPAddresses = []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
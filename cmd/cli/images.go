@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+var (
+	imagesAll     bool
+	imagesForce   bool
+	imagesNoPrune bool
+)
+
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Manage images on nodes",
+}
+
+// runImageCommand runs an image command on the target nodes and hands each
+// successful output to show. Pulls show their progress.
+func runImageCommand(command string, args []string, timeout time.Duration, show func(output []byte)) {
+	opts := getDialOptions()
+	conn, err := grpc.NewClient(hubAddr, opts...)
+	if err != nil {
+		fmt.Printf("Error connecting to hub: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	client := pb.NewDockletServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	nodes := targetNodes(ctx, client)
+	failed := false
+	for _, nodeID := range nodes {
+		if len(nodes) > 1 {
+			fmt.Printf("== %s ==\n", nodeID)
+		}
+		req := &pb.ExecuteCommandRequest{
+			NodeId:  nodeID,
+			Command: command,
+			Args:    args,
+		}
+		var resp *pb.ExecuteCommandResponse
+		if command == "image_pull" {
+			resp, err = executeWithProgress(ctx, client, req)
+		} else {
+			resp, err = client.ExecuteCommand(ctx, req)
+		}
+		if err != nil {
+			fmt.Printf("Error executing command: %v\n", err)
+			failed = true
+			continue
+		}
+		if resp.ExitCode != 0 {
+			fmt.Printf("Command failed (Exit Code %d): %s\n", resp.ExitCode, resp.Error)
+			failed = true
+			continue
+		}
+		show(resp.Output)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// imageOptionsArg encodes the --all/--force/--no-prune flags for the agent.
+func imageOptionsArg() []string {
+	opts := map[string]bool{}
+	if imagesAll {
+		opts["all"] = true
+	}
+	if imagesForce {
+		opts["force"] = true
+	}
+	if imagesNoPrune {
+		opts["noprune"] = true
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(opts)
+	return []string{string(b)}
+}
+
+func shortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+var imagesLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List images",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runImageCommand("image_ls", imageOptionsArg(), 15*time.Second, func(output []byte) {
+			var images []struct {
+				ID       string   `json:"Id"`
+				RepoTags []string `json:"RepoTags"`
+				Created  int64    `json:"Created"`
+				Size     int64    `json:"Size"`
+			}
+			if err := json.Unmarshal(output, &images); err != nil {
+				fmt.Println(string(output))
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE")
+			for _, img := range images {
+				tags := img.RepoTags
+				if len(tags) == 0 {
+					tags = []string{"<none>:<none>"}
+				}
+				created := time.Unix(img.Created, 0).Format("2006-01-02 15:04")
+				for _, t := range tags {
+					i := strings.LastIndex(t, ":")
+					repo, tag := t, "<none>"
+					if i > 0 && !strings.Contains(t[i:], "/") {
+						repo, tag = t[:i], t[i+1:]
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", repo, tag, shortImageID(img.ID), created, formatBytes(img.Size))
+				}
+			}
+			w.Flush()
+		})
+	},
+}
+
+var imagesInspectCmd = &cobra.Command{
+	Use:   "inspect [image]",
+	Short: "Show low-level information about an image",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImageCommand("image_inspect", args, 15*time.Second, func(output []byte) {
+			fmt.Println(string(output))
+		})
+	},
+}
+
+var imagesRmCmd = &cobra.Command{
+	Use:   "rm [image]",
+	Short: "Remove an image",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImageCommand("image_rm", append(args, imageOptionsArg()...), 30*time.Second, func(output []byte) {
+			var deleted []struct {
+				Deleted  string `json:"Deleted"`
+				Untagged string `json:"Untagged"`
+			}
+			if err := json.Unmarshal(output, &deleted); err != nil {
+				fmt.Println(string(output))
+				return
+			}
+			for _, d := range deleted {
+				if d.Untagged != "" {
+					fmt.Printf("Untagged: %s\n", d.Untagged)
+				}
+				if d.Deleted != "" {
+					fmt.Printf("Deleted: %s\n", d.Deleted)
+				}
+			}
+		})
+	},
+}
+
+var imagesPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove dangling images, or all unused images with --all",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runImageCommand("image_prune", imageOptionsArg(), 5*time.Minute, func(output []byte) {
+			var report struct {
+				ImagesDeleted  []struct{ Deleted, Untagged string }
+				SpaceReclaimed int64
+			}
+			if err := json.Unmarshal(output, &report); err != nil {
+				fmt.Println(string(output))
+				return
+			}
+			fmt.Printf("Removed %d images, reclaimed %s\n", len(report.ImagesDeleted), formatBytes(report.SpaceReclaimed))
+		})
+	},
+}
+
+var imagesPullCmd = &cobra.Command{
+	Use:   "pull [image]",
+	Short: "Pull an image",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImageCommand("image_pull", args, 10*time.Minute, func(output []byte) {
+			fmt.Println(string(output))
+		})
+	},
+}
+
+var imagesTagCmd = &cobra.Command{
+	Use:   "tag [source] [target]",
+	Short: "Tag an image",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImageCommand("image_tag", args, 15*time.Second, func(output []byte) {
+			fmt.Println(string(output))
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(imagesCmd)
+	imagesCmd.PersistentFlags().StringVar(&targetNodeID, "node", "", "Target Node ID")
+	imagesCmd.PersistentFlags().StringVarP(&targetSelector, "selector", "l", "", "Target nodes matching a label selector")
+
+	imagesCmd.AddCommand(imagesLsCmd)
+	imagesLsCmd.Flags().BoolVarP(&imagesAll, "all", "a", false, "Show intermediate images too")
+	imagesCmd.AddCommand(imagesInspectCmd)
+	imagesCmd.AddCommand(imagesRmCmd)
+	imagesRmCmd.Flags().BoolVarP(&imagesForce, "force", "f", false, "Remove the image even if containers use it")
+	imagesRmCmd.Flags().BoolVar(&imagesNoPrune, "no-prune", false, "Keep untagged parent images")
+	imagesCmd.AddCommand(imagesPruneCmd)
+	imagesPruneCmd.Flags().BoolVarP(&imagesAll, "all", "a", false, "Remove all unused images, not just dangling ones")
+	imagesCmd.AddCommand(imagesPullCmd)
+	imagesCmd.AddCommand(imagesTagCmd)
+}
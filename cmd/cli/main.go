@@ -6,6 +6,8 @@ import (
 	"crypto/x509"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -17,8 +19,9 @@ import (
 )
 
 var (
-	hubAddr      string
-	targetNodeID string
+	hubAddr        string
+	targetNodeID   string
+	targetSelector string
 )
 
 var rootCmd = &cobra.Command{
@@ -86,30 +89,178 @@ var nodesLsCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		resp, err := client.ListNodes(ctx, &pb.ListNodesRequest{})
+		resp, err := client.ListNodes(ctx, &pb.ListNodesRequest{Selector: targetSelector})
 		if err != nil {
 			fmt.Printf("Error listing nodes: %v\n", err)
 			os.Exit(1)
 		}
 
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-		fmt.Fprintln(w, "NODE ID\tMACHINE ID\tVERSION\tADDRESS\tSTATUS")
+		fmt.Fprintln(w, "NODE ID\tHOSTNAME\tOS\tKERNEL\tCPUS\tMEMORY\tDOCKER\tVERSION\tADDRESS\tSTATUS\tLABELS")
 		for _, node := range resp.Nodes {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", node.NodeId, node.MachineId, node.Version, node.RemoteAddr, node.Status)
+			host := node.GetHost()
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				node.NodeId, host.GetHostname(), host.GetOs(), host.GetKernel(), host.GetCpuCount(),
+				formatBytes(host.GetMemoryBytes()), host.GetDockerVersion(), node.Version, node.RemoteAddr, node.Status,
+				formatLabels(node.Labels))
 		}
 		w.Flush()
 	},
 }
 
-var psCmd = &cobra.Command{
-	Use:   "ps",
-	Short: "List containers on a node",
+// formatBytes renders a byte count as e.g. "15.6 GiB"; 0 means unknown.
+func formatBytes(n int64) string {
+	if n <= 0 {
+		return "-"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatLabels renders labels as sorted "key=value" pairs.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+var nodesLabelCmd = &cobra.Command{
+	Use:   "label [node-id] key=value... key-",
+	Short: "Set (key=value) or remove (key-) labels of a node",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		req := &pb.UpdateNodeLabelsRequest{NodeId: args[0], SetLabels: map[string]string{}}
+		for _, arg := range args[1:] {
+			if key, ok := strings.CutSuffix(arg, "-"); ok && !strings.Contains(arg, "=") {
+				req.RemoveLabels = append(req.RemoveLabels, key)
+				continue
+			}
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				fmt.Printf("Error: expected key=value or key-, got %q\n", arg)
+				os.Exit(1)
+			}
+			req.SetLabels[key] = value
+		}
+		updateNodeLabels(req)
+	},
+}
+
+var nodesTaintCmd = &cobra.Command{
+	Use:   "taint [node-id] key[=value]... key-",
+	Short: "Add or remove (key-) taints of a node",
+	Long:  `A tainted node is only targeted by selectors that name every taint key.`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		req := &pb.UpdateNodeLabelsRequest{NodeId: args[0]}
+		for _, arg := range args[1:] {
+			if key, ok := strings.CutSuffix(arg, "-"); ok && !strings.Contains(arg, "=") {
+				req.RemoveTaints = append(req.RemoveTaints, key)
+				continue
+			}
+			req.AddTaints = append(req.AddTaints, arg)
+		}
+		updateNodeLabels(req)
+	},
+}
+
+func updateNodeLabels(req *pb.UpdateNodeLabelsRequest) {
+	opts := getDialOptions()
+	conn, err := grpc.NewClient(hubAddr, opts...)
+	if err != nil {
+		fmt.Printf("Error connecting to hub: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	client := pb.NewDockletServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.UpdateNodeLabels(ctx, req)
+	if err != nil {
+		fmt.Printf("Error updating node: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Node %s\n  labels: %s\n", req.NodeId, formatLabels(resp.Labels))
+	if len(resp.Taints) > 0 {
+		fmt.Printf("  taints: %s\n", strings.Join(resp.Taints, ","))
+	}
+}
+
+// targetNodes returns --node, or the nodes matching --selector.
+func targetNodes(ctx context.Context, client pb.DockletServiceClient) []string {
+	if targetNodeID != "" {
+		return []string{targetNodeID}
+	}
+	if targetSelector == "" {
+		fmt.Println("Error: --node or --selector flag is required")
+		os.Exit(1)
+	}
+	resp, err := client.ListNodes(ctx, &pb.ListNodesRequest{Selector: targetSelector})
+	if err != nil {
+		fmt.Printf("Error listing nodes: %v\n", err)
+		os.Exit(1)
+	}
+	var ids []string
+	for _, node := range resp.Nodes {
+		ids = append(ids, node.NodeId)
+	}
+	if len(ids) == 0 {
+		fmt.Printf("No nodes match %q\n", targetSelector)
+		os.Exit(1)
+	}
+	return ids
+}
+
+var nodesRevokeCmd = &cobra.Command{
+	Use:   "revoke [node-id]",
+	Short: "Revoke all certificates of a node and disconnect it",
+	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		if targetNodeID == "" {
-			fmt.Println("Error: --node flag is required")
+		opts := getDialOptions()
+		conn, err := grpc.NewClient(hubAddr, opts...)
+		if err != nil {
+			fmt.Printf("Error connecting to hub: %v\n", err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+
+		client := pb.NewDockletServiceClient(conn)
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		resp, err := client.RevokeNode(ctx, &pb.RevokeNodeRequest{NodeId: args[0]})
+		if err != nil {
+			fmt.Printf("Error revoking node: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Printf("Node %s revoked (%d certificates)\n", args[0], len(resp.Serials))
+		for _, serial := range resp.Serials {
+			fmt.Printf("  %s\n", serial)
+		}
+	},
+}
 
+var nodesRotateCertCmd = &cobra.Command{
+	Use:   "rotate-cert [node-id]",
+	Short: "Issue a new certificate to a connected node",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
 		opts := getDialOptions()
 		conn, err := grpc.NewClient(hubAddr, opts...)
 		if err != nil {
@@ -119,24 +270,62 @@ var psCmd = &cobra.Command{
 		defer conn.Close()
 
 		client := pb.NewDockletServiceClient(conn)
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second) // Longer timeout for docker ops
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 		defer cancel()
 
-		resp, err := client.ExecuteCommand(ctx, &pb.ExecuteCommandRequest{
-			NodeId:  targetNodeID,
-			Command: "docker_ps",
-		})
+		resp, err := client.RotateNodeCertificate(ctx, &pb.RotateNodeCertificateRequest{NodeId: args[0]})
 		if err != nil {
-			fmt.Printf("Error executing command: %v\n", err)
+			fmt.Printf("Error rotating certificate: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Printf("New certificate %s for node %s, valid until %s\n",
+			resp.Serial, args[0], time.Unix(resp.ExpiresAt, 0).Format(time.RFC3339))
+	},
+}
 
-		if resp.ExitCode != 0 {
-			fmt.Printf("Command failed (Exit Code %d): %s\n%s\n", resp.ExitCode, resp.Error, string(resp.Output))
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List containers on a node",
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := getDialOptions()
+		conn, err := grpc.NewClient(hubAddr, opts...)
+		if err != nil {
+			fmt.Printf("Error connecting to hub: %v\n", err)
 			os.Exit(1)
 		}
+		defer conn.Close()
+
+		client := pb.NewDockletServiceClient(conn)
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second) // Longer timeout for docker ops
+		defer cancel()
 
-		fmt.Println(string(resp.Output))
+		nodes := targetNodes(ctx, client)
+		failed := false
+		for _, nodeID := range nodes {
+			if len(nodes) > 1 {
+				fmt.Printf("== %s ==\n", nodeID)
+			}
+			resp, err := client.ExecuteCommand(ctx, &pb.ExecuteCommandRequest{
+				NodeId:  nodeID,
+				Command: "docker_ps",
+			})
+			if err != nil {
+				fmt.Printf("Error executing command: %v\n", err)
+				failed = true
+				continue
+			}
+
+			if resp.ExitCode != 0 {
+				fmt.Printf("Command failed (Exit Code %d): %s\n%s\n", resp.ExitCode, resp.Error, string(resp.Output))
+				failed = true
+				continue
+			}
+
+			fmt.Println(string(resp.Output))
+		}
+		if failed {
+			os.Exit(1)
+		}
 	},
 }
 
@@ -145,11 +334,6 @@ var runCmd = &cobra.Command{
 	Short: "Run a container on a node",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		if targetNodeID == "" {
-			fmt.Println("Error: --node flag is required")
-			os.Exit(1)
-		}
-
 		imageName := args[0]
 
 		opts := getDialOptions()
@@ -161,28 +345,36 @@ var runCmd = &cobra.Command{
 		defer conn.Close()
 
 		client := pb.NewDockletServiceClient(conn)
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second) // Long timeout for pull
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute) // Long timeout for pull
 		defer cancel()
 
-		fmt.Printf("Requesting node %s to pull and run %s...\n", targetNodeID, imageName)
-
-		resp, err := client.ExecuteCommand(ctx, &pb.ExecuteCommandRequest{
-			NodeId:  targetNodeID,
-			Command: "docker_run",
-			Args:    []string{imageName},
-		})
-		if err != nil {
-			fmt.Printf("Error executing command: %v\n", err)
-			os.Exit(1)
+		failed := false
+		for _, nodeID := range targetNodes(ctx, client) {
+			fmt.Printf("Requesting node %s to pull and run %s...\n", nodeID, imageName)
+
+			resp, err := executeWithProgress(ctx, client, &pb.ExecuteCommandRequest{
+				NodeId:  nodeID,
+				Command: "docker_run",
+				Args:    []string{imageName},
+			})
+			if err != nil {
+				fmt.Printf("Error executing command: %v\n", err)
+				failed = true
+				continue
+			}
+
+			if resp.ExitCode != 0 {
+				fmt.Printf("Command failed (Exit Code %d): %s\n", resp.ExitCode, resp.Error)
+				failed = true
+				continue
+			}
+
+			containerID := string(resp.Output)
+			fmt.Printf("Container started successfully! ID: %s\n", containerID)
 		}
-
-		if resp.ExitCode != 0 {
-			fmt.Printf("Command failed (Exit Code %d): %s\n", resp.ExitCode, resp.Error)
+		if failed {
 			os.Exit(1)
 		}
-
-		containerID := string(resp.Output)
-		fmt.Printf("Container started successfully! ID: %s\n", containerID)
 	},
 }
 
@@ -190,12 +382,19 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&hubAddr, "hub", "localhost:50051", "Docklet Hub address")
 	rootCmd.AddCommand(nodesCmd)
 	nodesCmd.AddCommand(nodesLsCmd)
+	nodesLsCmd.Flags().StringVarP(&targetSelector, "selector", "l", "", "Label selector, e.g. region=eu,role=edge")
+	nodesCmd.AddCommand(nodesLabelCmd)
+	nodesCmd.AddCommand(nodesTaintCmd)
+	nodesCmd.AddCommand(nodesRevokeCmd)
+	nodesCmd.AddCommand(nodesRotateCertCmd)
 
 	rootCmd.AddCommand(psCmd)
 	psCmd.Flags().StringVar(&targetNodeID, "node", "", "Target Node ID")
+	psCmd.Flags().StringVarP(&targetSelector, "selector", "l", "", "Target nodes matching a label selector")
 
 	rootCmd.AddCommand(runCmd)
 	runCmd.Flags().StringVar(&targetNodeID, "node", "", "Target Node ID")
+	runCmd.Flags().StringVarP(&targetSelector, "selector", "l", "", "Target nodes matching a label selector")
 }
 
 func Execute() {
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// executeWithProgress runs a pulling command (docker_run, image_pull) and
+// shows the pull progress per layer as the agent reports it. Hubs without
+// streaming support run it without progress.
+func executeWithProgress(ctx context.Context, client pb.DockletServiceClient, req *pb.ExecuteCommandRequest) (*pb.ExecuteCommandResponse, error) {
+	stream, err := client.ExecuteCommandStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fd, isTerminal := term.GetFdInfo(os.Stdout)
+		if err := jsonmessage.DisplayJSONMessagesStream(pr, os.Stdout, fd, isTerminal, nil); err != nil {
+			// The error is also in the command result; keep draining.
+			io.Copy(io.Discard, pr)
+		}
+	}()
+	finish := func() {
+		pw.Close()
+		<-done
+	}
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			finish()
+			if status.Code(err) == codes.Unimplemented {
+				return client.ExecuteCommand(ctx, req)
+			}
+			return nil, err
+		}
+		switch payload := msg.Payload.(type) {
+		case *pb.ExecuteCommandStreamResponse_Chunk:
+			pw.Write(payload.Chunk)
+		case *pb.ExecuteCommandStreamResponse_Result:
+			finish()
+			return payload.Result, nil
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/astracat/docklet/internal/storage"
+)
+
+// certDir holds the hub CA and server certificate.
+const certDir = "certs"
+
+// backupFiles are the files of certDir a backup carries.
+var backupFiles = []string{"ca-cert.pem", "ca-key.pem", "server-cert.pem", "server-key.pem", "secrets.key", "secrets.salt"}
+
+// openStateStore opens the configured store the way the hub does, without
+// falling back to memory: backing up or restoring an empty store by mistake
+// is worse than failing. A file: store opened readOnly is not written to.
+func openStateStore(ctx context.Context, readOnly bool) storage.NodeRepository {
+	aliasPath, clusterStatePath := resolveStatePaths()
+	base, err := newBaseStore(ctx, clusterStatePath, readOnly)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, ok := base.(*storage.ClusterFileStore); ok {
+		log.Println("Note: the in-memory store keeps only aliases and clusters on disk; users, tokens and certificates of a running hub are not included")
+	}
+	store := storage.NewAliasBackupStore(base, aliasPath)
+	if err := store.Init(ctx); err != nil {
+		log.Fatalf("Failed to init store: %v", err)
+	}
+	return store
+}
+
+// backupPassphrase reads the passphrase from file, or from
+// DOCKLET_BACKUP_PASSPHRASE. Empty means no encryption.
+func backupPassphrase(file string) string {
+	if file == "" {
+		return os.Getenv("DOCKLET_BACKUP_PASSPHRASE")
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("Failed to read passphrase: %v", err)
+	}
+	return strings.TrimRight(string(b), "\r\n")
+}
+
+// backup writes nodes, aliases, users, certificates, clusters and the CA
+// material into one archive:
+//
+//	hub backup [-o file] [-passphrase-file file]
+func backup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "docklet-"+time.Now().Format("20060102-150405")+".backup", "archive to write")
+	passFile := fs.String("passphrase-file", "", "encrypt with the passphrase in this file (default: $DOCKLET_BACKUP_PASSPHRASE, if set)")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		log.Fatal("Usage: hub backup [-o file] [-passphrase-file file]")
+	}
+	passphrase := backupPassphrase(*passFile)
+
+	ctx := context.Background()
+	store := openStateStore(ctx, true)
+	defer store.Close()
+
+	b, err := storage.ExportBackup(ctx, store)
+	if err != nil {
+		log.Fatalf("Failed to read state: %v", err)
+	}
+	b.Files = make(map[string][]byte)
+	for _, name := range backupFiles {
+		data, err := os.ReadFile(filepath.Join(certDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", name, err)
+		}
+		b.Files[name] = data
+	}
+
+	tmp := *out + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", tmp, err)
+	}
+	err = storage.WriteBackup(f, b, passphrase)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, *out)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Fatalf("Failed to write backup: %v", err)
+	}
+
+	encrypted := "unencrypted"
+	if passphrase != "" {
+		encrypted = "encrypted"
+	}
+	log.Printf("Wrote %s backup %s: %s", encrypted, *out, backupSummary(b))
+}
+
+// restore validates an archive written by backup and imports it into the
+// configured store. CA files are written to certs/ if missing there:
+//
+//	hub restore [-passphrase-file file] [-dry-run] [-overwrite-certs] <file>
+func restore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	passFile := fs.String("passphrase-file", "", "decrypt with the passphrase in this file (default: $DOCKLET_BACKUP_PASSPHRASE)")
+	dryRun := fs.Bool("dry-run", false, "only validate the archive")
+	overwrite := fs.Bool("overwrite-certs", false, "replace CA files that differ from the backup")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: hub restore [-passphrase-file file] [-dry-run] [-overwrite-certs] <file>")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open backup: %v", err)
+	}
+	b, err := storage.ReadBackup(f, backupPassphrase(*passFile))
+	f.Close()
+	if err != nil {
+		log.Fatalf("Invalid backup: %v", err)
+	}
+	log.Printf("Backup of %s (version %d): %s", b.CreatedAt.Format(time.RFC3339), b.Version, backupSummary(b))
+	if *dryRun {
+		return
+	}
+
+	for name, data := range b.Files {
+		path := filepath.Join(certDir, name)
+		current, err := os.ReadFile(path)
+		switch {
+		case err == nil && bytes.Equal(current, data):
+			continue
+		case err == nil && !*overwrite:
+			log.Printf("Warning: %s differs from the backup, keeping it (use -overwrite-certs to replace it)", path)
+			continue
+		case err != nil && !os.IsNotExist(err):
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if err := os.MkdirAll(certDir, 0o700); err != nil {
+			log.Fatalf("Failed to create %s: %v", certDir, err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			log.Fatalf("Failed to write %s: %v", path, err)
+		}
+		log.Printf("Restored %s", path)
+	}
+
+	ctx := context.Background()
+	store := openStateStore(ctx, false)
+	defer store.Close()
+	if err := storage.ImportBackup(ctx, store, b); err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	log.Println("Restore complete")
+}
+
+func backupSummary(b *storage.Backup) string {
+	return fmt.Sprintf("%d nodes, %d aliases, %d users, %d tokens, %d certificates, %d clusters, %d registries, %d key files",
+		len(b.Nodes), len(b.Aliases), len(b.Users), len(b.Tokens), len(b.Certificates), len(b.Clusters), len(b.Registries), len(b.Files))
+}
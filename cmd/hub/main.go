@@ -9,40 +9,49 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/astracat/docklet/internal/server"
 	"github.com/astracat/docklet/internal/storage"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
-	dbURL := os.Getenv("DATABASE_URL")
-	dbURL = strings.TrimSpace(dbURL)
-	aliasPath := resolveAliasBackupPath()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		backup(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		restore(os.Args[2:])
+		return
+	}
 
-	ctx := context.Background()
-	var baseStore storage.NodeRepository
+	aliasPath, clusterStatePath := resolveStatePaths()
 
-	if dbURL == "" {
-		log.Println("DATABASE_URL not set, using in-memory node store with alias backup")
-		baseStore = storage.NewMemoryStore()
-	} else {
-		pgStore, err := storage.NewPostgresStore(ctx, dbURL)
-		if err != nil {
-			log.Printf("Warning: Failed to connect to database (%v). Falling back to IN-MEMORY storage.", err)
-			baseStore = storage.NewMemoryStore()
-		} else {
-			baseStore = pgStore
-		}
+	ctx := context.Background()
+	storeFallback := false
+	baseStore, err := newBaseStore(ctx, clusterStatePath, false)
+	if err != nil {
+		log.Printf("Warning: %v. Falling back to IN-MEMORY storage.", err)
+		baseStore = storage.NewClusterFileStore(storage.NewMemoryStore(), clusterStatePath)
+		storeFallback = true
 	}
 
 	var store storage.NodeRepository
 	primary := storage.NewAliasBackupStore(baseStore, aliasPath)
 	if err := primary.Init(ctx); err != nil {
 		log.Printf("Warning: Failed to init store with alias backup (%v). Falling back to IN-MEMORY.", err)
-		memStore := storage.NewMemoryStore()
+		storeFallback = true
+		memStore := storage.NewClusterFileStore(storage.NewMemoryStore(), clusterStatePath)
 		fallback := storage.NewAliasBackupStore(memStore, aliasPath)
 		if err2 := fallback.Init(ctx); err2 != nil {
 			log.Printf("Warning: Failed to init alias backup (%v). Running with plain in-memory storage.", err2)
@@ -64,8 +73,14 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
+	hubServer := server.NewDockletServer(store)
+	hubServer.StoreFallback = storeFallback
+	if err := hubServer.LoadRevocations(ctx); err != nil {
+		log.Printf("Warning: Failed to load certificate revocations: %v", err)
+	}
+
 	// TLS Configuration
-	creds, err := loadTLSCreds("certs/ca-cert.pem", "certs/server-cert.pem", "certs/server-key.pem")
+	creds, err := loadTLSCreds("certs/ca-cert.pem", "certs/server-cert.pem", "certs/server-key.pem", hubServer.VerifyPeerCertificate)
 	if err != nil {
 		log.Printf("Failed to load TLS credentials: %v. Running in INSECURE mode.", err)
 		// For demo purposes only
@@ -75,15 +90,41 @@ func main() {
 	if creds != nil {
 		opts = append(opts, grpc.Creds(creds))
 		log.Println("Secure mode (mTLS) ENABLED")
+		// Other replicas present the same certificate when they forward commands
+		if pair, err := tls.LoadX509KeyPair("certs/server-cert.pem", "certs/server-key.pem"); err == nil {
+			hubServer.HubCert = pair.Leaf
+		}
 	} else {
 		log.Println("WARNING: Secure mode DISABLED (Plainbox)")
 	}
 
 	s := grpc.NewServer(opts...)
-
-	hubServer := server.NewDockletServer(store)
 	hubServer.Register(s)
 
+	// Replicas sharing the database forward commands to the one holding the agent's stream
+	replicaID, replicaAddr := resolveReplica(port)
+	peerOpts, err := loadPeerDialOptions("certs/ca-cert.pem", "certs/server-cert.pem", "certs/server-key.pem")
+	if err != nil {
+		log.Printf("Failed to load hub-to-hub TLS credentials: %v. Connecting to other replicas INSECURELY.", err)
+	}
+	if err := hubServer.StartReplica(ctx, replicaID, replicaAddr, peerOpts...); err != nil {
+		log.Printf("Warning: Failed to register hub replica %s: %v", replicaID, err)
+	}
+
+	// The hub signs node certificates at enrollment
+	if ca, err := server.LoadCertAuthority("certs/ca-cert.pem", "certs/ca-key.pem"); err != nil {
+		log.Printf("CA key not available (%v). Agent enrollment DISABLED.", err)
+	} else {
+		hubServer.CA = ca
+	}
+
+	// Registry credentials are encrypted at rest with the hub's secret key
+	if box, err := server.LoadSecretBox(filepath.Join(certDir, "secrets.key"), filepath.Join(certDir, "secrets.salt")); err != nil {
+		log.Printf("Secret key not available (%v). Registry credentials DISABLED.", err)
+	} else {
+		hubServer.Secrets = box
+	}
+
 	// Start HTTP Server
 	go func() {
 		httpSrv := server.NewHTTPServer(hubServer, "./web/dashboard/dist")
@@ -98,6 +139,47 @@ func main() {
 	}
 }
 
+// resolveStatePaths returns the alias backup file and, next to it, the file
+// clusters are kept in without a database.
+func resolveStatePaths() (aliasPath, clusterStatePath string) {
+	aliasPath = resolveAliasBackupPath()
+	return aliasPath, filepath.Join(filepath.Dir(aliasPath), "clusters_state.json")
+}
+
+// newBaseStore opens the backend selected by DOCKLET_STORE and DATABASE_URL:
+// DOCKLET_STORE=file:<path> keeps all state in a local file instead of
+// Postgres, DOCKLET_STORE=memory ignores DATABASE_URL. With readOnly, a
+// file: store is opened for reading only.
+func newBaseStore(ctx context.Context, clusterStatePath string, readOnly bool) (storage.NodeRepository, error) {
+	storeSpec := strings.TrimSpace(os.Getenv("DOCKLET_STORE"))
+	dbURL := strings.TrimSpace(os.Getenv("DATABASE_URL"))
+	switch {
+	case strings.HasPrefix(storeSpec, "file:"):
+		path := strings.TrimPrefix(storeSpec, "file:")
+		open := storage.NewBoltStore
+		if readOnly {
+			open = storage.NewReadOnlyBoltStore
+		}
+		boltStore, err := open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open state file (%v)", err)
+		}
+		log.Printf("Using embedded store at %s", path)
+		return boltStore, nil
+	case storeSpec != "" && storeSpec != "memory":
+		return nil, fmt.Errorf("unknown DOCKLET_STORE %q (expected file:<path> or memory)", storeSpec)
+	case storeSpec == "memory" || dbURL == "":
+		log.Println("Using in-memory node store with alias backup")
+		return storage.NewClusterFileStore(storage.NewMemoryStore(), clusterStatePath), nil
+	default:
+		pgStore, err := storage.NewPostgresStore(ctx, dbURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database (%v)", err)
+		}
+		return pgStore, nil
+	}
+}
+
 func resolveAliasBackupPath() string {
 	custom := strings.TrimSpace(os.Getenv("DOCKLET_ALIASES_FILE"))
 	if custom != "" {
@@ -117,7 +199,149 @@ func resolveAliasBackupPath() string {
 	return filepath.Join(".docklet-data", "node_aliases.json")
 }
 
-func loadTLSCreds(caPath, certPath, keyPath string) (credentials.TransportCredentials, error) {
+// migrate manages the schema of the database at DATABASE_URL. The hub
+// applies pending migrations itself at startup; this is for inspecting them
+// and for reverting before a downgrade:
+//
+//	hub migrate status
+//	hub migrate up [version]
+//	hub migrate down [version]  (default: revert the latest migration)
+func migrate(args []string) {
+	const usage = "Usage: hub migrate status | up [version] | down [version]"
+	if len(args) == 0 || len(args) > 2 {
+		log.Fatal(usage)
+	}
+	target := -1
+	if len(args) == 2 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			log.Fatal(usage)
+		}
+		target = v
+	}
+	dbURL := strings.TrimSpace(os.Getenv("DATABASE_URL"))
+	if dbURL == "" {
+		log.Fatal("DATABASE_URL is required")
+	}
+
+	ctx := context.Background()
+	store, err := storage.NewPostgresStore(ctx, dbURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "status":
+		states, err := store.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, m := range states {
+			name, applied := m.Name, "pending"
+			if name == "" {
+				name = "(unknown, applied by a newer hub)"
+			}
+			if !m.AppliedAt.IsZero() {
+				applied = m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, name, applied)
+		}
+		w.Flush()
+
+	case "up":
+		applied, err := store.MigrateUp(ctx, max(target, 0))
+		for _, m := range applied {
+			log.Printf("Applied migration %d (%s)", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			log.Println("Schema is up to date")
+		}
+
+	case "down":
+		if target < 0 {
+			states, err := store.MigrationStatus(ctx)
+			if err != nil {
+				log.Fatalf("Failed to read migrations: %v", err)
+			}
+			for _, m := range states {
+				if !m.AppliedAt.IsZero() && m.Name != "" {
+					target = m.Version - 1
+				}
+			}
+			if target < 0 {
+				log.Println("No migrations to revert")
+				return
+			}
+		}
+		reverted, err := store.MigrateDown(ctx, target)
+		for _, m := range reverted {
+			log.Printf("Reverted migration %d (%s)", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(reverted) == 0 {
+			log.Println("No migrations to revert")
+		}
+
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// resolveReplica returns the replica ID (DOCKLET_HUB_ID, default the
+// hostname) and the gRPC address other replicas reach this hub at
+// (DOCKLET_HUB_ADDR, default hostname and port).
+func resolveReplica(port string) (id, addr string) {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "localhost"
+	}
+	id = strings.TrimSpace(os.Getenv("DOCKLET_HUB_ID"))
+	if id == "" {
+		id = hostname
+	}
+	addr = strings.TrimSpace(os.Getenv("DOCKLET_HUB_ADDR"))
+	if addr == "" {
+		addr = hostname + port
+	}
+	return id, addr
+}
+
+// loadPeerDialOptions connects to other replicas with the hub's own
+// certificate. DOCKLET_HUB_PEER_SERVER_NAME overrides the name their
+// certificates are checked against.
+func loadPeerDialOptions(caPath, certPath, keyPath string) ([]grpc.DialOption, error) {
+	insecureOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+
+	pemServerCA, err := os.ReadFile(caPath)
+	if err != nil {
+		return insecureOpts, err
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pemServerCA) {
+		return insecureOpts, fmt.Errorf("failed to add server CA's certificate")
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return insecureOpts, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      certPool,
+		ServerName:   strings.TrimSpace(os.Getenv("DOCKLET_HUB_PEER_SERVER_NAME")),
+	}
+	return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(config))}, nil
+}
+
+func loadTLSCreds(caPath, certPath, keyPath string, verifyPeer func([][]byte, [][]*x509.Certificate) error) (credentials.TransportCredentials, error) {
 	// Load existing CA
 	pemServerCA, err := os.ReadFile(caPath)
 	if err != nil {
@@ -140,6 +364,8 @@ func loadTLSCreds(caPath, certPath, keyPath string) (credentials.TransportCreden
 		Certificates: []tls.Certificate{serverCert},
 		ClientAuth:   tls.RequireAndVerifyClientCert,
 		ClientCAs:    certPool,
+		// Reject revoked client certificates
+		VerifyPeerCertificate: verifyPeer,
 	}
 
 	return credentials.NewTLS(config), nil
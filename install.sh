@@ -2,8 +2,8 @@
 set -e
 
 # Usage: 
-#   ./install.sh -install node <HUB_IP> [BOOTSTRAP_TOKEN]
-#   ./install.sh -install hub [BOOTSTRAP_TOKEN]
+#   ./install.sh -install node <HUB_IP> <ENROLLMENT_TOKEN>
+#   ./install.sh -install hub
 
 GREEN='\033[0;32m'
 CYAN='\033[0;36m'
@@ -105,13 +105,6 @@ fi
 
 # --- HUB INSTALLATION ---
 if [ "$MODE" == "hub" ]; then
-    BOOTSTRAP_TOKEN="$3"
-    if [ -z "$BOOTSTRAP_TOKEN" ]; then
-        # Generate random token if not provided
-        BOOTSTRAP_TOKEN=$(openssl rand -hex 16 2>/dev/null || echo "docklet-secret-token-$(date +%s)")
-        echo -e "🔑 Generated Bootstrap Token: ${CYAN}$BOOTSTRAP_TOKEN${NC}"
-    fi
-
     # Install Node.js for Dashboard
     echo -e "${GREEN}Installing Node.js (for Dashboard)...${NC}"
     if ! command -v node &> /dev/null; then
@@ -141,6 +134,7 @@ if [ "$MODE" == "hub" ]; then
     if [ -f "$CERTS_PERSIST_DIR/ca-cert.pem" ]; then
         mkdir -p certs
         cp "$CERTS_PERSIST_DIR/ca-cert.pem" certs/ca-cert.pem
+        cp "$CERTS_PERSIST_DIR/ca-key.pem" certs/ca-key.pem 2>/dev/null || true
         cp "$CERTS_PERSIST_DIR/server-cert.pem" certs/server-cert.pem 2>/dev/null || true
         cp "$CERTS_PERSIST_DIR/server-key.pem" certs/server-key.pem 2>/dev/null || true
         cp "$CERTS_PERSIST_DIR/agent-cert.pem" certs/agent-cert.pem 2>/dev/null || true
@@ -162,6 +156,7 @@ if [ "$MODE" == "hub" ]; then
     # Persist certs for future updates (prevents CA rotation on re-clone)
     if [ -f "certs/ca-cert.pem" ]; then
         $SUDO cp -f certs/ca-cert.pem "$CERTS_PERSIST_DIR/ca-cert.pem"
+        $SUDO cp -f certs/ca-key.pem "$CERTS_PERSIST_DIR/ca-key.pem" 2>/dev/null || true
         $SUDO cp -f certs/server-cert.pem "$CERTS_PERSIST_DIR/server-cert.pem" 2>/dev/null || true
         $SUDO cp -f certs/server-key.pem "$CERTS_PERSIST_DIR/server-key.pem" 2>/dev/null || true
         $SUDO cp -f certs/agent-cert.pem "$CERTS_PERSIST_DIR/agent-cert.pem" 2>/dev/null || true
@@ -211,13 +206,8 @@ if [ "$MODE" == "hub" ]; then
             echo "DOCKLET_WEB_CERT=/etc/docklet/web-cert.pem" | $SUDO tee -a /etc/docklet/hub.env > /dev/null
             echo "DOCKLET_WEB_KEY=/etc/docklet/web-key.pem" | $SUDO tee -a /etc/docklet/hub.env > /dev/null
         fi
-        
-        # Read token for display
-        source /etc/docklet/hub.env
-        BOOTSTRAP_TOKEN=$DOCKLET_BOOTSTRAP_TOKEN
     else
-        echo "DOCKLET_BOOTSTRAP_TOKEN=$BOOTSTRAP_TOKEN" | $SUDO tee /etc/docklet/hub.env > /dev/null
-        echo "DOCKLET_WEB_CERT=/etc/docklet/web-cert.pem" | $SUDO tee -a /etc/docklet/hub.env > /dev/null
+        echo "DOCKLET_WEB_CERT=/etc/docklet/web-cert.pem" | $SUDO tee /etc/docklet/hub.env > /dev/null
         echo "DOCKLET_WEB_KEY=/etc/docklet/web-key.pem" | $SUDO tee -a /etc/docklet/hub.env > /dev/null
         $SUDO chmod 600 /etc/docklet/hub.env
         $SUDO chown docklet:docklet /etc/docklet/hub.env
@@ -256,8 +246,6 @@ EOF
              set -a
              source /etc/docklet/hub.env
              set +a
-        else
-             export DOCKLET_BOOTSTRAP_TOKEN=$BOOTSTRAP_TOKEN
         fi
         
         pkill -f "bin/hub" || true
@@ -267,20 +255,19 @@ EOF
     fi
 
     echo -e "Dashboard: https://<YOUR_IP>:1499 (SSL Enabled)"
-    echo -e "Bootstrap Token: $BOOTSTRAP_TOKEN"
+    echo -e "Create a single-use enrollment token for each node (as admin):"
+    echo -e "  curl -sk -X POST -H \"Authorization: Bearer <TOKEN>\" https://<YOUR_IP>:1499/api/enrollment-tokens"
+    echo -e "Then on the node: ./install.sh -install node <YOUR_IP> <ENROLLMENT_TOKEN>"
 fi
 
 # --- NODE INSTALLATION ---
 if [ "$MODE" == "node" ]; then
     HUB_IP="$3"
-    BOOTSTRAP_TOKEN="$4"
+    ENROLL_TOKEN="${4:-$DOCKLET_ENROLL_TOKEN}"
 
     if [ -z "$HUB_IP" ]; then
         read -p "👉 Enter Hub IP (e.g. 192.168.1.5): " HUB_IP
     fi
-    if [ -z "$BOOTSTRAP_TOKEN" ]; then
-         BOOTSTRAP_TOKEN="${DOCKLET_BOOTSTRAP_TOKEN:-bootstrap-token-123}"
-    fi
     
     if [ -z "$HUB_IP" ]; then
         echo -e "${RED}❌ IP address is required.${NC}"
@@ -315,7 +302,8 @@ if [ "$MODE" == "node" ]; then
     export PATH=/usr/local/go/bin:$PATH
 
     echo -e "${GREEN}Building Agent...${NC}"
-    go build -o bin/agent ./cmd/agent
+    AGENT_VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+    go build -ldflags "-X github.com/astracat/docklet/internal/agent.Version=$AGENT_VERSION" -o bin/agent ./cmd/agent
 
     # Bootstrapping Certs
     # In Safe Mode (update), we check if certs exist.
@@ -324,32 +312,18 @@ if [ "$MODE" == "node" ]; then
         # We assume the HUB_IP didn't change, or if it did, mTLS might break if SANs don't match.
         # But usually updates are on same infra.
     else
-        echo -e "${GREEN}Bootstrapping Certs from $HUB_IP...${NC}"
-        # Try HTTPS first (since we enabled SSL), fallback to HTTP?
-        # For now, let's try HTTP port 1499. If we enabled SSL, it might be HTTPS only?
-        # The Go server uses StartTLS if env vars are set. If we set them on Hub, Hub is HTTPS.
-        # So we should try HTTPS first with -k (insecure) because self-signed.
-        
-        PROTO="https"
-        RESPONSE=$(curl -sk "https://$HUB_IP:1499/api/bootstrap/certs?token=$BOOTSTRAP_TOKEN")
-        
-        # Check if response is JSON or error
-        if ! echo "$RESPONSE" | jq -e . >/dev/null 2>&1; then
-             # Fallback to HTTP if HTTPS failed (maybe old hub?)
-             echo "HTTPS failed, trying HTTP..."
-             PROTO="http"
-             RESPONSE=$(curl -s "http://$HUB_IP:1499/api/bootstrap/certs?token=$BOOTSTRAP_TOKEN")
+        if [ -z "$ENROLL_TOKEN" ]; then
+            read -p "👉 Enter Enrollment Token: " ENROLL_TOKEN
         fi
-        
-        mkdir -p certs
-        echo "$RESPONSE" | jq -r .ca_cert > certs/ca-cert.pem
-        echo "$RESPONSE" | jq -r .agent_cert > certs/agent-cert.pem
-        echo "$RESPONSE" | jq -r .agent_key > certs/agent-key.pem
-
-        if [ ! -s certs/agent-key.pem ]; then
-            echo -e "${RED}❌ Failed to fetch certs. Check Hub IP ($PROTO) and Token.${NC}"
-            echo "Response: $RESPONSE"
-            exit 1
+        echo -e "${GREEN}Enrolling with $HUB_IP...${NC}"
+        # The dashboard certificate is self-signed, hence --insecure.
+        # Fall back to HTTP for hubs without web SSL.
+        if ! ./bin/agent enroll --url "https://$HUB_IP:1499" --token "$ENROLL_TOKEN" --insecure; then
+            echo "HTTPS failed, trying HTTP..."
+            if ! ./bin/agent enroll --url "http://$HUB_IP:1499" --token "$ENROLL_TOKEN"; then
+                echo -e "${RED}❌ Enrollment failed. Check Hub IP and that the token is unused and not expired.${NC}"
+                exit 1
+            fi
         fi
     fi
 
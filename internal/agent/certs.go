@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// tlsCreds loads the CA and the node certificate. The certificate is read
+// through a.clientCert on every handshake so a rotated one is used on the
+// next connection without restarting the agent.
+func (a *Agent) tlsCreds() (credentials.TransportCredentials, error) {
+	pemServerCA, err := os.ReadFile(a.CACert)
+	if err != nil {
+		return nil, err
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pemServerCA) {
+		return nil, fmt.Errorf("failed to add server CA's certificate")
+	}
+
+	clientCert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	a.clientCert.Store(&clientCert)
+
+	config := &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return a.clientCert.Load(), nil
+		},
+		RootCAs: certPool,
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// requestCertificate generates a new key, keeps it until the signed
+// certificate arrives, and returns a CSR for it (cert_request).
+func (a *Agent) requestCertificate() ([]byte, error) {
+	if a.clientCert.Load() == nil {
+		return nil, errors.New("agent runs without mTLS")
+	}
+	key, csrPEM, err := newKeyAndCSR(a.NodeID)
+	if err != nil {
+		return nil, err
+	}
+	a.certMu.Lock()
+	a.pendingKey = key
+	a.certMu.Unlock()
+	return csrPEM, nil
+}
+
+// installCertificate stores the certificate signed for the pending key and
+// uses it from the next handshake on (cert_install). The current stream is
+// not affected.
+func (a *Agent) installCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Subject.CommonName != a.NodeID {
+		return nil, fmt.Errorf("certificate is for %q, not this node", cert.Subject.CommonName)
+	}
+
+	a.certMu.Lock()
+	defer a.certMu.Unlock()
+	key := a.pendingKey
+	if key == nil {
+		return nil, errors.New("no certificate request pending")
+	}
+	if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || !pub.Equal(key.Public()) {
+		return nil, errors.New("certificate does not match the pending key")
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPair(a.CertFile, a.KeyFile, certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+	a.clientCert.Store(&pair)
+	a.pendingKey = nil
+	return cert, nil
+}
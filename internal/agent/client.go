@@ -3,25 +3,24 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	pb "github.com/astracat/docklet/api/proto/v1"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
 	"os/exec"
 )
 
@@ -32,6 +31,32 @@ type Agent struct {
 	CACert    string
 	CertFile  string
 	KeyFile   string
+
+	// Reported in the handshake; the hub merges them into the node's labels and taints.
+	Labels map[string]string
+	Taints []string
+
+	// gRPC client streams are not safe for concurrent Send.
+	sendMu sync.Mutex
+
+	// Running commands that can be cancelled by the hub.
+	// Key: CommandID, Value: context.CancelFunc
+	running sync.Map
+
+	// Interactive exec sessions
+	// Key: CommandID, Value: *execSession
+	execSessions sync.Map
+
+	// Current hub stream (nil while disconnected) and results waiting for it.
+	mu      sync.Mutex
+	stream  pb.DockletService_RegisterStreamClient
+	pending []*pb.CommandResult
+
+	// Node certificate used for new connections, and the key of a
+	// certificate rotation in progress.
+	clientCert atomic.Pointer[tls.Certificate]
+	certMu     sync.Mutex
+	pendingKey *ecdsa.PrivateKey
 }
 
 func NewAgent(hubAddr string, nodeID string, caCert, certFile, keyFile string) *Agent {
@@ -54,90 +79,103 @@ func NewAgent(hubAddr string, nodeID string, caCert, certFile, keyFile string) *
 	}
 }
 
-func (a *Agent) Start() error {
-	var opts []grpc.DialOption
-
-	if a.CACert != "" {
-		creds, err := loadTLSCreds(a.CACert, a.CertFile, a.KeyFile)
-		if err != nil {
-			return fmt.Errorf("failed to load TLS creds: %w", err)
-		}
-		opts = append(opts, grpc.WithTransportCredentials(creds))
-		log.Println("Secure mode (mTLS) ENABLED")
-	} else {
-		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-		log.Println("WARNING: Secure mode DISABLED")
-	}
-
-	// Connect to Hub
-	conn, err := grpc.NewClient(a.HubAddr, opts...)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	client := pb.NewDockletServiceClient(conn)
+// connect runs a single hub session: open the stream, handshake, deliver
+// results queued while disconnected and serve commands until the stream
+// breaks. established reports whether the hub acknowledged the session.
+func (a *Agent) connect(ctx context.Context, client pb.DockletServiceClient) (established bool, err error) {
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	// Establish stream
-	stream, err := client.RegisterStream(context.Background())
+	stream, err := client.RegisterStream(sessionCtx)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Send Handshake
-	err = stream.Send(&pb.StreamPayload{
+	err = a.send(stream, &pb.StreamPayload{
 		Payload: &pb.StreamPayload_Handshake{
 			Handshake: &pb.Handshake{
 				NodeId:    a.NodeID,
-				MachineId: "test-machine-id", // Implement real one later
-				Version:   "0.1.0",
+				MachineId: machineID(),
+				Version:   Version,
+				Host:      a.hostInfo(ctx),
+				Labels:    a.Labels,
+				Taints:    a.Taints,
 			},
 		},
 	})
 	if err != nil {
 		log.Printf("Failed to send handshake: %v", err)
-		return err
+		return false, err
 	}
 
+	a.attach(stream)
+	defer a.detach(stream)
+	go a.heartbeatLoop(sessionCtx, stream)
 	log.Println("Connected to Hub. Waiting for commands...")
 
 	// Listen loop
-	waitc := make(chan struct{})
-	go func() {
-		for {
-			in, err := stream.Recv()
-			if err == io.EOF {
-				close(waitc)
-				return
-			}
-			if err != nil {
-				log.Printf("Failed to receive a note : %v", err)
-				close(waitc)
-				return
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return established, nil
+		}
+		if err != nil {
+			return established, err
+		}
+		established = true
+
+		// Handle incoming messages
+		switch payload := in.Payload.(type) {
+		case *pb.StreamPayload_Heartbeat:
+			log.Printf("Received Heartbeat from Hub: %d", payload.Heartbeat.Timestamp)
+		case *pb.StreamPayload_Command:
+			cmd := payload.Command
+			log.Printf("Received Command: %s (ID: %s)", cmd.Type, cmd.Id)
+
+			// Execute Command. Commands outlive the session: if the stream
+			// breaks, the result is reported after reconnecting.
+			cmdCtx, cancel := context.WithCancel(context.Background())
+			a.running.Store(cmd.Id, cancel)
+			go func() {
+				defer func() {
+					a.running.Delete(cmd.Id)
+					cancel()
+				}()
+				a.handleCommand(cmdCtx, stream, cmd)
+			}()
+
+		case *pb.StreamPayload_Exec:
+			a.handleExecFrame(payload.Exec)
+
+		case *pb.StreamPayload_Cancel:
+			if cancel, ok := a.running.Load(payload.Cancel.CommandId); ok {
+				log.Printf("Cancelling command %s", payload.Cancel.CommandId)
+				cancel.(context.CancelFunc)()
 			}
 
-			// Handle incoming messages
-			switch payload := in.Payload.(type) {
-			case *pb.StreamPayload_Heartbeat:
-				log.Printf("Received Heartbeat from Hub: %d", payload.Heartbeat.Timestamp)
-			case *pb.StreamPayload_Command:
-				cmd := payload.Command
-				log.Printf("Received Command: %s (ID: %s)", cmd.Type, cmd.Id)
-
-				// Execute Command
-				go a.handleCommand(stream, cmd)
-
-			default:
-				log.Printf("Received unknown from Hub")
-			}
+		default:
+			log.Printf("Received unknown from Hub")
 		}
-	}()
+	}
+}
 
-	<-waitc
-	return nil
+// send serializes writes to the hub stream.
+func (a *Agent) send(stream pb.DockletService_RegisterStreamClient, payload *pb.StreamPayload) error {
+	a.sendMu.Lock()
+	defer a.sendMu.Unlock()
+	return stream.Send(payload)
 }
 
-func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd *pb.Command) {
+func (a *Agent) cancelRunning() {
+	a.running.Range(func(key, value interface{}) bool {
+		value.(context.CancelFunc)()
+		return true
+	})
+}
+
+func (a *Agent) handleCommand(ctx context.Context, stream pb.DockletService_RegisterStreamClient, cmd *pb.Command) {
 	var output []byte
 	var errStr string
 	var exitCode int32
@@ -180,10 +218,47 @@ func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd
 				exitCode = 1
 			} else {
 				containerID := cmd.Args[0]
-				out, err := a.DockerCli.ContainerLogs(context.Background(), containerID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: false})
+
+				// Optional Args[1]: JSON options
+				type LogsConfig struct {
+					Follow     bool   `json:"follow"`
+					Since      string `json:"since"`
+					Tail       string `json:"tail"`
+					Timestamps bool   `json:"timestamps"`
+				}
+				var config LogsConfig
+				if len(cmd.Args) > 1 && strings.TrimSpace(cmd.Args[1]) != "" {
+					if err := json.Unmarshal([]byte(cmd.Args[1]), &config); err != nil {
+						log.Printf("Invalid logs options for %s: %v", cmd.Id, err)
+					}
+				}
+
+				logsCtx := ctx
+				if config.Follow {
+					var stop context.CancelFunc
+					logsCtx, stop = bindToStream(ctx, stream)
+					defer stop()
+				}
+
+				out, err := a.DockerCli.ContainerLogs(logsCtx, containerID, container.LogsOptions{
+					ShowStdout: true,
+					ShowStderr: true,
+					Follow:     config.Follow,
+					Since:      config.Since,
+					Tail:       config.Tail,
+					Timestamps: config.Timestamps,
+				})
 				if err != nil {
 					errStr = err.Error()
 					exitCode = 1
+				} else if config.Follow {
+					defer out.Close()
+					if err := a.streamLogs(logsCtx, stream, cmd.Id, containerID, out); err != nil && logsCtx.Err() == nil {
+						errStr = err.Error()
+						exitCode = 1
+					} else {
+						exitCode = 0
+					}
 				} else {
 					defer out.Close()
 					logs, _ := io.ReadAll(out)
@@ -288,6 +363,20 @@ func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd
 				}
 			}
 		}
+	case "docker_exec_session":
+		if a.DockerCli == nil {
+			errStr = "docker client not initialized"
+			exitCode = 1
+		} else if len(cmd.Args) < 1 {
+			errStr = "container id required"
+			exitCode = 1
+		} else {
+			code, err := a.runExecSession(ctx, stream, cmd)
+			if err != nil {
+				errStr = err.Error()
+			}
+			exitCode = code
+		}
 	case "docker_run":
 		if a.DockerCli == nil {
 			errStr = "docker client not initialized"
@@ -299,15 +388,17 @@ func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd
 			} else {
 				// Parse Config
 				type RunConfig struct {
-					Image string `json:"image"`
-					Name  string `json:"name"`
-					AutoRestart *bool  `json:"auto_restart"`
+					Image         string `json:"image"`
+					Name          string `json:"name"`
+					AutoRestart   *bool  `json:"auto_restart"`
 					RestartPolicy string `json:"restart_policy"`
-					Ports []struct {
+					Ports         []struct {
 						Host      string `json:"host"`
 						Container string `json:"container"`
 					} `json:"ports"`
-					Env []string `json:"env"`
+					Env      []string     `json:"env"`
+					Networks []runNetwork `json:"networks"`
+					Volumes  []runVolume  `json:"volumes"`
 				}
 
 				var config RunConfig
@@ -319,25 +410,26 @@ func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd
 				if config.Image == "" {
 					errStr = "image name required"
 					exitCode = 1
+				} else if binds, mounts, err := volumeMounts(config.Volumes); err != nil {
+					errStr = "volume error: " + err.Error()
+					exitCode = 1
 				} else {
 					// 1. Pull Image
-					reader, err := a.DockerCli.ImagePull(context.Background(), config.Image, image.PullOptions{})
-					if err != nil {
+					if err := a.pullImage(context.Background(), stream, cmd, config.Image); err != nil {
 						errStr = "pull error: " + err.Error()
 						exitCode = 1
 					} else {
-						io.Copy(io.Discard, reader)
-						reader.Close()
-
 						// Prepare Config
 						containerConfig := &container.Config{
 							Image: config.Image,
 							Env:   config.Env,
 						}
 
-						// Prepare Host Config (Ports)
+						// Prepare Host Config (Ports, Volumes)
 						hostConfig := &container.HostConfig{
 							PortBindings: nat.PortMap{},
+							Binds:        binds,
+							Mounts:       mounts,
 						}
 
 						// Default auto-restart unless explicitly disabled
@@ -367,12 +459,20 @@ func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd
 							}
 						}
 
-						// 2. Create Container
-						resp, err := a.DockerCli.ContainerCreate(context.Background(), containerConfig, hostConfig, nil, nil, config.Name)
+						// 2. Create Container, attached to the first network
+						networkingConfig := firstNetwork(hostConfig, config.Networks)
+						resp, err := a.DockerCli.ContainerCreate(context.Background(), containerConfig, hostConfig, networkingConfig, nil, config.Name)
 
 						if err != nil {
 							errStr = "create error: " + err.Error()
 							exitCode = 1
+						} else if err := a.connectNetworks(context.Background(), resp.ID, config.Networks); err != nil {
+							errStr = "network error: " + err.Error()
+							exitCode = 1
+							// Don't leave a half-configured container behind.
+							if rmErr := a.DockerCli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true}); rmErr != nil {
+								log.Printf("Failed to remove container %s: %v", resp.ID, rmErr)
+							}
 						} else {
 							// 3. Start Container
 							if err := a.DockerCli.ContainerStart(context.Background(), resp.ID, container.StartOptions{}); err != nil {
@@ -407,9 +507,17 @@ func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd
 					errStr = "failed to write file: " + err.Error()
 					exitCode = 1
 				} else {
-					// 2. Run docker compose up -d
+					// 2. Run docker compose up -d, logged in to the stack's registries
 					c := exec.Command("docker", "compose", "-p", stackName, "-f", filePath, "up", "-d")
-					out, err := c.CombinedOutput()
+					configDir, err := dockerConfigDir(cmd)
+					if configDir != "" {
+						defer os.RemoveAll(configDir)
+						c.Env = append(os.Environ(), "DOCKER_CONFIG="+configDir)
+					}
+					var out []byte
+					if err == nil {
+						out, err = c.CombinedOutput()
+					}
 					if err != nil {
 						errStr = string(out) + "\n" + err.Error()
 						exitCode = 1
@@ -557,6 +665,33 @@ func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd
 				}
 			}
 		}
+	case "image_ls", "image_inspect", "image_rm", "image_prune", "image_pull", "image_tag":
+		out, err := a.handleImageCommand(ctx, stream, cmd)
+		if err != nil {
+			errStr = err.Error()
+			exitCode = 1
+		} else {
+			output = out
+			exitCode = 0
+		}
+	case "network_ls", "network_inspect", "network_create", "network_rm", "network_connect", "network_disconnect":
+		out, err := a.handleNetworkCommand(ctx, cmd)
+		if err != nil {
+			errStr = err.Error()
+			exitCode = 1
+		} else {
+			output = out
+			exitCode = 0
+		}
+	case "volume_ls", "volume_inspect", "volume_create", "volume_rm", "volume_prune":
+		out, err := a.handleVolumeCommand(ctx, cmd)
+		if err != nil {
+			errStr = err.Error()
+			exitCode = 1
+		} else {
+			output = out
+			exitCode = 0
+		}
 	case "node_rename":
 		if len(cmd.Args) < 1 {
 			errStr = "name required"
@@ -566,51 +701,84 @@ func (a *Agent) handleCommand(stream pb.DockletService_RegisterStreamClient, cmd
 			exitCode = 0
 		}
 
+	case "cert_request":
+		csr, err := a.requestCertificate()
+		if err != nil {
+			errStr = err.Error()
+			exitCode = 1
+		} else {
+			output = csr
+			exitCode = 0
+		}
+	case "cert_install":
+		if len(cmd.Args) < 1 {
+			errStr = "certificate required"
+			exitCode = 1
+		} else if cert, err := a.installCertificate([]byte(cmd.Args[0])); err != nil {
+			errStr = err.Error()
+			exitCode = 1
+		} else {
+			log.Printf("Installed new certificate, valid until %s", cert.NotAfter.Format(time.RFC3339))
+			output = []byte("installed")
+			exitCode = 0
+		}
+
 	default:
 		errStr = "unknown command type"
 		exitCode = 1
 	}
 
-	// Send Result
-	err := stream.Send(&pb.StreamPayload{
-		Payload: &pb.StreamPayload_Result{
-			Result: &pb.CommandResult{
-				CommandId: cmd.Id,
-				ExitCode:  exitCode,
-				Output:    output,
-				Error:     errStr,
-			},
-		},
+	a.sendResult(&pb.CommandResult{
+		CommandId: cmd.Id,
+		ExitCode:  exitCode,
+		Output:    output,
+		Error:     errStr,
 	})
+}
 
-	if err != nil {
-		log.Printf("Failed to send command result: %v", err)
+// streamLogs forwards followed container logs to the hub as CommandChunks until
+// the log stream ends or the hub cancels the command.
+func (a *Agent) streamLogs(ctx context.Context, stream pb.DockletService_RegisterStreamClient, cmdID, containerID string, logs io.Reader) error {
+	w := &chunkWriter{agent: a, stream: stream, commandID: cmdID}
+
+	// Non-TTY containers multiplex stdout/stderr with 8-byte frame headers.
+	tty := false
+	if info, err := a.DockerCli.ContainerInspect(ctx, containerID); err == nil && info.Config != nil {
+		tty = info.Config.Tty
+	}
+	if tty {
+		_, err := io.Copy(w, logs)
+		return err
 	}
+	_, err := stdcopy.StdCopy(w, w, logs)
+	return err
 }
 
-func loadTLSCreds(caPath, certPath, keyPath string) (credentials.TransportCredentials, error) {
-	// Load existing CA
-	pemServerCA, err := os.ReadFile(caPath)
-	if err != nil {
-		return nil, err
-	}
+// chunkWriter sends everything written to it as CommandChunks of a single command.
+type chunkWriter struct {
+	agent     *Agent
+	stream    pb.DockletService_RegisterStreamClient
+	commandID string
+	seq       int64
+}
 
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(pemServerCA) {
-		return nil, fmt.Errorf("failed to add server CA's certificate")
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
 	}
-
-	// Load client's cert and private key
-	clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	w.seq++
+	data := append([]byte(nil), p...)
+	err := w.agent.send(w.stream, &pb.StreamPayload{
+		Payload: &pb.StreamPayload_Chunk{
+			Chunk: &pb.CommandChunk{
+				CommandId: w.commandID,
+				Data:      data,
+				Seq:       w.seq,
+			},
+		},
+	})
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-
-	// Create creds object
-	config := &tls.Config{
-		Certificates: []tls.Certificate{clientCert},
-		RootCAs:      certPool,
-	}
-
-	return credentials.NewTLS(config), nil
+	return len(p), nil
 }
@@ -0,0 +1,16 @@
+//go:build linux
+
+package agent
+
+import "syscall"
+
+// diskUsage returns used and total bytes of the filesystem containing path.
+func diskUsage(path string) (used, total int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	total = int64(st.Blocks) * int64(st.Bsize)
+	used = total - int64(st.Bfree)*int64(st.Bsize)
+	return used, total, nil
+}
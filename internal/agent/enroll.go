@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EnrollOptions configures Enroll.
+type EnrollOptions struct {
+	HubURL string // dashboard URL of the hub, e.g. https://10.0.0.1:1499
+	Token  string // single-use enrollment token
+	NodeID string
+
+	// Skip verification of the dashboard certificate, which is self-signed
+	// by default. The returned CA certificate is trusted on first use.
+	Insecure bool
+
+	CACert   string
+	CertFile string
+	KeyFile  string
+}
+
+// Enroll generates a private key for the node, has the hub CA sign a
+// certificate for it and writes key, certificate and CA certificate.
+func Enroll(ctx context.Context, opts EnrollOptions) error {
+	key, csrPEM, err := newKeyAndCSR(opts.NodeID)
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"token":   opts.Token,
+		"node_id": opts.NodeID,
+		"csr":     string(csrPEM),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(opts.HubURL, "/")+"/api/bootstrap/enroll", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if opts.Insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("hub refused enrollment: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var certs struct {
+		CACert    string `json:"ca_cert"`
+		AgentCert string `json:"agent_cert"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&certs); err != nil {
+		return fmt.Errorf("invalid enrollment response: %w", err)
+	}
+	if certs.CACert == "" || certs.AgentCert == "" {
+		return fmt.Errorf("invalid enrollment response: missing certificates")
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(opts.CACert, []byte(certs.CACert), 0o644); err != nil {
+		return err
+	}
+	return writeKeyPair(opts.CertFile, opts.KeyFile, []byte(certs.AgentCert), keyPEM)
+}
+
+// newKeyAndCSR generates a node key and a CSR for it with CN nodeID.
+func newKeyAndCSR(nodeID string) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: nodeID},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), nil
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeKeyPair replaces certificate and key files. Both are written to
+// temporary files first so a failure leaves the previous pair intact.
+func writeKeyPair(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	certTmp, err := writeTemp(certFile, certPEM, 0o644)
+	if err != nil {
+		return err
+	}
+	keyTmp, err := writeTemp(keyFile, keyPEM, 0o600)
+	if err != nil {
+		os.Remove(certTmp)
+		return err
+	}
+	if err := os.Rename(keyTmp, keyFile); err != nil {
+		os.Remove(certTmp)
+		os.Remove(keyTmp)
+		return err
+	}
+	return os.Rename(certTmp, certFile)
+}
+
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := writeTemp(path, data, mode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeTemp writes data to a new file next to path and returns its name.
+func writeTemp(path string, data []byte, mode os.FileMode) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// errStdinOverflow ends an exec session whose process does not keep up with
+// its input.
+var errStdinOverflow = errors.New("exec input was not consumed fast enough")
+
+// execSession is an interactive exec attached to a container.
+// It is keyed in Agent.execSessions by the id of the command that opened it.
+type execSession struct {
+	execID string
+	conn   types.HijackedResponse
+	stdin  chan []byte // nil marks end of input
+
+	// Closed when stdin could not be queued; input is never dropped, the
+	// session ends with errStdinOverflow instead.
+	overflow     chan struct{}
+	overflowOnce sync.Once
+}
+
+// queueStdin queues input for the process without blocking the hub stream.
+func (s *execSession) queueStdin(data []byte) {
+	select {
+	case s.stdin <- data:
+	default:
+		s.overflowOnce.Do(func() { close(s.overflow) })
+	}
+}
+
+// runExecSession handles "docker_exec_session".
+// Args[0] is the container id, optional Args[1] is a JSON config.
+// Output is streamed to the hub as "stdout" ExecFrames, input arrives as
+// "stdin" frames. The session ends when the process exits or the hub cancels
+// the command; the process exit code is reported in an "exit" frame.
+func (a *Agent) runExecSession(ctx context.Context, stream pb.DockletService_RegisterStreamClient, cmd *pb.Command) (int32, error) {
+	type ExecSessionConfig struct {
+		Cmd  []string `json:"cmd"`
+		Tty  *bool    `json:"tty"`
+		Rows uint     `json:"rows"`
+		Cols uint     `json:"cols"`
+	}
+
+	// The terminal is only reachable through the session that opened it.
+	ctx, stop := bindToStream(ctx, stream)
+	defer stop()
+
+	containerID := strings.TrimSpace(cmd.Args[0])
+	var config ExecSessionConfig
+	if len(cmd.Args) > 1 && strings.TrimSpace(cmd.Args[1]) != "" {
+		if err := json.Unmarshal([]byte(cmd.Args[1]), &config); err != nil {
+			return 1, fmt.Errorf("invalid exec config: %w", err)
+		}
+	}
+	if len(config.Cmd) == 0 {
+		config.Cmd = []string{"/bin/sh"}
+	}
+	tty := config.Tty == nil || *config.Tty
+
+	var consoleSize *[2]uint
+	if tty && config.Rows > 0 && config.Cols > 0 {
+		consoleSize = &[2]uint{config.Rows, config.Cols}
+	}
+
+	created, err := a.DockerCli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          tty,
+		ConsoleSize:  consoleSize,
+		Cmd:          config.Cmd,
+	})
+	if err != nil {
+		return 1, err
+	}
+
+	conn, err := a.DockerCli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{
+		Tty:         tty,
+		ConsoleSize: consoleSize,
+	})
+	if err != nil {
+		return 1, err
+	}
+	defer conn.Close()
+
+	session := &execSession{
+		execID:   created.ID,
+		conn:     conn,
+		stdin:    make(chan []byte, 64),
+		overflow: make(chan struct{}),
+	}
+	a.execSessions.Store(cmd.Id, session)
+	defer a.execSessions.Delete(cmd.Id)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Feed stdin without blocking the hub receive loop.
+	go func() {
+		for {
+			select {
+			case data := <-session.stdin:
+				if data == nil {
+					_ = conn.CloseWrite()
+					return
+				}
+				if _, err := conn.Conn.Write(data); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Cancellation from the hub, or input piling up, closes the attached
+	// connection, which ends the copy below.
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-session.overflow:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	w := &execFrameWriter{agent: a, stream: stream, sessionID: cmd.Id}
+	if tty {
+		_, err = io.Copy(w, conn.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(w, w, conn.Reader)
+	}
+	select {
+	case <-session.overflow:
+		log.Printf("Exec session %s: %v", cmd.Id, errStdinOverflow)
+		return 1, errStdinOverflow
+	default:
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Exec session %s output error: %v", cmd.Id, err)
+	}
+
+	exitCode := a.execExitCode(created.ID)
+	sendErr := a.send(stream, &pb.StreamPayload{
+		Payload: &pb.StreamPayload_Exec{
+			Exec: &pb.ExecFrame{
+				SessionId: cmd.Id,
+				Kind:      "exit",
+				ExitCode:  exitCode,
+			},
+		},
+	})
+	if sendErr != nil {
+		log.Printf("Failed to send exec exit frame: %v", sendErr)
+	}
+	return exitCode, nil
+}
+
+// execExitCode waits briefly for the exec process to be reaped and returns its exit code.
+func (a *Agent) execExitCode(execID string) int32 {
+	for i := 0; i < 10; i++ {
+		inspect, err := a.DockerCli.ContainerExecInspect(context.Background(), execID)
+		if err != nil {
+			return -1
+		}
+		if !inspect.Running {
+			return int32(inspect.ExitCode)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return -1
+}
+
+// handleExecFrame applies a hub frame to the matching exec session.
+func (a *Agent) handleExecFrame(frame *pb.ExecFrame) {
+	val, ok := a.execSessions.Load(frame.SessionId)
+	if !ok {
+		log.Printf("Exec frame for unknown session %s", frame.SessionId)
+		return
+	}
+	session := val.(*execSession)
+
+	switch frame.Kind {
+	case "stdin":
+		if len(frame.Data) == 0 {
+			return
+		}
+		session.queueStdin(append([]byte(nil), frame.Data...))
+	case "resize":
+		if frame.Rows == 0 || frame.Cols == 0 {
+			return
+		}
+		err := a.DockerCli.ContainerExecResize(context.Background(), session.execID, container.ResizeOptions{
+			Height: uint(frame.Rows),
+			Width:  uint(frame.Cols),
+		})
+		if err != nil {
+			log.Printf("Exec session %s resize error: %v", frame.SessionId, err)
+		}
+	case "close":
+		// nil marks end of input, queued behind pending stdin.
+		session.queueStdin(nil)
+	default:
+		log.Printf("Unknown exec frame kind %q", frame.Kind)
+	}
+}
+
+// execFrameWriter sends everything written to it as "stdout" ExecFrames.
+type execFrameWriter struct {
+	agent     *Agent
+	stream    pb.DockletService_RegisterStreamClient
+	sessionID string
+}
+
+func (w *execFrameWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	err := w.agent.send(w.stream, &pb.StreamPayload{
+		Payload: &pb.StreamPayload_Exec{
+			Exec: &pb.ExecFrame{
+				SessionId: w.sessionID,
+				Kind:      "stdout",
+				Data:      append([]byte(nil), p...),
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+)
+
+// Version is the agent build version, set at build time:
+//
+//	go build -ldflags "-X github.com/astracat/docklet/internal/agent.Version=v1.2.3" ./cmd/agent
+var Version = "dev"
+
+// machineID returns the systemd/dbus machine id, or "" when unavailable.
+func machineID() string {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if b, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(b)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// hostInfo collects facts about the host for the handshake. Values the host
+// does not expose are taken from the Docker daemon when possible.
+func (a *Agent) hostInfo(ctx context.Context) *pb.HostInfo {
+	info := &pb.HostInfo{
+		Os:          osRelease(),
+		Kernel:      readTrimmed("/proc/sys/kernel/osrelease"),
+		Arch:        runtime.GOARCH,
+		CpuCount:    int32(runtime.NumCPU()),
+		MemoryBytes: memTotal(),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		info.Hostname = hostname
+	}
+
+	if a.DockerCli == nil {
+		return info
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	dockerInfo, err := a.DockerCli.Info(ctx)
+	if err != nil {
+		log.Printf("Failed to get Docker info: %v", err)
+		return info
+	}
+	info.DockerVersion = dockerInfo.ServerVersion
+	info.StorageDriver = dockerInfo.Driver
+	if info.Os == "" {
+		info.Os = dockerInfo.OperatingSystem
+	}
+	if info.Kernel == "" {
+		info.Kernel = dockerInfo.KernelVersion
+	}
+	if info.MemoryBytes == 0 {
+		info.MemoryBytes = dockerInfo.MemTotal
+	}
+	return info
+}
+
+// osRelease returns PRETTY_NAME from /etc/os-release.
+func osRelease() string {
+	f, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); ok {
+			return strings.Trim(value, `"'`)
+		}
+	}
+	return ""
+}
+
+// memTotal returns MemTotal from /proc/meminfo in bytes.
+func memTotal() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:       16318584 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+func readTrimmed(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
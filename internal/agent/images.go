@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+)
+
+// imageOptions is the optional JSON argument of the image commands. Filters
+// uses the Docker Engine API encoding, e.g. {"reference":["nginx"]}.
+type imageOptions struct {
+	All     bool   `json:"all"`
+	Force   bool   `json:"force"`
+	NoPrune bool   `json:"noprune"`
+	Filters string `json:"filters"`
+}
+
+func parseImageOptions(arg string) (imageOptions, filters.Args, error) {
+	var opts imageOptions
+	if strings.TrimSpace(arg) != "" {
+		if err := json.Unmarshal([]byte(arg), &opts); err != nil {
+			return opts, filters.Args{}, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+	f, err := filters.FromJSON(opts.Filters)
+	if err != nil {
+		return opts, filters.Args{}, fmt.Errorf("invalid filters: %w", err)
+	}
+	return opts, f, nil
+}
+
+// handleImageCommand runs the image_* commands:
+//
+//	image_ls      [options]           all, filters; JSON list of images
+//	image_inspect <image>             raw inspect JSON
+//	image_rm      <image> [options]   force, noprune; JSON list of deleted/untagged
+//	image_prune   [options]           all (also unused tagged images), filters; JSON report
+//	image_pull    <image>             progress is streamed when the hub asks for it
+//	image_tag     <source> <target>
+func (a *Agent) handleImageCommand(ctx context.Context, stream pb.DockletService_RegisterStreamClient, cmd *pb.Command) ([]byte, error) {
+	if a.DockerCli == nil {
+		return nil, errors.New("docker client not initialized")
+	}
+	arg := func(i int) string {
+		if i < len(cmd.Args) {
+			return strings.TrimSpace(cmd.Args[i])
+		}
+		return ""
+	}
+
+	switch cmd.Type {
+	case "image_ls":
+		opts, f, err := parseImageOptions(arg(0))
+		if err != nil {
+			return nil, err
+		}
+		images, err := a.DockerCli.ImageList(ctx, image.ListOptions{All: opts.All, Filters: f})
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(images)
+
+	case "image_inspect":
+		if arg(0) == "" {
+			return nil, errors.New("image name required")
+		}
+		_, raw, err := a.DockerCli.ImageInspectWithRaw(ctx, arg(0))
+		return raw, err
+
+	case "image_rm":
+		if arg(0) == "" {
+			return nil, errors.New("image name required")
+		}
+		opts, _, err := parseImageOptions(arg(1))
+		if err != nil {
+			return nil, err
+		}
+		deleted, err := a.DockerCli.ImageRemove(ctx, arg(0), image.RemoveOptions{Force: opts.Force, PruneChildren: !opts.NoPrune})
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(deleted)
+
+	case "image_prune":
+		opts, f, err := parseImageOptions(arg(0))
+		if err != nil {
+			return nil, err
+		}
+		if opts.All && !f.Contains("dangling") {
+			f.Add("dangling", "false")
+		}
+		report, err := a.DockerCli.ImagesPrune(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(report)
+
+	case "image_pull":
+		if arg(0) == "" {
+			return nil, errors.New("image name required")
+		}
+		if err := a.pullImage(ctx, stream, cmd, arg(0)); err != nil {
+			return nil, fmt.Errorf("pull error: %w", err)
+		}
+		return []byte("pulled " + arg(0)), nil
+
+	case "image_tag":
+		if arg(0) == "" || arg(1) == "" {
+			return nil, errors.New("source and target image required")
+		}
+		if err := a.DockerCli.ImageTag(ctx, arg(0), arg(1)); err != nil {
+			return nil, err
+		}
+		return []byte("tagged " + arg(1)), nil
+	}
+	return nil, fmt.Errorf("unknown image command %s", cmd.Type)
+}
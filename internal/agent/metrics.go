@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+)
+
+const defaultHeartbeatInterval = 15 * time.Second
+
+// heartbeatInterval reads DOCKLET_HEARTBEAT_INTERVAL (e.g. "30s"; plain numbers are seconds).
+func heartbeatInterval() time.Duration {
+	v := strings.TrimSpace(os.Getenv("DOCKLET_HEARTBEAT_INTERVAL"))
+	if v == "" {
+		return defaultHeartbeatInterval
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	log.Printf("Invalid DOCKLET_HEARTBEAT_INTERVAL %q, using %s", v, defaultHeartbeatInterval)
+	return defaultHeartbeatInterval
+}
+
+// heartbeatLoop sends a Heartbeat with host metrics every interval until ctx is done.
+func (a *Agent) heartbeatLoop(ctx context.Context, stream pb.DockletService_RegisterStreamClient) {
+	ticker := time.NewTicker(heartbeatInterval())
+	defer ticker.Stop()
+
+	// CPU usage is measured between two samples; take the first one now.
+	prevCPU, _ := readCPUTimes()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		metrics := a.collectMetrics(ctx)
+		if cur, ok := readCPUTimes(); ok {
+			metrics.CpuPercent = cur.percentSince(prevCPU)
+			prevCPU = cur
+		}
+
+		err := a.send(stream, &pb.StreamPayload{
+			Payload: &pb.StreamPayload_Heartbeat{
+				Heartbeat: &pb.Heartbeat{
+					Timestamp: time.Now().Unix(),
+					Metrics:   metrics,
+				},
+			},
+		})
+		if err != nil {
+			// The receive loop notices the broken stream and reconnects.
+			log.Printf("Failed to send heartbeat: %v", err)
+			return
+		}
+	}
+}
+
+// collectMetrics samples memory, disk and container counts.
+func (a *Agent) collectMetrics(ctx context.Context) *pb.NodeMetrics {
+	metrics := &pb.NodeMetrics{}
+	if total, available, ok := readMemInfo(); ok {
+		metrics.MemoryTotalBytes = total
+		metrics.MemoryUsedBytes = total - available
+	}
+
+	dataDir := "/"
+	if a.DockerCli != nil {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		info, err := a.DockerCli.Info(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to get Docker info: %v", err)
+		} else {
+			metrics.ContainersRunning = int32(info.ContainersRunning)
+			metrics.ContainersTotal = int32(info.Containers)
+			if info.DockerRootDir != "" {
+				dataDir = info.DockerRootDir
+			}
+		}
+	}
+
+	used, total, err := diskUsage(dataDir)
+	if err != nil && dataDir != "/" {
+		// The Docker root dir is not visible when the agent itself runs in a container.
+		used, total, err = diskUsage("/")
+	}
+	if err == nil {
+		metrics.DiskUsedBytes = used
+		metrics.DiskTotalBytes = total
+	}
+	return metrics
+}
+
+// cpuTimes holds the aggregate "cpu" line of /proc/stat, in clock ticks.
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+func (c cpuTimes) percentSince(prev cpuTimes) float64 {
+	total := c.total - prev.total
+	if prev.total == 0 || c.total <= prev.total {
+		return 0
+	}
+	busy := total - (c.idle - prev.idle)
+	return float64(busy) / float64(total) * 100
+}
+
+func readCPUTimes() (cpuTimes, bool) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return cpuTimes{}, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return cpuTimes{}, false
+	}
+	// cpu  user nice system idle iowait irq softirq steal guest guest_nice
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return cpuTimes{}, false
+	}
+
+	var times cpuTimes
+	// guest time is already included in user/nice.
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return cpuTimes{}, false
+		}
+		times.total += v
+		if i == 3 || i == 4 { // idle, iowait
+			times.idle += v
+		}
+	}
+	return times, true
+}
+
+// readMemInfo returns MemTotal and MemAvailable from /proc/meminfo in bytes.
+func readMemInfo() (total, available int64, ok bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb * 1024
+		case "MemAvailable:":
+			available = kb * 1024
+		}
+	}
+	return total, available, total > 0
+}
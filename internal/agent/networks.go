@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+)
+
+// networkOptions is the optional JSON argument of network_ls and
+// network_disconnect. Filters uses the Docker Engine API encoding.
+type networkOptions struct {
+	Force   bool   `json:"force"`
+	Filters string `json:"filters"`
+}
+
+// handleNetworkCommand runs the network_* commands:
+//
+//	network_ls         [options]                          filters; JSON list of networks
+//	network_inspect    <network>                          raw inspect JSON
+//	network_create     <request>                          Engine API create body ({"Name": ..., "Driver": ...}); JSON with the ID
+//	network_rm         <network>
+//	network_connect    <network> <container> [endpoint]   endpoint: Engine API EndpointSettings (Aliases, IPAMConfig)
+//	network_disconnect <network> <container> [options]    force
+func (a *Agent) handleNetworkCommand(ctx context.Context, cmd *pb.Command) ([]byte, error) {
+	if a.DockerCli == nil {
+		return nil, errors.New("docker client not initialized")
+	}
+	arg := func(i int) string {
+		if i < len(cmd.Args) {
+			return strings.TrimSpace(cmd.Args[i])
+		}
+		return ""
+	}
+	options := func(i int) (networkOptions, error) {
+		var opts networkOptions
+		if arg(i) != "" {
+			if err := json.Unmarshal([]byte(arg(i)), &opts); err != nil {
+				return opts, fmt.Errorf("invalid options: %w", err)
+			}
+		}
+		return opts, nil
+	}
+
+	switch cmd.Type {
+	case "network_ls":
+		opts, err := options(0)
+		if err != nil {
+			return nil, err
+		}
+		f, err := filters.FromJSON(opts.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filters: %w", err)
+		}
+		networks, err := a.DockerCli.NetworkList(ctx, network.ListOptions{Filters: f})
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(networks)
+
+	case "network_inspect":
+		if arg(0) == "" {
+			return nil, errors.New("network name required")
+		}
+		_, raw, err := a.DockerCli.NetworkInspectWithRaw(ctx, arg(0), network.InspectOptions{})
+		return raw, err
+
+	case "network_create":
+		var req network.CreateRequest
+		if err := json.Unmarshal([]byte(arg(0)), &req); err != nil {
+			return nil, fmt.Errorf("invalid network: %w", err)
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			return nil, errors.New("network name required")
+		}
+		resp, err := a.DockerCli.NetworkCreate(ctx, req.Name, req.CreateOptions)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(resp)
+
+	case "network_rm":
+		if arg(0) == "" {
+			return nil, errors.New("network name required")
+		}
+		if err := a.DockerCli.NetworkRemove(ctx, arg(0)); err != nil {
+			return nil, err
+		}
+		return []byte("removed " + arg(0)), nil
+
+	case "network_connect":
+		if arg(0) == "" || arg(1) == "" {
+			return nil, errors.New("network and container required")
+		}
+		var endpoint *network.EndpointSettings
+		if arg(2) != "" {
+			endpoint = &network.EndpointSettings{}
+			if err := json.Unmarshal([]byte(arg(2)), endpoint); err != nil {
+				return nil, fmt.Errorf("invalid endpoint settings: %w", err)
+			}
+		}
+		if err := a.DockerCli.NetworkConnect(ctx, arg(0), arg(1), endpoint); err != nil {
+			return nil, err
+		}
+		return []byte("connected " + arg(1) + " to " + arg(0)), nil
+
+	case "network_disconnect":
+		if arg(0) == "" || arg(1) == "" {
+			return nil, errors.New("network and container required")
+		}
+		opts, err := options(2)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.DockerCli.NetworkDisconnect(ctx, arg(0), arg(1), opts.Force); err != nil {
+			return nil, err
+		}
+		return []byte("disconnected " + arg(1) + " from " + arg(0)), nil
+	}
+	return nil, fmt.Errorf("unknown network command %s", cmd.Type)
+}
+
+// runNetwork is an entry of the docker_run "networks" field: a network name,
+// or {"name": ..., "aliases": [...], "ipv4_address": ...}.
+type runNetwork struct {
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases"`
+	IPv4Address string   `json:"ipv4_address"`
+}
+
+func (n *runNetwork) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		n.Name = name
+		return nil
+	}
+	type plain runNetwork
+	return json.Unmarshal(data, (*plain)(n))
+}
+
+func (n runNetwork) endpoint() *network.EndpointSettings {
+	ep := &network.EndpointSettings{Aliases: n.Aliases}
+	if n.IPv4Address != "" {
+		ep.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: n.IPv4Address}
+	}
+	return ep
+}
+
+// firstNetwork puts the container on the first of networks at creation,
+// instead of the default bridge. Daemons before API 1.44 take only one
+// network at creation; connectNetworks attaches the others.
+func firstNetwork(hostConfig *container.HostConfig, networks []runNetwork) *network.NetworkingConfig {
+	if len(networks) == 0 {
+		return nil
+	}
+	first := networks[0]
+	hostConfig.NetworkMode = container.NetworkMode(first.Name)
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{first.Name: first.endpoint()},
+	}
+}
+
+// connectNetworks attaches a created container to all but the first of
+// networks.
+func (a *Agent) connectNetworks(ctx context.Context, containerID string, networks []runNetwork) error {
+	for i := 1; i < len(networks); i++ {
+		n := networks[i]
+		if err := a.DockerCli.NetworkConnect(ctx, n.Name, containerID, n.endpoint()); err != nil {
+			return fmt.Errorf("%s: %w", n.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// pullProgressInterval limits how often the progress of one layer is
+// forwarded; status changes (e.g. "Download complete") always are.
+const pullProgressInterval = 500 * time.Millisecond
+
+// pullImage pulls ref with the registry credentials the hub sent with cmd.
+// When the hub relays the command's output (cmd.Stream), Docker's progress
+// messages are forwarded as CommandChunks, one JSON message per line in the
+// Docker Engine API format. A failed pull is reported by the returned error.
+func (a *Agent) pullImage(ctx context.Context, stream pb.DockletService_RegisterStreamClient, cmd *pb.Command, ref string) error {
+	reader, err := a.DockerCli.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: registryAuth(cmd, ref)})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var w *chunkWriter
+	if cmd.Stream && stream != nil {
+		w = &chunkWriter{agent: a, stream: stream, commandID: cmd.Id}
+	}
+	type layerState struct {
+		status string
+		sent   time.Time
+	}
+	layers := make(map[string]*layerState)
+
+	dec := json.NewDecoder(reader)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.ErrorMessage != "" {
+			return errors.New(msg.ErrorMessage)
+		}
+		if w == nil {
+			continue
+		}
+
+		now := time.Now()
+		if st := layers[msg.ID]; st != nil && st.status == msg.Status && now.Sub(st.sent) < pullProgressInterval {
+			continue
+		}
+		layers[msg.ID] = &layerState{status: msg.Status, sent: now}
+		line, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			w = nil // the hub is gone; finish the pull anyway
+		}
+	}
+}
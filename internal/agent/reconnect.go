@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = time.Minute
+
+	// Results kept for the hub while disconnected; the oldest are dropped first.
+	maxPendingResults = 256
+)
+
+// Run connects to the hub and keeps reconnecting with jittered exponential
+// backoff until ctx is cancelled. It only returns early on configuration errors.
+func (a *Agent) Run(ctx context.Context) error {
+	var opts []grpc.DialOption
+
+	if a.CACert != "" {
+		creds, err := a.tlsCreds()
+		if err != nil {
+			return fmt.Errorf("failed to load TLS creds: %w", err)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+		log.Println("Secure mode (mTLS) ENABLED")
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		log.Println("WARNING: Secure mode DISABLED")
+	}
+
+	defer a.cancelRunning()
+
+	attempt := 0
+	for {
+		// A new channel per session, so the TLS handshake picks up a
+		// rotated certificate instead of reusing the old connection.
+		conn, err := grpc.NewClient(a.HubAddr, opts...)
+		if err != nil {
+			return err
+		}
+		established, err := a.connect(ctx, pb.NewDockletServiceClient(conn))
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if established {
+			attempt = 0
+		}
+
+		delay := reconnectDelay(attempt)
+		attempt++
+		if err != nil {
+			log.Printf("Hub connection lost: %v. Reconnecting in %s", err, delay.Round(time.Millisecond))
+		} else {
+			log.Printf("Hub closed the stream. Reconnecting in %s", delay.Round(time.Millisecond))
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// reconnectDelay returns the wait before reconnect attempt n (0-based).
+// The delay doubles up to reconnectMaxDelay; half of it is random so agents
+// cut off at the same time do not hit the hub in lockstep.
+func reconnectDelay(attempt int) time.Duration {
+	d := reconnectMaxDelay
+	if attempt < 16 {
+		if exp := reconnectBaseDelay << attempt; exp < d {
+			d = exp
+		}
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// attach makes stream the destination for command results and flushes
+// results of commands that finished while the agent was disconnected.
+func (a *Agent) attach(stream pb.DockletService_RegisterStreamClient) {
+	a.mu.Lock()
+	a.stream = stream
+	pending := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+
+	if len(pending) > 0 {
+		log.Printf("Reporting %d command results from while disconnected", len(pending))
+	}
+	for i, res := range pending {
+		if err := a.sendOn(stream, res); err != nil {
+			log.Printf("Failed to report queued results: %v", err)
+			a.queueResults(pending[i:]...)
+			return
+		}
+	}
+}
+
+// detach stops routing results to stream; later results are queued.
+func (a *Agent) detach(stream pb.DockletService_RegisterStreamClient) {
+	a.mu.Lock()
+	if a.stream == stream {
+		a.stream = nil
+	}
+	a.mu.Unlock()
+}
+
+// sendResult reports a command result on the current stream, or queues it
+// until the next session when the agent is disconnected.
+func (a *Agent) sendResult(res *pb.CommandResult) {
+	a.mu.Lock()
+	stream := a.stream
+	if stream == nil {
+		a.mu.Unlock()
+		log.Printf("Hub not connected, queueing result of %s", res.CommandId)
+		a.queueResults(res)
+		return
+	}
+	a.mu.Unlock()
+
+	if err := a.sendOn(stream, res); err != nil {
+		log.Printf("Failed to send command result: %v. Queueing for reconnect", err)
+		a.queueResults(res)
+	}
+}
+
+func (a *Agent) sendOn(stream pb.DockletService_RegisterStreamClient, res *pb.CommandResult) error {
+	return a.send(stream, &pb.StreamPayload{
+		Payload: &pb.StreamPayload_Result{Result: res},
+	})
+}
+
+func (a *Agent) queueResults(results ...*pb.CommandResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = append(a.pending, results...)
+	if over := len(a.pending) - maxPendingResults; over > 0 {
+		log.Printf("Result queue full, dropping %d oldest results", over)
+		a.pending = append([]*pb.CommandResult(nil), a.pending[over:]...)
+	}
+}
+
+// bindToStream derives a context that is also cancelled when stream ends.
+// Streaming commands (followed logs, exec sessions) are useless once the
+// session that started them is gone, unlike one-shot commands.
+func bindToStream(ctx context.Context, stream pb.DockletService_RegisterStreamClient) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(stream.Context(), cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/astracat/docklet/pkg/utils"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerHubConfigKey is the key the docker CLI uses for Docker Hub in
+// config.json.
+const dockerHubConfigKey = "https://index.docker.io/v1/"
+
+// registryAuth returns the encoded credentials the hub sent with cmd for the
+// registry of ref, or "" to pull anonymously.
+func registryAuth(cmd *pb.Command, ref string) string {
+	host := utils.RegistryHost(ref)
+	for _, auth := range cmd.GetRegistryAuths() {
+		if auth.ServerAddress != host {
+			continue
+		}
+		encoded, err := registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			ServerAddress: auth.ServerAddress,
+		})
+		if err != nil {
+			return ""
+		}
+		return encoded
+	}
+	return ""
+}
+
+// dockerConfigDir writes the credentials the hub sent with cmd to a
+// temporary docker config directory for `docker compose`, which does not
+// take credentials any other way. Callers remove the directory when done;
+// it is "" when there are no credentials.
+func dockerConfigDir(cmd *pb.Command) (string, error) {
+	auths := cmd.GetRegistryAuths()
+	if len(auths) == 0 {
+		return "", nil
+	}
+	type authEntry struct {
+		Auth string `json:"auth"`
+	}
+	config := struct {
+		Auths map[string]authEntry `json:"auths"`
+	}{Auths: make(map[string]authEntry, len(auths))}
+	for _, auth := range auths {
+		key := auth.ServerAddress
+		if key == utils.DefaultRegistry {
+			key = dockerHubConfigKey
+		}
+		config.Auths[key] = authEntry{
+			Auth: base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password)),
+		}
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "docklet-docker-config-")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	// The compose plugin may be installed per user, in the config directory.
+	if home := defaultDockerConfigDir(); home != "" {
+		plugins := filepath.Join(home, "cli-plugins")
+		if _, err := os.Stat(plugins); err == nil {
+			_ = os.Symlink(plugins, filepath.Join(dir, "cli-plugins"))
+		}
+	}
+	return dir, nil
+}
+
+func defaultDockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker")
+}
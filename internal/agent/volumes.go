@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-units"
+)
+
+// volumeOptions is the optional JSON argument of volume_ls, volume_rm and
+// volume_prune. Filters uses the Docker Engine API encoding.
+type volumeOptions struct {
+	All     bool   `json:"all"`
+	Force   bool   `json:"force"`
+	Filters string `json:"filters"`
+}
+
+// handleVolumeCommand runs the volume_* commands:
+//
+//	volume_ls      [options]          filters; Engine API list response
+//	volume_inspect <volume>           raw inspect JSON
+//	volume_create  <request>          Engine API create body ({"Name": ..., "Driver": ...}); JSON volume
+//	volume_rm      <volume> [options] force
+//	volume_prune   [options]          all (also named volumes), filters; JSON report
+func (a *Agent) handleVolumeCommand(ctx context.Context, cmd *pb.Command) ([]byte, error) {
+	if a.DockerCli == nil {
+		return nil, errors.New("docker client not initialized")
+	}
+	arg := func(i int) string {
+		if i < len(cmd.Args) {
+			return strings.TrimSpace(cmd.Args[i])
+		}
+		return ""
+	}
+	options := func(i int) (volumeOptions, filters.Args, error) {
+		var opts volumeOptions
+		if arg(i) != "" {
+			if err := json.Unmarshal([]byte(arg(i)), &opts); err != nil {
+				return opts, filters.Args{}, fmt.Errorf("invalid options: %w", err)
+			}
+		}
+		f, err := filters.FromJSON(opts.Filters)
+		if err != nil {
+			return opts, filters.Args{}, fmt.Errorf("invalid filters: %w", err)
+		}
+		return opts, f, nil
+	}
+
+	switch cmd.Type {
+	case "volume_ls":
+		_, f, err := options(0)
+		if err != nil {
+			return nil, err
+		}
+		volumes, err := a.DockerCli.VolumeList(ctx, volume.ListOptions{Filters: f})
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(volumes)
+
+	case "volume_inspect":
+		if arg(0) == "" {
+			return nil, errors.New("volume name required")
+		}
+		_, raw, err := a.DockerCli.VolumeInspectWithRaw(ctx, arg(0))
+		return raw, err
+
+	case "volume_create":
+		var req volume.CreateOptions
+		if arg(0) != "" {
+			if err := json.Unmarshal([]byte(arg(0)), &req); err != nil {
+				return nil, fmt.Errorf("invalid volume: %w", err)
+			}
+		}
+		vol, err := a.DockerCli.VolumeCreate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(vol)
+
+	case "volume_rm":
+		if arg(0) == "" {
+			return nil, errors.New("volume name required")
+		}
+		opts, _, err := options(1)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.DockerCli.VolumeRemove(ctx, arg(0), opts.Force); err != nil {
+			return nil, err
+		}
+		return []byte("removed " + arg(0)), nil
+
+	case "volume_prune":
+		opts, f, err := options(0)
+		if err != nil {
+			return nil, err
+		}
+		// Since API 1.42 only anonymous volumes are pruned unless all is set.
+		if opts.All && !f.Contains("all") {
+			f.Add("all", "true")
+		}
+		report, err := a.DockerCli.VolumesPrune(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(report)
+	}
+	return nil, fmt.Errorf("unknown volume command %s", cmd.Type)
+}
+
+// runVolume is an entry of the docker_run "volumes" field: a
+// "source:target[:ro]" string as in `docker run -v`, or a mount object
+// {"type": "bind"|"volume"|"tmpfs", "source": ..., "target": ...,
+// "read_only": true, "size": "64m"}. Size only applies to tmpfs.
+type runVolume struct {
+	Spec     string `json:"-"`
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+	Size     string `json:"size"`
+}
+
+func (v *runVolume) UnmarshalJSON(data []byte) error {
+	var spec string
+	if err := json.Unmarshal(data, &spec); err == nil {
+		v.Spec = strings.TrimSpace(spec)
+		return nil
+	}
+	type plain runVolume
+	return json.Unmarshal(data, (*plain)(v))
+}
+
+// volumeMounts turns the docker_run volumes into host config binds (the
+// strings, kept as is) and mounts (the objects).
+func volumeMounts(volumes []runVolume) (binds []string, mounts []mount.Mount, err error) {
+	for _, v := range volumes {
+		if v.Spec != "" {
+			binds = append(binds, v.Spec)
+			continue
+		}
+		m, err := v.mount()
+		if err != nil {
+			return nil, nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return binds, mounts, nil
+}
+
+func (v runVolume) mount() (mount.Mount, error) {
+	target := strings.TrimSpace(v.Target)
+	if !path.IsAbs(target) {
+		return mount.Mount{}, fmt.Errorf("volume target %q must be an absolute path", v.Target)
+	}
+	source := strings.TrimSpace(v.Source)
+	m := mount.Mount{Source: source, Target: target, ReadOnly: v.ReadOnly}
+
+	typ := strings.ToLower(strings.TrimSpace(v.Type))
+	if typ == "" {
+		typ = string(mount.TypeVolume)
+		if strings.HasPrefix(source, "/") {
+			typ = string(mount.TypeBind)
+		}
+	}
+	switch mount.Type(typ) {
+	case mount.TypeBind:
+		if !path.IsAbs(source) {
+			return mount.Mount{}, fmt.Errorf("bind source %q must be an absolute path", v.Source)
+		}
+	case mount.TypeVolume:
+		// An empty source is an anonymous volume.
+	case mount.TypeTmpfs:
+		if source != "" {
+			return mount.Mount{}, fmt.Errorf("tmpfs mount %s takes no source", target)
+		}
+		if size := strings.TrimSpace(v.Size); size != "" {
+			bytes, err := units.RAMInBytes(size)
+			if err != nil {
+				return mount.Mount{}, fmt.Errorf("tmpfs size %q: %w", v.Size, err)
+			}
+			m.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: bytes}
+		}
+	default:
+		return mount.Mount{}, fmt.Errorf("unsupported volume type %q", v.Type)
+	}
+	m.Type = mount.Type(typ)
+	return m, nil
+}
@@ -0,0 +1,687 @@
+package server
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astracat/docklet/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	passwordHashIterations = 600000
+	minPasswordLength      = 8
+
+	defaultSessionTTL = 24 * time.Hour
+	defaultTokenTTL   = 90 * 24 * time.Hour
+	maxTokenTTL       = 365 * 24 * time.Hour
+
+	// Failed logins are counted per client address and per username from
+	// that address; past either limit logins are refused until the window
+	// of the first failure ends.
+	loginFailureWindow   = 15 * time.Minute
+	maxLoginFailuresAddr = 20
+	maxLoginFailuresUser = 5
+
+	apiTokenPrefix    = "dkt_"
+	enrollTokenPrefix = "dke_"
+)
+
+var roleRank = map[string]int{
+	storage.RoleViewer:   1,
+	storage.RoleOperator: 2,
+	storage.RoleAdmin:    3,
+}
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// dummyPasswordHash is checked for unknown users, so that a failed login
+// takes as long whether or not the user exists.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := hashPassword(uuid.NewString())
+	if err != nil {
+		log.Printf("Failed to create dummy password hash: %v", err)
+	}
+	return hash
+})
+
+func validRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// hasRole reports whether role grants at least the required role.
+func hasRole(role, required string) bool {
+	return roleRank[role] >= roleRank[required]
+}
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<key>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordHashIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// newToken returns a random token with prefix and the hash stored for it.
+func newToken(prefix string) (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = prefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionTTL reads DOCKLET_SESSION_TTL (e.g. "12h"), the lifetime of login tokens.
+func sessionTTL() time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("DOCKLET_SESSION_TTL"))); err == nil && d > 0 {
+		return d
+	}
+	return defaultSessionTTL
+}
+
+type principalKey struct{}
+
+// principal is the authenticated caller of an HTTP request.
+type principal struct {
+	User  *storage.User
+	Token *storage.APIToken
+}
+
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// authenticate resolves a bearer token to its user. Revocation and expiry
+// take effect immediately since tokens are looked up on every request.
+func (s *HTTPServer) authenticate(ctx context.Context, token string) (*principal, error) {
+	if token == "" {
+		return nil, errInvalidToken
+	}
+	repo := s.grpcServer.Repo
+	t, err := repo.GetTokenByHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || !t.RevokedAt.IsZero() || (!t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)) {
+		return nil, errInvalidToken
+	}
+	user, err := repo.GetUser(ctx, t.Username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errInvalidToken
+	}
+	return &principal{User: user, Token: t}, nil
+}
+
+// requiredRole is the authorization policy for every protected route:
+// reads need viewer, anything that changes state needs operator, and user,
+// enrollment and revocation management needs admin. Exec is a GET when it is a WebSocket upgrade but
+// runs arbitrary commands, so it needs operator as well.
+func requiredRole(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/users"), strings.HasPrefix(path, "/api/enrollment-tokens"):
+		// Enrollment tokens mint node credentials.
+		return storage.RoleAdmin
+	case strings.HasPrefix(path, "/api/nodes/") && strings.HasSuffix(path, "/revoke"):
+		return storage.RoleAdmin
+	case strings.HasPrefix(path, "/api/registries") && r.Method != http.MethodGet && r.Method != http.MethodHead:
+		// Registry credentials grant pull access beyond the hub.
+		return storage.RoleAdmin
+	case path == "/api/me", path == "/api/logout", strings.HasPrefix(path, "/api/tokens"):
+		// Everyone manages their own session and tokens.
+		return storage.RoleViewer
+	case strings.Contains(path, "/exec"):
+		return storage.RoleOperator
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return storage.RoleViewer
+	default:
+		return storage.RoleOperator
+	}
+}
+
+// ensureAdminUser creates the first admin from DOCKLET_ADMIN_USER and
+// DOCKLET_ADMIN_PASSWORD when there are no users yet.
+func (s *HTTPServer) ensureAdminUser(ctx context.Context) error {
+	users, err := s.grpcServer.Repo.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+
+	password := os.Getenv("DOCKLET_ADMIN_PASSWORD")
+	if password == "" {
+		password = defaultPass
+		log.Printf("WARNING: creating admin user %q with the default password; set DOCKLET_ADMIN_PASSWORD", adminUser())
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = s.grpcServer.Repo.CreateUser(ctx, &storage.User{
+		Username:     adminUser(),
+		PasswordHash: hash,
+		Role:         storage.RoleAdmin,
+		CreatedAt:    time.Now(),
+	})
+	if err != nil && !errors.Is(err, storage.ErrUserExists) {
+		return err
+	}
+	log.Printf("Created admin user %q", adminUser())
+	return nil
+}
+
+// issueToken creates a token for username and returns its plaintext.
+func (s *HTTPServer) issueToken(ctx context.Context, username, name string, ttl time.Duration) (string, *storage.APIToken, error) {
+	plaintext, hash, err := newToken(apiTokenPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	token := &storage.APIToken{
+		ID:        uuid.NewString(),
+		Username:  username,
+		Name:      name,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.grpcServer.Repo.CreateToken(ctx, token); err != nil {
+		return "", nil, err
+	}
+	return plaintext, token, nil
+}
+
+// revokeUserTokens revokes every valid token of username except keep.
+func (s *HTTPServer) revokeUserTokens(ctx context.Context, username, keep string) error {
+	tokens, err := s.grpcServer.Repo.ListTokens(ctx, username)
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.ID == keep || !t.RevokedAt.IsZero() {
+			continue
+		}
+		if err := s.grpcServer.Repo.RevokeToken(ctx, t.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *HTTPServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := principalFromContext(r.Context())
+	if err := s.grpcServer.Repo.RevokeToken(r.Context(), p.Token.ID); err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+// handleMe returns the authenticated user.
+func (s *HTTPServer) handleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := principalFromContext(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(p.User))
+}
+
+type UserResponse struct {
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func newUserResponse(u *storage.User) UserResponse {
+	return UserResponse{Username: u.Username, Role: u.Role, CreatedAt: u.CreatedAt.Unix()}
+}
+
+type UserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// handleUsers lists (GET) or creates (POST) users. Admin only.
+func (s *HTTPServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+	repo := s.grpcServer.Repo
+	switch r.Method {
+	case http.MethodGet:
+		users, err := repo.ListUsers(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to list users", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]UserResponse, 0, len(users))
+		for _, u := range users {
+			resp = append(resp, newUserResponse(u))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"users": resp})
+
+	case http.MethodPost:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		req.Username = strings.TrimSpace(req.Username)
+		if !usernamePattern.MatchString(req.Username) {
+			http.Error(w, "Invalid username", http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = storage.RoleViewer
+		}
+		if !validRole(req.Role) {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+		if len(req.Password) < minPasswordLength {
+			http.Error(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+			return
+		}
+
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+		user := &storage.User{
+			Username:     req.Username,
+			PasswordHash: hash,
+			Role:         req.Role,
+			CreatedAt:    time.Now(),
+		}
+		if err := repo.CreateUser(r.Context(), user); err != nil {
+			if errors.Is(err, storage.ErrUserExists) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newUserResponse(user))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUserAction updates (PUT/PATCH: password and/or role) or deletes a user. Admin only.
+func (s *HTTPServer) handleUserAction(w http.ResponseWriter, r *http.Request) {
+	repo := s.grpcServer.Repo
+	username := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/")
+	user, err := repo.GetUser(r.Context(), username)
+	if err != nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+	self := principalFromContext(r.Context()).User.Username == user.Username
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		// Sessions and tokens issued under the old password or a higher
+		// role are revoked.
+		revoke := false
+		if req.Role != "" && req.Role != user.Role {
+			if !validRole(req.Role) {
+				http.Error(w, "Invalid role", http.StatusBadRequest)
+				return
+			}
+			if user.Role == storage.RoleAdmin {
+				if ok, err := s.otherAdminExists(r.Context(), user.Username); err != nil || !ok {
+					http.Error(w, "Cannot demote the last admin", http.StatusConflict)
+					return
+				}
+			}
+			revoke = revoke || !hasRole(req.Role, user.Role)
+			user.Role = req.Role
+		}
+		if req.Password != "" {
+			if len(req.Password) < minPasswordLength {
+				http.Error(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+				return
+			}
+			hash, err := hashPassword(req.Password)
+			if err != nil {
+				http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+				return
+			}
+			user.PasswordHash = hash
+			revoke = true
+		}
+		if err := repo.UpdateUser(r.Context(), user); err != nil {
+			http.Error(w, "Failed to update user", http.StatusInternalServerError)
+			return
+		}
+		if revoke {
+			// Changing their own account does not log the caller out.
+			keep := ""
+			if self {
+				keep = principalFromContext(r.Context()).Token.ID
+			}
+			if err := s.revokeUserTokens(r.Context(), user.Username, keep); err != nil {
+				http.Error(w, "Failed to revoke tokens", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newUserResponse(user))
+
+	case http.MethodDelete:
+		if self {
+			http.Error(w, "Cannot delete yourself", http.StatusConflict)
+			return
+		}
+		if user.Role == storage.RoleAdmin {
+			if ok, err := s.otherAdminExists(r.Context(), user.Username); err != nil || !ok {
+				http.Error(w, "Cannot delete the last admin", http.StatusConflict)
+				return
+			}
+		}
+		if err := repo.DeleteUser(r.Context(), user.Username); err != nil {
+			http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *HTTPServer) otherAdminExists(ctx context.Context, username string) (bool, error) {
+	users, err := s.grpcServer.Repo.ListUsers(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, u := range users {
+		if u.Username != username && u.Role == storage.RoleAdmin {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type TokenResponse struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	Token     string `json:"token,omitempty"` // only when created
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	RevokedAt int64  `json:"revoked_at,omitempty"`
+}
+
+func newTokenResponse(t *storage.APIToken) TokenResponse {
+	resp := TokenResponse{
+		ID:        t.ID,
+		Username:  t.Username,
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt.Unix(),
+		ExpiresAt: t.ExpiresAt.Unix(),
+	}
+	if !t.RevokedAt.IsZero() {
+		resp.RevokedAt = t.RevokedAt.Unix()
+	}
+	return resp
+}
+
+// handleTokens lists (GET) or creates (POST) API tokens of the caller.
+// Admins may list another user's tokens with ?user=<name>, or all with ?user=*.
+func (s *HTTPServer) handleTokens(w http.ResponseWriter, r *http.Request) {
+	p := principalFromContext(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		username := p.User.Username
+		if u := strings.TrimSpace(r.URL.Query().Get("user")); u != "" && u != username {
+			if !hasRole(p.User.Role, storage.RoleAdmin) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			username = u
+			if u == "*" {
+				username = ""
+			}
+		}
+		tokens, err := s.grpcServer.Repo.ListTokens(r.Context(), username)
+		if err != nil {
+			http.Error(w, "Failed to list tokens", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]TokenResponse, 0, len(tokens))
+		for _, t := range tokens {
+			resp = append(resp, newTokenResponse(t))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"tokens": resp})
+
+	case http.MethodPost:
+		type CreateTokenRequest struct {
+			Name     string `json:"name"`
+			TTLHours int    `json:"ttl_hours"` // default 90 days
+		}
+		var req CreateTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		ttl := defaultTokenTTL
+		if req.TTLHours > int(maxTokenTTL/time.Hour) {
+			http.Error(w, fmt.Sprintf("ttl_hours must be at most %d", int(maxTokenTTL/time.Hour)), http.StatusBadRequest)
+			return
+		}
+		if req.TTLHours > 0 {
+			ttl = time.Duration(req.TTLHours) * time.Hour
+		}
+
+		plaintext, token, err := s.issueToken(r.Context(), p.User.Username, req.Name, ttl)
+		if err != nil {
+			http.Error(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+		resp := newTokenResponse(token)
+		resp.Token = plaintext
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTokenAction revokes a token (DELETE /api/tokens/{id}). Users may
+// revoke their own tokens, admins any token.
+func (s *HTTPServer) handleTokenAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := principalFromContext(r.Context())
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tokens/"), "/")
+
+	username := p.User.Username
+	if hasRole(p.User.Role, storage.RoleAdmin) {
+		username = ""
+	}
+	tokens, err := s.grpcServer.Repo.ListTokens(r.Context(), username)
+	if err != nil {
+		http.Error(w, "Failed to load tokens", http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, t := range tokens {
+		if t.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := s.grpcServer.Repo.RevokeToken(r.Context(), id); err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+// loginLimiter counts failed logins; the zero value is ready to use.
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count int
+	since time.Time
+}
+
+// loginKeys returns the limiter keys of a login attempt: the client address
+// and the username from that address.
+func loginKeys(r *http.Request, username string) (addr, user string) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host, "user:" + strings.ToLower(username) + "@" + host
+}
+
+// blocked reports how long logins with the keys are refused, or zero.
+func (l *loginLimiter) blocked(addr, user string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	for key, max := range map[string]int{addr: maxLoginFailuresAddr, user: maxLoginFailuresUser} {
+		f := l.failures[key]
+		if f == nil || f.count < max {
+			continue
+		}
+		if left := f.since.Add(loginFailureWindow).Sub(now); left > wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// fail records a failed login with the keys.
+func (l *loginLimiter) fail(addr, user string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.failures == nil {
+		l.failures = make(map[string]*loginFailures)
+	}
+	for key, f := range l.failures {
+		if now.Sub(f.since) >= loginFailureWindow {
+			delete(l.failures, key)
+		}
+	}
+	for _, key := range []string{addr, user} {
+		f := l.failures[key]
+		if f == nil {
+			f = &loginFailures{since: now}
+			l.failures[key] = f
+		}
+		f.count++
+	}
+}
+
+// succeed forgets the failures of the username from its address.
+func (l *loginLimiter) succeed(user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, user)
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// nodeCertOU marks certificates the hub CA issued to a node. Their CN is the node id.
+	nodeCertOU = "docklet-node"
+
+	// hubCertOU marks certificates issued to hub replicas, which may forward
+	// commands to each other.
+	hubCertOU = "docklet-hub"
+
+	// CN of the agent certificate certgen creates and older hubs handed to every agent.
+	sharedAgentCertCN = "docklet-agent"
+
+	defaultNodeCertDays = 365
+)
+
+// CertAuthority signs node certificates with the hub CA key.
+type CertAuthority struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+}
+
+// LoadCertAuthority reads the CA certificate and its private key (PKCS#1, SEC 1 or PKCS#8 PEM).
+func LoadCertAuthority(certPath, keyPath string) (*CertAuthority, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no certificate found", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s: not a CA certificate", certPath)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	return &CertAuthority{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no private key found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+}
+
+// CertPEM returns the CA certificate agents use to verify the hub.
+func (ca *CertAuthority) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// SignNodeCSR issues a client certificate for nodeID from a PEM encoded CSR.
+// Only the public key of the request is used; the subject is set by the hub.
+func (ca *CertAuthority) SignNodeCSR(csrPEM []byte, nodeID string) ([]byte, *x509.Certificate, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, errors.New("no certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	if csr.Subject.CommonName != nodeID {
+		return nil, nil, fmt.Errorf("CSR common name %q does not match node %q", csr.Subject.CommonName, nodeID)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization:       []string{"Docklet"},
+			OrganizationalUnit: []string{nodeCertOU},
+			CommonName:         nodeID,
+		},
+		NotBefore:   now.Add(-5 * time.Minute), // tolerate clock skew
+		NotAfter:    now.AddDate(0, 0, nodeCertDays()),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert, nil
+}
+
+// nodeCertDays reads DOCKLET_NODE_CERT_DAYS, the validity of issued node certificates.
+func nodeCertDays() int {
+	if days, err := strconv.Atoi(strings.TrimSpace(os.Getenv("DOCKLET_NODE_CERT_DAYS"))); err == nil && days > 0 {
+		return days
+	}
+	return defaultNodeCertDays
+}
+
+// peerCertificate returns the verified client certificate of the gRPC peer,
+// or nil when the hub runs without mTLS.
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return tlsInfo.State.VerifiedChains[0][0]
+}
+
+func isNodeCert(cert *x509.Certificate) bool {
+	return slices.Contains(cert.Subject.OrganizationalUnit, nodeCertOU)
+}
+
+// verifyNodeIdentity checks that the peer certificate was issued to nodeID,
+// so an agent cannot register as another node. Revocation is checked again
+// because a stream may reuse a connection established before it.
+func (s *DockletServer) verifyNodeIdentity(ctx context.Context, nodeID string) error {
+	cert := peerCertificate(ctx)
+	if cert == nil {
+		return nil // insecure mode, nothing to verify
+	}
+	if s.revoked.contains(certSerial(cert)) {
+		return status.Error(codes.PermissionDenied, "certificate revoked")
+	}
+	if isNodeCert(cert) && cert.Subject.CommonName == nodeID {
+		return nil
+	}
+	if cert.Subject.CommonName == sharedAgentCertCN && allowSharedAgentCert() {
+		log.Printf("WARNING: node %s uses the shared agent certificate; re-enroll it to get its own", nodeID)
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "certificate %q is not valid for node %q", cert.Subject.CommonName, nodeID)
+}
+
+// allowSharedAgentCert reports whether DOCKLET_ALLOW_SHARED_AGENT_CERT is
+// set, which keeps agents installed before enrollment connecting until they
+// are re-enrolled.
+func allowSharedAgentCert() bool {
+	v, _ := strconv.ParseBool(os.Getenv("DOCKLET_ALLOW_SHARED_AGENT_CERT"))
+	return v
+}
+
+// rejectNodeCert keeps node certificates to RegisterStream: an agent must
+// not list or command other nodes.
+func rejectNodeCert(ctx context.Context) error {
+	if cert := peerCertificate(ctx); cert != nil && (isNodeCert(cert) || cert.Subject.CommonName == sharedAgentCertCN) {
+		return status.Error(codes.PermissionDenied, "node certificates may only register agent streams")
+	}
+	return nil
+}
+
+// requirePeerHub keeps hub-to-hub calls to other replicas: the caller must
+// present this hub's own server certificate, as replicas sharing the certs
+// directory do, or one issued to a hub (OU docklet-hub). Without TLS there
+// is nothing to check.
+func (s *DockletServer) requirePeerHub(ctx context.Context) error {
+	cert := peerCertificate(ctx)
+	if cert == nil {
+		if s.HubCert == nil {
+			return nil
+		}
+		return status.Error(codes.PermissionDenied, "hub certificate required")
+	}
+	if slices.Contains(cert.Subject.OrganizationalUnit, hubCertOU) {
+		return nil
+	}
+	if s.HubCert != nil && bytes.Equal(cert.RawSubjectPublicKeyInfo, s.HubCert.RawSubjectPublicKeyInfo) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "certificate %q is not a hub certificate", cert.Subject.CommonName)
+}
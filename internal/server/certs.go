@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/astracat/docklet/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultCertRotateDays = 30
+
+// revocationList is the in-memory copy of revoked certificate serials,
+// consulted on every TLS handshake.
+type revocationList struct {
+	mu      sync.RWMutex
+	serials map[string]struct{}
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{serials: make(map[string]struct{})}
+}
+
+func (l *revocationList) contains(serial string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.serials[serial]
+	return ok
+}
+
+func (l *revocationList) add(serial string) {
+	l.mu.Lock()
+	l.serials[serial] = struct{}{}
+	l.mu.Unlock()
+}
+
+func certSerial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// LoadRevocations reads the revocation list from the repository.
+func (s *DockletServer) LoadRevocations(ctx context.Context) error {
+	certs, err := s.Repo.ListCertificates(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		if !cert.RevokedAt.IsZero() {
+			s.revoked.add(cert.Serial)
+		}
+	}
+	return nil
+}
+
+// VerifyPeerCertificate rejects revoked client certificates. It is meant
+// for tls.Config.VerifyPeerCertificate and runs after chain verification.
+func (s *DockletServer) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		if len(chain) > 0 && s.revoked.contains(certSerial(chain[0])) {
+			return errors.New("certificate revoked")
+		}
+	}
+	return nil
+}
+
+// issueNodeCert signs a CSR for nodeID and records the certificate.
+func (s *DockletServer) issueNodeCert(ctx context.Context, csrPEM []byte, nodeID string) ([]byte, *x509.Certificate, error) {
+	if s.CA == nil {
+		return nil, nil, errors.New("hub CA key not loaded")
+	}
+	certPEM, cert, err := s.CA.SignNodeCSR(csrPEM, nodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = s.Repo.SaveCertificate(ctx, &storage.NodeCertificate{
+		Serial:    certSerial(cert),
+		NodeID:    nodeID,
+		IssuedAt:  time.Now(),
+		ExpiresAt: cert.NotAfter,
+	})
+	if err != nil {
+		s.metrics.storageError("save_certificate")
+		return nil, nil, err
+	}
+	return certPEM, cert, nil
+}
+
+// revokeNodeCerts revokes the certificates of nodeID except keep. The
+// certificate of the live session is included even if the hub did not issue
+// it, e.g. one from before enrollment.
+func (s *DockletServer) revokeNodeCerts(ctx context.Context, nodeID, keep string) ([]string, error) {
+	certs, err := s.Repo.ListCertificates(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	known := make(map[string]bool, len(certs))
+	var revoked []string
+	for _, cert := range certs {
+		known[cert.Serial] = true
+		if cert.Serial == keep || !cert.RevokedAt.IsZero() {
+			continue
+		}
+		if err := s.Repo.RevokeCertificate(ctx, cert.Serial, now); err != nil {
+			return revoked, err
+		}
+		s.revoked.add(cert.Serial)
+		revoked = append(revoked, cert.Serial)
+	}
+
+	if val, ok := s.agents.Load(nodeID); ok {
+		// Never the shared agent certificate: revoking it would lock out every legacy agent.
+		if cert := val.(*AgentSession).Cert; cert != nil && isNodeCert(cert) {
+			serial := certSerial(cert)
+			if serial != keep && !known[serial] {
+				err := s.Repo.SaveCertificate(ctx, &storage.NodeCertificate{
+					Serial:    serial,
+					NodeID:    nodeID,
+					IssuedAt:  cert.NotBefore,
+					ExpiresAt: cert.NotAfter,
+					RevokedAt: now,
+				})
+				if err != nil {
+					return revoked, err
+				}
+				s.revoked.add(serial)
+				revoked = append(revoked, serial)
+			}
+		}
+	}
+	return revoked, nil
+}
+
+// RevokeNode revokes every certificate of a node and disconnects it. The
+// node can only come back by enrolling again.
+func (s *DockletServer) RevokeNode(ctx context.Context, req *pb.RevokeNodeRequest) (*pb.RevokeNodeResponse, error) {
+	if err := rejectNodeCert(ctx); err != nil {
+		return nil, err
+	}
+	nodeID := strings.TrimSpace(req.NodeId)
+	if nodeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "node id is required")
+	}
+
+	node, err := s.Repo.GetNode(ctx, nodeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get node: %v", err)
+	}
+	certs, err := s.Repo.ListCertificates(ctx, nodeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list certificates: %v", err)
+	}
+	if node == nil && len(certs) == 0 {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", nodeID)
+	}
+
+	revoked, err := s.revokeNodeCerts(ctx, nodeID, "")
+	if err != nil {
+		s.metrics.storageError("revoke_certificate")
+		return nil, status.Errorf(codes.Internal, "failed to revoke certificates: %v", err)
+	}
+	log.Printf("Revoked node %s by %s: %d certificates", nodeID, issuerFromContext(ctx), len(revoked))
+
+	if val, ok := s.agents.Load(nodeID); ok {
+		val.(*AgentSession).close(status.Error(codes.PermissionDenied, "node certificate revoked"))
+	}
+	return &pb.RevokeNodeResponse{Serials: revoked}, nil
+}
+
+// RotateNodeCertificate replaces the certificate of a connected agent over
+// its stream: the agent generates a new key and returns a CSR
+// (cert_request), the hub signs it, and the agent swaps in the new
+// certificate (cert_install) for its next connection. The current stream
+// stays up. Previous certificates are revoked afterwards.
+func (s *DockletServer) RotateNodeCertificate(ctx context.Context, req *pb.RotateNodeCertificateRequest) (*pb.RotateNodeCertificateResponse, error) {
+	if err := rejectNodeCert(ctx); err != nil {
+		return nil, err
+	}
+	nodeID := strings.TrimSpace(req.NodeId)
+	if s.CA == nil {
+		return nil, status.Error(codes.FailedPrecondition, "hub CA key not loaded")
+	}
+	if !s.nodeConnected(nodeID) {
+		return nil, status.Errorf(codes.NotFound, "node %s not connected", nodeID)
+	}
+	if _, busy := s.rotating.LoadOrStore(nodeID, struct{}{}); busy {
+		return nil, status.Errorf(codes.Aborted, "certificate rotation of node %s already in progress", nodeID)
+	}
+	defer s.rotating.Delete(nodeID)
+
+	resp, err := s.ExecuteCommand(ctx, &pb.ExecuteCommandRequest{NodeId: nodeID, Command: "cert_request"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.ExitCode != 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "agent could not create a CSR: %s", resp.Error)
+	}
+
+	certPEM, cert, err := s.issueNodeCert(ctx, resp.Output, nodeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to sign CSR: %v", err)
+	}
+	serial := certSerial(cert)
+
+	resp, err = s.ExecuteCommand(ctx, &pb.ExecuteCommandRequest{NodeId: nodeID, Command: "cert_install", Args: []string{string(certPEM)}})
+	if err == nil && resp.ExitCode != 0 {
+		err = status.Errorf(codes.FailedPrecondition, "agent could not install the certificate: %s", resp.Error)
+	}
+	if err != nil {
+		// The agent may or may not have the new certificate; keep it valid
+		// rather than lock the node out, and keep the old ones too.
+		return nil, err
+	}
+
+	revoked, err := s.revokeNodeCerts(ctx, nodeID, serial)
+	if err != nil {
+		s.metrics.storageError("revoke_certificate")
+		log.Printf("Failed to revoke previous certificates of %s: %v", nodeID, err)
+	}
+	log.Printf("Rotated certificate of %s: new serial %s, %d revoked", nodeID, serial, len(revoked))
+
+	return &pb.RotateNodeCertificateResponse{Serial: serial, ExpiresAt: cert.NotAfter.Unix()}, nil
+}
+
+// maybeRotate rotates the certificate of a new session when it expires
+// within DOCKLET_CERT_ROTATE_DAYS (default 30).
+func (s *DockletServer) maybeRotate(session *AgentSession) {
+	cert := session.Cert
+	if s.CA == nil || cert == nil || !isNodeCert(cert) {
+		return
+	}
+	days := defaultCertRotateDays
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("DOCKLET_CERT_ROTATE_DAYS"))); err == nil && v >= 0 {
+		days = v
+	}
+	if time.Until(cert.NotAfter) > time.Duration(days)*24*time.Hour {
+		return
+	}
+
+	log.Printf("Certificate of %s expires %s, rotating", session.NodeID, cert.NotAfter.Format(time.RFC3339))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if _, err := s.RotateNodeCertificate(ctx, &pb.RotateNodeCertificateRequest{NodeId: session.NodeID}); err != nil {
+		log.Printf("Failed to rotate certificate of %s: %v", session.NodeID, err)
+	}
+}
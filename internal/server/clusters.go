@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/astracat/docklet/internal/storage"
+)
+
+// Cluster is the API representation of a storage.Cluster.
+type Cluster struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	StackName string   `json:"stack_name"`
+	Content   string   `json:"content"`
+	Nodes     []string `json:"nodes"`
+	Selector  string   `json:"selector,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+	UpdatedAt int64    `json:"updated_at"`
+
+	// Per-node state kept by the reconciler
+	Sync map[string]NodeSync `json:"sync,omitempty"`
+
+	// Revision Content belongs to; the history is served by
+	// /api/clusters/{id}/revisions.
+	Revision int `json:"revision,omitempty"`
+}
+
+func newClusterResponse(c *storage.Cluster) Cluster {
+	return Cluster{
+		ID:        c.ID,
+		Name:      c.Name,
+		StackName: c.StackName,
+		Content:   c.Content,
+		Nodes:     c.Nodes,
+		Selector:  c.Selector,
+		CreatedAt: c.CreatedAt.Unix(),
+		UpdatedAt: c.UpdatedAt.Unix(),
+		Sync:      c.Sync,
+		Revision:  c.Revision,
+	}
+}
+
+// ClusterRevision is the API representation of a storage.ClusterRevision.
+type ClusterRevision struct {
+	Revision   int    `json:"revision"`
+	Content    string `json:"content,omitempty"`
+	Hash       string `json:"hash"`
+	DeployedBy string `json:"deployed_by,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+	RollbackOf int    `json:"rollback_of,omitempty"`
+
+	// Outcome of the deploy, set once it finished.
+	JobID   string           `json:"job_id,omitempty"`
+	Status  string           `json:"status,omitempty"`
+	Results []RevisionResult `json:"results,omitempty"`
+}
+
+// RevisionResult is the outcome of a revision's deploy on one node.
+type RevisionResult = storage.RevisionResult
+
+func newClusterRevisionResponse(rev *storage.ClusterRevision, withContent bool) ClusterRevision {
+	resp := ClusterRevision{
+		Revision:   rev.Revision,
+		Hash:       contentHash(rev.Content),
+		DeployedBy: rev.DeployedBy,
+		CreatedAt:  rev.CreatedAt.Unix(),
+		RollbackOf: rev.RollbackOf,
+		JobID:      rev.JobID,
+		Status:     rev.Status,
+		Results:    rev.Results,
+	}
+	if withContent {
+		resp.Content = rev.Content
+	}
+	return resp
+}
+
+func (s *HTTPServer) getCluster(ctx context.Context, id string) (*storage.Cluster, error) {
+	c, err := s.grpcServer.Repo.GetCluster(ctx, id)
+	if err != nil {
+		s.grpcServer.metrics.storageError("get_cluster")
+	}
+	return c, err
+}
+
+// seedClusterRevisions records the content of a cluster deployed before
+// revisions were kept as its first revision.
+func (s *HTTPServer) seedClusterRevisions(ctx context.Context, c *storage.Cluster) error {
+	if c.Revision != 0 || c.Content == "" {
+		return nil
+	}
+	rev := &storage.ClusterRevision{
+		ClusterID: c.ID,
+		Content:   c.Content,
+		CreatedAt: c.UpdatedAt,
+	}
+	if err := s.grpcServer.Repo.AddClusterRevision(ctx, rev); err != nil {
+		return err
+	}
+	c.Revision = rev.Revision
+	return nil
+}
+
+// legacyCluster is a cluster as stored in clusters.json.
+type legacyCluster struct {
+	Cluster
+	Revisions []ClusterRevision `json:"revisions"`
+}
+
+// ImportClustersFile moves the clusters of the legacy clusters.json at path
+// into repo and renames the file to path.imported. Clusters already in repo
+// are left alone. A missing file is not an error. repo must be the hub's
+// configured store, not a fallback.
+func ImportClustersFile(ctx context.Context, repo storage.ClusterRepository, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var clusters []legacyCluster
+	if len(bytesTrimSpace(b)) > 0 {
+		if err := json.Unmarshal(b, &clusters); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	imported := 0
+	for _, lc := range clusters {
+		existing, err := repo.GetCluster(ctx, lc.ID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		if err := importCluster(ctx, repo, lc); err != nil {
+			return fmt.Errorf("import cluster %s: %w", lc.ID, err)
+		}
+		imported++
+	}
+
+	if err := os.Rename(path, path+".imported"); err != nil {
+		return err
+	}
+	log.Printf("Imported %d clusters from %s", imported, path)
+	return nil
+}
+
+func importCluster(ctx context.Context, repo storage.ClusterRepository, lc legacyCluster) error {
+	c := &storage.Cluster{
+		ID:        lc.ID,
+		Name:      lc.Name,
+		StackName: lc.StackName,
+		Content:   lc.Content,
+		Nodes:     lc.Nodes,
+		Selector:  lc.Selector,
+		Sync:      lc.Sync,
+		CreatedAt: time.Unix(lc.CreatedAt, 0),
+		UpdatedAt: time.Unix(lc.UpdatedAt, 0),
+	}
+	if err := repo.SaveCluster(ctx, c); err != nil {
+		return err
+	}
+	if len(lc.Revisions) == 0 {
+		return nil
+	}
+
+	for _, lr := range lc.Revisions {
+		rev := &storage.ClusterRevision{
+			ClusterID:  c.ID,
+			Content:    lr.Content,
+			DeployedBy: lr.DeployedBy,
+			RollbackOf: lr.RollbackOf,
+			CreatedAt:  time.Unix(lr.CreatedAt, 0),
+			JobID:      lr.JobID,
+			Status:     lr.Status,
+			Results:    lr.Results,
+		}
+		if err := repo.AddClusterRevision(ctx, rev); err != nil {
+			return err
+		}
+		if err := repo.FinishClusterRevision(ctx, rev); err != nil {
+			return err
+		}
+	}
+	// Revisions are renumbered from 1, as they were in the file.
+	c.Revision = lc.Revision
+	return repo.SaveCluster(ctx, c)
+}
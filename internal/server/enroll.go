@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/astracat/docklet/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultEnrollTokenTTL = time.Hour
+	maxEnrollTokenTTL     = 30 * 24 * time.Hour
+)
+
+var nodeIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type EnrollmentTokenResponse struct {
+	ID        string `json:"id"`
+	Token     string `json:"token,omitempty"` // only when created
+	NodeID    string `json:"node_id,omitempty"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	UsedAt    int64  `json:"used_at,omitempty"`
+	UsedBy    string `json:"used_by,omitempty"`
+}
+
+func newEnrollmentTokenResponse(t *storage.EnrollmentToken) EnrollmentTokenResponse {
+	resp := EnrollmentTokenResponse{
+		ID:        t.ID,
+		NodeID:    t.NodeID,
+		CreatedBy: t.CreatedBy,
+		CreatedAt: t.CreatedAt.Unix(),
+		ExpiresAt: t.ExpiresAt.Unix(),
+		UsedBy:    t.UsedBy,
+	}
+	if !t.UsedAt.IsZero() {
+		resp.UsedAt = t.UsedAt.Unix()
+	}
+	return resp
+}
+
+// handleEnrollmentTokens lists (GET) or creates (POST) single-use agent
+// enrollment tokens. Admin only.
+func (s *HTTPServer) handleEnrollmentTokens(w http.ResponseWriter, r *http.Request) {
+	repo := s.grpcServer.Repo
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := repo.ListEnrollmentTokens(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to list enrollment tokens", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]EnrollmentTokenResponse, 0, len(tokens))
+		for _, t := range tokens {
+			resp = append(resp, newEnrollmentTokenResponse(t))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"tokens": resp})
+
+	case http.MethodPost:
+		type CreateEnrollmentTokenRequest struct {
+			NodeID     string `json:"node_id"`     // optional: bind the token to one node
+			TTLMinutes int    `json:"ttl_minutes"` // default 60
+		}
+		var req CreateEnrollmentTokenRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+		req.NodeID = strings.TrimSpace(req.NodeID)
+		if req.NodeID != "" && !nodeIDPattern.MatchString(req.NodeID) {
+			http.Error(w, "Invalid node_id", http.StatusBadRequest)
+			return
+		}
+		ttl := defaultEnrollTokenTTL
+		if req.TTLMinutes > int(maxEnrollTokenTTL/time.Minute) {
+			http.Error(w, fmt.Sprintf("ttl_minutes must be at most %d", int(maxEnrollTokenTTL/time.Minute)), http.StatusBadRequest)
+			return
+		}
+		if req.TTLMinutes > 0 {
+			ttl = time.Duration(req.TTLMinutes) * time.Minute
+		}
+
+		plaintext, hash, err := newToken(enrollTokenPrefix)
+		if err != nil {
+			http.Error(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		token := &storage.EnrollmentToken{
+			ID:        uuid.NewString(),
+			TokenHash: hash,
+			NodeID:    req.NodeID,
+			CreatedBy: issuerFromContext(r.Context()),
+			CreatedAt: now,
+			ExpiresAt: now.Add(ttl),
+		}
+		if err := repo.CreateEnrollmentToken(r.Context(), token); err != nil {
+			http.Error(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+		resp := newEnrollmentTokenResponse(token)
+		resp.Token = plaintext
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleEnrollmentTokenAction deletes an enrollment token (DELETE /api/enrollment-tokens/{id}).
+func (s *HTTPServer) handleEnrollmentTokenAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/enrollment-tokens/"), "/")
+	if err := s.grpcServer.Repo.DeleteEnrollmentToken(r.Context(), id); err != nil {
+		http.Error(w, "Failed to delete token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+type EnrollRequest struct {
+	Token  string `json:"token"`
+	NodeID string `json:"node_id"`
+	CSR    string `json:"csr"` // PEM, CN must be node_id
+}
+
+type EnrollResponse struct {
+	CACert    string `json:"ca_cert"`
+	AgentCert string `json:"agent_cert"`
+}
+
+// handleEnroll exchanges an enrollment token and a CSR for a node
+// certificate signed by the hub CA. The private key never leaves the node.
+func (s *HTTPServer) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ca := s.grpcServer.CA
+	if ca == nil {
+		http.Error(w, "Enrollment disabled: hub CA key not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req EnrollRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !nodeIDPattern.MatchString(req.NodeID) {
+		http.Error(w, "Invalid node_id", http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.grpcServer.Repo.ConsumeEnrollmentToken(r.Context(), hashToken(req.Token), req.NodeID, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to check token", http.StatusInternalServerError)
+		return
+	}
+	if token == nil {
+		// Delay to slow down guessing (basic)
+		time.Sleep(100 * time.Millisecond)
+		http.Error(w, "Invalid enrollment token", http.StatusUnauthorized)
+		return
+	}
+
+	// The token is spent even if the CSR turns out to be invalid.
+	cert, _, err := s.grpcServer.issueNodeCert(r.Context(), []byte(req.CSR), req.NodeID)
+	if err != nil {
+		http.Error(w, "Invalid CSR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Enrolled node %s from %s (token %s)", req.NodeID, r.RemoteAddr, token.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EnrollResponse{
+		CACert:    string(ca.CertPEM()),
+		AgentCert: string(cert),
+	})
+}
+
+// handleBootstrapCerts used to hand every agent the same certificate and key.
+// Agents now enroll with their own key; see handleEnroll.
+func (s *HTTPServer) handleBootstrapCerts(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Shared agent certificates are no longer issued; enroll with `agent enroll` and an enrollment token", http.StatusGone)
+}
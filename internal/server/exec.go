@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExecOptions configures an interactive exec session (docker_exec_session).
+type ExecOptions struct {
+	Cmd  []string `json:"cmd,omitempty"`
+	Tty  *bool    `json:"tty,omitempty"`
+	Rows uint32   `json:"rows,omitempty"`
+	Cols uint32   `json:"cols,omitempty"`
+}
+
+// errExecOverflow ends an exec session whose client does not keep up with
+// its output.
+var errExecOverflow = errors.New("exec output was not read fast enough")
+
+// ExecSession is the hub side of an interactive exec running on an agent.
+// Output and the final exit code arrive on Frames, the command result on Done.
+type ExecSession struct {
+	ID string
+
+	server   *DockletServer
+	agent    *AgentSession
+	frames   chan *pb.ExecFrame
+	result   chan *pb.CommandResult
+	overflow chan struct{}
+
+	closeOnce    sync.Once
+	overflowOnce sync.Once
+}
+
+// OpenExecSession starts an interactive exec in containerID on nodeID.
+func (s *DockletServer) OpenExecSession(ctx context.Context, nodeID, containerID string, opts ExecOptions) (*ExecSession, error) {
+	val, ok := s.agents.Load(nodeID)
+	if !ok {
+		if owner := s.remoteOwner(nodeID); owner != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "node %s is connected to hub %s (%s); open the exec session there", nodeID, owner.ID, owner.Addr)
+		}
+		return nil, status.Errorf(codes.NotFound, "node %s not connected", nodeID)
+	}
+	agent := val.(*AgentSession)
+
+	config, err := json.Marshal(opts)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid exec options: %v", err)
+	}
+
+	sess := &ExecSession{
+		ID:       newCommandID(),
+		server:   s,
+		agent:    agent,
+		frames:   make(chan *pb.ExecFrame, 256),
+		result:   make(chan *pb.CommandResult, 1),
+		overflow: make(chan struct{}),
+	}
+	args := []string{containerID, string(config)}
+	s.startJob(ctx, sess.ID, nodeID, "docker_exec_session", args)
+	s.execSessions.Store(sess.ID, sess)
+	s.pendingCommands.Store(sess.ID, sess.result)
+
+	err = agent.Send(&pb.StreamPayload{
+		Payload: &pb.StreamPayload_Command{
+			Command: &pb.Command{
+				Id:   sess.ID,
+				Type: "docker_exec_session",
+				Args: args,
+			},
+		},
+	})
+	if err != nil {
+		s.execSessions.Delete(sess.ID)
+		s.pendingCommands.Delete(sess.ID)
+		s.failJob(sess.ID, "failed to send command to agent: "+err.Error())
+		return nil, status.Errorf(codes.Internal, "failed to send command to agent: %v", err)
+	}
+	return sess, nil
+}
+
+// Frames delivers "stdout" and "exit" frames from the agent.
+func (e *ExecSession) Frames() <-chan *pb.ExecFrame { return e.frames }
+
+// Done delivers the command result once the agent has finished the session.
+func (e *ExecSession) Done() <-chan *pb.CommandResult { return e.result }
+
+// Overflow is closed when frames arrived faster than they were read. Frames
+// are not dropped: the session is broken and should be closed.
+func (e *ExecSession) Overflow() <-chan struct{} { return e.overflow }
+
+// deliver routes a frame from the agent without blocking its stream.
+func (e *ExecSession) deliver(frame *pb.ExecFrame) {
+	select {
+	case e.frames <- frame:
+	default:
+		e.overflowOnce.Do(func() {
+			log.Printf("Warning: exec frame buffer full for %s, closing the session", e.ID)
+			close(e.overflow)
+		})
+	}
+}
+
+func (e *ExecSession) Stdin(data []byte) error {
+	return e.sendFrame(&pb.ExecFrame{Kind: "stdin", Data: data})
+}
+
+func (e *ExecSession) Resize(rows, cols uint32) error {
+	return e.sendFrame(&pb.ExecFrame{Kind: "resize", Rows: rows, Cols: cols})
+}
+
+// CloseStdin signals end of input to the process.
+func (e *ExecSession) CloseStdin() error {
+	return e.sendFrame(&pb.ExecFrame{Kind: "close"})
+}
+
+// Close cancels the session on the agent and stops routing its frames.
+func (e *ExecSession) Close() {
+	e.closeOnce.Do(func() {
+		e.server.cancelCommand(e.agent, e.ID)
+		e.server.execSessions.Delete(e.ID)
+		e.server.pendingCommands.Delete(e.ID)
+		select {
+		case <-e.overflow:
+			e.server.failJob(e.ID, errExecOverflow.Error())
+		default:
+		}
+	})
+}
+
+func (e *ExecSession) sendFrame(frame *pb.ExecFrame) error {
+	frame.SessionId = e.ID
+	return e.agent.Send(&pb.StreamPayload{
+		Payload: &pb.StreamPayload_Exec{Exec: frame},
+	})
+}
+
+// execControlMessage is a text frame sent by WebSocket clients.
+// Binary frames are treated as raw stdin.
+type execControlMessage struct {
+	Type string `json:"type"` // "stdin", "resize", "close"
+	Data string `json:"data,omitempty"`
+	Rows uint32 `json:"rows,omitempty"`
+	Cols uint32 `json:"cols,omitempty"`
+}
+
+// wsMessage keeps the frame type of a received WebSocket message.
+type wsMessage struct {
+	Binary bool
+	Data   []byte
+}
+
+var wsMessageCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		m := v.(wsMessage)
+		if m.Binary {
+			return m.Data, websocket.BinaryFrame, nil
+		}
+		return m.Data, websocket.TextFrame, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		m := v.(*wsMessage)
+		m.Binary = payloadType == websocket.BinaryFrame
+		m.Data = data
+		return nil
+	},
+}
+
+// handleExecWebSocket bridges a WebSocket client to an interactive exec session.
+//
+// Query: cmd (repeatable, default /bin/sh), tty (default true), rows, cols.
+// Client -> hub: binary frames are stdin; text frames are execControlMessage.
+// Hub -> client: binary frames are output; a final text frame
+// {"type":"exit","exit_code":N} (or {"type":"error","message":...}) ends the session.
+func (s *HTTPServer) handleExecWebSocket(w http.ResponseWriter, r *http.Request, nodeID, containerID string) {
+	q := r.URL.Query()
+	opts := ExecOptions{Cmd: q["cmd"]}
+	if strings.TrimSpace(q.Get("tty")) != "" {
+		tty := queryBool(q, "tty")
+		opts.Tty = &tty
+	}
+	if rows, err := strconv.ParseUint(q.Get("rows"), 10, 32); err == nil {
+		opts.Rows = uint32(rows)
+	}
+	if cols, err := strconv.ParseUint(q.Get("cols"), 10, 32); err == nil {
+		opts.Cols = uint32(cols)
+	}
+
+	if !s.grpcServer.nodeConnected(nodeID) {
+		http.Error(w, "node "+nodeID+" not connected", http.StatusInternalServerError)
+		return
+	}
+
+	wsServer := websocket.Server{
+		// Authentication is done by authMiddleware; accept any origin.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			sendJSON := func(v interface{}) {
+				b, _ := json.Marshal(v)
+				_ = wsMessageCodec.Send(ws, wsMessage{Data: b})
+			}
+
+			sess, err := s.grpcServer.OpenExecSession(r.Context(), nodeID, containerID, opts)
+			if err != nil {
+				sendJSON(map[string]string{"type": "error", "message": err.Error()})
+				return
+			}
+			defer sess.Close()
+
+			clientGone := make(chan struct{})
+			go func() {
+				defer close(clientGone)
+				for {
+					var msg wsMessage
+					if err := wsMessageCodec.Receive(ws, &msg); err != nil {
+						return
+					}
+					if msg.Binary {
+						if err := sess.Stdin(msg.Data); err != nil {
+							return
+						}
+						continue
+					}
+
+					var ctrl execControlMessage
+					if err := json.Unmarshal(msg.Data, &ctrl); err != nil {
+						log.Printf("Exec session %s: invalid control message: %v", sess.ID, err)
+						continue
+					}
+					switch ctrl.Type {
+					case "stdin":
+						err = sess.Stdin([]byte(ctrl.Data))
+					case "resize":
+						err = sess.Resize(ctrl.Rows, ctrl.Cols)
+					case "close":
+						err = sess.CloseStdin()
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+
+			for {
+				select {
+				case frame := <-sess.Frames():
+					switch frame.Kind {
+					case "stdout":
+						if err := wsMessageCodec.Send(ws, wsMessage{Binary: true, Data: frame.Data}); err != nil {
+							return
+						}
+					case "exit":
+						sendJSON(map[string]interface{}{"type": "exit", "exit_code": frame.ExitCode})
+						return
+					}
+				case res := <-sess.Done():
+					// Frames are routed before the result; flush what is still buffered.
+					for drained := false; !drained; {
+						select {
+						case frame := <-sess.Frames():
+							if frame.Kind == "exit" {
+								sendJSON(map[string]interface{}{"type": "exit", "exit_code": frame.ExitCode})
+								return
+							}
+							_ = wsMessageCodec.Send(ws, wsMessage{Binary: true, Data: frame.Data})
+						default:
+							drained = true
+						}
+					}
+					// No exit frame: the agent failed before the process started.
+					if strings.TrimSpace(res.Error) != "" {
+						sendJSON(map[string]string{"type": "error", "message": res.Error})
+					} else {
+						sendJSON(map[string]interface{}{"type": "exit", "exit_code": res.ExitCode})
+					}
+					return
+				case <-sess.Overflow():
+					sendJSON(map[string]string{"type": "error", "message": errExecOverflow.Error()})
+					return
+				case <-clientGone:
+					return
+				}
+			}
+		},
+	}
+	wsServer.ServeHTTP(w, r)
+}
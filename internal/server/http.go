@@ -1,14 +1,15 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -16,6 +17,7 @@ import (
 	"time"
 
 	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/astracat/docklet/internal/storage"
 	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -24,8 +26,17 @@ import (
 type HTTPServer struct {
 	grpcServer *DockletServer
 	staticPath string
+
+	// Serializes read-modify-write of cluster records
 	clustersMu sync.Mutex
+	// Legacy clusters file, imported into the repository once
 	clustersDB string
+
+	// Per-cluster *sync.Mutex held while a cluster is deployed, removed or reconciled
+	clusterLocks sync.Map
+	reconcileNow chan struct{}
+
+	logins loginLimiter
 }
 
 type LoginRequest struct {
@@ -34,7 +45,10 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
 }
 
 type RenameRequest struct {
@@ -50,10 +64,9 @@ type RestartPolicyRequest struct {
 }
 
 const (
-	// Default credentials if not set via env
+	// Credentials of the first admin if not set via env
 	defaultUser = "astracat"
 	defaultPass = "astracat"
-	validToken  = "simple-token-astracat-123" // In real app, generate UUIDs
 )
 
 func NewHTTPServer(grpcServer *DockletServer, staticPath string) *HTTPServer {
@@ -63,18 +76,33 @@ func NewHTTPServer(grpcServer *DockletServer, staticPath string) *HTTPServer {
 	}
 
 	return &HTTPServer{
-		grpcServer: grpcServer,
-		staticPath: staticPath,
-		clustersDB: dbPath,
+		grpcServer:   grpcServer,
+		staticPath:   staticPath,
+		clustersDB:   dbPath,
+		reconcileNow: make(chan struct{}, 1),
 	}
 }
 
 func (s *HTTPServer) Start(addr string) error {
+	if err := s.ensureAdminUser(context.Background()); err != nil {
+		return fmt.Errorf("failed to create admin user: %w", err)
+	}
+	// Imported clusters would be lost with the fallback store, and the
+	// renamed file would not be imported again.
+	if s.grpcServer.StoreFallback {
+		log.Printf("Running on the fallback store, not importing clusters from %s", s.clustersDB)
+	} else if err := ImportClustersFile(context.Background(), s.grpcServer.Repo, s.clustersDB); err != nil {
+		log.Printf("Failed to import clusters from %s: %v", s.clustersDB, err)
+	}
+
+	s.startReconciler(reconcileInterval())
+
 	mux := http.NewServeMux()
 
 	// Public Routes
 	mux.HandleFunc("/api/login", s.handleLogin)
 	mux.HandleFunc("/api/bootstrap/certs", s.handleBootstrapCerts)
+	mux.HandleFunc("/api/bootstrap/enroll", s.handleEnroll)
 
 	// Protected Routes (manually wrapped middleware)
 	mux.HandleFunc("/api/nodes", s.authMiddleware(s.handleListNodes))
@@ -90,6 +118,21 @@ func (s *HTTPServer) Start(addr string) error {
 	mux.HandleFunc("/api/clusters/deploy", s.authMiddleware(s.handleClusterDeploy))
 	mux.HandleFunc("/api/clusters", s.authMiddleware(s.handleClusters))
 	mux.HandleFunc("/api/clusters/", s.authMiddleware(s.handleClusterAction))
+	mux.HandleFunc("/api/jobs", s.authMiddleware(s.handleJobs))
+	mux.HandleFunc("/api/jobs/", s.authMiddleware(s.handleJobAction))
+	mux.HandleFunc("/api/logout", s.authMiddleware(s.handleLogout))
+	mux.HandleFunc("/api/me", s.authMiddleware(s.handleMe))
+	mux.HandleFunc("/api/users", s.authMiddleware(s.handleUsers))
+	mux.HandleFunc("/api/users/", s.authMiddleware(s.handleUserAction))
+	mux.HandleFunc("/api/tokens", s.authMiddleware(s.handleTokens))
+	mux.HandleFunc("/api/tokens/", s.authMiddleware(s.handleTokenAction))
+	mux.HandleFunc("/api/enrollment-tokens", s.authMiddleware(s.handleEnrollmentTokens))
+	mux.HandleFunc("/api/enrollment-tokens/", s.authMiddleware(s.handleEnrollmentTokenAction))
+	mux.HandleFunc("/api/registries", s.authMiddleware(s.handleRegistries))
+	mux.HandleFunc("/api/registries/", s.authMiddleware(s.handleRegistryAction))
+
+	// Prometheus scrape endpoint, outside dashboard auth (see handleMetrics)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 
 	// Static Files
 	if s.staticPath != "" {
@@ -110,51 +153,6 @@ func (s *HTTPServer) Start(addr string) error {
 	return http.ListenAndServe(addr, mux)
 }
 
-type Cluster struct {
-	ID        string   `json:"id"`
-	Name      string   `json:"name"`
-	StackName string   `json:"stack_name"`
-	Content   string   `json:"content"`
-	Nodes     []string `json:"nodes"`
-	CreatedAt int64    `json:"created_at"`
-	UpdatedAt int64    `json:"updated_at"`
-}
-
-func (s *HTTPServer) loadClustersLocked() ([]Cluster, error) {
-	b, err := os.ReadFile(s.clustersDB)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []Cluster{}, nil
-		}
-		return nil, err
-	}
-
-	if len(bytesTrimSpace(b)) == 0 {
-		return []Cluster{}, nil
-	}
-
-	var clusters []Cluster
-	if err := json.Unmarshal(b, &clusters); err != nil {
-		return nil, err
-	}
-	return clusters, nil
-}
-
-func (s *HTTPServer) saveClustersLocked(clusters []Cluster) error {
-	dir := filepath.Dir(s.clustersDB)
-	if dir != "." && dir != "" {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return err
-		}
-	}
-
-	b, err := json.MarshalIndent(clusters, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(s.clustersDB, b, 0600)
-}
-
 func bytesTrimSpace(b []byte) []byte {
 	i := 0
 	j := len(b)
@@ -174,10 +172,16 @@ func (s *HTTPServer) handleClusterDeploy(w http.ResponseWriter, r *http.Request)
 	}
 
 	type ClusterDeployRequest struct {
-		Name    string   `json:"name"`
-		Content string   `json:"content"`
-		Nodes   []string `json:"nodes"`
-		ID      string   `json:"id"`
+		Name        string   `json:"name"`
+		Content     string   `json:"content"`
+		Nodes       []string `json:"nodes"`
+		Selector    string   `json:"selector"`
+		ID          string   `json:"id"`
+		Async       bool     `json:"async"`
+		Parallelism int      `json:"parallelism"`
+
+		// Rolling update with health gates; nil updates all nodes at once.
+		Strategy *RolloutStrategy `json:"strategy"`
 	}
 
 	var req ClusterDeployRequest
@@ -191,11 +195,114 @@ func (s *HTTPServer) handleClusterDeploy(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Name and content required", http.StatusBadRequest)
 		return
 	}
-	if len(req.Nodes) == 0 {
-		http.Error(w, "nodes required", http.StatusBadRequest)
+	if req.Strategy != nil {
+		if err := req.Strategy.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	nodes, err := s.grpcServer.resolveTargets(r.Context(), req.Nodes, req.Selector)
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), httpStatusFromGRPC(err))
+		return
+	}
+	if len(nodes) == 0 {
+		http.Error(w, "nodes or a matching selector required", http.StatusBadRequest)
+		return
+	}
+
+	// Lock before saving, so the reconciler does not apply the new content
+	// ahead of the deploy.
+	clusterID, unlock, err := s.lockClusterDeployment(r.Context(), req.ID, req.Name)
+	if err != nil {
+		http.Error(w, "Failed to lock cluster", http.StatusInternalServerError)
+		return
+	}
+
+	// The cluster is the desired state; if the deploy fails the reconciler
+	// retries, unless a rollout halted.
+	cluster, prevContent, err := s.saveClusterDeployment(r.Context(), clusterID, req.Name, req.Content, nodes, strings.TrimSpace(req.Selector), 0)
+	if err != nil {
+		unlock()
+		http.Error(w, "Failed to save cluster", http.StatusInternalServerError)
+		return
+	}
+
+	s.deployCluster(w, r, cluster, prevContent, nodes, req.Parallelism, req.Strategy, req.Async, unlock)
+}
+
+// lockClusterDeployment takes the lock of the cluster a deploy targets: the
+// one with id, or else the one with the stack name. A new cluster gets its
+// ID here; the name stays locked too until unlock, so concurrent deploys of
+// a new stack do not create it twice.
+func (s *HTTPServer) lockClusterDeployment(ctx context.Context, id, name string) (clusterID string, unlock func(), err error) {
+	clusterID = strings.TrimSpace(id)
+	if clusterID != "" {
+		unlock, err := s.lockCluster(ctx, clusterID, true)
+		return clusterID, unlock, err
+	}
+
+	unlockName, err := s.lockCluster(ctx, "name:"+name, true)
+	if err != nil {
+		return "", nil, err
+	}
+	clusters, err := s.grpcServer.Repo.ListClusters(ctx)
+	if err != nil {
+		unlockName()
+		s.grpcServer.metrics.storageError("list_clusters")
+		return "", nil, err
+	}
+	for _, c := range clusters {
+		if c.StackName == name {
+			clusterID = c.ID
+			break
+		}
+	}
+	if clusterID == "" {
+		clusterID = uuid.New().String()
+	}
+	unlockID, err := s.lockCluster(ctx, clusterID, true)
+	if err != nil {
+		unlockName()
+		return "", nil, err
+	}
+	return clusterID, func() {
+		unlockID()
+		unlockName()
+	}, nil
+}
+
+// deployCluster applies the saved cluster to nodes and writes the job (async)
+// or its results to w. The caller holds the cluster lock; unlock releases it
+// once the deploy finished.
+func (s *HTTPServer) deployCluster(w http.ResponseWriter, r *http.Request, cluster storage.Cluster, prevContent string, nodes []string, parallelism int, strategy *RolloutStrategy, async bool, unlock func()) {
+	job, err := s.submitDeploy(r.Context(), cluster, nodes, parallelism, strategy)
+	if err != nil {
+		unlock()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	finished := make(chan struct{})
+	var outcome RolloutOutcome
+	go func() {
+		defer unlock()
+		defer close(finished)
+		outcome = s.finishDeploy(context.WithoutCancel(r.Context()), cluster, prevContent, nodes, job, strategy)
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	if async {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"job_id":   job.ID,
+			"revision": cluster.Revision,
+		})
 		return
 	}
 
+	<-finished
+
 	type ClusterDeployResult struct {
 		NodeID    string `json:"node_id"`
 		OK        bool   `json:"ok"`
@@ -205,102 +312,101 @@ func (s *HTTPServer) handleClusterDeploy(w http.ResponseWriter, r *http.Request)
 		Timestamp int64  `json:"timestamp"`
 	}
 
-	results := make([]ClusterDeployResult, 0, len(req.Nodes))
-	now := time.Now().Unix()
-
-	for _, nodeID := range req.Nodes {
-		nodeID = strings.TrimSpace(nodeID)
-		if nodeID == "" {
-			continue
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
-		resp, err := s.grpcServer.ExecuteCommand(ctx, &pb.ExecuteCommandRequest{
-			NodeId:  nodeID,
-			Command: "stack_up",
-			Args:    []string{req.Name, req.Content},
-		})
-		cancel()
-
-		if err != nil {
-			results = append(results, ClusterDeployResult{
-				NodeID:    nodeID,
-				OK:        false,
-				Error:     err.Error(),
-				Timestamp: now,
-			})
-			continue
-		}
-
-		ok := resp.ExitCode == 0
+	results := make([]ClusterDeployResult, 0, len(nodes))
+	for _, res := range job.Results() {
 		errMsg := ""
-		if !ok {
-			errMsg = resp.Error
+		if !res.OK {
+			errMsg = res.Error
 		}
-
 		results = append(results, ClusterDeployResult{
-			NodeID:    nodeID,
-			OK:        ok,
-			ExitCode:  resp.ExitCode,
+			NodeID:    res.NodeID,
+			OK:        res.OK,
+			ExitCode:  res.ExitCode,
 			Error:     errMsg,
-			Output:    string(resp.Output),
-			Timestamp: now,
+			Output:    res.Output,
+			Timestamp: res.Timestamp,
 		})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"results": results,
+		"job_id":   job.ID,
+		"revision": cluster.Revision,
+		"results":  results,
+		"rollout":  outcome,
 	})
+}
 
+// saveClusterDeployment stores the desired stack of a cluster, matching an
+// existing cluster by id or stack name. nodes are the resolved targets,
+// selector the one they were picked with, if any. prevContent is the content
+// the cluster had before, empty for a new cluster. The content is recorded
+// as a new revision deployed by the issuer of ctx; rollbackOf is the
+// revision it was taken from, if any.
+func (s *HTTPServer) saveClusterDeployment(ctx context.Context, id, name, content string, nodes []string, selector string, rollbackOf int) (saved storage.Cluster, prevContent string, err error) {
 	s.clustersMu.Lock()
 	defer s.clustersMu.Unlock()
 
-	clusters, err := s.loadClustersLocked()
-	if err != nil {
-		return
-	}
-
-	clusterID := strings.TrimSpace(req.ID)
+	repo := s.grpcServer.Repo
+	now := time.Now()
+	clusterID := strings.TrimSpace(id)
 	if clusterID == "" {
+		clusters, err := repo.ListClusters(ctx)
+		if err != nil {
+			return storage.Cluster{}, "", err
+		}
 		for _, c := range clusters {
-			if c.StackName == req.Name {
+			if c.StackName == name {
 				clusterID = c.ID
 				break
 			}
 		}
 	}
-	if clusterID == "" {
-		clusterID = uuid.New().String()
-	}
 
-	updated := false
-	for i := range clusters {
-		if clusters[i].ID == clusterID {
-			if clusters[i].Name == "" {
-				clusters[i].Name = req.Name
-			}
-			clusters[i].StackName = req.Name
-			clusters[i].Content = req.Content
-			clusters[i].Nodes = req.Nodes
-			clusters[i].UpdatedAt = now
-			updated = true
-			break
+	var target *storage.Cluster
+	if clusterID != "" {
+		if target, err = repo.GetCluster(ctx, clusterID); err != nil {
+			return storage.Cluster{}, "", err
 		}
 	}
-	if !updated {
-		clusters = append(clusters, Cluster{
+	if target != nil {
+		if target.Name == "" {
+			target.Name = name
+		}
+		if err := s.seedClusterRevisions(ctx, target); err != nil {
+			return storage.Cluster{}, "", err
+		}
+		prevContent = target.Content
+	} else {
+		if clusterID == "" {
+			clusterID = uuid.New().String()
+		}
+		target = &storage.Cluster{
 			ID:        clusterID,
-			Name:      req.Name,
-			StackName: req.Name,
-			Content:   req.Content,
-			Nodes:     req.Nodes,
+			Name:      name,
 			CreatedAt: now,
-			UpdatedAt: now,
-		})
+		}
 	}
+	target.StackName = name
+	target.Content = content
+	target.Nodes = nodes
+	target.Selector = selector
+	target.UpdatedAt = now
 
-	_ = s.saveClustersLocked(clusters)
+	if err := repo.SaveCluster(ctx, target); err != nil {
+		return storage.Cluster{}, "", err
+	}
+	rev := &storage.ClusterRevision{
+		ClusterID:  target.ID,
+		Content:    content,
+		DeployedBy: issuerFromContext(ctx),
+		RollbackOf: rollbackOf,
+		CreatedAt:  now,
+	}
+	if err := repo.AddClusterRevision(ctx, rev); err != nil {
+		return storage.Cluster{}, "", err
+	}
+	target.Revision = rev.Revision
+	return *target, prevContent, nil
 }
 
 func (s *HTTPServer) handleClusters(w http.ResponseWriter, r *http.Request) {
@@ -309,17 +415,20 @@ func (s *HTTPServer) handleClusters(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.clustersMu.Lock()
-	clusters, err := s.loadClustersLocked()
-	s.clustersMu.Unlock()
+	clusters, err := s.grpcServer.Repo.ListClusters(r.Context())
 	if err != nil {
+		s.grpcServer.metrics.storageError("list_clusters")
 		http.Error(w, "Failed to load clusters", http.StatusInternalServerError)
 		return
 	}
 
+	out := make([]Cluster, 0, len(clusters))
+	for _, c := range clusters {
+		out = append(out, newClusterResponse(c))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"clusters": clusters,
+		"clusters": out,
 	})
 }
 
@@ -351,23 +460,17 @@ func (s *HTTPServer) handleClusterAction(w http.ResponseWriter, r *http.Request)
 		}
 
 		s.clustersMu.Lock()
-		clusters, err := s.loadClustersLocked()
-		if err == nil {
-			found := false
-			now := time.Now().Unix()
-			for i := range clusters {
-				if clusters[i].ID == id {
-					clusters[i].Name = newName
-					clusters[i].UpdatedAt = now
-					found = true
-					break
-				}
-			}
-			if found {
-				_ = s.saveClustersLocked(clusters)
-			}
+		c, err := s.grpcServer.Repo.GetCluster(r.Context(), id)
+		if err == nil && c != nil {
+			c.Name = newName
+			c.UpdatedAt = time.Now()
+			err = s.grpcServer.Repo.SaveCluster(r.Context(), c)
 		}
 		s.clustersMu.Unlock()
+		if err != nil {
+			http.Error(w, "Failed to rename cluster", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -376,31 +479,37 @@ func (s *HTTPServer) handleClusterAction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if r.Method == http.MethodPost && action == "sync" {
+		s.handleClusterSync(w, r, id)
+		return
+	}
+
+	if action == "revisions" || action == "rollback" {
+		s.handleClusterRevisions(w, r, id, parts[1:])
+		return
+	}
+
 	if r.Method == http.MethodDelete && action == "" {
-		s.clustersMu.Lock()
-		clusters, err := s.loadClustersLocked()
+		// Keep the reconciler from bringing the stack back while it is removed.
+		unlock, err := s.lockCluster(r.Context(), id, true)
 		if err != nil {
-			s.clustersMu.Unlock()
-			http.Error(w, "Failed to load clusters", http.StatusInternalServerError)
+			http.Error(w, "Failed to lock cluster", http.StatusInternalServerError)
 			return
 		}
+		defer unlock()
 
-		var target *Cluster
-		for i := range clusters {
-			if clusters[i].ID == id {
-				target = &clusters[i]
-				break
-			}
+		target, err := s.getCluster(r.Context(), id)
+		if err != nil {
+			http.Error(w, "Failed to load clusters", http.StatusInternalServerError)
+			return
 		}
 		if target == nil {
-			s.clustersMu.Unlock()
 			http.NotFound(w, r)
 			return
 		}
 
 		stackName := target.StackName
 		nodes := append([]string{}, target.Nodes...)
-		s.clustersMu.Unlock()
 
 		type DownResult struct {
 			NodeID   string `json:"node_id"`
@@ -411,42 +520,37 @@ func (s *HTTPServer) handleClusterAction(w http.ResponseWriter, r *http.Request)
 		results := make([]DownResult, 0, len(nodes))
 		allOK := true
 
+		tasks := make([]NodeTask, 0, len(nodes))
 		for _, nodeID := range nodes {
-			ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
-			resp, err := s.grpcServer.ExecuteCommand(ctx, &pb.ExecuteCommandRequest{
-				NodeId:  nodeID,
+			tasks = append(tasks, NodeTask{
+				NodeID:  nodeID,
 				Command: "stack_down",
 				Args:    []string{stackName},
+				Timeout: 90 * time.Second,
 			})
-			cancel()
+		}
+		if len(tasks) > 0 {
+			job, err := s.grpcServer.Jobs.Submit(r.Context(), "cluster_remove", []string{stackName}, tasks, 0)
 			if err != nil {
-				allOK = false
-				results = append(results, DownResult{NodeID: nodeID, OK: false, Error: err.Error()})
-				continue
-			}
-			ok := resp.ExitCode == 0
-			if !ok {
-				allOK = false
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
-			errMsg := ""
-			if !ok {
-				errMsg = resp.Error
+			<-job.Done()
+			for _, res := range job.Results() {
+				if !res.OK {
+					allOK = false
+				}
+				results = append(results, DownResult{NodeID: res.NodeID, OK: res.OK, ExitCode: res.ExitCode, Error: res.Error})
 			}
-			results = append(results, DownResult{NodeID: nodeID, OK: ok, ExitCode: resp.ExitCode, Error: errMsg})
 		}
 
 		deleted := false
 		if allOK {
 			s.clustersMu.Lock()
-			clusters2, err := s.loadClustersLocked()
-			if err == nil {
-				out := make([]Cluster, 0, len(clusters2))
-				for _, c := range clusters2 {
-					if c.ID != id {
-						out = append(out, c)
-					}
-				}
-				_ = s.saveClustersLocked(out)
+			if err := s.grpcServer.Repo.DeleteCluster(r.Context(), id); err != nil {
+				log.Printf("Failed to delete cluster %s: %v", id, err)
+				s.grpcServer.metrics.storageError("delete_cluster")
+			} else {
 				deleted = true
 			}
 			s.clustersMu.Unlock()
@@ -463,6 +567,24 @@ func (s *HTTPServer) handleClusterAction(w http.ResponseWriter, r *http.Request)
 	http.NotFound(w, r)
 }
 
+// httpStatusFromGRPC maps the status code of err to an HTTP status.
+func httpStatusFromGRPC(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.FailedPrecondition, codes.Aborted:
+		return http.StatusConflict
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s *HTTPServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -475,41 +597,362 @@ func (s *HTTPServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check Env Vars first
-	validUser := os.Getenv("DOCKLET_ADMIN_USER")
-	if validUser == "" {
-		validUser = defaultUser
+	addrKey, userKey := loginKeys(r, req.Username)
+	if wait := s.logins.blocked(addrKey, userKey, time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many failed logins; try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := s.grpcServer.Repo.GetUser(r.Context(), req.Username)
+	if err != nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
 	}
-	validPass := os.Getenv("DOCKLET_ADMIN_PASSWORD")
-	if validPass == "" {
-		validPass = defaultPass
+	passwordHash := dummyPasswordHash()
+	if user != nil {
+		passwordHash = user.PasswordHash
+	}
+	if !checkPassword(passwordHash, req.Password) || user == nil {
+		// Delay to prevent timing attacks (basic)
+		time.Sleep(100 * time.Millisecond)
+		s.logins.fail(addrKey, userKey, time.Now())
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
 	}
+	s.logins.succeed(userKey)
 
-	if req.Username == validUser && req.Password == validPass {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(LoginResponse{Token: validToken})
+	// Login is a convenient moment to drop old session tokens.
+	if err := s.grpcServer.Repo.DeleteExpiredTokens(r.Context(), time.Now()); err != nil {
+		log.Printf("Failed to delete expired tokens: %v", err)
+	}
+	plaintext, token, err := s.issueToken(r.Context(), user.Username, "login", sessionTTL())
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
 
-	// Delay to prevent timing attacks (basic)
-	time.Sleep(100 * time.Millisecond)
-	http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginResponse{
+		Token:     plaintext,
+		ExpiresAt: token.ExpiresAt.Unix(),
+		Username:  user.Username,
+		Role:      user.Role,
+	})
 }
 
+// authMiddleware authenticates the bearer token and enforces requiredRole.
 func (s *HTTPServer) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if auth != "Bearer "+validToken {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// Browsers cannot set headers on WebSocket requests.
+		if !ok && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			token = r.URL.Query().Get("token")
+		}
+		p, err := s.authenticate(r.Context(), strings.TrimSpace(token))
+		if err != nil {
+			if !errors.Is(err, errInvalidToken) {
+				log.Printf("Failed to authenticate request: %v", err)
+			}
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		next(w, r)
+		if !hasRole(p.User.Role, requiredRole(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, p)
+		next(w, r.WithContext(WithIssuer(ctx, p.User.Username)))
+	}
+}
+
+// adminUser is the name of the admin created on first start.
+func adminUser() string {
+	if user := os.Getenv("DOCKLET_ADMIN_USER"); user != "" {
+		return user
+	}
+	return defaultUser
+}
+
+type JobResponse struct {
+	ID         string   `json:"id"`
+	ParentID   string   `json:"parent_id,omitempty"`
+	NodeID     string   `json:"node_id,omitempty"`
+	Type       string   `json:"type"`
+	Args       []string `json:"args"`
+	IssuedBy   string   `json:"issued_by"`
+	Status     string   `json:"status"`
+	ExitCode   int32    `json:"exit_code"`
+	Error      string   `json:"error,omitempty"`
+	Output     string   `json:"output,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+	FinishedAt int64    `json:"finished_at,omitempty"`
+
+	// Per-node commands of a hub-side job.
+	Children []JobResponse `json:"children,omitempty"`
+}
+
+func newJobResponse(job *storage.Job, withOutput bool) JobResponse {
+	resp := JobResponse{
+		ID:        job.ID,
+		ParentID:  job.ParentID,
+		NodeID:    job.NodeID,
+		Type:      job.Type,
+		Args:      job.Args,
+		IssuedBy:  job.IssuedBy,
+		Status:    job.Status,
+		ExitCode:  job.ExitCode,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt.Unix(),
+	}
+	if resp.Args == nil {
+		resp.Args = []string{}
+	}
+	if !job.FinishedAt.IsZero() {
+		resp.FinishedAt = job.FinishedAt.Unix()
+	}
+	if withOutput {
+		resp.Output = string(job.Output)
+	}
+	return resp
+}
+
+// Command types that can be submitted as hub-side jobs via POST /api/jobs.
+var submittableJobTypes = map[string]bool{
+	"stack_up":              true,
+	"stack_down":            true,
+	"image_pull":            true,
+	"image_prune":           true,
+	"image_rm":              true,
+	"network_create":        true,
+	"network_rm":            true,
+	"volume_create":         true,
+	"volume_rm":             true,
+	"volume_prune":          true,
+	"docker_run":            true,
+	"docker_start":          true,
+	"docker_stop":           true,
+	"docker_rm":             true,
+	"docker_update_restart": true,
+}
+
+// handleJobs lists the command history (GET) or submits a hub-side job (POST).
+// GET query: parent, node, type, issued_by, status, limit (default 100, max 1000).
+func (s *HTTPServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.handleSubmitJob(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := 100
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	if limit > 1000 {
+		limit = 1000
 	}
+
+	jobs, err := s.grpcServer.Repo.ListJobs(r.Context(), storage.JobFilter{
+		ParentID: strings.TrimSpace(q.Get("parent")),
+		NodeID:   strings.TrimSpace(q.Get("node")),
+		Type:     strings.TrimSpace(q.Get("type")),
+		IssuedBy: strings.TrimSpace(q.Get("issued_by")),
+		Status:   strings.TrimSpace(q.Get("status")),
+		Limit:    limit,
+	})
+	if err != nil {
+		http.Error(w, "Failed to load jobs", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]JobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		out = append(out, newJobResponse(job, false))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": out})
 }
 
+// handleSubmitJob runs a command on several nodes in the background and
+// returns the job id right away. Progress: GET /api/jobs/{id} or /events.
+func (s *HTTPServer) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	type SubmitJobRequest struct {
+		Type           string   `json:"type"`
+		Nodes          []string `json:"nodes"`
+		Selector       string   `json:"selector"`
+		Args           []string `json:"args"`
+		Parallelism    int      `json:"parallelism"`
+		TimeoutSeconds int      `json:"timeout_seconds"`
+	}
+
+	var req SubmitJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Type = strings.TrimSpace(req.Type)
+	if !submittableJobTypes[req.Type] {
+		http.Error(w, "Unsupported job type: "+req.Type, http.StatusBadRequest)
+		return
+	}
+
+	timeout := time.Duration(req.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+
+	nodes, err := s.grpcServer.resolveTargets(r.Context(), req.Nodes, req.Selector)
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), httpStatusFromGRPC(err))
+		return
+	}
+	if len(nodes) == 0 {
+		http.Error(w, "nodes or a matching selector required", http.StatusBadRequest)
+		return
+	}
+
+	tasks := make([]NodeTask, 0, len(nodes))
+	for _, nodeID := range nodes {
+		tasks = append(tasks, NodeTask{NodeID: nodeID, Command: req.Type, Args: req.Args, Timeout: timeout})
+	}
+
+	job, err := s.grpcServer.Jobs.Submit(r.Context(), req.Type, req.Args, tasks, req.Parallelism)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"job_id": job.ID})
+}
+
+// handleJobAction serves /api/jobs/{id}, /api/jobs/{id}/events and /api/jobs/{id}/cancel.
+func (s *HTTPServer) handleJobAction(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	parts := strings.Split(path, "/")
+	id := parts[0]
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if id == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		job, err := s.grpcServer.Repo.GetJob(r.Context(), id)
+		if err != nil {
+			http.Error(w, "Failed to load job", http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		resp := newJobResponse(job, true)
+		children, err := s.grpcServer.Repo.ListJobs(r.Context(), storage.JobFilter{ParentID: id})
+		if err != nil {
+			http.Error(w, "Failed to load job", http.StatusInternalServerError)
+			return
+		}
+		for _, child := range children {
+			resp.Children = append(resp.Children, newJobResponse(child, false))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case action == "cancel" && r.Method == http.MethodPost:
+		if !s.grpcServer.Jobs.Cancel(id) {
+			http.Error(w, "Job is not running", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+
+	case action == "events" && r.Method == http.MethodGet:
+		s.streamJobEvents(w, r, id)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// streamJobEvents sends JobEvents of a running job as SSE until it finishes.
+// For a finished job a single final event with its stored status is sent.
+func (s *HTTPServer) streamJobEvents(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe, running := s.grpcServer.Jobs.Subscribe(id)
+	defer unsubscribe()
+
+	if !running {
+		job, err := s.grpcServer.Repo.GetJob(r.Context(), id)
+		if err != nil {
+			http.Error(w, "Failed to load job", http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			http.NotFound(w, r)
+			return
+		}
+		events = nil
+		defer func() {
+			b, _ := json.Marshal(JobEvent{JobID: id, Status: job.Status, Final: true})
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+		}()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if events == nil {
+		return
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			b, _ := json.Marshal(event)
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleListNodes lists known nodes, optionally only those matching ?selector=.
 func (s *HTTPServer) handleListNodes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	sel, err := ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	dbNodes, err := s.grpcServer.listNodesWithCleanup(context.Background())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -524,10 +967,32 @@ func (s *HTTPServer) handleListNodes(w http.ResponseWriter, r *http.Request) {
 		Status     string `json:"status"`
 		RemoteAddr string `json:"remote_addr"`
 		LastSeen   int64  `json:"last_seen"`
+
+		Hostname      string `json:"hostname,omitempty"`
+		OS            string `json:"os,omitempty"`
+		Kernel        string `json:"kernel,omitempty"`
+		Arch          string `json:"arch,omitempty"`
+		CPUCount      int    `json:"cpu_count,omitempty"`
+		MemoryBytes   int64  `json:"memory_bytes,omitempty"`
+		DockerVersion string `json:"docker_version,omitempty"`
+		StorageDriver string `json:"storage_driver,omitempty"`
+
+		Labels map[string]string `json:"labels"`
+		Taints []string          `json:"taints"`
 	}
 
 	nodes := make([]NodeResponse, 0, len(dbNodes))
 	for _, n := range dbNodes {
+		if !sel.Empty() && !sel.Matches(n) {
+			continue
+		}
+		labels, taints := n.Labels, n.Taints
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		if taints == nil {
+			taints = []string{}
+		}
 		nodeStatus := "disconnected"
 		if s.grpcServer.nodeConnected(n.ID) {
 			nodeStatus = "connected"
@@ -540,6 +1005,18 @@ func (s *HTTPServer) handleListNodes(w http.ResponseWriter, r *http.Request) {
 			Status:     nodeStatus,
 			RemoteAddr: n.RemoteAddr,
 			LastSeen:   n.LastSeen.Unix(),
+
+			Hostname:      n.Hostname,
+			OS:            n.OS,
+			Kernel:        n.Kernel,
+			Arch:          n.Arch,
+			CPUCount:      n.CPUCount,
+			MemoryBytes:   n.MemoryBytes,
+			DockerVersion: n.DockerVersion,
+			StorageDriver: n.StorageDriver,
+
+			Labels: labels,
+			Taints: taints,
 		})
 	}
 
@@ -595,12 +1072,96 @@ func (s *HTTPServer) handleNodeAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Pattern: {nodeID}/revoke and {nodeID}/rotate-cert
+	if strings.HasSuffix(path, "/revoke") || strings.HasSuffix(path, "/rotate-cert") {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if nodeID, ok := strings.CutSuffix(path, "/revoke"); ok {
+			resp, err := s.grpcServer.RevokeNode(r.Context(), &pb.RevokeNodeRequest{NodeId: nodeID})
+			if err != nil {
+				http.Error(w, status.Convert(err).Message(), httpStatusFromGRPC(err))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "revoked": resp.Serials})
+			return
+		}
+
+		nodeID := strings.TrimSuffix(path, "/rotate-cert")
+		resp, err := s.grpcServer.RotateNodeCertificate(r.Context(), &pb.RotateNodeCertificateRequest{NodeId: nodeID})
+		if err != nil {
+			http.Error(w, status.Convert(err).Message(), httpStatusFromGRPC(err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"serial": resp.Serial, "expires_at": resp.ExpiresAt})
+		return
+	}
+
+	// Pattern: {nodeID}/labels
+	if nodeID, ok := strings.CutSuffix(path, "/labels"); ok {
+		s.handleNodeLabels(w, r, nodeID)
+		return
+	}
+
+	// Pattern: {nodeID}/metrics?since=<unix>
+	if strings.HasSuffix(path, "/metrics") {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		nodeID := strings.TrimSuffix(path, "/metrics")
+		node, err := s.grpcServer.Repo.GetNode(r.Context(), nodeID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if node == nil {
+			http.Error(w, "node not found", http.StatusNotFound)
+			return
+		}
+
+		var since int64
+		if v := strings.TrimSpace(r.URL.Query().Get("since")); v != "" {
+			since, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"node_id": nodeID,
+			"samples": s.grpcServer.NodeMetrics(nodeID, since),
+		})
+		return
+	}
+
+	// Pattern: {nodeID}/images[/...]
+	if nodeID, rest, ok := strings.Cut(path, "/images"); ok && !strings.Contains(nodeID, "/") && (rest == "" || strings.HasPrefix(rest, "/")) {
+		s.handleNodeImages(w, r, nodeID, rest)
+		return
+	}
+
+	// Pattern: {nodeID}/networks[/...]
+	if nodeID, rest, ok := strings.Cut(path, "/networks"); ok && !strings.Contains(nodeID, "/") && (rest == "" || strings.HasPrefix(rest, "/")) {
+		s.handleNodeNetworks(w, r, nodeID, rest)
+		return
+	}
+
+	// Pattern: {nodeID}/volumes[/...]
+	if nodeID, rest, ok := strings.Cut(path, "/volumes"); ok && !strings.Contains(nodeID, "/") && (rest == "" || strings.HasPrefix(rest, "/")) {
+		s.handleNodeVolumes(w, r, nodeID, rest)
+		return
+	}
+
 	// Pattern: {nodeID}/stacks
 	if len(path) > 7 && path[len(path)-7:] == "/stacks" {
 		nodeID := path[:len(path)-7]
 
 		if r.Method == http.MethodGet {
-			s.proxyCommand(w, nodeID, "stack_ls", nil)
+			s.proxyCommand(w, r, nodeID, "stack_ls", nil)
 			return
 		}
 
@@ -619,7 +1180,7 @@ func (s *HTTPServer) handleNodeAction(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Name and content required", http.StatusBadRequest)
 				return
 			}
-			s.proxyCommand(w, nodeID, "stack_up", []string{req.Name, req.Content})
+			s.proxyCommand(w, r, nodeID, "stack_up", []string{req.Name, req.Content})
 			return
 		}
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -644,7 +1205,7 @@ func (s *HTTPServer) handleNodeAction(w http.ResponseWriter, r *http.Request) {
 		// rest = stackName or stackName/action?
 		// Assume DELETE for down
 		if r.Method == http.MethodDelete {
-			s.proxyCommand(w, nodeID, "stack_down", []string{rest})
+			s.proxyCommand(w, r, nodeID, "stack_down", []string{rest})
 			return
 		}
 	}
@@ -654,7 +1215,7 @@ func (s *HTTPServer) handleNodeAction(w http.ResponseWriter, r *http.Request) {
 		nodeID := path[:len(path)-11]
 
 		if r.Method == http.MethodGet {
-			s.proxyCommand(w, nodeID, "docker_ps", nil)
+			s.proxyCommand(w, r, nodeID, "docker_ps", nil)
 			return
 		}
 
@@ -684,7 +1245,11 @@ func (s *HTTPServer) handleNodeAction(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// Call docker_run with JSON string as first arg
-			s.proxyCommand(w, nodeID, "docker_run", []string{string(jsonBytes)})
+			if wantsStream(r) {
+				s.streamCommand(w, r, nodeID, "docker_run", []string{string(jsonBytes)})
+				return
+			}
+			s.proxyCommand(w, r, nodeID, "docker_run", []string{string(jsonBytes)})
 			return
 		}
 
@@ -839,6 +1404,36 @@ func (s *HTTPServer) handlePortlinerEndpointAction(w http.ResponseWriter, r *htt
 	// POST   /endpoints/{id}/docker/containers/{cid}/restart
 	// DELETE /endpoints/{id}/docker/containers/{cid}
 	// POST   /endpoints/{id}/docker/containers/create
+	// GET    /endpoints/{id}/docker/images/json
+	// POST   /endpoints/{id}/docker/images/create?fromImage=
+	// POST   /endpoints/{id}/docker/images/prune
+	// GET    /endpoints/{id}/docker/images/{name}/json
+	// POST   /endpoints/{id}/docker/images/{name}/tag?repo=&tag=
+	// DELETE /endpoints/{id}/docker/images/{name}
+	// GET    /endpoints/{id}/docker/networks
+	// POST   /endpoints/{id}/docker/networks/create
+	// GET    /endpoints/{id}/docker/networks/{name}
+	// POST   /endpoints/{id}/docker/networks/{name}/connect
+	// POST   /endpoints/{id}/docker/networks/{name}/disconnect
+	// DELETE /endpoints/{id}/docker/networks/{name}
+	// GET    /endpoints/{id}/docker/volumes
+	// POST   /endpoints/{id}/docker/volumes/create
+	// POST   /endpoints/{id}/docker/volumes/prune
+	// GET    /endpoints/{id}/docker/volumes/{name}
+	// DELETE /endpoints/{id}/docker/volumes/{name}
+	if rest, ok := strings.CutPrefix(tail, "images"); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		s.handlePortlinerImages(w, r, nodeID, rest)
+		return
+	}
+	if rest, ok := strings.CutPrefix(tail, "networks"); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		s.handlePortlinerNetworks(w, r, nodeID, rest)
+		return
+	}
+	if rest, ok := strings.CutPrefix(tail, "volumes"); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		s.handlePortlinerVolumes(w, r, nodeID, rest)
+		return
+	}
+
 	if tail == "containers/json" && r.Method == http.MethodGet {
 		resp, err := s.executeNodeCommand(r.Context(), nodeID, "docker_ps", nil, 20*time.Second)
 		if err != nil {
@@ -902,8 +1497,13 @@ func (s *HTTPServer) handlePortlinerEndpointAction(w http.ResponseWriter, r *htt
 			_ = json.NewEncoder(w).Encode(map[string]string{"output": string(resp.Output)})
 			return
 		case "logs":
+			logsArgs, follow := logsArgsFromQuery(containerID, r.URL.Query())
+			if follow {
+				s.streamCommand(w, r, nodeID, "docker_logs", logsArgs)
+				return
+			}
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			resp, err := s.executeNodeCommand(r.Context(), nodeID, "docker_logs", []string{containerID}, 20*time.Second)
+			resp, err := s.executeNodeCommand(r.Context(), nodeID, "docker_logs", logsArgs, 20*time.Second)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -970,6 +1570,12 @@ func (s *HTTPServer) handlePortlinerContainerCreate(w http.ResponseWriter, r *ht
 	type hostPortBinding struct {
 		HostPort string `json:"HostPort"`
 	}
+	type endpointConfig struct {
+		Aliases    []string `json:"Aliases"`
+		IPAMConfig *struct {
+			IPv4Address string `json:"IPv4Address"`
+		} `json:"IPAMConfig"`
+	}
 	type createRequest struct {
 		Image      string   `json:"Image"`
 		Name       string   `json:"Name"`
@@ -979,7 +1585,21 @@ func (s *HTTPServer) handlePortlinerContainerCreate(w http.ResponseWriter, r *ht
 			RestartPolicy struct {
 				Name string `json:"Name"`
 			} `json:"RestartPolicy"`
+			NetworkMode string   `json:"NetworkMode"`
+			Binds       []string `json:"Binds"`
+			Mounts      []struct {
+				Type         string `json:"Type"`
+				Source       string `json:"Source"`
+				Target       string `json:"Target"`
+				ReadOnly     bool   `json:"ReadOnly"`
+				TmpfsOptions *struct {
+					SizeBytes int64 `json:"SizeBytes"`
+				} `json:"TmpfsOptions"`
+			} `json:"Mounts"`
 		} `json:"HostConfig"`
+		NetworkingConfig struct {
+			EndpointsConfig map[string]endpointConfig `json:"EndpointsConfig"`
+		} `json:"NetworkingConfig"`
 	}
 
 	var req createRequest
@@ -1025,6 +1645,60 @@ func (s *HTTPServer) handlePortlinerContainerCreate(w http.ResponseWriter, r *ht
 		payload["restart_policy"] = restartPolicy
 	}
 
+	// NetworkMode names the primary network; EndpointsConfig may add more.
+	networkNames := make([]string, 0, len(req.NetworkingConfig.EndpointsConfig)+1)
+	if mode := strings.TrimSpace(req.HostConfig.NetworkMode); mode != "" && mode != "default" {
+		networkNames = append(networkNames, mode)
+	}
+	endpointNames := make([]string, 0, len(req.NetworkingConfig.EndpointsConfig))
+	for name := range req.NetworkingConfig.EndpointsConfig {
+		if len(networkNames) == 0 || name != networkNames[0] {
+			endpointNames = append(endpointNames, name)
+		}
+	}
+	sort.Strings(endpointNames)
+	networks := make([]map[string]interface{}, 0)
+	for _, name := range append(networkNames, endpointNames...) {
+		n := map[string]interface{}{"name": name}
+		if ep, ok := req.NetworkingConfig.EndpointsConfig[name]; ok {
+			if len(ep.Aliases) > 0 {
+				n["aliases"] = ep.Aliases
+			}
+			if ep.IPAMConfig != nil && ep.IPAMConfig.IPv4Address != "" {
+				n["ipv4_address"] = ep.IPAMConfig.IPv4Address
+			}
+		}
+		networks = append(networks, n)
+	}
+	if len(networks) > 0 {
+		payload["networks"] = networks
+	}
+
+	// Binds keep the `docker run -v` syntax; Mounts become mount objects.
+	volumes := make([]interface{}, 0, len(req.HostConfig.Binds)+len(req.HostConfig.Mounts))
+	for _, bind := range req.HostConfig.Binds {
+		if bind = strings.TrimSpace(bind); bind != "" {
+			volumes = append(volumes, bind)
+		}
+	}
+	for _, m := range req.HostConfig.Mounts {
+		v := map[string]interface{}{
+			"type":   strings.ToLower(m.Type),
+			"source": m.Source,
+			"target": m.Target,
+		}
+		if m.ReadOnly {
+			v["read_only"] = true
+		}
+		if m.TmpfsOptions != nil && m.TmpfsOptions.SizeBytes > 0 {
+			v["size"] = strconv.FormatInt(m.TmpfsOptions.SizeBytes, 10)
+		}
+		volumes = append(volumes, v)
+	}
+	if len(volumes) > 0 {
+		payload["volumes"] = volumes
+	}
+
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		http.Error(w, "Failed to encode payload", http.StatusInternalServerError)
@@ -1073,6 +1747,24 @@ func (s *HTTPServer) resolvePortlinerNodeID(ctx context.Context, endpointID stri
 	return "", fmt.Errorf("endpoint %s not found", endpointID)
 }
 
+// dockerErrorStatus maps the agent error of an image, volume or network
+// command to the status the Docker Engine API answers with: 404 for a
+// missing object, 409 for one a container still uses, 500 otherwise. Agents
+// only report the daemon's error text.
+func dockerErrorStatus(err error) int {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "no such image"), strings.Contains(msg, "no such volume"),
+		strings.Contains(msg, "no such network"), strings.Contains(msg, "no such container"),
+		strings.Contains(msg, "network") && strings.Contains(msg, "not found"):
+		return http.StatusNotFound
+	case strings.Contains(msg, "conflict:"), strings.Contains(msg, "is using"), strings.Contains(msg, "being used"),
+		strings.Contains(msg, "volume is in use"), strings.Contains(msg, "has active endpoints"):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 func (s *HTTPServer) handleContainerActionDynamic(w http.ResponseWriter, r *http.Request, path string) {
 	// path is everything after /api/nodes/
 	// Format: NODEID/containers/CONTAINERID/ACTION
@@ -1122,16 +1814,16 @@ func (s *HTTPServer) handleContainerActionDynamic(w http.ResponseWriter, r *http
 			http.Error(w, "Invalid path for DELETE", http.StatusBadRequest)
 			return
 		}
-		s.proxyCommand(w, nodeID, "docker_rm", []string{containerID})
+		s.proxyCommand(w, r, nodeID, "docker_rm", []string{containerID})
 		return
 	}
 
 	if r.Method == http.MethodPost {
 		switch action {
 		case "start":
-			s.proxyCommand(w, nodeID, "docker_start", []string{containerID})
+			s.proxyCommand(w, r, nodeID, "docker_start", []string{containerID})
 		case "stop":
-			s.proxyCommand(w, nodeID, "docker_stop", []string{containerID})
+			s.proxyCommand(w, r, nodeID, "docker_stop", []string{containerID})
 		case "restart-policy":
 			var req RestartPolicyRequest
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1143,7 +1835,7 @@ func (s *HTTPServer) handleContainerActionDynamic(w http.ResponseWriter, r *http
 				http.Error(w, "policy is required", http.StatusBadRequest)
 				return
 			}
-			s.proxyCommand(w, nodeID, "docker_update_restart", []string{containerID, policy})
+			s.proxyCommand(w, r, nodeID, "docker_update_restart", []string{containerID, policy})
 		case "exec":
 			var req ExecRequest
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1156,7 +1848,7 @@ func (s *HTTPServer) handleContainerActionDynamic(w http.ResponseWriter, r *http
 			}
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			args := append([]string{containerID}, req.Cmd...)
-			s.proxyCommand(w, nodeID, "docker_exec", args)
+			s.proxyCommand(w, r, nodeID, "docker_exec", args)
 		default:
 			http.Error(w, "Unknown action: "+action, http.StatusBadRequest)
 		}
@@ -1164,19 +1856,28 @@ func (s *HTTPServer) handleContainerActionDynamic(w http.ResponseWriter, r *http
 	}
 
 	if r.Method == http.MethodGet {
+		if action == "exec/ws" {
+			s.handleExecWebSocket(w, r, nodeID, containerID)
+			return
+		}
 		if action == "logs" {
+			logsArgs, follow := logsArgsFromQuery(containerID, r.URL.Query())
+			if follow {
+				s.streamCommand(w, r, nodeID, "docker_logs", logsArgs)
+				return
+			}
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			s.proxyCommand(w, nodeID, "docker_logs", []string{containerID})
+			s.proxyCommand(w, r, nodeID, "docker_logs", logsArgs)
 			return
 		}
 		if action == "inspect" {
 			w.Header().Set("Content-Type", "application/json")
-			s.proxyCommand(w, nodeID, "docker_inspect", []string{containerID})
+			s.proxyCommand(w, r, nodeID, "docker_inspect", []string{containerID})
 			return
 		}
 		if action == "stats" {
 			w.Header().Set("Content-Type", "application/json")
-			s.proxyCommand(w, nodeID, "docker_stats", []string{containerID})
+			s.proxyCommand(w, r, nodeID, "docker_stats", []string{containerID})
 			return
 		}
 	}
@@ -1184,16 +1885,19 @@ func (s *HTTPServer) handleContainerActionDynamic(w http.ResponseWriter, r *http
 	http.NotFound(w, r)
 }
 
-func (s *HTTPServer) proxyCommand(w http.ResponseWriter, nodeID, cmd string, args []string) {
+func (s *HTTPServer) proxyCommand(w http.ResponseWriter, r *http.Request, nodeID, cmd string, args []string) {
 	timeout := 15 * time.Second
 	switch cmd {
 	case "docker_logs":
 		timeout = 120 * time.Second
-	case "stack_up", "stack_down", "docker_run":
+	case "stack_up", "stack_down":
 		timeout = 60 * time.Second
+	case "image_pull", "docker_run":
+		timeout = imagePullTimeout
 	}
 
-	resp, err := s.executeNodeCommand(context.Background(), nodeID, cmd, args, timeout)
+	// Commands keep running if the client disconnects; only request values (issuer) are kept.
+	resp, err := s.executeNodeCommand(context.WithoutCancel(r.Context()), nodeID, cmd, args, timeout)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -1201,82 +1905,170 @@ func (s *HTTPServer) proxyCommand(w http.ResponseWriter, nodeID, cmd string, arg
 	w.Write(resp.Output)
 }
 
-func (s *HTTPServer) executeNodeCommand(ctx context.Context, nodeID, cmd string, args []string, timeout time.Duration) (*pb.ExecuteCommandResponse, error) {
-	if timeout <= 0 {
-		timeout = 15 * time.Second
+// logsArgsFromQuery builds docker_logs arguments from the follow, since, tail
+// and timestamps query parameters (same names as the Docker Engine API).
+func logsArgsFromQuery(containerID string, q url.Values) ([]string, bool) {
+	follow := queryBool(q, "follow")
+	opts := map[string]interface{}{}
+	if follow {
+		opts["follow"] = true
+	}
+	if since := strings.TrimSpace(q.Get("since")); since != "" {
+		opts["since"] = since
+	}
+	if tail := strings.TrimSpace(q.Get("tail")); tail != "" {
+		opts["tail"] = tail
+	}
+	if queryBool(q, "timestamps") {
+		opts["timestamps"] = true
 	}
-	cctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
 
-	resp, err := s.grpcServer.ExecuteCommand(cctx, &pb.ExecuteCommandRequest{
+	args := []string{containerID}
+	if len(opts) > 0 {
+		b, _ := json.Marshal(opts)
+		args = append(args, string(b))
+	}
+	return args, follow
+}
+
+func queryBool(q url.Values, key string) bool {
+	v, err := strconv.ParseBool(strings.TrimSpace(q.Get(key)))
+	return err == nil && v
+}
+
+// wantsStream reports whether the client asked for a command's progress
+// (image pulls) as it happens: with text/event-stream, ?format=sse or
+// ?progress=1.
+func wantsStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream") ||
+		r.URL.Query().Get("format") == "sse" || queryBool(r.URL.Query(), "progress")
+}
+
+// streamCommand relays a streaming agent command to the client as it runs.
+// Clients asking for text/event-stream (or ?format=sse) get one SSE event per
+// output line and a "result" event with the final output, everyone else gets
+// chunked plain text. The command is cancelled on the agent once the client
+// goes away.
+func (s *HTTPServer) streamCommand(w http.ResponseWriter, r *http.Request, nodeID, cmd string, args []string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	if !s.grpcServer.nodeConnected(nodeID) {
+		http.Error(w, fmt.Sprintf("node %s not connected", nodeID), http.StatusInternalServerError)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream") || r.URL.Query().Get("format") == "sse"
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	lines := &sseLineWriter{w: w}
+	resp, err := s.grpcServer.StreamCommand(r.Context(), &pb.ExecuteCommandRequest{
 		NodeId:  nodeID,
 		Command: cmd,
 		Args:    args,
+	}, func(data []byte) error {
+		var err error
+		if sse {
+			err = lines.Write(data)
+		} else {
+			_, err = w.Write(data)
+		}
+		flusher.Flush()
+		return err
 	})
-	if err != nil {
-		return nil, err
+	if r.Context().Err() != nil {
+		return
 	}
-	if resp.ExitCode != 0 {
-		msg := strings.TrimSpace(resp.Error)
+
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	} else if resp.ExitCode != 0 {
+		msg = strings.TrimSpace(resp.Error)
 		if msg == "" {
 			msg = fmt.Sprintf("command %s failed", cmd)
 		}
-		return nil, errors.New(msg)
 	}
-	return resp, nil
-}
 
-func (s *HTTPServer) handleBootstrapCerts(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	if sse {
+		_ = lines.Flush()
+		if msg != "" {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", strings.ReplaceAll(msg, "\n", " "))
+		} else if len(resp.Output) > 0 {
+			fmt.Fprintf(w, "event: result\ndata: %s\n\n", bytes.ReplaceAll(resp.Output, []byte("\n"), []byte(" ")))
+		}
+		fmt.Fprint(w, "event: end\ndata: \n\n")
+	} else if msg != "" {
+		log.Printf("Streaming %s on %s failed: %s", cmd, nodeID, msg)
+	} else if len(resp.Output) > 0 {
+		w.Write(resp.Output)
 	}
+	flusher.Flush()
+}
 
-	bootstrapToken := strings.TrimSpace(os.Getenv("DOCKLET_BOOTSTRAP_TOKEN"))
-	if bootstrapToken == "" {
-		bootstrapToken = "bootstrap-token-123"
-	}
+// sseLineWriter emits one SSE data event per complete line, holding back
+// partial lines until the rest arrives.
+type sseLineWriter struct {
+	w       http.ResponseWriter
+	pending []byte
+}
 
-	if r.URL.Query().Get("token") != bootstrapToken {
-		http.Error(w, "Invalid bootstrap token", http.StatusUnauthorized)
-		return
+func (l *sseLineWriter) Write(data []byte) error {
+	l.pending = append(l.pending, data...)
+	for {
+		i := bytes.IndexByte(l.pending, '\n')
+		if i < 0 {
+			return nil
+		}
+		line := bytes.TrimRight(l.pending[:i], "\r")
+		l.pending = l.pending[i+1:]
+		if _, err := fmt.Fprintf(l.w, "data: %s\n\n", line); err != nil {
+			return err
+		}
 	}
+}
 
-	// Create a zip or just return JSON with file contents?
-	// For simplicity, let's return a JSON with 3 files.
-	type CertsResponse struct {
-		CACert    string `json:"ca_cert"`
-		AgentCert string `json:"agent_cert"`
-		AgentKey  string `json:"agent_key"`
+func (l *sseLineWriter) Flush() error {
+	if len(l.pending) == 0 {
+		return nil
 	}
+	line := bytes.TrimRight(l.pending, "\r")
+	l.pending = nil
+	_, err := fmt.Fprintf(l.w, "data: %s\n\n", line)
+	return err
+}
 
-	w.Header().Set("Content-Type", "application/json")
-
-	// Read files
-	// Assuming certs are in ./certs relative to CWD
-	ca, err := readFile("certs/ca-cert.pem")
-	if err != nil {
-		http.Error(w, "CA missing", http.StatusInternalServerError)
-		return
+func (s *HTTPServer) executeNodeCommand(ctx context.Context, nodeID, cmd string, args []string, timeout time.Duration) (*pb.ExecuteCommandResponse, error) {
+	if timeout <= 0 {
+		timeout = 15 * time.Second
 	}
-	cert, err := readFile("certs/agent-cert.pem")
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := s.grpcServer.ExecuteCommand(cctx, &pb.ExecuteCommandRequest{
+		NodeId:  nodeID,
+		Command: cmd,
+		Args:    args,
+	})
 	if err != nil {
-		http.Error(w, "Agent cert missing", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-	key, err := readFile("certs/agent-key.pem")
-	if err != nil {
-		http.Error(w, "Agent key missing", http.StatusInternalServerError)
-		return
+	if resp.ExitCode != 0 {
+		msg := strings.TrimSpace(resp.Error)
+		if msg == "" {
+			msg = fmt.Sprintf("command %s failed", cmd)
+		}
+		return nil, errors.New(msg)
 	}
-
-	json.NewEncoder(w).Encode(CertsResponse{
-		CACert:    string(ca),
-		AgentCert: string(cert),
-		AgentKey:  string(key),
-	})
-}
-
-func readFile(path string) ([]byte, error) {
-	return os.ReadFile(path)
+	return resp, nil
 }
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+)
+
+// imagePullTimeout bounds image_pull; large images take a while.
+const imagePullTimeout = 10 * time.Minute
+
+// errNoImageRoute marks an image request that matches no route.
+var errNoImageRoute = errors.New("no such image route")
+
+// imageRequest maps an image request to an agent command. rest is the path
+// after ".../images", routed like the Docker Engine API:
+//
+//	GET    /json or /          list (?all, ?filters)
+//	POST   /create or /pull    pull (?fromImage&tag, or {"image": ...})
+//	POST   /prune              prune (?all, ?filters)
+//	GET    /{name}/json        inspect (also /{name})
+//	POST   /{name}/tag         tag (?repo&tag, or {"target": ...})
+//	DELETE /{name}             remove (?force, ?noprune)
+//
+// Image names may contain slashes.
+func imageRequest(r *http.Request, rest string) (cmd string, args []string, err error) {
+	q := r.URL.Query()
+	rest = strings.Trim(rest, "/")
+
+	opts := map[string]interface{}{}
+	for _, key := range []string{"all", "force", "noprune"} {
+		if queryBool(q, key) {
+			opts[key] = true
+		}
+	}
+	if f := strings.TrimSpace(q.Get("filters")); f != "" {
+		opts["filters"] = f
+	}
+	optsArg := func() []string {
+		if len(opts) == 0 {
+			return nil
+		}
+		b, _ := json.Marshal(opts)
+		return []string{string(b)}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && (rest == "" || rest == "json"):
+		return "image_ls", optsArg(), nil
+
+	case r.Method == http.MethodPost && (rest == "create" || rest == "pull"):
+		ref := strings.TrimSpace(q.Get("fromImage"))
+		if ref == "" {
+			var req struct {
+				Image string `json:"image"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return "", nil, errors.New("image is required")
+			}
+			ref = strings.TrimSpace(req.Image)
+		}
+		if ref == "" {
+			return "", nil, errors.New("image is required")
+		}
+		if tag := strings.TrimSpace(q.Get("tag")); tag != "" {
+			if strings.Contains(tag, ":") {
+				ref += "@" + tag // digest
+			} else {
+				ref += ":" + tag
+			}
+		}
+		return "image_pull", []string{ref}, nil
+
+	case r.Method == http.MethodPost && rest == "prune":
+		return "image_prune", optsArg(), nil
+
+	case r.Method == http.MethodPost && strings.HasSuffix(rest, "/tag"):
+		source := strings.TrimSuffix(rest, "/tag")
+		target := strings.TrimSpace(q.Get("repo"))
+		if target == "" {
+			var req struct {
+				Target string `json:"target"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return "", nil, errors.New("target is required")
+			}
+			target = strings.TrimSpace(req.Target)
+		} else if tag := strings.TrimSpace(q.Get("tag")); tag != "" {
+			target += ":" + tag
+		}
+		if target == "" {
+			return "", nil, errors.New("target is required")
+		}
+		return "image_tag", []string{source, target}, nil
+
+	case r.Method == http.MethodGet && rest != "":
+		return "image_inspect", []string{strings.TrimSuffix(rest, "/json")}, nil
+
+	case r.Method == http.MethodDelete && rest != "":
+		return "image_rm", append([]string{rest}, optsArg()...), nil
+	}
+	return "", nil, errNoImageRoute
+}
+
+// handleNodeImages serves /api/nodes/{id}/images.
+func (s *HTTPServer) handleNodeImages(w http.ResponseWriter, r *http.Request, nodeID, rest string) {
+	cmd, args, err := imageRequest(r, rest)
+	if err == errNoImageRoute {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cmd == "image_pull" && wantsStream(r) {
+		s.streamCommand(w, r, nodeID, cmd, args)
+		return
+	}
+	if cmd == "image_pull" || cmd == "image_tag" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	s.proxyCommand(w, r, nodeID, cmd, args)
+}
+
+// handlePortlinerImages serves the Portainer docker/images routes.
+func (s *HTTPServer) handlePortlinerImages(w http.ResponseWriter, r *http.Request, nodeID, rest string) {
+	cmd, args, err := imageRequest(r, rest)
+	if err == errNoImageRoute {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cmd == "image_pull" {
+		s.streamPull(w, r, nodeID, args)
+		return
+	}
+	resp, err := s.executeNodeCommand(r.Context(), nodeID, cmd, args, 20*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), dockerErrorStatus(err))
+		return
+	}
+
+	switch cmd {
+	case "image_tag":
+		w.WriteHeader(http.StatusCreated)
+	default:
+		if json.Valid(resp.Output) {
+			w.Write(resp.Output)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"output": string(resp.Output)})
+	}
+}
+
+// streamPull relays the agent's pull progress like the Engine API does: one
+// JSON message per line, ending with a status or error message.
+func (s *HTTPServer) streamPull(w http.ResponseWriter, r *http.Request, nodeID string, args []string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	if !s.grpcServer.nodeConnected(nodeID) {
+		http.Error(w, fmt.Sprintf("node %s not connected", nodeID), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx, cancel := context.WithTimeout(r.Context(), imagePullTimeout)
+	defer cancel()
+	resp, err := s.grpcServer.StreamCommand(ctx, &pb.ExecuteCommandRequest{
+		NodeId:  nodeID,
+		Command: "image_pull",
+		Args:    args,
+	}, func(data []byte) error {
+		_, err := w.Write(data)
+		flusher.Flush()
+		return err
+	})
+	if r.Context().Err() != nil {
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	switch {
+	case err != nil:
+		_ = enc.Encode(map[string]string{"error": err.Error()})
+	case resp.ExitCode != 0:
+		_ = enc.Encode(map[string]string{"error": strings.TrimSpace(resp.Error)})
+	default:
+		_ = enc.Encode(map[string]string{"status": string(resp.Output)})
+	}
+	flusher.Flush()
+}
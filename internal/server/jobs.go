@@ -0,0 +1,416 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/astracat/docklet/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	fallbackJobParallelism = 10
+	maxJobParallelism      = 100
+)
+
+// defaultJobParallelism reads DOCKLET_JOB_PARALLELISM (nodes handled at once per job).
+func defaultJobParallelism() int {
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("DOCKLET_JOB_PARALLELISM"))); err == nil && v > 0 {
+		return v
+	}
+	return fallbackJobParallelism
+}
+
+// NodeTask is one command of a job, sent to a single node.
+type NodeTask struct {
+	NodeID  string
+	Command string
+	Args    []string
+	Timeout time.Duration
+
+	// Check, if set, runs after the command succeeded; an error fails the task.
+	Check func(ctx context.Context) error
+}
+
+// Rollout splits the tasks of a job into batches that run one after another.
+// A batch with a failed node halts the job: tasks of later batches are not
+// run and end as canceled.
+type Rollout struct {
+	BatchSize int           // <= 0 runs all tasks as a single batch
+	Pause     time.Duration // wait between batches
+}
+
+// NodeTaskResult is the outcome of a NodeTask.
+type NodeTaskResult struct {
+	NodeID    string `json:"node_id"`
+	Status    string `json:"status"`
+	OK        bool   `json:"ok"`
+	ExitCode  int32  `json:"exit_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Output    string `json:"output,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// JobEvent is published to subscribers whenever a node finishes and once
+// more when the whole job is done (Final).
+type JobEvent struct {
+	JobID  string          `json:"job_id"`
+	Status string          `json:"status"`
+	Done   int             `json:"done"`
+	Total  int             `json:"total"`
+	Node   *NodeTaskResult `json:"node,omitempty"`
+	Final  bool            `json:"final,omitempty"`
+}
+
+// JobHandle tracks a submitted job.
+type JobHandle struct {
+	ID string
+
+	done    chan struct{}
+	results []NodeTaskResult
+	status  string
+}
+
+// Done is closed when every task has finished or the job was cancelled.
+func (h *JobHandle) Done() <-chan struct{} { return h.done }
+
+// Results returns per-node results in task order; valid after Done.
+func (h *JobHandle) Results() []NodeTaskResult { return h.results }
+
+// Status returns the final job status; valid after Done.
+func (h *JobHandle) Status() string { return h.status }
+
+type runningJob struct {
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	subscribers map[chan JobEvent]struct{}
+}
+
+// JobRunner runs hub-side jobs: a command fanned out to many nodes
+// concurrently, with at most `parallelism` nodes in flight per job.
+// Jobs are recorded in the job history with their per-node commands as children.
+type JobRunner struct {
+	server      *DockletServer
+	parallelism int
+
+	mu      sync.Mutex
+	running map[string]*runningJob
+}
+
+func NewJobRunner(server *DockletServer, parallelism int) *JobRunner {
+	if parallelism <= 0 {
+		parallelism = fallbackJobParallelism
+	}
+	return &JobRunner{
+		server:      server,
+		parallelism: parallelism,
+		running:     make(map[string]*runningJob),
+	}
+}
+
+type parentJobKey struct{}
+
+func withParentJob(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, parentJobKey{}, jobID)
+}
+
+func parentJobFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(parentJobKey{}).(string)
+	return id
+}
+
+// Submit records a job of jobType and starts running tasks in the background.
+// ctx only provides request values (issuer); the job outlives it. args are
+// stored on the job record for the history, redacted and truncated like
+// those of single commands. parallelism <= 0 uses the default.
+func (j *JobRunner) Submit(ctx context.Context, jobType string, args []string, tasks []NodeTask, parallelism int) (*JobHandle, error) {
+	return j.SubmitRollout(ctx, jobType, args, tasks, parallelism, Rollout{})
+}
+
+// SubmitRollout is Submit with tasks run in the batches of rollout.
+func (j *JobRunner) SubmitRollout(ctx context.Context, jobType string, args []string, tasks []NodeTask, parallelism int, rollout Rollout) (*JobHandle, error) {
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("no nodes to run on")
+	}
+	if parallelism <= 0 {
+		parallelism = j.parallelism
+	}
+	if parallelism > maxJobParallelism {
+		parallelism = maxJobParallelism
+	}
+
+	handle := &JobHandle{
+		ID:      uuid.NewString(),
+		done:    make(chan struct{}),
+		results: make([]NodeTaskResult, len(tasks)),
+	}
+
+	dbCtx, dbCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer dbCancel()
+	err := j.server.Repo.CreateJob(dbCtx, &storage.Job{
+		ID:        handle.ID,
+		Type:      jobType,
+		Args:      jobArgs(jobType, args),
+		IssuedBy:  issuerFromContext(ctx),
+		Status:    storage.JobRunning,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		j.server.metrics.storageError("create_job")
+		return nil, fmt.Errorf("failed to record job: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(withParentJob(context.WithoutCancel(ctx), handle.ID))
+	rj := &runningJob{
+		cancel:      cancel,
+		subscribers: make(map[chan JobEvent]struct{}),
+	}
+	j.mu.Lock()
+	j.running[handle.ID] = rj
+	j.mu.Unlock()
+
+	go j.run(runCtx, handle, rj, tasks, parallelism, rollout)
+	return handle, nil
+}
+
+func (j *JobRunner) run(ctx context.Context, handle *JobHandle, rj *runningJob, tasks []NodeTask, parallelism int, rollout Rollout) {
+	defer rj.cancel()
+
+	var (
+		wg       sync.WaitGroup
+		progress sync.Mutex
+		done     int
+	)
+	sem := make(chan struct{}, parallelism)
+	record := func(i int, res NodeTaskResult) {
+		progress.Lock()
+		handle.results[i] = res
+		done++
+		event := JobEvent{JobID: handle.ID, Status: storage.JobRunning, Done: done, Total: len(tasks), Node: &res}
+		progress.Unlock()
+		j.publish(rj, event)
+	}
+
+	batchSize := rollout.BatchSize
+	if batchSize <= 0 || batchSize > len(tasks) {
+		batchSize = len(tasks)
+	}
+	halted := ""
+	for start := 0; start < len(tasks); start += batchSize {
+		end := min(start+batchSize, len(tasks))
+		if halted == "" && start > 0 && rollout.Pause > 0 {
+			select {
+			case <-time.After(rollout.Pause):
+			case <-ctx.Done():
+			}
+		}
+		if halted == "" && ctx.Err() != nil {
+			halted = "job canceled"
+		}
+		if halted != "" {
+			// Skipped tasks are recorded, but nothing is sent to their nodes.
+			for i := start; i < end; i++ {
+				record(i, canceledTask(tasks[i], halted))
+			}
+			continue
+		}
+
+		for i := start; i < end; i++ {
+			wg.Add(1)
+			go func(i int, task NodeTask) {
+				defer wg.Done()
+
+				// select picks at random among ready cases; check the
+				// cancel first so a free slot does not start the task.
+				if ctx.Err() != nil {
+					record(i, canceledTask(task, "job canceled"))
+					return
+				}
+				var res NodeTaskResult
+				select {
+				case sem <- struct{}{}:
+					res = j.runTask(ctx, task)
+					<-sem
+				case <-ctx.Done():
+					res = canceledTask(task, "job canceled")
+				}
+				record(i, res)
+			}(i, tasks[i])
+		}
+		wg.Wait()
+
+		for i := start; i < end && end < len(tasks); i++ {
+			if !handle.results[i].OK {
+				halted = fmt.Sprintf("rollout halted: batch %d failed", start/batchSize+1)
+				log.Printf("Job %s: %s on %s", handle.ID, halted, handle.results[i].NodeID)
+				break
+			}
+		}
+	}
+
+	jobStatus := storage.JobSucceeded
+	for _, res := range handle.results {
+		if !res.OK {
+			jobStatus = storage.JobFailed
+		}
+	}
+	if ctx.Err() != nil {
+		jobStatus = storage.JobCanceled
+	}
+	handle.status = jobStatus
+
+	summary, _ := json.Marshal(handle.results)
+	exitCode := int32(0)
+	errMsg := ""
+	if jobStatus != storage.JobSucceeded {
+		exitCode = 1
+		errMsg = fmt.Sprintf("job %s", jobStatus)
+	}
+	j.server.saveJobOutcome(&storage.Job{
+		ID:         handle.ID,
+		Status:     jobStatus,
+		ExitCode:   exitCode,
+		Error:      errMsg,
+		Output:     []byte(truncate(string(summary), maxJobOutputBytes)),
+		FinishedAt: time.Now(),
+	})
+
+	j.mu.Lock()
+	delete(j.running, handle.ID)
+	j.mu.Unlock()
+
+	j.publish(rj, JobEvent{JobID: handle.ID, Status: jobStatus, Done: len(tasks), Total: len(tasks), Final: true})
+	rj.mu.Lock()
+	for ch := range rj.subscribers {
+		close(ch)
+	}
+	rj.subscribers = nil
+	rj.mu.Unlock()
+
+	close(handle.done)
+}
+
+// canceledTask is the result of a task that was never sent to its node.
+func canceledTask(task NodeTask, reason string) NodeTaskResult {
+	return NodeTaskResult{NodeID: task.NodeID, Status: storage.JobCanceled, Error: reason, Timestamp: time.Now().Unix()}
+}
+
+func (j *JobRunner) runTask(ctx context.Context, task NodeTask) NodeTaskResult {
+	if ctx.Err() != nil {
+		return canceledTask(task, "job canceled")
+	}
+	timeout := task.Timeout
+	if timeout <= 0 {
+		timeout = 90 * time.Second
+	}
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res := NodeTaskResult{NodeID: task.NodeID}
+	resp, err := j.server.ExecuteCommand(cctx, &pb.ExecuteCommandRequest{
+		NodeId:  task.NodeID,
+		Command: task.Command,
+		Args:    task.Args,
+	})
+	res.Timestamp = time.Now().Unix()
+	if err != nil {
+		res.Status = storage.JobFailed
+		if ctx.Err() != nil {
+			res.Status = storage.JobCanceled
+		}
+		res.Error = err.Error()
+		return res
+	}
+
+	res.OK = resp.ExitCode == 0
+	res.ExitCode = resp.ExitCode
+	res.Output = string(resp.Output)
+	res.Status = storage.JobSucceeded
+	if !res.OK {
+		res.Status = storage.JobFailed
+		res.Error = resp.Error
+		return res
+	}
+
+	if task.Check != nil {
+		if err := task.Check(ctx); err != nil {
+			res.OK = false
+			res.Status = storage.JobFailed
+			if ctx.Err() != nil {
+				res.Status = storage.JobCanceled
+			}
+			res.Error = err.Error()
+			res.Timestamp = time.Now().Unix()
+		}
+	}
+	return res
+}
+
+func (j *JobRunner) runningCount() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.running)
+}
+
+// Cancel stops a running job. Nodes already executing are asked to abort.
+func (j *JobRunner) Cancel(id string) bool {
+	j.mu.Lock()
+	rj, ok := j.running[id]
+	j.mu.Unlock()
+	if !ok {
+		return false
+	}
+	rj.cancel()
+	return true
+}
+
+// Subscribe returns a channel of events for a running job, closed after the
+// final event. ok is false when the job is not running on this hub.
+func (j *JobRunner) Subscribe(id string) (events <-chan JobEvent, unsubscribe func(), ok bool) {
+	j.mu.Lock()
+	rj, ok := j.running[id]
+	j.mu.Unlock()
+	if !ok {
+		return nil, func() {}, false
+	}
+
+	ch := make(chan JobEvent, 64)
+	rj.mu.Lock()
+	if rj.subscribers == nil {
+		// Finished between the lookup and now.
+		rj.mu.Unlock()
+		return nil, func() {}, false
+	}
+	rj.subscribers[ch] = struct{}{}
+	rj.mu.Unlock()
+
+	unsubscribe = func() {
+		rj.mu.Lock()
+		defer rj.mu.Unlock()
+		if _, ok := rj.subscribers[ch]; ok {
+			delete(rj.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe, true
+}
+
+func (j *JobRunner) publish(rj *runningJob, event JobEvent) {
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+	for ch := range rj.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Job %s: subscriber too slow, dropping event", event.JobID)
+		}
+	}
+}
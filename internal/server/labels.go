@@ -0,0 +1,408 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/astracat/docklet/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Label keys may carry a DNS-style prefix ("docklet.io/role"); values are short words.
+var (
+	labelKeyRe   = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]*[a-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelValueRe = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+)
+
+const (
+	maxLabelKeyLen   = 253
+	maxLabelValueLen = 63
+)
+
+func validateLabel(key, value string) error {
+	if len(key) > maxLabelKeyLen || !labelKeyRe.MatchString(key) {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	if len(value) > maxLabelValueLen || !labelValueRe.MatchString(value) {
+		return fmt.Errorf("invalid value %q for label %q", value, key)
+	}
+	return nil
+}
+
+// parseTaint splits "key" or "key=value".
+func parseTaint(taint string) (key, value string, err error) {
+	key, value, _ = strings.Cut(strings.TrimSpace(taint), "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if err := validateLabel(key, value); err != nil {
+		return "", "", fmt.Errorf("invalid taint %q: %w", taint, err)
+	}
+	return key, value, nil
+}
+
+func formatTaint(key, value string) string {
+	if value == "" {
+		return key
+	}
+	return key + "=" + value
+}
+
+// Selector operators.
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorExists    = "exists"
+	selectorNotExists = "!exists"
+)
+
+type selectorRequirement struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+// LabelSelector is a comma-separated list of requirements that must all hold:
+// "key=value", "key!=value", "key" (label present) and "!key" (label absent).
+type LabelSelector []selectorRequirement
+
+// ParseSelector parses a selector such as "region=eu,role=edge".
+func ParseSelector(s string) (LabelSelector, error) {
+	var sel LabelSelector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var req selectorRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			key, value, _ := strings.Cut(part, "!=")
+			req = selectorRequirement{Key: strings.TrimSpace(key), Op: selectorNotEquals, Value: strings.TrimSpace(value)}
+		case strings.Contains(part, "="):
+			key, value, _ := strings.Cut(part, "=")
+			req = selectorRequirement{Key: strings.TrimSpace(key), Op: selectorEquals, Value: strings.TrimSpace(strings.TrimPrefix(value, "="))}
+		case strings.HasPrefix(part, "!"):
+			req = selectorRequirement{Key: strings.TrimSpace(part[1:]), Op: selectorNotExists}
+		default:
+			req = selectorRequirement{Key: part, Op: selectorExists}
+		}
+		if err := validateLabel(req.Key, req.Value); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", part, err)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// Empty reports whether the selector has no requirements.
+func (sel LabelSelector) Empty() bool { return len(sel) == 0 }
+
+// Matches reports whether node satisfies every requirement and the selector
+// tolerates its taints. Taints count as labels here, so "dedicated=db" both
+// tolerates and matches the taint dedicated=db.
+func (sel LabelSelector) Matches(node *storage.Node) bool {
+	taints := make(map[string]string, len(node.Taints))
+	for _, taint := range node.Taints {
+		key, value, _ := strings.Cut(taint, "=")
+		taints[key] = value
+	}
+	for _, req := range sel {
+		value, ok := node.Labels[req.Key]
+		if !ok {
+			value, ok = taints[req.Key]
+		}
+		switch req.Op {
+		case selectorEquals:
+			if !ok || value != req.Value {
+				return false
+			}
+		case selectorNotEquals:
+			if ok && value == req.Value {
+				return false
+			}
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	for key := range taints {
+		if !sel.names(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// names reports whether a positive requirement mentions key.
+func (sel LabelSelector) names(key string) bool {
+	for _, req := range sel {
+		if req.Key == key && (req.Op == selectorEquals || req.Op == selectorExists) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectNodes returns the ids of known nodes matching selector, sorted.
+func (s *DockletServer) SelectNodes(ctx context.Context, selector string) ([]string, error) {
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if sel.Empty() {
+		return nil, status.Error(codes.InvalidArgument, "selector is empty")
+	}
+	nodes, err := s.listNodesWithCleanup(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to fetch nodes from db: %v", err)
+	}
+	var ids []string
+	for _, n := range nodes {
+		if sel.Matches(n) {
+			ids = append(ids, n.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// resolveTargets combines explicit node ids with the nodes matching selector,
+// without duplicates and in the given order.
+func (s *DockletServer) resolveTargets(ctx context.Context, nodeIDs []string, selector string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	add := func(id string) {
+		id = strings.TrimSpace(id)
+		if id != "" && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	for _, id := range nodeIDs {
+		add(id)
+	}
+	if strings.TrimSpace(selector) != "" {
+		ids, err := s.SelectNodes(ctx, selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			add(id)
+		}
+	}
+	return out, nil
+}
+
+// UpdateNodeLabels changes labels and taints of a known node.
+func (s *DockletServer) UpdateNodeLabels(ctx context.Context, req *pb.UpdateNodeLabelsRequest) (*pb.UpdateNodeLabelsResponse, error) {
+	if err := rejectNodeCert(ctx); err != nil {
+		return nil, err
+	}
+	nodeID := strings.TrimSpace(req.NodeId)
+	if nodeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "node id is required")
+	}
+	for key, value := range req.SetLabels {
+		if err := validateLabel(key, value); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	var addTaints []string
+	for _, taint := range req.AddTaints {
+		key, value, err := parseTaint(taint)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		addTaints = append(addTaints, formatTaint(key, value))
+	}
+
+	node, err := s.Repo.GetNode(ctx, nodeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get node: %v", err)
+	}
+	if node == nil {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", nodeID)
+	}
+
+	labels := maps.Clone(node.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for _, key := range req.RemoveLabels {
+		delete(labels, strings.TrimSpace(key))
+	}
+	maps.Copy(labels, req.SetLabels)
+
+	taints := slices.DeleteFunc(slices.Clone(node.Taints), func(t string) bool {
+		key, _, _ := strings.Cut(t, "=")
+		for _, r := range req.RemoveTaints {
+			r = strings.TrimSpace(r)
+			if r == t || r == key {
+				return true
+			}
+		}
+		return false
+	})
+	taints = mergeTaints(taints, addTaints)
+
+	if err := s.Repo.SetNodeLabels(ctx, nodeID, labels, taints); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update labels: %v", err)
+	}
+	return &pb.UpdateNodeLabelsResponse{Labels: labels, Taints: taints}, nil
+}
+
+// mergeTaints adds taints, replacing existing ones with the same key.
+func mergeTaints(taints, add []string) []string {
+	for _, t := range add {
+		key, _, _ := strings.Cut(t, "=")
+		taints = slices.DeleteFunc(taints, func(old string) bool {
+			oldKey, _, _ := strings.Cut(old, "=")
+			return oldKey == key
+		})
+		taints = append(taints, t)
+	}
+	sort.Strings(taints)
+	return taints
+}
+
+// applyAgentLabels merges the labels and taints from an agent handshake into
+// those stored for the node. Agent config wins for the keys it sets; labels
+// set through the API are kept.
+func (s *DockletServer) applyAgentLabels(ctx context.Context, nodeID string, handshake *pb.Handshake) error {
+	if len(handshake.Labels) == 0 && len(handshake.Taints) == 0 {
+		return nil
+	}
+	var addTaints []string
+	for key, value := range handshake.Labels {
+		if err := validateLabel(key, value); err != nil {
+			return err
+		}
+	}
+	for _, taint := range handshake.Taints {
+		key, value, err := parseTaint(taint)
+		if err != nil {
+			return err
+		}
+		addTaints = append(addTaints, formatTaint(key, value))
+	}
+
+	node, err := s.Repo.GetNode(ctx, nodeID)
+	if err != nil || node == nil {
+		return err
+	}
+	labels := maps.Clone(node.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	maps.Copy(labels, handshake.Labels)
+	taints := mergeTaints(slices.Clone(node.Taints), addTaints)
+
+	if maps.Equal(labels, node.Labels) && slices.Equal(taints, node.Taints) {
+		return nil
+	}
+	return s.Repo.SetNodeLabels(ctx, nodeID, labels, taints)
+}
+
+// handleNodeLabels serves /api/nodes/{id}/labels:
+//
+//	GET    current labels and taints
+//	PUT    {"labels": {...}, "taints": [...]} replaces both
+//	PATCH  {"set": {...}, "remove": [...], "add_taints": [...], "remove_taints": [...]}
+func (s *HTTPServer) handleNodeLabels(w http.ResponseWriter, r *http.Request, nodeID string) {
+	var req *pb.UpdateNodeLabelsRequest
+	switch r.Method {
+	case http.MethodGet:
+		node, err := s.grpcServer.Repo.GetNode(r.Context(), nodeID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if node == nil {
+			http.Error(w, "node not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(labelsResponse(node.Labels, node.Taints))
+		return
+
+	case http.MethodPut:
+		var body struct {
+			Labels map[string]string `json:"labels"`
+			Taints []string          `json:"taints"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		node, err := s.grpcServer.Repo.GetNode(r.Context(), nodeID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if node == nil {
+			http.Error(w, "node not found", http.StatusNotFound)
+			return
+		}
+		req = &pb.UpdateNodeLabelsRequest{
+			NodeId:       nodeID,
+			SetLabels:    body.Labels,
+			RemoveLabels: slices.Collect(maps.Keys(node.Labels)),
+			AddTaints:    body.Taints,
+			RemoveTaints: node.Taints,
+		}
+
+	case http.MethodPatch, http.MethodPost:
+		var body struct {
+			Set          map[string]string `json:"set"`
+			Remove       []string          `json:"remove"`
+			AddTaints    []string          `json:"add_taints"`
+			RemoveTaints []string          `json:"remove_taints"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		req = &pb.UpdateNodeLabelsRequest{
+			NodeId:       nodeID,
+			SetLabels:    body.Set,
+			RemoveLabels: body.Remove,
+			AddTaints:    body.AddTaints,
+			RemoveTaints: body.RemoveTaints,
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, err := s.grpcServer.UpdateNodeLabels(r.Context(), req)
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), httpStatusFromGRPC(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(labelsResponse(resp.Labels, resp.Taints))
+}
+
+func labelsResponse(labels map[string]string, taints []string) map[string]interface{} {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if taints == nil {
+		taints = []string{}
+	}
+	return map[string]interface{}{"labels": labels, "taints": taints}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Latency buckets in seconds; commands range from instant inspects to image pulls.
+var commandLatencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type commandKey struct {
+	Type    string
+	Outcome string
+}
+
+type latencyHistogram struct {
+	buckets []uint64 // cumulative counts are computed on export
+	count   uint64
+	sum     float64
+}
+
+// hubMetrics holds the counters exported at /metrics. Gauges are read from
+// the server state at scrape time instead.
+type hubMetrics struct {
+	mu            sync.Mutex
+	commands      map[commandKey]*latencyHistogram
+	streamErrors  uint64
+	storageErrors map[string]uint64 // by operation
+}
+
+func newHubMetrics() *hubMetrics {
+	return &hubMetrics{
+		commands:      make(map[commandKey]*latencyHistogram),
+		storageErrors: make(map[string]uint64),
+	}
+}
+
+func (m *hubMetrics) observeCommand(cmdType, outcome string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := commandKey{Type: cmdType, Outcome: outcome}
+	h, ok := m.commands[key]
+	if !ok {
+		h = &latencyHistogram{buckets: make([]uint64, len(commandLatencyBuckets))}
+		m.commands[key] = h
+	}
+	secs := d.Seconds()
+	for i, bound := range commandLatencyBuckets {
+		if secs <= bound {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+func (m *hubMetrics) streamError() {
+	m.mu.Lock()
+	m.streamErrors++
+	m.mu.Unlock()
+}
+
+func (m *hubMetrics) storageError(op string) {
+	m.mu.Lock()
+	m.storageErrors[op]++
+	m.mu.Unlock()
+}
+
+// commandOutcome classifies a command for the outcome label.
+func commandOutcome(resp *pb.ExecuteCommandResponse, err error) string {
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Canceled:
+			return "canceled"
+		case codes.DeadlineExceeded:
+			return "timeout"
+		case codes.NotFound:
+			return "not_connected"
+		default:
+			return "error"
+		}
+	}
+	if resp.ExitCode != 0 || resp.Error != "" {
+		return "failed"
+	}
+	return "success"
+}
+
+// handleMetrics serves hub metrics in the Prometheus text format.
+// It bypasses dashboard auth; set DOCKLET_METRICS_TOKEN to require
+// "Authorization: Bearer <token>" from the scraper.
+func (s *HTTPServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := strings.TrimSpace(os.Getenv("DOCKLET_METRICS_TOKEN")); token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.grpcServer.writeMetrics(w)
+}
+
+func (s *DockletServer) writeMetrics(w io.Writer) {
+	agents := 0
+	s.agents.Range(func(_, _ interface{}) bool {
+		agents++
+		return true
+	})
+	pending := 0
+	s.pendingCommands.Range(func(_, _ interface{}) bool {
+		pending++
+		return true
+	})
+
+	writeHeader(w, "docklet_agents_connected", "gauge", "Agents with an open stream to this hub.")
+	fmt.Fprintf(w, "docklet_agents_connected %d\n", agents)
+	writeHeader(w, "docklet_pending_commands", "gauge", "Commands waiting for an agent result.")
+	fmt.Fprintf(w, "docklet_pending_commands %d\n", pending)
+	writeHeader(w, "docklet_jobs_running", "gauge", "Hub-side fan-out jobs in progress.")
+	fmt.Fprintf(w, "docklet_jobs_running %d\n", s.Jobs.runningCount())
+
+	m := s.metrics
+	m.mu.Lock()
+	keys := make([]commandKey, 0, len(m.commands))
+	for key := range m.commands {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Type != keys[j].Type {
+			return keys[i].Type < keys[j].Type
+		}
+		return keys[i].Outcome < keys[j].Outcome
+	})
+
+	writeHeader(w, "docklet_commands_total", "counter", "Commands sent to agents by type and outcome.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "docklet_commands_total{%s} %d\n", commandLabels(key), m.commands[key].count)
+	}
+	writeHeader(w, "docklet_command_duration_seconds", "histogram", "Time from sending a command to its result.")
+	for _, key := range keys {
+		h := m.commands[key]
+		labels := commandLabels(key)
+		var cumulative uint64
+		for i, bound := range commandLatencyBuckets {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(w, "docklet_command_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "docklet_command_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "docklet_command_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "docklet_command_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeHeader(w, "docklet_stream_errors_total", "counter", "Agent streams that ended with an error.")
+	fmt.Fprintf(w, "docklet_stream_errors_total %d\n", m.streamErrors)
+
+	ops := make([]string, 0, len(m.storageErrors))
+	for op := range m.storageErrors {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	writeHeader(w, "docklet_storage_errors_total", "counter", "Failed storage operations by operation.")
+	for _, op := range ops {
+		fmt.Fprintf(w, "docklet_storage_errors_total{op=\"%s\"} %d\n", escapeLabel(op), m.storageErrors[op])
+	}
+	m.mu.Unlock()
+
+	s.writeNodeMetrics(w)
+}
+
+// writeNodeMetrics exports the latest heartbeat sample of every node.
+func (s *DockletServer) writeNodeMetrics(w io.Writer) {
+	type nodeSample struct {
+		id     string
+		sample MetricSample
+	}
+	var samples []nodeSample
+	s.nodeMetrics.Range(func(key, value interface{}) bool {
+		if latest, ok := value.(*metricSeries).latest(); ok {
+			samples = append(samples, nodeSample{id: key.(string), sample: latest})
+		}
+		return true
+	})
+	sort.Slice(samples, func(i, j int) bool { return samples[i].id < samples[j].id })
+
+	gauges := []struct {
+		name, help string
+		value      func(MetricSample) string
+	}{
+		{"docklet_node_cpu_percent", "Host CPU usage over the last heartbeat interval.", func(m MetricSample) string { return formatFloat(m.CPUPercent) }},
+		{"docklet_node_memory_used_bytes", "Host memory in use.", func(m MetricSample) string { return strconv.FormatInt(m.MemoryUsedBytes, 10) }},
+		{"docklet_node_memory_total_bytes", "Host memory size.", func(m MetricSample) string { return strconv.FormatInt(m.MemoryTotalBytes, 10) }},
+		{"docklet_node_disk_used_bytes", "Used space on the Docker data filesystem.", func(m MetricSample) string { return strconv.FormatInt(m.DiskUsedBytes, 10) }},
+		{"docklet_node_disk_total_bytes", "Size of the Docker data filesystem.", func(m MetricSample) string { return strconv.FormatInt(m.DiskTotalBytes, 10) }},
+		{"docklet_node_containers_running", "Running containers.", func(m MetricSample) string { return strconv.Itoa(int(m.ContainersRunning)) }},
+		{"docklet_node_containers_total", "All containers, including stopped ones.", func(m MetricSample) string { return strconv.Itoa(int(m.ContainersTotal)) }},
+		{"docklet_node_last_heartbeat_timestamp_seconds", "Hub time of the last heartbeat.", func(m MetricSample) string { return strconv.FormatInt(m.Timestamp, 10) }},
+	}
+	for _, g := range gauges {
+		writeHeader(w, g.name, "gauge", g.help)
+		for _, ns := range samples {
+			fmt.Fprintf(w, "%s{node=\"%s\"} %s\n", g.name, escapeLabel(ns.id), g.value(ns.sample))
+		}
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func commandLabels(key commandKey) string {
+	return fmt.Sprintf("type=\"%s\",outcome=\"%s\"", escapeLabel(key.Type), escapeLabel(key.Outcome))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
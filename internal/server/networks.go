@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errNoNetworkRoute marks a network request that matches no route.
+var errNoNetworkRoute = errors.New("no such network route")
+
+// networkRequest maps a network request to an agent command. rest is the
+// path after ".../networks", routed like the Docker Engine API:
+//
+//	GET    / or /json         list (?filters)
+//	POST   /create            create ({"Name": ..., "Driver": ..., ...})
+//	GET    /{name}            inspect
+//	DELETE /{name}            remove
+//	POST   /{name}/connect    connect ({"Container": ..., "EndpointConfig": {...}})
+//	POST   /{name}/disconnect disconnect ({"Container": ..., "Force": true})
+func networkRequest(r *http.Request, rest string) (cmd string, args []string, err error) {
+	rest = strings.Trim(rest, "/")
+	name, action, _ := strings.Cut(rest, "/")
+
+	switch {
+	case r.Method == http.MethodGet && (rest == "" || rest == "json"):
+		if f := strings.TrimSpace(r.URL.Query().Get("filters")); f != "" {
+			b, _ := json.Marshal(map[string]string{"filters": f})
+			return "network_ls", []string{string(b)}, nil
+		}
+		return "network_ls", nil, nil
+
+	case r.Method == http.MethodPost && rest == "create":
+		var req json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return "", nil, errors.New("invalid network")
+		}
+		var named struct {
+			Name string `json:"name"` // also matches "Name"
+		}
+		if err := json.Unmarshal(req, &named); err != nil || strings.TrimSpace(named.Name) == "" {
+			return "", nil, errors.New("name is required")
+		}
+		return "network_create", []string{string(req)}, nil
+
+	case r.Method == http.MethodPost && (action == "connect" || action == "disconnect"):
+		var req struct {
+			Container      string          `json:"container"`
+			EndpointConfig json.RawMessage `json:"endpointconfig"`
+			Force          bool            `json:"force"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return "", nil, errors.New("container is required")
+		}
+		containerID := strings.TrimSpace(req.Container)
+		if containerID == "" {
+			return "", nil, errors.New("container is required")
+		}
+		args := []string{name, containerID}
+		if action == "connect" {
+			if len(req.EndpointConfig) > 0 && string(req.EndpointConfig) != "null" {
+				args = append(args, string(req.EndpointConfig))
+			}
+			return "network_connect", args, nil
+		}
+		if req.Force {
+			args = append(args, `{"force":true}`)
+		}
+		return "network_disconnect", args, nil
+
+	case r.Method == http.MethodGet && action == "":
+		return "network_inspect", []string{name}, nil
+
+	case r.Method == http.MethodDelete && action == "":
+		return "network_rm", []string{name}, nil
+	}
+	return "", nil, errNoNetworkRoute
+}
+
+// handleNodeNetworks serves /api/nodes/{id}/networks.
+func (s *HTTPServer) handleNodeNetworks(w http.ResponseWriter, r *http.Request, nodeID, rest string) {
+	cmd, args, err := networkRequest(r, rest)
+	if err == errNoNetworkRoute {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch cmd {
+	case "network_rm", "network_connect", "network_disconnect":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	s.proxyCommand(w, r, nodeID, cmd, args)
+}
+
+// handlePortlinerNetworks serves the Portainer docker/networks routes.
+func (s *HTTPServer) handlePortlinerNetworks(w http.ResponseWriter, r *http.Request, nodeID, rest string) {
+	cmd, args, err := networkRequest(r, rest)
+	if err == errNoNetworkRoute {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.executeNodeCommand(r.Context(), nodeID, cmd, args, 20*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), dockerErrorStatus(err))
+		return
+	}
+
+	switch cmd {
+	case "network_create":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(resp.Output)
+	case "network_rm":
+		w.WriteHeader(http.StatusNoContent)
+	case "network_connect", "network_disconnect":
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp.Output)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+)
+
+// Samples kept per node: one hour at the default 15s heartbeat interval.
+const nodeMetricsRetention = 240
+
+// MetricSample is the load of a node as reported in one heartbeat.
+type MetricSample struct {
+	Timestamp         int64   `json:"timestamp"`
+	CPUPercent        float64 `json:"cpu_percent"`
+	MemoryUsedBytes   int64   `json:"memory_used_bytes"`
+	MemoryTotalBytes  int64   `json:"memory_total_bytes"`
+	DiskUsedBytes     int64   `json:"disk_used_bytes"`
+	DiskTotalBytes    int64   `json:"disk_total_bytes"`
+	ContainersRunning int32   `json:"containers_running"`
+	ContainersTotal   int32   `json:"containers_total"`
+}
+
+// metricSeries is a fixed-size ring of samples for one node.
+type metricSeries struct {
+	mu      sync.Mutex
+	samples []MetricSample
+	next    int
+}
+
+func (m *metricSeries) add(sample MetricSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) < nodeMetricsRetention {
+		m.samples = append(m.samples, sample)
+		return
+	}
+	m.samples[m.next] = sample
+	m.next = (m.next + 1) % nodeMetricsRetention
+}
+
+// since returns samples newer than ts (unix seconds), oldest first.
+func (m *metricSeries) since(ts int64) []MetricSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]MetricSample, 0, len(m.samples))
+	for i := range m.samples {
+		sample := m.samples[(m.next+i)%len(m.samples)]
+		if sample.Timestamp > ts {
+			out = append(out, sample)
+		}
+	}
+	return out
+}
+
+// latest returns the most recent sample.
+func (m *metricSeries) latest() (MetricSample, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) == 0 {
+		return MetricSample{}, false
+	}
+	return m.samples[(m.next+len(m.samples)-1)%len(m.samples)], true
+}
+
+// recordMetrics stores heartbeat metrics, timestamped with the hub clock so
+// series from nodes with skewed clocks line up.
+func (s *DockletServer) recordMetrics(nodeID string, hb *pb.Heartbeat) {
+	if hb.Metrics == nil {
+		return
+	}
+	val, _ := s.nodeMetrics.LoadOrStore(nodeID, &metricSeries{})
+	val.(*metricSeries).add(MetricSample{
+		Timestamp:         time.Now().Unix(),
+		CPUPercent:        hb.Metrics.CpuPercent,
+		MemoryUsedBytes:   hb.Metrics.MemoryUsedBytes,
+		MemoryTotalBytes:  hb.Metrics.MemoryTotalBytes,
+		DiskUsedBytes:     hb.Metrics.DiskUsedBytes,
+		DiskTotalBytes:    hb.Metrics.DiskTotalBytes,
+		ContainersRunning: hb.Metrics.ContainersRunning,
+		ContainersTotal:   hb.Metrics.ContainersTotal,
+	})
+}
+
+// NodeMetrics returns the recorded samples of a node newer than since (unix seconds).
+func (s *DockletServer) NodeMetrics(nodeID string, since int64) []MetricSample {
+	val, ok := s.nodeMetrics.Load(nodeID)
+	if !ok {
+		return []MetricSample{}
+	}
+	return val.(*metricSeries).since(since)
+}
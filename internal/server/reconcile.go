@@ -0,0 +1,478 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astracat/docklet/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Per-node sync states of a cluster.
+const (
+	SyncInSync    = storage.SyncInSync
+	SyncOutOfSync = storage.SyncOutOfSync
+	SyncFailed    = storage.SyncFailed
+)
+
+const defaultReconcileInterval = time.Minute
+
+// NodeSync is the last reconciliation outcome of a cluster on one node.
+type NodeSync = storage.NodeSync
+
+// reconcileInterval reads DOCKLET_RECONCILE_INTERVAL (e.g. "30s"); "0" or
+// "off" disables the reconciler.
+func reconcileInterval() time.Duration {
+	v := strings.TrimSpace(os.Getenv("DOCKLET_RECONCILE_INTERVAL"))
+	if v == "" {
+		return defaultReconcileInterval
+	}
+	if v == "off" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid DOCKLET_RECONCILE_INTERVAL %q, using %s", v, defaultReconcileInterval)
+		return defaultReconcileInterval
+	}
+	return d
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// startReconciler re-applies clusters every interval and soon after an
+// agent connects.
+func (s *HTTPServer) startReconciler(interval time.Duration) {
+	if interval <= 0 {
+		log.Println("Cluster reconciler DISABLED")
+		return
+	}
+	s.grpcServer.OnNodeConnected(func(string) {
+		select {
+		case s.reconcileNow <- struct{}{}:
+		default:
+		}
+	})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.reconcileNow:
+			}
+			s.reconcileAll(context.Background())
+		}
+	}()
+}
+
+func (s *HTTPServer) reconcileAll(ctx context.Context) {
+	if !s.grpcServer.leadsReplicas() {
+		// Another replica reconciles; agents connected here are reached by forwarding.
+		return
+	}
+	clusters, err := s.grpcServer.Repo.ListClusters(ctx)
+	if err != nil {
+		log.Printf("Reconciler: failed to load clusters: %v", err)
+		s.grpcServer.metrics.storageError("list_clusters")
+		return
+	}
+
+	probes := newProbeCache(s)
+	for _, c := range clusters {
+		_, err := s.reconcileCluster(ctx, c.ID, probes, false)
+		if err != nil && err != errClusterBusy && err != errClusterHalted {
+			log.Printf("Reconciler: cluster %s: %v", c.ID, err)
+		}
+	}
+}
+
+var (
+	errClusterBusy     = errors.New("cluster is being deployed or synced")
+	errClusterNotFound = errors.New("cluster not found")
+	errClusterHalted   = errors.New("cluster rollout halted; deploy it again to resume")
+)
+
+const (
+	// A lease outlives a replica that died holding it by at most this long;
+	// holders renew it every third of it.
+	clusterLeaseTTL = 30 * time.Second
+	// How often a waiting lockCluster retries a lease another replica holds.
+	clusterLeasePoll = time.Second
+)
+
+// clusterLock serializes deploys, removals and reconciliation of a cluster
+// within this replica.
+func (s *HTTPServer) clusterLock(id string) *sync.Mutex {
+	mu, _ := s.clusterLocks.LoadOrStore(id, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// lockCluster serializes deploys, removals and reconciliation of the cluster
+// key across replicas: it takes the local lock, then the lease of key in the
+// shared store, which is renewed until unlock is called. Unless wait is set,
+// a cluster that is busy here or on another replica returns errClusterBusy.
+func (s *HTTPServer) lockCluster(ctx context.Context, key string, wait bool) (unlock func(), err error) {
+	mu := s.clusterLock(key)
+	if wait {
+		mu.Lock()
+	} else if !mu.TryLock() {
+		return nil, errClusterBusy
+	}
+
+	repo := s.grpcServer.Repo
+	holder := uuid.NewString()
+	for {
+		ok, err := repo.AcquireClusterLease(ctx, key, holder, time.Now().Add(clusterLeaseTTL))
+		if err != nil {
+			mu.Unlock()
+			s.grpcServer.metrics.storageError("acquire_cluster_lease")
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if !wait {
+			mu.Unlock()
+			return nil, errClusterBusy
+		}
+		select {
+		case <-time.After(clusterLeasePoll):
+		case <-ctx.Done():
+			mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(clusterLeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				ok, err := repo.AcquireClusterLease(rctx, key, holder, time.Now().Add(clusterLeaseTTL))
+				cancel()
+				if err != nil {
+					log.Printf("Failed to renew lease of cluster %s: %v", key, err)
+					s.grpcServer.metrics.storageError("acquire_cluster_lease")
+				} else if !ok {
+					log.Printf("Warning: lost lease of cluster %s to another replica", key)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := repo.ReleaseClusterLease(rctx, key, holder); err != nil {
+				log.Printf("Failed to release lease of cluster %s: %v", key, err)
+				s.grpcServer.metrics.storageError("release_cluster_lease")
+			}
+			mu.Unlock()
+		})
+	}, nil
+}
+
+// reconcileCluster compares the desired stack of a cluster with what its
+// nodes run and applies it again where it is missing, stopped or outdated.
+// Nodes that are not connected stay OutOfSync until they come back. Unless
+// wait is set, a cluster that is busy is skipped with errClusterBusy. A
+// cluster whose rollout halted is skipped with errClusterHalted.
+func (s *HTTPServer) reconcileCluster(ctx context.Context, id string, probes *probeCache, wait bool) (map[string]NodeSync, error) {
+	unlock, err := s.lockCluster(ctx, id, wait)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Reload under the lock: a deploy may have changed the cluster.
+	c, err := s.getCluster(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errClusterNotFound
+	}
+	if c.Revision > 0 {
+		rev, err := s.grpcServer.Repo.GetClusterRevision(ctx, c.ID, c.Revision)
+		if err != nil {
+			return nil, err
+		}
+		if rev != nil && rev.Status == RolloutHalted {
+			return nil, errClusterHalted
+		}
+	}
+
+	nodes := c.Nodes
+	if c.Selector != "" {
+		if nodes, err = s.grpcServer.resolveTargets(ctx, c.Nodes, c.Selector); err != nil {
+			return nil, err
+		}
+	}
+
+	hash := contentHash(c.Content)
+	now := time.Now().Unix()
+	result := make(map[string]NodeSync, len(nodes))
+	var drifted []string
+	var wg sync.WaitGroup
+	var resultMu sync.Mutex
+	for _, nodeID := range nodes {
+		prev := c.Sync[nodeID]
+		prev.CheckedAt = now
+		if !s.grpcServer.nodeConnected(nodeID) {
+			prev.Status, prev.Message = SyncOutOfSync, "node not connected"
+			resultMu.Lock()
+			result[nodeID] = prev
+			resultMu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(nodeID string, st NodeSync) {
+			defer wg.Done()
+			ok, msg := probes.get(ctx, nodeID).check(c.StackName)
+			if ok && st.Hash != hash {
+				ok, msg = false, "stack content changed"
+			}
+
+			resultMu.Lock()
+			defer resultMu.Unlock()
+			switch {
+			case ok:
+				st.Status, st.Message = SyncInSync, ""
+			case msg == "":
+				// The node could not be inspected; applying would fail as well.
+				st.Status, st.Message = SyncFailed, "failed to inspect node"
+			default:
+				st.Status, st.Message = SyncOutOfSync, msg
+				drifted = append(drifted, nodeID)
+			}
+			result[nodeID] = st
+		}(nodeID, prev)
+	}
+	wg.Wait()
+
+	if len(drifted) > 0 {
+		log.Printf("Reconciler: applying cluster %s (%s) to %d nodes", c.ID, c.StackName, len(drifted))
+		job, err := s.submitStackUp(WithIssuer(ctx, "reconciler"), "cluster_reconcile", *c, drifted, 0)
+		if err != nil {
+			return nil, err
+		}
+		<-job.Done()
+		for nodeID, st := range syncFromJob(job, hash) {
+			result[nodeID] = st
+		}
+	}
+
+	s.saveClusterSync(c.ID, hash, nodes, result)
+	return result, nil
+}
+
+// submitStackUp runs stack_up of cluster c on nodes as a job of jobType.
+func (s *HTTPServer) submitStackUp(ctx context.Context, jobType string, c storage.Cluster, nodes []string, parallelism int) (*JobHandle, error) {
+	tasks := make([]NodeTask, 0, len(nodes))
+	for _, nodeID := range nodes {
+		tasks = append(tasks, NodeTask{
+			NodeID:  nodeID,
+			Command: "stack_up",
+			Args:    []string{c.StackName, c.Content},
+			Timeout: 90 * time.Second,
+		})
+	}
+	return s.grpcServer.Jobs.Submit(ctx, jobType, []string{c.StackName}, tasks, parallelism)
+}
+
+// syncFromJob turns the results of a finished stack_up job into sync
+// states. Failed nodes have no hash.
+func syncFromJob(job *JobHandle, hash string) map[string]NodeSync {
+	out := make(map[string]NodeSync)
+	for _, res := range job.Results() {
+		if res.OK {
+			out[res.NodeID] = NodeSync{Status: SyncInSync, Hash: hash, CheckedAt: res.Timestamp, SyncedAt: res.Timestamp}
+		} else {
+			out[res.NodeID] = NodeSync{Status: SyncFailed, Message: res.Error, CheckedAt: res.Timestamp}
+		}
+	}
+	return out
+}
+
+// saveClusterSync stores sync states of the cluster with id, unless its
+// content no longer has hash. nodes replaces the node list; states of nodes
+// missing from sync are kept, those of nodes no longer targeted dropped.
+func (s *HTTPServer) saveClusterSync(id, hash string, nodes []string, sync map[string]NodeSync) {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := s.grpcServer.Repo.GetCluster(ctx, id)
+	if err != nil {
+		log.Printf("Failed to load cluster %s: %v", id, err)
+		s.grpcServer.metrics.storageError("get_cluster")
+		return
+	}
+	if c == nil || contentHash(c.Content) != hash {
+		return
+	}
+
+	prev := c.Sync
+	c.Nodes = nodes
+	c.Sync = make(map[string]NodeSync, len(nodes))
+	for _, nodeID := range nodes {
+		st, ok := sync[nodeID]
+		if !ok {
+			st, ok = prev[nodeID]
+		} else if st.Hash == "" {
+			// Keep what a failed attempt did not replace.
+			st.Hash, st.SyncedAt = prev[nodeID].Hash, prev[nodeID].SyncedAt
+		}
+		if ok {
+			c.Sync[nodeID] = st
+		}
+	}
+	if err := s.grpcServer.Repo.SaveCluster(ctx, c); err != nil {
+		log.Printf("Failed to save cluster sync status: %v", err)
+		s.grpcServer.metrics.storageError("save_cluster")
+	}
+}
+
+// probeCache inspects every node at most once per reconciliation pass.
+type probeCache struct {
+	s      *HTTPServer
+	mu     sync.Mutex
+	probes map[string]*nodeProbe
+}
+
+func newProbeCache(s *HTTPServer) *probeCache {
+	return &probeCache{s: s, probes: make(map[string]*nodeProbe)}
+}
+
+type nodeProbe struct {
+	once sync.Once
+	err  error
+
+	stacks     map[string]string   // compose project -> status, from stack_ls
+	containers map[string][]string // compose project -> "name: state", from docker_ps
+}
+
+func (pc *probeCache) get(ctx context.Context, nodeID string) *nodeProbe {
+	pc.mu.Lock()
+	p, ok := pc.probes[nodeID]
+	if !ok {
+		p = &nodeProbe{}
+		pc.probes[nodeID] = p
+	}
+	pc.mu.Unlock()
+
+	p.once.Do(func() { p.err = p.load(withoutJobRecord(ctx), pc.s, nodeID) })
+	return p
+}
+
+func (p *nodeProbe) load(ctx context.Context, s *HTTPServer, nodeID string) error {
+	resp, err := s.executeNodeCommand(ctx, nodeID, "stack_ls", nil, 20*time.Second)
+	if err != nil {
+		return fmt.Errorf("stack_ls: %w", err)
+	}
+	var stacks []struct {
+		Name   string `json:"Name"`
+		Status string `json:"Status"`
+	}
+	if err := json.Unmarshal(bytesTrimSpace(resp.Output), &stacks); err != nil {
+		return fmt.Errorf("stack_ls: %w", err)
+	}
+	p.stacks = make(map[string]string, len(stacks))
+	for _, st := range stacks {
+		p.stacks[strings.ToLower(st.Name)] = st.Status
+	}
+
+	resp, err = s.executeNodeCommand(ctx, nodeID, "docker_ps", nil, 20*time.Second)
+	if err != nil {
+		return fmt.Errorf("docker_ps: %w", err)
+	}
+	var containers []struct {
+		Names  []string          `json:"Names"`
+		State  string            `json:"State"`
+		Status string            `json:"Status"` // e.g. "Exited (0) 2 minutes ago"
+		Labels map[string]string `json:"Labels"`
+	}
+	if err := json.Unmarshal(resp.Output, &containers); err != nil {
+		return fmt.Errorf("docker_ps: %w", err)
+	}
+	p.containers = make(map[string][]string)
+	for _, c := range containers {
+		project := strings.ToLower(c.Labels["com.docker.compose.project"])
+		if project == "" {
+			continue
+		}
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		state := c.State
+		if state == "exited" && strings.HasPrefix(c.Status, "Exited (0)") {
+			state = "exited (0)"
+		}
+		p.containers[project] = append(p.containers[project], name+": "+state)
+	}
+	return nil
+}
+
+// check reports whether stack runs with all of its containers. Containers
+// that exited cleanly count as healthy, as in stackHealth: one-shot
+// containers (e.g. migrations) are done. msg explains the drift; it is empty
+// when the node could not be inspected.
+func (p *nodeProbe) check(stack string) (ok bool, msg string) {
+	if p.err != nil {
+		log.Printf("Reconciler: %v", p.err)
+		return false, ""
+	}
+	stack = strings.ToLower(stack)
+	if _, running := p.stacks[stack]; !running {
+		return false, "stack not running"
+	}
+	for _, c := range p.containers[stack] {
+		if !strings.HasSuffix(c, ": running") && !strings.HasSuffix(c, ": exited (0)") {
+			return false, "container " + c
+		}
+	}
+	return true, ""
+}
+
+// handleClusterSync reconciles one cluster right away (POST /api/clusters/{id}/sync).
+func (s *HTTPServer) handleClusterSync(w http.ResponseWriter, r *http.Request, id string) {
+	sync, err := s.reconcileCluster(r.Context(), id, newProbeCache(s), true)
+	if err == errClusterNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err == errClusterHalted {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sync": sync})
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/astracat/docklet/internal/storage"
+	"github.com/astracat/docklet/pkg/utils"
+	"github.com/google/uuid"
+)
+
+type RegistryResponse struct {
+	ID        string `json:"id"`
+	Host      string `json:"host"`
+	Username  string `json:"username"`
+	CreatedBy string `json:"created_by,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+func newRegistryResponse(cred *storage.RegistryCredential) RegistryResponse {
+	return RegistryResponse{
+		ID:        cred.ID,
+		Host:      cred.Host,
+		Username:  cred.Username,
+		CreatedBy: cred.CreatedBy,
+		CreatedAt: cred.CreatedAt.Unix(),
+		UpdatedAt: cred.UpdatedAt.Unix(),
+	}
+}
+
+// RegistryRequest creates or updates a registry credential. Token and
+// Password are the same thing; registries such as GHCR call it a token.
+type RegistryRequest struct {
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+func (req *RegistryRequest) secret() string {
+	if req.Token != "" {
+		return req.Token
+	}
+	return req.Password
+}
+
+// handleRegistries lists (GET) or adds (POST) registry credentials. Secrets
+// are write-only. Changes are admin only.
+func (s *HTTPServer) handleRegistries(w http.ResponseWriter, r *http.Request) {
+	repo := s.grpcServer.Repo
+	switch r.Method {
+	case http.MethodGet:
+		creds, err := repo.ListRegistries(r.Context())
+		if err != nil {
+			s.grpcServer.metrics.storageError("list_registries")
+			http.Error(w, "Failed to list registries", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]RegistryResponse, 0, len(creds))
+		for _, cred := range creds {
+			resp = append(resp, newRegistryResponse(cred))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"registries": resp})
+
+	case http.MethodPost:
+		var req RegistryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		host := utils.NormalizeRegistryHost(req.Host)
+		username := strings.TrimSpace(req.Username)
+		if host == "" || username == "" || req.secret() == "" {
+			http.Error(w, "host, username and password or token are required", http.StatusBadRequest)
+			return
+		}
+		existing, err := s.grpcServer.registryByHost(r.Context(), host)
+		if err != nil {
+			http.Error(w, "Failed to list registries", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			http.Error(w, "Registry "+host+" already exists", http.StatusConflict)
+			return
+		}
+
+		now := time.Now()
+		cred := &storage.RegistryCredential{
+			ID:        uuid.NewString(),
+			Host:      host,
+			Username:  username,
+			CreatedBy: issuerFromContext(r.Context()),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if !s.sealRegistrySecret(w, cred, req.secret()) {
+			return
+		}
+		if err := repo.SaveRegistry(r.Context(), cred); err != nil {
+			s.grpcServer.metrics.storageError("save_registry")
+			http.Error(w, "Failed to save registry", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newRegistryResponse(cred))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRegistryAction shows (GET), updates (PUT, PATCH: username and/or
+// secret) or deletes (DELETE) /api/registries/{id}.
+func (s *HTTPServer) handleRegistryAction(w http.ResponseWriter, r *http.Request) {
+	repo := s.grpcServer.Repo
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/registries/"), "/")
+
+	if r.Method == http.MethodDelete {
+		if err := repo.DeleteRegistry(r.Context(), id); err != nil {
+			s.grpcServer.metrics.storageError("delete_registry")
+			http.Error(w, "Failed to delete registry", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+		return
+	}
+
+	cred, err := repo.GetRegistry(r.Context(), id)
+	if err != nil {
+		s.grpcServer.metrics.storageError("get_registry")
+		http.Error(w, "Failed to load registry", http.StatusInternalServerError)
+		return
+	}
+	if cred == nil {
+		http.Error(w, "registry not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		var req RegistryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Host != "" && utils.NormalizeRegistryHost(req.Host) != cred.Host {
+			http.Error(w, "host cannot be changed", http.StatusBadRequest)
+			return
+		}
+		if username := strings.TrimSpace(req.Username); username != "" {
+			cred.Username = username
+		}
+		if req.secret() != "" && !s.sealRegistrySecret(w, cred, req.secret()) {
+			return
+		}
+		cred.UpdatedAt = time.Now()
+		if err := repo.SaveRegistry(r.Context(), cred); err != nil {
+			s.grpcServer.metrics.storageError("save_registry")
+			http.Error(w, "Failed to save registry", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newRegistryResponse(cred))
+}
+
+func (s *HTTPServer) sealRegistrySecret(w http.ResponseWriter, cred *storage.RegistryCredential, secret string) bool {
+	if s.grpcServer.Secrets == nil {
+		http.Error(w, "Secret key not available, registry credentials are disabled", http.StatusServiceUnavailable)
+		return false
+	}
+	sealed, err := s.grpcServer.Secrets.Seal([]byte(secret))
+	if err != nil {
+		http.Error(w, "Failed to encrypt secret", http.StatusInternalServerError)
+		return false
+	}
+	cred.Secret = sealed
+	return true
+}
+
+func (s *DockletServer) registryByHost(ctx context.Context, host string) (*storage.RegistryCredential, error) {
+	creds, err := s.Repo.ListRegistries(ctx)
+	if err != nil {
+		s.metrics.storageError("list_registries")
+		return nil, err
+	}
+	for _, cred := range creds {
+		if cred.Host == host {
+			return cred, nil
+		}
+	}
+	return nil, nil
+}
+
+// composeImageRe finds the images of a compose file.
+var composeImageRe = regexp.MustCompile(`(?m)^\s*image:\s*["']?([^\s"'#]+)`)
+
+// commandImages returns the images a command pulls.
+func commandImages(command string, args []string) []string {
+	switch command {
+	case "docker_run":
+		if len(args) == 0 {
+			return nil
+		}
+		var config struct {
+			Image string `json:"image"`
+		}
+		if err := json.Unmarshal([]byte(args[0]), &config); err != nil {
+			return []string{args[0]} // plain image name
+		}
+		return []string{config.Image}
+	case "image_pull":
+		if len(args) > 0 {
+			return []string{args[0]}
+		}
+	case "stack_up":
+		if len(args) > 1 {
+			var images []string
+			for _, m := range composeImageRe.FindAllStringSubmatch(args[1], -1) {
+				images = append(images, m[1])
+			}
+			return images
+		}
+	}
+	return nil
+}
+
+// registryAuths returns the stored credentials of the registries the command
+// pulls from. Failures are logged; the pull is then tried anonymously.
+func (s *DockletServer) registryAuths(ctx context.Context, command string, args []string) []*pb.RegistryAuth {
+	images := commandImages(command, args)
+	if len(images) == 0 || s.Secrets == nil {
+		return nil
+	}
+	hosts := make(map[string]bool)
+	for _, ref := range images {
+		if host := utils.RegistryHost(ref); host != "" {
+			hosts[host] = true
+		}
+	}
+
+	creds, err := s.Repo.ListRegistries(ctx)
+	if err != nil {
+		log.Printf("Failed to load registry credentials: %v", err)
+		s.metrics.storageError("list_registries")
+		return nil
+	}
+	var auths []*pb.RegistryAuth
+	for _, cred := range creds {
+		if !hosts[cred.Host] {
+			continue
+		}
+		secret, err := s.Secrets.Open(cred.Secret)
+		if err != nil {
+			log.Printf("Failed to decrypt credentials of registry %s: %v", cred.Host, err)
+			continue
+		}
+		auths = append(auths, &pb.RegistryAuth{
+			ServerAddress: cred.Host,
+			Username:      cred.Username,
+			Password:      string(secret),
+		})
+	}
+	return auths
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/astracat/docklet/api/proto/v1"
+	"github.com/astracat/docklet/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	replicaHeartbeatInterval = 10 * time.Second
+	// A replica that has not been seen for this long no longer owns sessions.
+	replicaTTL = 3 * replicaHeartbeatInterval
+)
+
+// replicaSet is what a hub knows about the other replicas sharing its store:
+// which of them are alive and which agent streams they hold.
+type replicaSet struct {
+	self     storage.Hub
+	dialOpts []grpc.DialOption
+
+	// Serializes refreshes.
+	refreshMu sync.Mutex
+
+	mu     sync.RWMutex
+	peers  map[string]*storage.Hub // live replicas by ID, without self
+	remote map[string]string       // node ID -> ID of the peer holding its stream
+	conns  map[string]*grpc.ClientConn
+}
+
+// StartReplica registers this hub as replica id, reachable by the others at
+// addr, and keeps its view of the other replicas up to date. dialOpts are
+// used to connect to them. Commands for agents whose stream another replica
+// holds are forwarded there. Without it the hub only knows its own agents.
+// It must be called before agents connect.
+func (s *DockletServer) StartReplica(ctx context.Context, id, addr string, dialOpts ...grpc.DialOption) error {
+	rs := &replicaSet{
+		self:     storage.Hub{ID: id, Addr: addr, StartedAt: time.Now()},
+		dialOpts: dialOpts,
+		peers:    make(map[string]*storage.Hub),
+		remote:   make(map[string]string),
+		conns:    make(map[string]*grpc.ClientConn),
+	}
+	// Sessions left behind by a previous run of this replica.
+	if err := s.Repo.DeleteHubSessions(ctx, id); err != nil {
+		s.metrics.storageError("delete_hub_sessions")
+		return err
+	}
+	s.replicas = rs
+	if err := s.refreshReplicas(ctx); err != nil {
+		return err
+	}
+	log.Printf("Hub replica %s registered (%s)", id, addr)
+
+	go func() {
+		ticker := time.NewTicker(replicaHeartbeatInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), replicaHeartbeatInterval)
+			if err := s.refreshReplicas(ctx); err != nil {
+				log.Printf("Failed to refresh hub replicas: %v", err)
+			}
+			cancel()
+		}
+	}()
+	return nil
+}
+
+// refreshReplicas records that this replica is alive and reloads the live
+// peers, the sessions they hold and the revocation list. Local sessions that
+// an agent replaced by connecting to another replica, or whose certificate
+// was revoked there, are closed; local sessions missing from the store are
+// recorded again.
+func (s *DockletServer) refreshReplicas(ctx context.Context) error {
+	rs := s.replicas
+	rs.refreshMu.Lock()
+	defer rs.refreshMu.Unlock()
+
+	now := time.Now()
+	self := rs.self
+	self.LastSeen = now
+	if err := s.Repo.UpsertHub(ctx, &self); err != nil {
+		s.metrics.storageError("upsert_hub")
+		return err
+	}
+	hubs, err := s.Repo.ListHubs(ctx)
+	if err != nil {
+		s.metrics.storageError("list_hubs")
+		return err
+	}
+	sessions, err := s.Repo.ListNodeSessions(ctx)
+	if err != nil {
+		s.metrics.storageError("list_node_sessions")
+		return err
+	}
+	if err := s.LoadRevocations(ctx); err != nil {
+		s.metrics.storageError("list_certificates")
+		return err
+	}
+
+	peers := make(map[string]*storage.Hub)
+	for _, hub := range hubs {
+		if hub.ID != self.ID && now.Sub(hub.LastSeen) < replicaTTL {
+			peers[hub.ID] = hub
+		}
+	}
+	remote := make(map[string]string)
+	stored := make(map[string]*storage.NodeSession, len(sessions))
+	for _, ns := range sessions {
+		stored[ns.NodeID] = ns
+		if _, ok := peers[ns.HubID]; ok {
+			remote[ns.NodeID] = ns.HubID
+		}
+	}
+
+	s.agents.Range(func(_, val interface{}) bool {
+		session := val.(*AgentSession)
+		if session.Cert != nil && s.revoked.contains(certSerial(session.Cert)) {
+			session.close(status.Error(codes.PermissionDenied, "node certificate revoked"))
+			return true
+		}
+		ns := stored[session.NodeID]
+		switch {
+		case ns != nil && ns.SessionID == session.SessionID:
+			delete(remote, session.NodeID)
+		case ns != nil && ns.ConnectedAt.After(session.ConnectedAt) && remote[session.NodeID] != "":
+			log.Printf("Agent %s reconnected to hub %s, closing session %s", session.NodeID, ns.HubID, session.SessionID)
+			session.close(status.Errorf(codes.Aborted, "superseded by a newer session for node %s on hub %s", session.NodeID, ns.HubID))
+		default:
+			delete(remote, session.NodeID)
+			if ns != nil && ns.ConnectedAt.After(session.ConnectedAt) {
+				// Newer, but held by a replica that is gone.
+				if err := s.Repo.DeleteNodeSession(ctx, ns.NodeID, ns.SessionID); err != nil {
+					s.metrics.storageError("delete_node_session")
+				}
+			}
+			s.claimSession(ctx, session)
+		}
+		return true
+	})
+
+	rs.mu.Lock()
+	rs.peers, rs.remote = peers, remote
+	rs.mu.Unlock()
+	return nil
+}
+
+// claimSession records that this replica holds the stream of session.
+func (s *DockletServer) claimSession(ctx context.Context, session *AgentSession) {
+	if s.replicas == nil {
+		return
+	}
+	err := s.Repo.SaveNodeSession(ctx, &storage.NodeSession{
+		NodeID:      session.NodeID,
+		SessionID:   session.SessionID,
+		HubID:       s.replicas.self.ID,
+		ConnectedAt: session.ConnectedAt,
+	})
+	if err != nil {
+		log.Printf("Failed to record session of %s: %v", session.NodeID, err)
+		s.metrics.storageError("save_node_session")
+	}
+}
+
+// releaseSession removes the record of session unless a newer one replaced it.
+func (s *DockletServer) releaseSession(session *AgentSession) {
+	if s.replicas == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Repo.DeleteNodeSession(ctx, session.NodeID, session.SessionID); err != nil {
+		log.Printf("Failed to remove session of %s: %v", session.NodeID, err)
+		s.metrics.storageError("delete_node_session")
+	}
+}
+
+// remoteOwner returns the live peer holding the stream of nodeID, or nil.
+func (s *DockletServer) remoteOwner(nodeID string) *storage.Hub {
+	rs := s.replicas
+	if rs == nil {
+		return nil
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.peers[rs.remote[nodeID]]
+}
+
+// leadsReplicas reports whether this hub runs fleet-wide background work,
+// such as the reconciler: it does unless a live peer has a lower ID.
+func (s *DockletServer) leadsReplicas() bool {
+	rs := s.replicas
+	if rs == nil {
+		return true
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	for id := range rs.peers {
+		if id < rs.self.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// peerClient returns a client for the replica at addr, connecting lazily.
+func (rs *replicaSet) peerClient(addr string) (pb.DockletServiceClient, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	conn, ok := rs.conns[addr]
+	if !ok {
+		var err error
+		if conn, err = grpc.NewClient(addr, rs.dialOpts...); err != nil {
+			return nil, err
+		}
+		rs.conns[addr] = conn
+	}
+	return pb.NewDockletServiceClient(conn), nil
+}
+
+// forwardCommand runs req on the replica holding the agent's stream. The job
+// is recorded here; the owner stores its outcome. onChunk is set for
+// streaming commands.
+func (s *DockletServer) forwardCommand(ctx context.Context, cmdID string, req *pb.ExecuteCommandRequest, onChunk func(data []byte) error) (*pb.ExecuteCommandResponse, error) {
+	owner := s.remoteOwner(req.NodeId)
+	if owner == nil && s.replicas != nil {
+		// The agent may have just connected to another replica.
+		if err := s.refreshReplicas(ctx); err != nil {
+			log.Printf("Failed to refresh hub replicas: %v", err)
+		}
+		owner = s.remoteOwner(req.NodeId)
+	}
+	if owner == nil {
+		return nil, status.Errorf(codes.NotFound, "node %s not connected", req.NodeId)
+	}
+	client, err := s.replicas.peerClient(owner.Addr)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to connect to hub %s: %v", owner.ID, err)
+	}
+
+	s.startJob(ctx, cmdID, req.NodeId, req.Command, req.Args)
+
+	// Ending the call early cancels the command on the owner.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.ForwardCommand(ctx, &pb.ForwardCommandRequest{
+		CommandId:   cmdID,
+		Command:     req,
+		Stream:      onChunk != nil,
+		IssuedBy:    issuerFromContext(ctx),
+		ParentJobId: parentJobFromContext(ctx),
+		Unrecorded:  ctx.Value(unrecordedKey{}) != nil,
+	})
+	if err != nil {
+		s.failJob(cmdID, fmt.Sprintf("failed to forward command to hub %s: %v", owner.ID, err))
+		return nil, status.Errorf(codes.Unavailable, "failed to forward command to hub %s: %v", owner.ID, err)
+	}
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			err = status.Errorf(codes.Internal, "hub %s ended the command without a result", owner.ID)
+		}
+		if err != nil {
+			if status.Code(err) == codes.Unavailable {
+				s.failJob(cmdID, fmt.Sprintf("lost connection to hub %s: %v", owner.ID, err))
+			}
+			return nil, err
+		}
+		switch payload := msg.Payload.(type) {
+		case *pb.ForwardCommandResponse_Chunk:
+			if onChunk != nil {
+				if err := onChunk(payload.Chunk); err != nil {
+					return nil, err
+				}
+			}
+		case *pb.ForwardCommandResponse_Result:
+			return payload.Result, nil
+		}
+	}
+}
+
+// ForwardCommand runs a command another replica received for an agent whose
+// stream this replica holds. Only other replicas may call it.
+func (s *DockletServer) ForwardCommand(req *pb.ForwardCommandRequest, stream pb.DockletService_ForwardCommandServer) error {
+	ctx := stream.Context()
+	if err := s.requirePeerHub(ctx); err != nil {
+		return err
+	}
+	if req.Command == nil || req.CommandId == "" {
+		return status.Error(codes.InvalidArgument, "command and command id are required")
+	}
+	val, ok := s.agents.Load(req.Command.NodeId)
+	if !ok {
+		return status.Errorf(codes.NotFound, "node %s not connected to this hub", req.Command.NodeId)
+	}
+	session := val.(*AgentSession)
+	ctx = s.recordForwardedJob(ctx, req)
+
+	var resp *pb.ExecuteCommandResponse
+	var err error
+	if req.Stream {
+		resp, err = s.sendStreamCommand(ctx, session, req.CommandId, req.Command, func(data []byte) error {
+			return stream.Send(&pb.ForwardCommandResponse{
+				Payload: &pb.ForwardCommandResponse_Chunk{Chunk: data},
+			})
+		})
+	} else {
+		resp, err = s.sendCommand(ctx, session, req.CommandId, req.Command)
+	}
+	if err != nil {
+		return err
+	}
+	return stream.Send(&pb.ForwardCommandResponse{
+		Payload: &pb.ForwardCommandResponse_Result{Result: resp},
+	})
+}
+
+// recordForwardedJob records a forwarded command in the job history like a
+// local one, unless the forwarding replica already did or it is a probe.
+// The returned context carries the original issuer.
+func (s *DockletServer) recordForwardedJob(ctx context.Context, req *pb.ForwardCommandRequest) context.Context {
+	ctx = WithIssuer(ctx, req.IssuedBy)
+	if req.ParentJobId != "" {
+		ctx = withParentJob(ctx, req.ParentJobId)
+	}
+	if req.Unrecorded {
+		return withoutJobRecord(ctx)
+	}
+	job, err := s.Repo.GetJob(ctx, req.CommandId)
+	if err != nil {
+		log.Printf("Failed to look up forwarded job %s: %v", req.CommandId, err)
+		s.metrics.storageError("get_job")
+	}
+	if job == nil {
+		s.startJob(ctx, req.CommandId, req.Command.NodeId, req.Command.Command, req.Command.Args)
+	}
+	return ctx
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astracat/docklet/internal/storage"
+)
+
+// recordRevisionOutcome stores the results of job and the rollout status on
+// revision rev of cluster id.
+func (s *HTTPServer) recordRevisionOutcome(id string, rev int, job *JobHandle, rolloutStatus string) {
+	if rev == 0 {
+		return
+	}
+	results := make([]RevisionResult, 0, len(job.Results()))
+	for _, res := range job.Results() {
+		results = append(results, RevisionResult{NodeID: res.NodeID, OK: res.OK, Error: res.Error, Timestamp: res.Timestamp})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.grpcServer.Repo.FinishClusterRevision(ctx, &storage.ClusterRevision{
+		ClusterID: id,
+		Revision:  rev,
+		JobID:     job.ID,
+		Status:    rolloutStatus,
+		Results:   results,
+	})
+	if err != nil {
+		log.Printf("Failed to save revision %d of cluster %s: %v", rev, id, err)
+		s.grpcServer.metrics.storageError("finish_cluster_revision")
+	}
+}
+
+// handleClusterRevisions serves the revision history of a cluster:
+//
+//	GET  /api/clusters/{id}/revisions                 list, without content
+//	GET  /api/clusters/{id}/revisions/{rev}           one revision
+//	GET  /api/clusters/{id}/revisions/{rev}/diff?to=N unified diff of rev to N (default: current)
+//	POST /api/clusters/{id}/rollback/{rev}            deploy rev again as a new revision
+func (s *HTTPServer) handleClusterRevisions(w http.ResponseWriter, r *http.Request, id string, parts []string) {
+	c, err := s.getCluster(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load clusters", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var rev *storage.ClusterRevision
+	if len(parts) > 1 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			http.Error(w, "Invalid revision", http.StatusBadRequest)
+			return
+		}
+		if rev, err = s.grpcServer.Repo.GetClusterRevision(r.Context(), id, n); err != nil {
+			http.Error(w, "Failed to load revision", http.StatusInternalServerError)
+			return
+		}
+		if rev == nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	switch {
+	case parts[0] == "rollback" && rev != nil && len(parts) == 2:
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.rollbackCluster(w, r, c, rev)
+
+	case r.Method != http.MethodGet:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	case parts[0] == "revisions" && len(parts) == 1:
+		revs, err := s.grpcServer.Repo.ListClusterRevisions(r.Context(), id)
+		if err != nil {
+			http.Error(w, "Failed to load revisions", http.StatusInternalServerError)
+			return
+		}
+		out := make([]ClusterRevision, 0, len(revs))
+		for _, rev := range revs {
+			out = append(out, newClusterRevisionResponse(rev, false))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"current":   c.Revision,
+			"revisions": out,
+		})
+
+	case parts[0] == "revisions" && rev != nil && len(parts) == 2:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newClusterRevisionResponse(rev, true))
+
+	case parts[0] == "revisions" && rev != nil && len(parts) == 3 && parts[2] == "diff":
+		toRev := c.Revision
+		if v := r.URL.Query().Get("to"); v != "" {
+			if toRev, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "Invalid revision", http.StatusBadRequest)
+				return
+			}
+		}
+		to, err := s.grpcServer.Repo.GetClusterRevision(r.Context(), id, toRev)
+		if err != nil {
+			http.Error(w, "Failed to load revision", http.StatusInternalServerError)
+			return
+		}
+		if to == nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"from": rev.Revision,
+			"to":   to.Revision,
+			"diff": unifiedDiff(
+				fmt.Sprintf("revision %d", rev.Revision), fmt.Sprintf("revision %d", to.Revision),
+				rev.Content, to.Content),
+		})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// rollbackCluster deploys the content of rev to the nodes of c.
+func (s *HTTPServer) rollbackCluster(w http.ResponseWriter, r *http.Request, c *storage.Cluster, rev *storage.ClusterRevision) {
+	var req struct {
+		Async       bool             `json:"async"`
+		Parallelism int              `json:"parallelism"`
+		Strategy    *RolloutStrategy `json:"strategy"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Strategy != nil {
+		if err := req.Strategy.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	nodes := c.Nodes
+	if c.Selector != "" {
+		var err error
+		if nodes, err = s.grpcServer.resolveTargets(r.Context(), nil, c.Selector); err != nil {
+			http.Error(w, err.Error(), httpStatusFromGRPC(err))
+			return
+		}
+	}
+	if len(nodes) == 0 {
+		http.Error(w, "cluster has no nodes", http.StatusBadRequest)
+		return
+	}
+
+	unlock, err := s.lockCluster(r.Context(), c.ID, true)
+	if err != nil {
+		http.Error(w, "Failed to lock cluster", http.StatusInternalServerError)
+		return
+	}
+	cluster, prevContent, err := s.saveClusterDeployment(r.Context(), c.ID, c.StackName, rev.Content, nodes, c.Selector, rev.Revision)
+	if err != nil {
+		unlock()
+		http.Error(w, "Failed to save cluster", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Cluster %s (%s): rolling back to revision %d as revision %d", c.ID, c.StackName, rev.Revision, cluster.Revision)
+	s.deployCluster(w, r, cluster, prevContent, nodes, req.Parallelism, req.Strategy, req.Async, unlock)
+}
+
+// maxDiffCells bounds the LCS table of unifiedDiff; larger inputs are
+// shown as replaced entirely.
+const maxDiffCells = 4 << 20
+
+// unifiedDiff returns a line diff of a and b in unified format with three
+// lines of context. It is empty when both are equal.
+func unifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	x, y := splitLines(a), splitLines(b)
+
+	type op struct {
+		kind   byte // ' ', '-' or '+'
+		line   string
+		ai, bi int // positions in x and y before the op
+	}
+	var ops []op
+	if (len(x)+1)*(len(y)+1) > maxDiffCells {
+		for i, l := range x {
+			ops = append(ops, op{'-', l, i, 0})
+		}
+		for j, l := range y {
+			ops = append(ops, op{'+', l, len(x), j})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+		lcs := make([][]int, len(x)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(y)+1)
+		}
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(x) || j < len(y) {
+			switch {
+			case i < len(x) && j < len(y) && x[i] == y[j]:
+				ops = append(ops, op{' ', x[i], i, j})
+				i++
+				j++
+			case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, op{'-', x[i], i, j})
+				i++
+			default:
+				ops = append(ops, op{'+', y[j], i, j})
+				j++
+			}
+		}
+	}
+
+	const contextLines = 3
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		// Extend the hunk while changes are at most 2*contextLines lines apart.
+		start := max(0, k-contextLines)
+		end := k
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*contextLines {
+				break
+			}
+			end = next
+		}
+		end = min(len(ops), end+contextLines)
+
+		aCount, bCount := 0, 0
+		for _, o := range ops[start:end] {
+			if o.kind != '+' {
+				aCount++
+			}
+			if o.kind != '-' {
+				bCount++
+			}
+		}
+		aStart, bStart := ops[start].ai+1, ops[start].bi+1
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, o := range ops[start:end] {
+			sb.WriteByte(o.kind)
+			sb.WriteString(o.line)
+			sb.WriteByte('\n')
+		}
+		k = end
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
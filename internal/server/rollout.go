@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/astracat/docklet/internal/storage"
+)
+
+const (
+	defaultHealthTimeout = 2 * time.Minute
+	healthPollInterval   = 3 * time.Second
+)
+
+// Outcomes of a cluster deploy.
+const (
+	RolloutCompleted      = "completed"
+	RolloutFailed         = "failed"
+	RolloutRolledBack     = "rolled_back"
+	RolloutRollbackFailed = "rollback_failed"
+	// A batch failed and there was nothing to roll back to. The reconciler
+	// leaves the cluster alone until it is deployed again.
+	RolloutHalted = "halted"
+)
+
+// RolloutStrategy controls how a cluster deploy reaches its nodes. Without a
+// strategy all nodes are updated at once and health is not checked.
+type RolloutStrategy struct {
+	// Nodes updated per batch; 0 updates all nodes in one batch.
+	BatchSize int `json:"batch_size"`
+	// Wait between batches.
+	PauseSeconds int `json:"pause_seconds"`
+	// How long a node's containers may take to become healthy (default
+	// 120); negative skips the health gate.
+	HealthTimeoutSeconds int `json:"health_timeout_seconds"`
+	// Re-apply the previous content when a batch fails (default true).
+	Rollback *bool `json:"rollback"`
+}
+
+func (rs *RolloutStrategy) validate() error {
+	if rs.BatchSize < 0 || rs.PauseSeconds < 0 {
+		return errors.New("batch_size and pause_seconds must not be negative")
+	}
+	return nil
+}
+
+func (rs *RolloutStrategy) healthTimeout() time.Duration {
+	if rs.HealthTimeoutSeconds == 0 {
+		return defaultHealthTimeout
+	}
+	return time.Duration(rs.HealthTimeoutSeconds) * time.Second
+}
+
+func (rs *RolloutStrategy) rollback() bool {
+	return rs.Rollback == nil || *rs.Rollback
+}
+
+// RolloutOutcome reports how a deploy ended and whether it was rolled back.
+type RolloutOutcome struct {
+	Status        string `json:"status"`
+	RollbackJobID string `json:"rollback_job_id,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// submitDeploy runs stack_up of cluster c on nodes as a cluster_deploy job,
+// in the batches of strategy and gated on container health when it is set.
+func (s *HTTPServer) submitDeploy(ctx context.Context, c storage.Cluster, nodes []string, parallelism int, strategy *RolloutStrategy) (*JobHandle, error) {
+	if strategy == nil {
+		return s.submitStackUp(ctx, "cluster_deploy", c, nodes, parallelism)
+	}
+
+	timeout := strategy.healthTimeout()
+	tasks := make([]NodeTask, 0, len(nodes))
+	for _, nodeID := range nodes {
+		task := NodeTask{
+			NodeID:  nodeID,
+			Command: "stack_up",
+			Args:    []string{c.StackName, c.Content},
+			Timeout: 90 * time.Second,
+		}
+		if timeout > 0 {
+			nodeID := nodeID
+			task.Check = func(ctx context.Context) error {
+				return s.waitStackHealthy(ctx, nodeID, c.StackName, timeout)
+			}
+		}
+		tasks = append(tasks, task)
+	}
+	rollout := Rollout{
+		BatchSize: strategy.BatchSize,
+		Pause:     time.Duration(strategy.PauseSeconds) * time.Second,
+	}
+	return s.grpcServer.Jobs.SubmitRollout(ctx, "cluster_deploy", []string{c.StackName}, tasks, parallelism, rollout)
+}
+
+// finishDeploy waits for the deploy job of cluster c and stores the sync
+// states and the outcome of its revision. When the job failed under a
+// strategy with rollback, the previous content is restored as a new revision
+// and re-applied on every node the deploy reached. A strategy deploy that
+// fails without rolling back halts the cluster, so the reconciler does not
+// push the failed content to the remaining nodes.
+func (s *HTTPServer) finishDeploy(ctx context.Context, c storage.Cluster, prevContent string, nodes []string, job *JobHandle, strategy *RolloutStrategy) RolloutOutcome {
+	<-job.Done()
+	hash := contentHash(c.Content)
+
+	if job.Status() == storage.JobSucceeded {
+		s.saveClusterSync(c.ID, hash, nodes, syncFromJob(job, hash))
+		s.recordRevisionOutcome(c.ID, c.Revision, job, RolloutCompleted)
+		return RolloutOutcome{Status: RolloutCompleted}
+	}
+	if strategy == nil {
+		s.saveClusterSync(c.ID, hash, nodes, syncFromJob(job, hash))
+		s.recordRevisionOutcome(c.ID, c.Revision, job, RolloutFailed)
+		return RolloutOutcome{Status: RolloutFailed}
+	}
+	if !strategy.rollback() || prevContent == "" || prevContent == c.Content {
+		log.Printf("Cluster %s (%s): rollout halted, not rolled back", c.ID, c.StackName)
+		s.saveClusterSync(c.ID, hash, nodes, syncFromJob(job, hash))
+		s.recordRevisionOutcome(c.ID, c.Revision, job, RolloutHalted)
+		return RolloutOutcome{Status: RolloutHalted}
+	}
+
+	var reached []string
+	for _, res := range job.Results() {
+		if res.Status != storage.JobCanceled {
+			reached = append(reached, res.NodeID)
+		}
+	}
+
+	prev, ok := s.restoreClusterContent(ctx, c.ID, hash, prevContent)
+	if !ok {
+		// Deployed again in the meantime; the newer content wins.
+		s.recordRevisionOutcome(c.ID, c.Revision, job, RolloutFailed)
+		return RolloutOutcome{Status: RolloutFailed, Error: "cluster changed during rollout, not rolled back"}
+	}
+	log.Printf("Cluster %s (%s): deploy failed, rolling back %d nodes to revision %d", c.ID, c.StackName, len(reached), prev.Revision)
+
+	if len(reached) == 0 {
+		s.recordRevisionOutcome(c.ID, c.Revision, job, RolloutRolledBack)
+		return RolloutOutcome{Status: RolloutRolledBack}
+	}
+	rollbackJob, err := s.submitStackUp(WithIssuer(ctx, "rollback"), "cluster_rollback", prev, reached, 0)
+	if err != nil {
+		s.recordRevisionOutcome(c.ID, c.Revision, job, RolloutRollbackFailed)
+		return RolloutOutcome{Status: RolloutRollbackFailed, Error: err.Error()}
+	}
+	<-rollbackJob.Done()
+	prevHash := contentHash(prevContent)
+	s.saveClusterSync(c.ID, prevHash, nodes, syncFromJob(rollbackJob, prevHash))
+
+	outcome := RolloutOutcome{Status: RolloutRolledBack, RollbackJobID: rollbackJob.ID}
+	rollbackStatus := RolloutCompleted
+	if rollbackJob.Status() != storage.JobSucceeded {
+		outcome.Status = RolloutRollbackFailed
+		outcome.Error = "rollback job " + rollbackJob.Status()
+		rollbackStatus = RolloutFailed
+	}
+	s.recordRevisionOutcome(c.ID, c.Revision, job, outcome.Status)
+	s.recordRevisionOutcome(c.ID, prev.Revision, rollbackJob, rollbackStatus)
+	return outcome
+}
+
+// restoreClusterContent makes content the current content of cluster id
+// again, as a new revision rolling back to the one before the current. It
+// does nothing unless the current content still has hash.
+func (s *HTTPServer) restoreClusterContent(ctx context.Context, id, hash, content string) (storage.Cluster, bool) {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	repo := s.grpcServer.Repo
+	c, err := repo.GetCluster(ctx, id)
+	if err != nil {
+		log.Printf("Failed to load cluster %s: %v", id, err)
+		return storage.Cluster{}, false
+	}
+	if c == nil || contentHash(c.Content) != hash {
+		return storage.Cluster{}, false
+	}
+
+	now := time.Now()
+	rev := &storage.ClusterRevision{
+		ClusterID:  id,
+		Content:    content,
+		DeployedBy: "rollback",
+		RollbackOf: c.Revision - 1,
+		CreatedAt:  now,
+	}
+	c.Content = content
+	c.UpdatedAt = now
+	if err := repo.SaveCluster(ctx, c); err != nil {
+		log.Printf("Failed to restore cluster %s: %v", id, err)
+		return storage.Cluster{}, false
+	}
+	if err := repo.AddClusterRevision(ctx, rev); err != nil {
+		log.Printf("Failed to record rollback of cluster %s: %v", id, err)
+		return storage.Cluster{}, false
+	}
+	c.Revision = rev.Revision
+	return *c, true
+}
+
+// waitStackHealthy polls the containers of stack on nodeID until all of them
+// run and pass their health checks. It fails right away on an unhealthy or
+// crashed container and after timeout otherwise.
+func (s *HTTPServer) waitStackHealthy(ctx context.Context, nodeID, stack string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(withoutJobRecord(ctx), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+	for {
+		waiting, err := s.stackHealth(ctx, nodeID, stack)
+		if err != nil {
+			return err
+		}
+		if waiting == "" {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("not healthy after %s: %s", timeout, waiting)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// stackHealth inspects the containers of stack once. waiting describes what
+// is not ready yet and is empty when the stack is healthy; err is set when
+// a container is unhealthy or exited with an error.
+func (s *HTTPServer) stackHealth(ctx context.Context, nodeID, stack string) (waiting string, err error) {
+	resp, err := s.executeNodeCommand(ctx, nodeID, "docker_ps", nil, 20*time.Second)
+	if err != nil {
+		return "docker_ps: " + err.Error(), nil
+	}
+	var containers []struct {
+		ID     string            `json:"Id"`
+		Labels map[string]string `json:"Labels"`
+	}
+	if err := json.Unmarshal(resp.Output, &containers); err != nil {
+		return "docker_ps: " + err.Error(), nil
+	}
+
+	found := false
+	for _, c := range containers {
+		if !strings.EqualFold(c.Labels["com.docker.compose.project"], stack) {
+			continue
+		}
+		found = true
+
+		resp, err := s.executeNodeCommand(ctx, nodeID, "docker_inspect", []string{c.ID}, 20*time.Second)
+		if err != nil {
+			return "docker_inspect: " + err.Error(), nil
+		}
+		var info struct {
+			Name  string `json:"Name"`
+			State struct {
+				Status   string `json:"Status"`
+				ExitCode int    `json:"ExitCode"`
+				Health   *struct {
+					Status string `json:"Status"`
+				} `json:"Health"`
+			} `json:"State"`
+		}
+		if err := json.Unmarshal(resp.Output, &info); err != nil {
+			return "docker_inspect: " + err.Error(), nil
+		}
+		name := strings.TrimPrefix(info.Name, "/")
+
+		switch info.State.Status {
+		case "running":
+		case "exited", "dead":
+			// One-shot containers (e.g. migrations) may exit cleanly.
+			if info.State.ExitCode == 0 {
+				continue
+			}
+			return "", fmt.Errorf("container %s exited with code %d", name, info.State.ExitCode)
+		default:
+			return "container " + name + " is " + info.State.Status, nil
+		}
+		if info.State.Health == nil {
+			continue
+		}
+		switch info.State.Health.Status {
+		case "unhealthy":
+			return "", fmt.Errorf("container %s is unhealthy", name)
+		case "starting":
+			return "container " + name + " health is starting", nil
+		}
+	}
+	if !found {
+		return "no containers of stack " + stack, nil
+	}
+	return "", nil
+}
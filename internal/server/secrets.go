@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	secretKeySize  = 32
+	secretSaltSize = 16
+	// PBKDF2 rounds for DOCKLET_SECRETS_KEY, as for backup passphrases.
+	secretKeyIterations = 600000
+)
+
+// SecretBox seals secrets the hub keeps in the store, such as registry
+// passwords, with AES-256-GCM. Replicas sharing a store need the same key.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// LoadSecretBox derives the key from DOCKLET_SECRETS_KEY with PBKDF2 and the
+// random salt in saltPath if the variable is set, otherwise it reads the key
+// file at keyPath. Either file is created on first start.
+func LoadSecretBox(keyPath, saltPath string) (*SecretBox, error) {
+	var key []byte
+	if passphrase := os.Getenv("DOCKLET_SECRETS_KEY"); strings.TrimSpace(passphrase) != "" {
+		salt, err := loadOrCreateRandom(saltPath, secretSaltSize)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = pbkdf2.Key(sha256.New, passphrase, salt, secretKeyIterations, secretKeySize); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if key, err = loadOrCreateRandom(keyPath, secretKeySize); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// loadOrCreateRandom reads the size random bytes stored at path, creating
+// the file on first use.
+func loadOrCreateRandom(path string, size int) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != size {
+			return nil, fmt.Errorf("%s: expected %d bytes", path, size)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key = make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	// O_EXCL: a replica starting at the same time may have won the race.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if os.IsExist(err) {
+		return loadOrCreateRandom(path, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Write(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext; the nonce is prepended to the result.
+func (b *SecretBox) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts what Seal returned.
+func (b *SecretBox) Open(sealed []byte) ([]byte, error) {
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("sealed secret is truncated")
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, errors.New("secret was sealed with another key")
+	}
+	return plaintext, nil
+}
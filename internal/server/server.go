@@ -2,18 +2,22 @@ package server
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	pb "github.com/astracat/docklet/api/proto/v1"
 	"github.com/astracat/docklet/internal/storage"
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
@@ -26,6 +30,57 @@ type AgentSession struct {
 	Version     string
 	ConnectedAt time.Time
 	RemoteAddr  string
+	Host        *pb.HostInfo
+
+	// Client certificate of the stream; nil without mTLS.
+	Cert *x509.Certificate
+
+	// gRPC server streams are not safe for concurrent Send.
+	sendMu sync.Mutex
+
+	// Closed when the hub ends the session, e.g. because a newer session
+	// for the same node replaced it. closeErr is returned to the agent.
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// close ends the session with err.
+func (a *AgentSession) close(err error) {
+	a.closeOnce.Do(func() {
+		a.closeErr = err
+		close(a.closed)
+	})
+}
+
+// Send serializes writes to the agent stream.
+func (a *AgentSession) Send(payload *pb.StreamPayload) error {
+	a.sendMu.Lock()
+	defer a.sendMu.Unlock()
+	return a.Stream.Send(payload)
+}
+
+// node returns the storage record for this session, seen now.
+func (a *AgentSession) node() *storage.Node {
+	host := a.Host
+	if host == nil {
+		host = &pb.HostInfo{}
+	}
+	return &storage.Node{
+		ID:            a.NodeID,
+		MachineID:     a.MachineID,
+		Version:       a.Version,
+		RemoteAddr:    a.RemoteAddr,
+		LastSeen:      time.Now(),
+		Hostname:      host.Hostname,
+		OS:            host.Os,
+		Kernel:        host.Kernel,
+		Arch:          host.Arch,
+		CPUCount:      int(host.CpuCount),
+		MemoryBytes:   host.MemoryBytes,
+		DockerVersion: host.DockerVersion,
+		StorageDriver: host.StorageDriver,
+	}
 }
 
 type DockletServer struct {
@@ -38,30 +93,123 @@ type DockletServer struct {
 	// Persistence
 	Repo storage.NodeRepository
 
+	// Signs node certificates at enrollment; nil when the CA key is unavailable
+	CA *CertAuthority
+
+	// Seals registry credentials; nil when no key is available
+	Secrets *SecretBox
+
+	// Set when the configured store was unavailable and the hub runs on the
+	// in-memory fallback
+	StoreFallback bool
+
+	// This hub's server certificate, which other replicas present when they
+	// forward commands; nil without TLS
+	HubCert *x509.Certificate
+
+	// Revoked certificate serials, checked at TLS handshake
+	revoked *revocationList
+
+	// Nodes with a certificate rotation in progress
+	rotating sync.Map
+
+	// Hub-side jobs fanning commands out to many nodes
+	Jobs *JobRunner
+
 	// Pension commands waiting for result
 	// Key: CommandID, Value: chan *pb.CommandResult
 	pendingCommands sync.Map
+
+	// Output chunks of streaming commands
+	// Key: CommandID, Value: *commandChunks
+	pendingChunks sync.Map
+
+	// Interactive exec sessions
+	// Key: CommandID, Value: *ExecSession
+	execSessions sync.Map
+
+	// Rolling load history from heartbeats
+	// Key: NodeID, Value: *metricSeries
+	nodeMetrics sync.Map
+
+	metrics *hubMetrics
+
+	// Agent results waiting to be recorded in the job history, so that a
+	// slow store does not hold up the agent's stream
+	jobResults chan *storage.Job
+
+	// Other hub replicas sharing Repo; nil until StartReplica
+	replicas *replicaSet
+
+	// Called whenever an agent registers
+	hooksMu      sync.Mutex
+	connectHooks []func(nodeID string)
 }
 
 const inactiveNodeTTL = 10 * time.Minute
 
+// Limits for what the job history keeps of a command.
+const (
+	maxJobArgBytes    = 4 * 1024
+	maxJobOutputBytes = 64 * 1024
+)
+
+// jobResultQueue bounds the agent results waiting to be recorded; past it
+// the receiving stream records them itself.
+const jobResultQueue = 1024
+
 func NewDockletServer(repo storage.NodeRepository) *DockletServer {
-	return &DockletServer{
-		Repo: repo,
+	s := &DockletServer{
+		Repo:       repo,
+		metrics:    newHubMetrics(),
+		revoked:    newRevocationList(),
+		jobResults: make(chan *storage.Job, jobResultQueue),
+	}
+	s.Jobs = NewJobRunner(s, defaultJobParallelism())
+	go s.recordJobResults()
+	return s
+}
+
+// OnNodeConnected registers fn to be called in its own goroutine whenever an
+// agent registers.
+func (s *DockletServer) OnNodeConnected(fn func(nodeID string)) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.connectHooks = append(s.connectHooks, fn)
+}
+
+func (s *DockletServer) runConnectHooks(nodeID string) {
+	s.hooksMu.Lock()
+	hooks := append([]func(string){}, s.connectHooks...)
+	s.hooksMu.Unlock()
+	for _, fn := range hooks {
+		go fn(nodeID)
 	}
 }
 
 func (s *DockletServer) ListNodes(ctx context.Context, req *pb.ListNodesRequest) (*pb.ListNodesResponse, error) {
+	if err := rejectNodeCert(ctx); err != nil {
+		return nil, err
+	}
+
 	resp := &pb.ListNodesResponse{
 		Nodes: []*pb.NodeInfo{},
 	}
 
+	sel, err := ParseSelector(req.Selector)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	dbNodes, err := s.listNodesWithCleanup(ctx)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to fetch nodes from db: %v", err)
 	}
 
 	for _, n := range dbNodes {
+		if !sel.Empty() && !sel.Matches(n) {
+			continue
+		}
 		nodeStatus := "disconnected"
 		if s.nodeConnected(n.ID) {
 			nodeStatus = "connected"
@@ -73,6 +221,18 @@ func (s *DockletServer) ListNodes(ctx context.Context, req *pb.ListNodesRequest)
 			Version:    n.Version,
 			Status:     nodeStatus,
 			RemoteAddr: n.RemoteAddr,
+			Host: &pb.HostInfo{
+				Hostname:      n.Hostname,
+				Os:            n.OS,
+				Kernel:        n.Kernel,
+				Arch:          n.Arch,
+				CpuCount:      int32(n.CPUCount),
+				MemoryBytes:   n.MemoryBytes,
+				DockerVersion: n.DockerVersion,
+				StorageDriver: n.StorageDriver,
+			},
+			Labels: n.Labels,
+			Taints: n.Taints,
 		})
 	}
 
@@ -109,16 +269,23 @@ func (s *DockletServer) RenameNode(ctx context.Context, nodeID, name string) err
 func (s *DockletServer) listNodesWithCleanup(ctx context.Context) ([]*storage.Node, error) {
 	dbNodes, err := s.Repo.ListNodes(ctx)
 	if err != nil {
+		s.metrics.storageError("list_nodes")
 		return nil, err
 	}
 
 	cutoff := time.Now().Add(-inactiveNodeTTL)
 	nodes := make([]*storage.Node, 0, len(dbNodes))
 	for _, n := range dbNodes {
-		if !s.nodeConnected(n.ID) && !n.LastSeen.IsZero() && n.LastSeen.Before(cutoff) {
+		// Labels and taints live on the node record, so a labeled node is
+		// kept to find them again when it reconnects.
+		if !s.nodeConnected(n.ID) && !n.LastSeen.IsZero() && n.LastSeen.Before(cutoff) &&
+			len(n.Labels) == 0 && len(n.Taints) == 0 {
 			if err := s.Repo.DeleteNode(ctx, n.ID); err != nil {
 				log.Printf("Failed to cleanup stale node %s: %v", n.ID, err)
+				s.metrics.storageError("delete_node")
 				nodes = append(nodes, n)
+			} else {
+				s.nodeMetrics.Delete(n.ID)
 			}
 			continue
 		}
@@ -128,39 +295,55 @@ func (s *DockletServer) listNodesWithCleanup(ctx context.Context) ([]*storage.No
 	return nodes, nil
 }
 
+// nodeConnected reports whether nodeID has a stream to this hub or to
+// another live replica.
 func (s *DockletServer) nodeConnected(nodeID string) bool {
-	_, ok := s.agents.Load(nodeID)
-	return ok
+	if _, ok := s.agents.Load(nodeID); ok {
+		return true
+	}
+	return s.remoteOwner(nodeID) != nil
 }
 
-func (s *DockletServer) ExecuteCommand(ctx context.Context, req *pb.ExecuteCommandRequest) (*pb.ExecuteCommandResponse, error) {
-	// 1. Find Node
+func (s *DockletServer) ExecuteCommand(ctx context.Context, req *pb.ExecuteCommandRequest) (resp *pb.ExecuteCommandResponse, err error) {
+	if err := rejectNodeCert(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() { s.metrics.observeCommand(req.Command, commandOutcome(resp, err), time.Since(start)) }()
+
+	// 1. Find Node; agents connected to another replica are served there
+	cmdID := newCommandID()
 	val, ok := s.agents.Load(req.NodeId)
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "node %s not connected", req.NodeId)
+		return s.forwardCommand(ctx, cmdID, req, nil)
 	}
-	session := val.(*AgentSession)
 
 	// 2. Prepare Command
-	cmdID := fmt.Sprintf("cmd-%d", time.Now().UnixNano()) // TODO: Better UUID
+	s.startJob(ctx, cmdID, req.NodeId, req.Command, req.Args)
+	return s.sendCommand(ctx, val.(*AgentSession), cmdID, req)
+}
 
+// sendCommand sends a recorded command to session and waits for its result.
+func (s *DockletServer) sendCommand(ctx context.Context, session *AgentSession, cmdID string, req *pb.ExecuteCommandRequest) (*pb.ExecuteCommandResponse, error) {
 	// 3. Register pending channel
 	resultChan := make(chan *pb.CommandResult, 1)
 	s.pendingCommands.Store(cmdID, resultChan)
 	defer s.pendingCommands.Delete(cmdID)
 
 	// 4. Send Command to Agent
-	err := session.Stream.Send(&pb.StreamPayload{
+	err := session.Send(&pb.StreamPayload{
 		Payload: &pb.StreamPayload_Command{
 			Command: &pb.Command{
-				Id:   cmdID,
-				Type: req.Command,
-				Args: req.Args,
+				Id:            cmdID,
+				Type:          req.Command,
+				Args:          req.Args,
+				RegistryAuths: s.registryAuths(ctx, req.Command, req.Args),
 			},
 		},
 	})
 
 	if err != nil {
+		s.failJob(cmdID, "failed to send command to agent: "+err.Error())
 		return nil, status.Errorf(codes.Internal, "failed to send command to agent: %v", err)
 	}
 
@@ -180,12 +363,328 @@ func (s *DockletServer) ExecuteCommand(ctx context.Context, req *pb.ExecuteComma
 			ExitCode: res.ExitCode,
 		}, nil
 	case <-ctx.Done():
+		s.cancelCommand(session, cmdID)
+		if ctx.Err() == context.Canceled {
+			s.cancelJob(cmdID)
+			return nil, status.Errorf(codes.Canceled, "command canceled")
+		}
+		s.failJob(cmdID, "command timed out")
 		return nil, status.Errorf(codes.DeadlineExceeded, "command timed out")
 	case <-fallbackTimer:
+		s.cancelCommand(session, cmdID)
+		s.failJob(cmdID, "hub command timed out")
 		return nil, status.Errorf(codes.DeadlineExceeded, "hub command timed out")
 	}
 }
 
+// StreamCommand runs a command whose output is delivered incrementally as
+// CommandChunks (e.g. followed logs). onChunk is called for every chunk in
+// order. The command ends when the agent sends its CommandResult, when onChunk
+// returns an error, or when ctx is done; in the latter two cases the agent is
+// asked to cancel it.
+func (s *DockletServer) StreamCommand(ctx context.Context, req *pb.ExecuteCommandRequest, onChunk func(data []byte) error) (resp *pb.ExecuteCommandResponse, err error) {
+	start := time.Now()
+	defer func() { s.metrics.observeCommand(req.Command, commandOutcome(resp, err), time.Since(start)) }()
+
+	cmdID := newCommandID()
+	val, ok := s.agents.Load(req.NodeId)
+	if !ok {
+		return s.forwardCommand(ctx, cmdID, req, onChunk)
+	}
+	s.startJob(ctx, cmdID, req.NodeId, req.Command, req.Args)
+	return s.sendStreamCommand(ctx, val.(*AgentSession), cmdID, req, onChunk)
+}
+
+// ExecuteCommandStream is the gRPC form of StreamCommand.
+func (s *DockletServer) ExecuteCommandStream(req *pb.ExecuteCommandRequest, stream pb.DockletService_ExecuteCommandStreamServer) error {
+	if err := rejectNodeCert(stream.Context()); err != nil {
+		return err
+	}
+	resp, err := s.StreamCommand(stream.Context(), req, func(data []byte) error {
+		return stream.Send(&pb.ExecuteCommandStreamResponse{
+			Payload: &pb.ExecuteCommandStreamResponse_Chunk{Chunk: data},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return stream.Send(&pb.ExecuteCommandStreamResponse{
+		Payload: &pb.ExecuteCommandStreamResponse_Result{Result: resp},
+	})
+}
+
+// commandChunks buffers the output chunks of a streaming command between the
+// agent's stream and the caller. The stream never waits for a caller: when
+// the buffer is full, overflow is closed and the command fails instead of
+// losing output.
+type commandChunks struct {
+	ch       chan *pb.CommandChunk
+	overflow chan struct{}
+	once     sync.Once
+}
+
+func newCommandChunks() *commandChunks {
+	return &commandChunks{
+		ch:       make(chan *pb.CommandChunk, 256),
+		overflow: make(chan struct{}),
+	}
+}
+
+func (c *commandChunks) push(chunk *pb.CommandChunk) {
+	select {
+	case c.ch <- chunk:
+	default:
+		c.once.Do(func() {
+			log.Printf("Warning: chunk buffer full for %s at chunk %d, failing the command", chunk.CommandId, chunk.Seq)
+			close(c.overflow)
+		})
+	}
+}
+
+// sendStreamCommand is sendCommand for streaming commands.
+func (s *DockletServer) sendStreamCommand(ctx context.Context, session *AgentSession, cmdID string, req *pb.ExecuteCommandRequest, onChunk func(data []byte) error) (*pb.ExecuteCommandResponse, error) {
+	resultChan := make(chan *pb.CommandResult, 1)
+	chunks := newCommandChunks()
+	chunkChan := chunks.ch
+	s.pendingCommands.Store(cmdID, resultChan)
+	s.pendingChunks.Store(cmdID, chunks)
+	defer s.pendingCommands.Delete(cmdID)
+	defer s.pendingChunks.Delete(cmdID)
+
+	err := session.Send(&pb.StreamPayload{
+		Payload: &pb.StreamPayload_Command{
+			Command: &pb.Command{
+				Id:            cmdID,
+				Type:          req.Command,
+				Args:          req.Args,
+				RegistryAuths: s.registryAuths(ctx, req.Command, req.Args),
+				Stream:        true,
+			},
+		},
+	})
+	if err != nil {
+		s.failJob(cmdID, "failed to send command to agent: "+err.Error())
+		return nil, status.Errorf(codes.Internal, "failed to send command to agent: %v", err)
+	}
+
+	for {
+		select {
+		case chunk := <-chunkChan:
+			if err := onChunk(chunk.Data); err != nil {
+				s.cancelCommand(session, cmdID)
+				return nil, err
+			}
+		case res := <-resultChan:
+			// Chunks are routed before the result, flush what is still buffered.
+		drain:
+			for {
+				select {
+				case chunk := <-chunkChan:
+					if err := onChunk(chunk.Data); err != nil {
+						return nil, err
+					}
+				default:
+					break drain
+				}
+			}
+			return &pb.ExecuteCommandResponse{
+				Output:   res.Output,
+				Error:    res.Error,
+				ExitCode: res.ExitCode,
+			}, nil
+		case <-chunks.overflow:
+			s.cancelCommand(session, cmdID)
+			s.failJob(cmdID, "command output was not read fast enough")
+			return nil, status.Error(codes.ResourceExhausted, "command output was not read fast enough")
+		case <-ctx.Done():
+			s.cancelCommand(session, cmdID)
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// newCommandID returns a unique command id; it is also the id of the job record.
+func newCommandID() string {
+	return uuid.NewString()
+}
+
+type issuerKey struct{}
+
+// WithIssuer attaches the identity issuing commands (e.g. the dashboard user) to ctx.
+func WithIssuer(ctx context.Context, issuer string) context.Context {
+	return context.WithValue(ctx, issuerKey{}, issuer)
+}
+
+// issuerFromContext returns who issued a command: the identity set with
+// WithIssuer, the CommonName of the gRPC client certificate, or "system".
+func issuerFromContext(ctx context.Context) string {
+	if issuer, ok := ctx.Value(issuerKey{}).(string); ok && issuer != "" {
+		return issuer
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+			return "cert:" + tlsInfo.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	return "system"
+}
+
+type unrecordedKey struct{}
+
+// withoutJobRecord keeps commands run with ctx out of the job history. It is
+// meant for read-only probes the hub runs on its own, e.g. by the reconciler.
+func withoutJobRecord(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unrecordedKey{}, true)
+}
+
+// startJob records a command in the job history before it is sent to the agent.
+func (s *DockletServer) startJob(ctx context.Context, cmdID, nodeID, cmdType string, args []string) {
+	if ctx.Value(unrecordedKey{}) != nil {
+		return
+	}
+	dbCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := s.Repo.CreateJob(dbCtx, &storage.Job{
+		ID:        cmdID,
+		ParentID:  parentJobFromContext(ctx),
+		NodeID:    nodeID,
+		Type:      cmdType,
+		Args:      jobArgs(cmdType, args),
+		IssuedBy:  issuerFromContext(ctx),
+		Status:    storage.JobRunning,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to record job %s: %v", cmdID, err)
+		s.metrics.storageError("create_job")
+	}
+}
+
+// finishJob queues the agent's result for a command to be stored. Results
+// that arrive after the hub gave up waiting still land here, so the history
+// reflects the real outcome.
+func (s *DockletServer) finishJob(res *pb.CommandResult) {
+	jobStatus := storage.JobSucceeded
+	if res.ExitCode != 0 || res.Error != "" {
+		jobStatus = storage.JobFailed
+	}
+	job := &storage.Job{
+		ID:         res.CommandId,
+		Status:     jobStatus,
+		ExitCode:   res.ExitCode,
+		Error:      res.Error,
+		Output:     []byte(truncate(string(res.Output), maxJobOutputBytes)),
+		FinishedAt: time.Now(),
+	}
+	select {
+	case s.jobResults <- job:
+	default:
+		s.saveJobOutcome(job)
+	}
+}
+
+// recordJobResults stores the results queued by finishJob.
+func (s *DockletServer) recordJobResults() {
+	for job := range s.jobResults {
+		s.saveJobOutcome(job)
+	}
+}
+
+// failJob marks a command as failed on the hub side (send error, timeout).
+func (s *DockletServer) failJob(cmdID, errMsg string) {
+	s.saveJobOutcome(&storage.Job{
+		ID:         cmdID,
+		Status:     storage.JobFailed,
+		ExitCode:   -1,
+		Error:      errMsg,
+		FinishedAt: time.Now(),
+	})
+}
+
+// cancelJob marks a command whose caller went away before the agent answered.
+func (s *DockletServer) cancelJob(cmdID string) {
+	s.saveJobOutcome(&storage.Job{
+		ID:         cmdID,
+		Status:     storage.JobCanceled,
+		ExitCode:   -1,
+		Error:      "command canceled",
+		FinishedAt: time.Now(),
+	})
+}
+
+func (s *DockletServer) saveJobOutcome(job *storage.Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Repo.FinishJob(ctx, job); err != nil {
+		log.Printf("Failed to update job %s: %v", job.ID, err)
+		s.metrics.storageError("finish_job")
+	}
+}
+
+// jobArgs returns the args of a command as the job history keeps them.
+// Anyone who can read jobs sees them, so compose files and env values are
+// redacted; each arg is cut to maxJobArgBytes.
+func jobArgs(cmdType string, args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case cmdType == "stack_up" && i == 1:
+			// The hash matches the content of the cluster revision.
+			arg = fmt.Sprintf("[compose file, %d bytes, sha256 %s]", len(arg), contentHash(arg)[:12])
+		case cmdType == "docker_run" && i == 0:
+			arg = redactRunEnv(arg)
+		}
+		out[i] = truncate(arg, maxJobArgBytes)
+	}
+	return out
+}
+
+// redactRunEnv blanks the values of the env entries of a docker_run config,
+// keeping the names. Args that are not a JSON config are kept.
+func redactRunEnv(arg string) string {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(arg), &config); err != nil || config["env"] == nil {
+		return arg
+	}
+	var env []string
+	if err := json.Unmarshal(config["env"], &env); err != nil {
+		return arg
+	}
+	for i, kv := range env {
+		if name, _, ok := strings.Cut(kv, "="); ok {
+			env[i] = name + "=[redacted]"
+		}
+	}
+	config["env"], _ = json.Marshal(env)
+	b, err := json.Marshal(config)
+	if err != nil {
+		return arg
+	}
+	return string(b)
+}
+
+// truncate cuts s to at most max bytes without splitting a UTF-8 sequence.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+func (s *DockletServer) cancelCommand(session *AgentSession, cmdID string) {
+	err := session.Send(&pb.StreamPayload{
+		Payload: &pb.StreamPayload_Cancel{
+			Cancel: &pb.CancelCommand{CommandId: cmdID},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to cancel command %s on %s: %v", cmdID, session.NodeID, err)
+	}
+}
+
 func (s *DockletServer) RegisterStream(stream pb.DockletService_RegisterStreamServer) error {
 	// Get peer info (IP address)
 	remoteAddr := "unknown"
@@ -207,6 +706,10 @@ func (s *DockletServer) RegisterStream(stream pb.DockletService_RegisterStreamSe
 
 	handshake := handshakePayload.Handshake
 	nodeID := handshake.NodeId
+	if err := s.verifyNodeIdentity(stream.Context(), nodeID); err != nil {
+		log.Printf("Rejected agent %s from %s: %v", nodeID, remoteAddr, err)
+		return err
+	}
 
 	// Register sesssion
 	session := &AgentSession{
@@ -217,50 +720,53 @@ func (s *DockletServer) RegisterStream(stream pb.DockletService_RegisterStreamSe
 		Version:     handshake.Version,
 		ConnectedAt: time.Now(),
 		RemoteAddr:  remoteAddr,
+		Host:        handshake.Host,
+		Cert:        peerCertificate(stream.Context()),
+		closed:      make(chan struct{}),
 	}
 
-	s.agents.Store(nodeID, session)
+	// A reconnecting agent may arrive before the hub noticed its old stream
+	// died (e.g. half-open TCP). The newest session wins; the old one is closed.
+	if prev, loaded := s.agents.Swap(nodeID, session); loaded {
+		old := prev.(*AgentSession)
+		log.Printf("Agent %s reconnected from %s, closing previous session from %s", nodeID, remoteAddr, old.RemoteAddr)
+		old.close(status.Errorf(codes.Aborted, "superseded by a newer session for node %s", nodeID))
+	}
+
+	s.claimSession(stream.Context(), session)
 
 	// Persist to DB
-	err = s.Repo.UpsertNode(stream.Context(), &storage.Node{
-		ID:         nodeID,
-		MachineID:  handshake.MachineId,
-		Version:    handshake.Version,
-		RemoteAddr: remoteAddr,
-		LastSeen:   time.Now(),
-	})
+	err = s.Repo.UpsertNode(stream.Context(), session.node())
 	if err != nil {
 		log.Printf("Failed to persist node %s: %v", nodeID, err)
+		s.metrics.storageError("upsert_node")
 		// Proceed anyway, don't block connection on DB error?
 	}
+	if err := s.applyAgentLabels(stream.Context(), nodeID, handshake); err != nil {
+		log.Printf("Failed to apply labels of node %s: %v", nodeID, err)
+	}
 
 	log.Printf("Agent registered: %s (%s)", nodeID, remoteAddr)
 
 	defer func() {
-		if cur, ok := s.agents.Load(nodeID); ok {
-			if cur.(*AgentSession) == session {
-				s.agents.Delete(nodeID)
-				log.Printf("Agent disconnected: %s", nodeID)
-			}
-		} else {
-			log.Printf("Agent stream ended: %s", nodeID)
+		s.releaseSession(session)
+		if !s.agents.CompareAndDelete(nodeID, session) {
+			// Replaced by a newer session; leave the node record to it.
+			log.Printf("Agent stream ended: %s (session %s)", nodeID, session.SessionID)
+			return
 		}
+		log.Printf("Agent disconnected: %s", nodeID)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
-		if err := s.Repo.UpsertNode(ctx, &storage.Node{
-			ID:         nodeID,
-			MachineID:  session.MachineID,
-			Version:    session.Version,
-			RemoteAddr: session.RemoteAddr,
-			LastSeen:   time.Now(),
-		}); err != nil {
+		if err := s.Repo.UpsertNode(ctx, session.node()); err != nil {
 			log.Printf("Failed to persist disconnect timestamp for %s: %v", nodeID, err)
+			s.metrics.storageError("upsert_node")
 		}
 	}()
 
 	// Send Ack/Heartbeat immediately to confirm connection
-	err = stream.Send(&pb.StreamPayload{
+	err = session.Send(&pb.StreamPayload{
 		Payload: &pb.StreamPayload_Heartbeat{
 			Heartbeat: &pb.Heartbeat{
 				Timestamp: time.Now().Unix(),
@@ -271,13 +777,37 @@ func (s *DockletServer) RegisterStream(stream pb.DockletService_RegisterStreamSe
 		return err
 	}
 
+	// Receive in the background so a closed session can return right away;
+	// returning ends the stream, which also stops the receiver.
+	errc := make(chan error, 1)
+	go func() { errc <- s.receive(session) }()
+	go s.maybeRotate(session)
+	s.runConnectHooks(nodeID)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-session.closed:
+		return session.closeErr
+	}
+}
+
+// receive handles messages from an agent until its stream ends.
+func (s *DockletServer) receive(session *AgentSession) error {
+	stream, nodeID := session.Stream, session.NodeID
 	for {
 		in, err := stream.Recv()
 		if err == io.EOF {
 			return nil
 		}
 		if err != nil {
-			log.Printf("Stream error for %s: %v", nodeID, err)
+			select {
+			case <-session.closed:
+				// Expected: RegisterStream returned and ended this stream.
+			default:
+				log.Printf("Stream error for %s: %v", nodeID, err)
+				s.metrics.streamError()
+			}
 			return err
 		}
 
@@ -298,15 +828,25 @@ func (s *DockletServer) RegisterStream(stream pb.DockletService_RegisterStreamSe
 			} else {
 				log.Printf("Warning: received result for unknown/expired command %s", cmdID)
 			}
+			s.finishJob(payload.Result)
+
+		case *pb.StreamPayload_Chunk:
+			cmdID := payload.Chunk.CommandId
+			if chunks, ok := s.pendingChunks.Load(cmdID); ok {
+				chunks.(*commandChunks).push(payload.Chunk)
+			}
+
+		case *pb.StreamPayload_Exec:
+			sessionID := payload.Exec.SessionId
+			if sess, ok := s.execSessions.Load(sessionID); ok {
+				sess.(*ExecSession).deliver(payload.Exec)
+			}
 
 		case *pb.StreamPayload_Heartbeat:
-			_ = s.Repo.UpsertNode(context.Background(), &storage.Node{
-				ID:         nodeID,
-				MachineID:  session.MachineID,
-				Version:    session.Version,
-				RemoteAddr: session.RemoteAddr,
-				LastSeen:   time.Now(),
-			})
+			s.recordMetrics(nodeID, payload.Heartbeat)
+			if err := s.Repo.UpsertNode(context.Background(), session.node()); err != nil {
+				s.metrics.storageError("upsert_node")
+			}
 
 		default:
 			log.Printf("[%s] Unknown payload type: %T", nodeID, payload)
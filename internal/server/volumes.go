@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errNoVolumeRoute marks a volume request that matches no route.
+var errNoVolumeRoute = errors.New("no such volume route")
+
+// volumeRequest maps a volume request to an agent command. rest is the path
+// after ".../volumes", routed like the Docker Engine API:
+//
+//	GET    / or /json   list (?filters)
+//	POST   /create      create ({"Name": ..., "Driver": ..., "Labels": {...}})
+//	POST   /prune       prune unused anonymous volumes (?all: named ones too, ?filters)
+//	GET    /{name}      inspect
+//	DELETE /{name}      remove (?force)
+func volumeRequest(r *http.Request, rest string) (cmd string, args []string, err error) {
+	q := r.URL.Query()
+	rest = strings.Trim(rest, "/")
+
+	opts := map[string]interface{}{}
+	for _, key := range []string{"all", "force"} {
+		if queryBool(q, key) {
+			opts[key] = true
+		}
+	}
+	if f := strings.TrimSpace(q.Get("filters")); f != "" {
+		opts["filters"] = f
+	}
+	optsArg := func() []string {
+		if len(opts) == 0 {
+			return nil
+		}
+		b, _ := json.Marshal(opts)
+		return []string{string(b)}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && (rest == "" || rest == "json"):
+		return "volume_ls", optsArg(), nil
+
+	case r.Method == http.MethodPost && rest == "create":
+		// An empty body creates a volume with a generated name.
+		var req json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			return "", nil, errors.New("invalid volume")
+		}
+		if len(req) == 0 {
+			req = json.RawMessage("{}")
+		}
+		return "volume_create", []string{string(req)}, nil
+
+	case r.Method == http.MethodPost && rest == "prune":
+		return "volume_prune", optsArg(), nil
+
+	case r.Method == http.MethodGet && rest != "" && !strings.Contains(rest, "/"):
+		return "volume_inspect", []string{rest}, nil
+
+	case r.Method == http.MethodDelete && rest != "" && !strings.Contains(rest, "/"):
+		return "volume_rm", append([]string{rest}, optsArg()...), nil
+	}
+	return "", nil, errNoVolumeRoute
+}
+
+// handleNodeVolumes serves /api/nodes/{id}/volumes.
+func (s *HTTPServer) handleNodeVolumes(w http.ResponseWriter, r *http.Request, nodeID, rest string) {
+	cmd, args, err := volumeRequest(r, rest)
+	if err == errNoVolumeRoute {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cmd == "volume_rm" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	s.proxyCommand(w, r, nodeID, cmd, args)
+}
+
+// handlePortlinerVolumes serves the Portainer docker/volumes routes.
+func (s *HTTPServer) handlePortlinerVolumes(w http.ResponseWriter, r *http.Request, nodeID, rest string) {
+	cmd, args, err := volumeRequest(r, rest)
+	if err == errNoVolumeRoute {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timeout := 20 * time.Second
+	if cmd == "volume_prune" {
+		timeout = 5 * time.Minute
+	}
+	resp, err := s.executeNodeCommand(r.Context(), nodeID, cmd, args, timeout)
+	if err != nil {
+		http.Error(w, err.Error(), dockerErrorStatus(err))
+		return
+	}
+
+	switch cmd {
+	case "volume_create":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(resp.Output)
+	case "volume_rm":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp.Output)
+	}
+}
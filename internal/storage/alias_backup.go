@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 type AliasBackupStore struct {
@@ -91,11 +92,131 @@ func (s *AliasBackupStore) RenameNode(ctx context.Context, id, name string) erro
 	return s.saveAliases()
 }
 
+func (s *AliasBackupStore) SetNodeLabels(ctx context.Context, id string, labels map[string]string, taints []string) error {
+	return s.base.SetNodeLabels(ctx, id, labels, taints)
+}
+
 func (s *AliasBackupStore) DeleteNode(ctx context.Context, id string) error {
 	// Keep alias in backup even if stale node cleanup removes row.
 	return s.base.DeleteNode(ctx, id)
 }
 
+func (s *AliasBackupStore) CreateJob(ctx context.Context, job *Job) error {
+	return s.base.CreateJob(ctx, job)
+}
+
+func (s *AliasBackupStore) FinishJob(ctx context.Context, job *Job) error {
+	return s.base.FinishJob(ctx, job)
+}
+
+func (s *AliasBackupStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	return s.base.GetJob(ctx, id)
+}
+
+func (s *AliasBackupStore) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error) {
+	return s.base.ListJobs(ctx, filter)
+}
+
+func (s *AliasBackupStore) CreateUser(ctx context.Context, user *User) error {
+	return s.base.CreateUser(ctx, user)
+}
+
+func (s *AliasBackupStore) UpdateUser(ctx context.Context, user *User) error {
+	return s.base.UpdateUser(ctx, user)
+}
+
+func (s *AliasBackupStore) GetUser(ctx context.Context, username string) (*User, error) {
+	return s.base.GetUser(ctx, username)
+}
+
+func (s *AliasBackupStore) ListUsers(ctx context.Context) ([]*User, error) {
+	return s.base.ListUsers(ctx)
+}
+
+func (s *AliasBackupStore) DeleteUser(ctx context.Context, username string) error {
+	return s.base.DeleteUser(ctx, username)
+}
+
+func (s *AliasBackupStore) CreateToken(ctx context.Context, token *APIToken) error {
+	return s.base.CreateToken(ctx, token)
+}
+
+func (s *AliasBackupStore) GetTokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	return s.base.GetTokenByHash(ctx, hash)
+}
+
+func (s *AliasBackupStore) ListTokens(ctx context.Context, username string) ([]*APIToken, error) {
+	return s.base.ListTokens(ctx, username)
+}
+
+func (s *AliasBackupStore) RevokeToken(ctx context.Context, id string) error {
+	return s.base.RevokeToken(ctx, id)
+}
+
+func (s *AliasBackupStore) DeleteExpiredTokens(ctx context.Context, before time.Time) error {
+	return s.base.DeleteExpiredTokens(ctx, before)
+}
+
+func (s *AliasBackupStore) CreateEnrollmentToken(ctx context.Context, token *EnrollmentToken) error {
+	return s.base.CreateEnrollmentToken(ctx, token)
+}
+
+func (s *AliasBackupStore) ListEnrollmentTokens(ctx context.Context) ([]*EnrollmentToken, error) {
+	return s.base.ListEnrollmentTokens(ctx)
+}
+
+func (s *AliasBackupStore) DeleteEnrollmentToken(ctx context.Context, id string) error {
+	return s.base.DeleteEnrollmentToken(ctx, id)
+}
+
+func (s *AliasBackupStore) ConsumeEnrollmentToken(ctx context.Context, hash, nodeID string, now time.Time) (*EnrollmentToken, error) {
+	return s.base.ConsumeEnrollmentToken(ctx, hash, nodeID, now)
+}
+
+func (s *AliasBackupStore) SaveCertificate(ctx context.Context, cert *NodeCertificate) error {
+	return s.base.SaveCertificate(ctx, cert)
+}
+
+func (s *AliasBackupStore) ListCertificates(ctx context.Context, nodeID string) ([]*NodeCertificate, error) {
+	return s.base.ListCertificates(ctx, nodeID)
+}
+
+func (s *AliasBackupStore) RevokeCertificate(ctx context.Context, serial string, at time.Time) error {
+	return s.base.RevokeCertificate(ctx, serial, at)
+}
+
+func (s *AliasBackupStore) ListClusters(ctx context.Context) ([]*Cluster, error) {
+	return s.base.ListClusters(ctx)
+}
+
+func (s *AliasBackupStore) GetCluster(ctx context.Context, id string) (*Cluster, error) {
+	return s.base.GetCluster(ctx, id)
+}
+
+func (s *AliasBackupStore) SaveCluster(ctx context.Context, cluster *Cluster) error {
+	return s.base.SaveCluster(ctx, cluster)
+}
+
+func (s *AliasBackupStore) DeleteCluster(ctx context.Context, id string) error {
+	return s.base.DeleteCluster(ctx, id)
+}
+
+func (s *AliasBackupStore) AddClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	return s.base.AddClusterRevision(ctx, rev)
+}
+
+func (s *AliasBackupStore) FinishClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	return s.base.FinishClusterRevision(ctx, rev)
+}
+
+func (s *AliasBackupStore) GetClusterRevision(ctx context.Context, clusterID string, revision int) (*ClusterRevision, error) {
+	return s.base.GetClusterRevision(ctx, clusterID, revision)
+}
+
+func (s *AliasBackupStore) ListClusterRevisions(ctx context.Context, clusterID string) ([]*ClusterRevision, error) {
+	return s.base.ListClusterRevisions(ctx, clusterID)
+}
+
 func (s *AliasBackupStore) restoreAliases(ctx context.Context) error {
 	nodes, err := s.base.ListNodes(ctx)
 	if err != nil {
@@ -127,6 +248,18 @@ func (s *AliasBackupStore) withAlias(node *Node) *Node {
 	return &copied
 }
 
+// Aliases returns a copy of the saved aliases by node ID, including those of
+// nodes that no longer exist.
+func (s *AliasBackupStore) Aliases() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]string, len(s.aliases))
+	for id, alias := range s.aliases {
+		out[id] = alias
+	}
+	return out
+}
+
 func (s *AliasBackupStore) getAlias(id string) string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -206,3 +339,51 @@ func (s *AliasBackupStore) saveAliases() error {
 	}
 	return os.Rename(tmp, s.aliasPath)
 }
+
+func (s *AliasBackupStore) UpsertHub(ctx context.Context, hub *Hub) error {
+	return s.base.UpsertHub(ctx, hub)
+}
+
+func (s *AliasBackupStore) ListHubs(ctx context.Context) ([]*Hub, error) {
+	return s.base.ListHubs(ctx)
+}
+
+func (s *AliasBackupStore) SaveNodeSession(ctx context.Context, session *NodeSession) error {
+	return s.base.SaveNodeSession(ctx, session)
+}
+
+func (s *AliasBackupStore) DeleteNodeSession(ctx context.Context, nodeID, sessionID string) error {
+	return s.base.DeleteNodeSession(ctx, nodeID, sessionID)
+}
+
+func (s *AliasBackupStore) DeleteHubSessions(ctx context.Context, hubID string) error {
+	return s.base.DeleteHubSessions(ctx, hubID)
+}
+
+func (s *AliasBackupStore) ListNodeSessions(ctx context.Context) ([]*NodeSession, error) {
+	return s.base.ListNodeSessions(ctx)
+}
+
+func (s *AliasBackupStore) AcquireClusterLease(ctx context.Context, key, holder string, expires time.Time) (bool, error) {
+	return s.base.AcquireClusterLease(ctx, key, holder, expires)
+}
+
+func (s *AliasBackupStore) ReleaseClusterLease(ctx context.Context, key, holder string) error {
+	return s.base.ReleaseClusterLease(ctx, key, holder)
+}
+
+func (s *AliasBackupStore) SaveRegistry(ctx context.Context, cred *RegistryCredential) error {
+	return s.base.SaveRegistry(ctx, cred)
+}
+
+func (s *AliasBackupStore) GetRegistry(ctx context.Context, id string) (*RegistryCredential, error) {
+	return s.base.GetRegistry(ctx, id)
+}
+
+func (s *AliasBackupStore) ListRegistries(ctx context.Context) ([]*RegistryCredential, error) {
+	return s.base.ListRegistries(ctx)
+}
+
+func (s *AliasBackupStore) DeleteRegistry(ctx context.Context, id string) error {
+	return s.base.DeleteRegistry(ctx, id)
+}
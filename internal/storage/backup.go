@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// BackupVersion is the archive format written by WriteBackup. ReadBackup
+// accepts this and older versions.
+const BackupVersion = 1
+
+const (
+	// Encrypted archives start with backupMagic, followed by the PBKDF2 salt,
+	// the AES-GCM nonce and the sealed gzip payload.
+	backupMagic      = "DOCKLET-BACKUP-AES256GCM\n"
+	backupIterations = 600000
+	backupSaltSize   = 16
+)
+
+// ErrBackupPassphrase is returned by ReadBackup when an encrypted archive is
+// read without a passphrase or with a wrong one.
+var ErrBackupPassphrase = errors.New("backup is encrypted: missing or wrong passphrase")
+
+// Backup is the hub state that survives a move to another store: nodes and
+// their aliases, users and their tokens, node certificates, clusters with
+// their revisions, registry credentials, and the CA and secret key files. Jobs, enrollment tokens and replica
+// sessions are not included.
+type Backup struct {
+	Version      int                `json:"version"`
+	CreatedAt    time.Time          `json:"created_at"`
+	Nodes        []*Node            `json:"nodes"`
+	Aliases      map[string]string  `json:"aliases"`
+	Users        []*User            `json:"users"`
+	Tokens       []*APIToken        `json:"tokens"`
+	Certificates []*NodeCertificate `json:"certificates"`
+	Clusters     []*BackupCluster   `json:"clusters"`
+	// Registries stay sealed; restore the "secrets.key" file, or
+	// "secrets.salt" with DOCKLET_SECRETS_KEY, to open them.
+	Registries []*RegistryCredential `json:"registries,omitempty"`
+	// Files holds the CA material and secret key by file name, e.g. "ca-key.pem".
+	Files map[string][]byte `json:"files,omitempty"`
+}
+
+// BackupCluster is a cluster with its revisions, oldest first.
+type BackupCluster struct {
+	Cluster   *Cluster           `json:"cluster"`
+	Revisions []*ClusterRevision `json:"revisions"`
+}
+
+// ExportBackup reads the state of repo. Revoked and expired tokens are left out.
+func ExportBackup(ctx context.Context, repo NodeRepository) (*Backup, error) {
+	b := &Backup{
+		Version:   BackupVersion,
+		CreatedAt: time.Now().UTC(),
+		Aliases:   make(map[string]string),
+	}
+	var err error
+	if b.Nodes, err = repo.ListNodes(ctx); err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	if aliases, ok := repo.(*AliasBackupStore); ok {
+		b.Aliases = aliases.Aliases()
+	}
+	for _, n := range b.Nodes {
+		if n.Name != "" {
+			b.Aliases[n.ID] = n.Name
+		}
+	}
+	if b.Users, err = repo.ListUsers(ctx); err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	tokens, err := repo.ListTokens(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tokens: %w", err)
+	}
+	for _, t := range tokens {
+		if t.RevokedAt.IsZero() && (t.ExpiresAt.IsZero() || t.ExpiresAt.After(b.CreatedAt)) {
+			b.Tokens = append(b.Tokens, t)
+		}
+	}
+	if b.Certificates, err = repo.ListCertificates(ctx, ""); err != nil {
+		return nil, fmt.Errorf("list certificates: %w", err)
+	}
+	clusters, err := repo.ListClusters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list clusters: %w", err)
+	}
+	for _, c := range clusters {
+		revs, err := repo.ListClusterRevisions(ctx, c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("list revisions of cluster %s: %w", c.ID, err)
+		}
+		oldest := make([]*ClusterRevision, 0, len(revs))
+		for i := len(revs) - 1; i >= 0; i-- {
+			oldest = append(oldest, revs[i])
+		}
+		b.Clusters = append(b.Clusters, &BackupCluster{Cluster: c, Revisions: oldest})
+	}
+	if b.Registries, err = repo.ListRegistries(ctx); err != nil {
+		return nil, fmt.Errorf("list registries: %w", err)
+	}
+	return b, nil
+}
+
+// Validate checks that b can be imported without leaving the store half
+// restored.
+func (b *Backup) Validate() error {
+	if b.Version < 1 || b.Version > BackupVersion {
+		return fmt.Errorf("unsupported backup version %d (this hub reads up to %d)", b.Version, BackupVersion)
+	}
+	for _, n := range b.Nodes {
+		if n == nil || n.ID == "" {
+			return errors.New("node without ID")
+		}
+	}
+	users := make(map[string]bool, len(b.Users))
+	for _, u := range b.Users {
+		if u == nil || u.Username == "" || u.PasswordHash == "" {
+			return errors.New("user without name or password hash")
+		}
+		users[u.Username] = true
+	}
+	for _, t := range b.Tokens {
+		if t == nil || t.ID == "" || t.TokenHash == "" {
+			return errors.New("token without ID or hash")
+		}
+		if !users[t.Username] {
+			return fmt.Errorf("token %s belongs to unknown user %q", t.ID, t.Username)
+		}
+	}
+	for _, c := range b.Certificates {
+		if c == nil || c.Serial == "" {
+			return errors.New("certificate without serial")
+		}
+	}
+	for _, bc := range b.Clusters {
+		if bc == nil || bc.Cluster == nil || bc.Cluster.ID == "" {
+			return errors.New("cluster without ID")
+		}
+		for i, rev := range bc.Revisions {
+			if rev == nil || rev.ClusterID != bc.Cluster.ID || rev.Revision != i+1 {
+				return fmt.Errorf("cluster %s: revisions must be numbered from 1 without gaps", bc.Cluster.ID)
+			}
+		}
+	}
+	hosts := make(map[string]bool, len(b.Registries))
+	for _, r := range b.Registries {
+		if r == nil || r.ID == "" || r.Host == "" {
+			return errors.New("registry without ID or host")
+		}
+		if hosts[r.Host] {
+			return fmt.Errorf("registry %s appears twice", r.Host)
+		}
+		hosts[r.Host] = true
+	}
+	for name := range b.Files {
+		if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			return fmt.Errorf("invalid file name %q", name)
+		}
+	}
+	return nil
+}
+
+// ImportBackup writes b into repo. Records with the same key are replaced;
+// a restored cluster gets exactly the revisions of the backup. Other records
+// in repo are kept.
+func ImportBackup(ctx context.Context, repo NodeRepository, b *Backup) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	for _, u := range b.Users {
+		err := repo.CreateUser(ctx, u)
+		if err == ErrUserExists {
+			err = repo.UpdateUser(ctx, u)
+		}
+		if err != nil {
+			return fmt.Errorf("user %s: %w", u.Username, err)
+		}
+	}
+	for _, t := range b.Tokens {
+		existing, err := repo.GetTokenByHash(ctx, t.TokenHash)
+		if err != nil {
+			return fmt.Errorf("token %s: %w", t.ID, err)
+		}
+		if existing != nil {
+			continue
+		}
+		if err := repo.CreateToken(ctx, t); err != nil {
+			return fmt.Errorf("token %s: %w", t.ID, err)
+		}
+	}
+	for _, n := range b.Nodes {
+		if err := repo.UpsertNode(ctx, n); err != nil {
+			return fmt.Errorf("node %s: %w", n.ID, err)
+		}
+		if err := repo.SetNodeLabels(ctx, n.ID, n.Labels, n.Taints); err != nil {
+			return fmt.Errorf("node %s: %w", n.ID, err)
+		}
+	}
+	for id, alias := range b.Aliases {
+		if err := repo.RenameNode(ctx, id, alias); err != nil {
+			return fmt.Errorf("alias of node %s: %w", id, err)
+		}
+	}
+	for _, c := range b.Certificates {
+		if err := repo.SaveCertificate(ctx, c); err != nil {
+			return fmt.Errorf("certificate %s: %w", c.Serial, err)
+		}
+	}
+	for _, bc := range b.Clusters {
+		c := *bc.Cluster
+		if err := repo.DeleteCluster(ctx, c.ID); err != nil {
+			return fmt.Errorf("cluster %s: %w", c.ID, err)
+		}
+		empty := c
+		empty.Revision = 0
+		if err := repo.SaveCluster(ctx, &empty); err != nil {
+			return fmt.Errorf("cluster %s: %w", c.ID, err)
+		}
+		for _, rev := range bc.Revisions {
+			r := *rev
+			if err := repo.AddClusterRevision(ctx, &r); err != nil {
+				return fmt.Errorf("cluster %s revision %d: %w", c.ID, rev.Revision, err)
+			}
+			if err := repo.FinishClusterRevision(ctx, &r); err != nil {
+				return fmt.Errorf("cluster %s revision %d: %w", c.ID, rev.Revision, err)
+			}
+		}
+		if err := repo.SaveCluster(ctx, &c); err != nil {
+			return fmt.Errorf("cluster %s: %w", c.ID, err)
+		}
+	}
+	if len(b.Registries) > 0 {
+		existing, err := repo.ListRegistries(ctx)
+		if err != nil {
+			return fmt.Errorf("list registries: %w", err)
+		}
+		byHost := make(map[string]string, len(existing))
+		for _, r := range existing {
+			byHost[r.Host] = r.ID
+		}
+		for _, r := range b.Registries {
+			// A registry added again after the backup has another ID.
+			if id, ok := byHost[r.Host]; ok && id != r.ID {
+				if err := repo.DeleteRegistry(ctx, id); err != nil {
+					return fmt.Errorf("registry %s: %w", r.Host, err)
+				}
+			}
+			if err := repo.SaveRegistry(ctx, r); err != nil {
+				return fmt.Errorf("registry %s: %w", r.Host, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WriteBackup writes b to w as gzip-compressed JSON, sealed with a key
+// derived from passphrase unless it is empty.
+func WriteBackup(w io.Writer, b *Backup, passphrase string) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(b); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if passphrase == "" {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out := append([]byte(backupMagic), salt...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, buf.Bytes(), []byte(backupMagic))
+	_, err = w.Write(out)
+	return err
+}
+
+// ReadBackup reads and validates an archive written by WriteBackup.
+func ReadBackup(r io.Reader, passphrase string) (*Backup, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(backupMagic)) {
+		if passphrase == "" {
+			return nil, ErrBackupPassphrase
+		}
+		data = data[len(backupMagic):]
+		if len(data) < backupSaltSize {
+			return nil, errors.New("backup is truncated")
+		}
+		gcm, err := backupCipher(passphrase, data[:backupSaltSize])
+		if err != nil {
+			return nil, err
+		}
+		data = data[backupSaltSize:]
+		if len(data) < gcm.NonceSize() {
+			return nil, errors.New("backup is truncated")
+		}
+		data, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(backupMagic))
+		if err != nil {
+			return nil, ErrBackupPassphrase
+		}
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not a docklet backup: %w", err)
+	}
+	defer zr.Close()
+	var b Backup
+	if err := json.NewDecoder(zr).Decode(&b); err != nil {
+		return nil, fmt.Errorf("not a docklet backup: %w", err)
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, backupIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
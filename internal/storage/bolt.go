@@ -0,0 +1,804 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// BoltStore keeps everything in a single bbolt file, for hubs without
+// Postgres. Records are stored as JSON, one bucket per kind. Only one hub
+// can open the file at a time.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var (
+	boltNodes        = []byte("nodes")
+	boltJobs         = []byte("jobs")
+	boltJobsByTime   = []byte("jobs_by_time") // created_at + id -> id
+	boltUsers        = []byte("users")
+	boltTokens       = []byte("api_tokens")
+	boltEnrollTokens = []byte("enrollment_tokens")
+	boltCerts        = []byte("node_certificates")
+	boltClusters     = []byte("clusters")
+	boltRevisions    = []byte("cluster_revisions") // one nested bucket per cluster
+	boltHubs         = []byte("hub_replicas")
+	boltSessions     = []byte("node_sessions")
+	boltRegistries   = []byte("registries")
+	boltLeases       = []byte("cluster_leases")
+)
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// NewReadOnlyBoltStore opens an existing state file for reading, for
+// backups. The hub holds the file exclusively while it runs, so this fails
+// until the hub is stopped.
+func NewReadOnlyBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if errors.Is(err, berrors.ErrTimeout) {
+		return nil, fmt.Errorf("%s is locked by a running hub; stop it first", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Init(ctx context.Context) error {
+	if s.db.IsReadOnly() {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			boltNodes, boltJobs, boltJobsByTime, boltUsers, boltTokens, boltEnrollTokens,
+			boltCerts, boltClusters, boltRevisions, boltHubs, boltSessions, boltRegistries, boltLeases,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() {
+	s.db.Close()
+}
+
+// boltGet decodes the record under key into v. It reports false if there is none.
+func boltGet(tx *bolt.Tx, bucket []byte, key string, v interface{}) (bool, error) {
+	b := tx.Bucket(bucket).Get([]byte(key))
+	if b == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(b, v)
+}
+
+func boltPut(tx *bolt.Tx, bucket []byte, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put([]byte(key), b)
+}
+
+// boltEach decodes every record of bucket with newRecord and passes it to fn.
+func boltEach(tx *bolt.Tx, bucket []byte, newRecord func() interface{}, fn func(v interface{}) error) error {
+	return tx.Bucket(bucket).ForEach(func(_, b []byte) error {
+		v := newRecord()
+		if err := json.Unmarshal(b, v); err != nil {
+			return err
+		}
+		return fn(v)
+	})
+}
+
+func (s *BoltStore) UpsertNode(ctx context.Context, node *Node) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		n := *node
+		var prev Node
+		found, err := boltGet(tx, boltNodes, node.ID, &prev)
+		if err != nil {
+			return err
+		}
+		n.Labels, n.Taints = nil, nil
+		if found {
+			n.Labels, n.Taints = prev.Labels, prev.Taints
+			if n.Name == "" {
+				n.Name = prev.Name
+			}
+		}
+		return boltPut(tx, boltNodes, node.ID, &n)
+	})
+}
+
+func (s *BoltStore) ListNodes(ctx context.Context) ([]*Node, error) {
+	var nodes []*Node
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltNodes, func() interface{} { return &Node{} }, func(v interface{}) error {
+			nodes = append(nodes, v.(*Node))
+			return nil
+		})
+	})
+	return nodes, err
+}
+
+func (s *BoltStore) GetNode(ctx context.Context, id string) (*Node, error) {
+	var node Node
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) (err error) {
+		found, err = boltGet(tx, boltNodes, id, &node)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// updateNode applies fn to node id if it exists.
+func (s *BoltStore) updateNode(id string, fn func(n *Node)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var n Node
+		found, err := boltGet(tx, boltNodes, id, &n)
+		if err != nil || !found {
+			return err
+		}
+		fn(&n)
+		return boltPut(tx, boltNodes, id, &n)
+	})
+}
+
+func (s *BoltStore) RenameNode(ctx context.Context, id, name string) error {
+	return s.updateNode(id, func(n *Node) { n.Name = strings.TrimSpace(name) })
+}
+
+func (s *BoltStore) SetNodeLabels(ctx context.Context, id string, labels map[string]string, taints []string) error {
+	return s.updateNode(id, func(n *Node) {
+		n.Labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			n.Labels[k] = v
+		}
+		n.Taints = append([]string(nil), taints...)
+	})
+}
+
+func (s *BoltStore) DeleteNode(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltNodes).Delete([]byte(id))
+	})
+}
+
+// jobTimeKey orders the jobs_by_time index by creation time.
+func jobTimeKey(job *Job) []byte {
+	key := make([]byte, 8, 8+len(job.ID))
+	binary.BigEndian.PutUint64(key, uint64(job.CreatedAt.UnixNano()))
+	return append(key, job.ID...)
+}
+
+func (s *BoltStore) CreateJob(ctx context.Context, job *Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var prev Job
+		found, err := boltGet(tx, boltJobs, job.ID, &prev)
+		if err != nil {
+			return err
+		}
+		if found {
+			if err := tx.Bucket(boltJobsByTime).Delete(jobTimeKey(&prev)); err != nil {
+				return err
+			}
+		}
+		if err := boltPut(tx, boltJobs, job.ID, job); err != nil {
+			return err
+		}
+		byTime := tx.Bucket(boltJobsByTime)
+		if err := byTime.Put(jobTimeKey(job), []byte(job.ID)); err != nil {
+			return err
+		}
+
+		// Drop the oldest jobs past maxStoredJobs. Bucket stats miss writes
+		// of this transaction, so the index is counted.
+		count := 0
+		c := byTime.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			count++
+		}
+		var oldest [][]byte
+		for k, id := c.First(); k != nil && len(oldest) < count-maxStoredJobs; k, id = c.Next() {
+			oldest = append(oldest, append([]byte(nil), k...))
+			if err := tx.Bucket(boltJobs).Delete(id); err != nil {
+				return err
+			}
+		}
+		for _, k := range oldest {
+			if err := byTime.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) FinishJob(ctx context.Context, job *Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var existing Job
+		found, err := boltGet(tx, boltJobs, job.ID, &existing)
+		if err != nil || !found {
+			return err
+		}
+		existing.Status = job.Status
+		existing.ExitCode = job.ExitCode
+		existing.Error = job.Error
+		existing.Output = job.Output
+		existing.FinishedAt = job.FinishedAt
+		return boltPut(tx, boltJobs, job.ID, &existing)
+	})
+}
+
+func (s *BoltStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) (err error) {
+		found, err = boltGet(tx, boltJobs, id, &job)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *BoltStore) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error) {
+	var jobs []*Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltJobsByTime).Cursor()
+		for k, id := c.Last(); k != nil; k, id = c.Prev() {
+			var job Job
+			found, err := boltGet(tx, boltJobs, string(id), &job)
+			if err != nil {
+				return err
+			}
+			if !found || !filter.matches(&job) {
+				continue
+			}
+			jobs = append(jobs, &job)
+			if filter.Limit > 0 && len(jobs) == filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+func (s *BoltStore) CreateUser(ctx context.Context, user *User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltUsers).Get([]byte(user.Username)) != nil {
+			return ErrUserExists
+		}
+		return boltPut(tx, boltUsers, user.Username, user)
+	})
+}
+
+func (s *BoltStore) UpdateUser(ctx context.Context, user *User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var existing User
+		found, err := boltGet(tx, boltUsers, user.Username, &existing)
+		if err != nil || !found {
+			return err
+		}
+		existing.PasswordHash = user.PasswordHash
+		existing.Role = user.Role
+		return boltPut(tx, boltUsers, user.Username, &existing)
+	})
+}
+
+func (s *BoltStore) GetUser(ctx context.Context, username string) (*User, error) {
+	var user User
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) (err error) {
+		found, err = boltGet(tx, boltUsers, username, &user)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *BoltStore) ListUsers(ctx context.Context) ([]*User, error) {
+	users := []*User{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Keys are usernames, so this is sorted already.
+		return boltEach(tx, boltUsers, func() interface{} { return &User{} }, func(v interface{}) error {
+			users = append(users, v.(*User))
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (s *BoltStore) DeleteUser(ctx context.Context, username string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltUsers).Delete([]byte(username)); err != nil {
+			return err
+		}
+		return deleteTokensWhere(tx, func(t *APIToken) bool { return t.Username == username })
+	})
+}
+
+func deleteTokensWhere(tx *bolt.Tx, match func(t *APIToken) bool) error {
+	var ids []string
+	err := boltEach(tx, boltTokens, func() interface{} { return &APIToken{} }, func(v interface{}) error {
+		if t := v.(*APIToken); match(t) {
+			ids = append(ids, t.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := tx.Bucket(boltTokens).Delete([]byte(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) CreateToken(ctx context.Context, token *APIToken) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx, boltTokens, token.ID, token)
+	})
+}
+
+func (s *BoltStore) GetTokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	var token *APIToken
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltTokens, func() interface{} { return &APIToken{} }, func(v interface{}) error {
+			if t := v.(*APIToken); t.TokenHash == hash {
+				token = t
+			}
+			return nil
+		})
+	})
+	return token, err
+}
+
+func (s *BoltStore) ListTokens(ctx context.Context, username string) ([]*APIToken, error) {
+	var tokens []*APIToken
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltTokens, func() interface{} { return &APIToken{} }, func(v interface{}) error {
+			if t := v.(*APIToken); username == "" || t.Username == username {
+				tokens = append(tokens, t)
+			}
+			return nil
+		})
+	})
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, err
+}
+
+func (s *BoltStore) RevokeToken(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var token APIToken
+		found, err := boltGet(tx, boltTokens, id, &token)
+		if err != nil || !found || !token.RevokedAt.IsZero() {
+			return err
+		}
+		token.RevokedAt = time.Now()
+		return boltPut(tx, boltTokens, id, &token)
+	})
+}
+
+func (s *BoltStore) DeleteExpiredTokens(ctx context.Context, before time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteTokensWhere(tx, func(t *APIToken) bool {
+			return !t.ExpiresAt.IsZero() && t.ExpiresAt.Before(before)
+		})
+	})
+}
+
+func (s *BoltStore) CreateEnrollmentToken(ctx context.Context, token *EnrollmentToken) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx, boltEnrollTokens, token.ID, token)
+	})
+}
+
+func (s *BoltStore) ListEnrollmentTokens(ctx context.Context) ([]*EnrollmentToken, error) {
+	tokens := []*EnrollmentToken{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltEnrollTokens, func() interface{} { return &EnrollmentToken{} }, func(v interface{}) error {
+			tokens = append(tokens, v.(*EnrollmentToken))
+			return nil
+		})
+	})
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, err
+}
+
+func (s *BoltStore) DeleteEnrollmentToken(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEnrollTokens).Delete([]byte(id))
+	})
+}
+
+func (s *BoltStore) ConsumeEnrollmentToken(ctx context.Context, hash, nodeID string, now time.Time) (*EnrollmentToken, error) {
+	var consumed *EnrollmentToken
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var token *EnrollmentToken
+		err := boltEach(tx, boltEnrollTokens, func() interface{} { return &EnrollmentToken{} }, func(v interface{}) error {
+			if t := v.(*EnrollmentToken); t.TokenHash == hash {
+				token = t
+			}
+			return nil
+		})
+		if err != nil || token == nil {
+			return err
+		}
+		if !token.UsedAt.IsZero() || !now.Before(token.ExpiresAt) || (token.NodeID != "" && token.NodeID != nodeID) {
+			return nil
+		}
+		token.UsedAt = now
+		token.UsedBy = nodeID
+		consumed = token
+		return boltPut(tx, boltEnrollTokens, token.ID, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return consumed, nil
+}
+
+func (s *BoltStore) SaveCertificate(ctx context.Context, cert *NodeCertificate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var existing NodeCertificate
+		found, err := boltGet(tx, boltCerts, cert.Serial, &existing)
+		if err != nil {
+			return err
+		}
+		if found && !existing.RevokedAt.IsZero() {
+			copied := *cert
+			copied.RevokedAt = existing.RevokedAt
+			cert = &copied
+		}
+		return boltPut(tx, boltCerts, cert.Serial, cert)
+	})
+}
+
+func (s *BoltStore) ListCertificates(ctx context.Context, nodeID string) ([]*NodeCertificate, error) {
+	var certs []*NodeCertificate
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltCerts, func() interface{} { return &NodeCertificate{} }, func(v interface{}) error {
+			if cert := v.(*NodeCertificate); nodeID == "" || cert.NodeID == nodeID {
+				certs = append(certs, cert)
+			}
+			return nil
+		})
+	})
+	sort.Slice(certs, func(i, j int) bool { return certs[i].IssuedAt.After(certs[j].IssuedAt) })
+	return certs, err
+}
+
+func (s *BoltStore) RevokeCertificate(ctx context.Context, serial string, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var cert NodeCertificate
+		found, err := boltGet(tx, boltCerts, serial, &cert)
+		if err != nil || !found || !cert.RevokedAt.IsZero() {
+			return err
+		}
+		cert.RevokedAt = at
+		return boltPut(tx, boltCerts, serial, &cert)
+	})
+}
+
+func (s *BoltStore) ListClusters(ctx context.Context) ([]*Cluster, error) {
+	clusters := []*Cluster{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltClusters, func() interface{} { return &Cluster{} }, func(v interface{}) error {
+			clusters = append(clusters, v.(*Cluster))
+			return nil
+		})
+	})
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].CreatedAt.Before(clusters[j].CreatedAt) })
+	return clusters, err
+}
+
+func (s *BoltStore) GetCluster(ctx context.Context, id string) (*Cluster, error) {
+	var c Cluster
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) (err error) {
+		found, err = boltGet(tx, boltClusters, id, &c)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *BoltStore) SaveCluster(ctx context.Context, cluster *Cluster) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx, boltClusters, cluster.ID, cluster)
+	})
+}
+
+func (s *BoltStore) DeleteCluster(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltClusters).Delete([]byte(id)); err != nil {
+			return err
+		}
+		err := tx.Bucket(boltRevisions).DeleteBucket([]byte(id))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+func revisionKey(revision int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(revision))
+	return key
+}
+
+func (s *BoltStore) AddClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var c Cluster
+		found, err := boltGet(tx, boltClusters, rev.ClusterID, &c)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("cluster %s not found", rev.ClusterID)
+		}
+		revs, err := tx.Bucket(boltRevisions).CreateBucketIfNotExists([]byte(rev.ClusterID))
+		if err != nil {
+			return err
+		}
+
+		rev.Revision = 1
+		if k, _ := revs.Cursor().Last(); k != nil {
+			rev.Revision = int(binary.BigEndian.Uint64(k)) + 1
+		}
+		b, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		if err := revs.Put(revisionKey(rev.Revision), b); err != nil {
+			return err
+		}
+		c.Revision = rev.Revision
+		return boltPut(tx, boltClusters, c.ID, &c)
+	})
+}
+
+func (s *BoltStore) FinishClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		revs := tx.Bucket(boltRevisions).Bucket([]byte(rev.ClusterID))
+		if revs == nil {
+			return nil
+		}
+		b := revs.Get(revisionKey(rev.Revision))
+		if b == nil {
+			return nil
+		}
+		var existing ClusterRevision
+		if err := json.Unmarshal(b, &existing); err != nil {
+			return err
+		}
+		existing.JobID = rev.JobID
+		existing.Status = rev.Status
+		existing.Results = rev.Results
+		if b, err := json.Marshal(&existing); err != nil {
+			return err
+		} else {
+			return revs.Put(revisionKey(rev.Revision), b)
+		}
+	})
+}
+
+func (s *BoltStore) GetClusterRevision(ctx context.Context, clusterID string, revision int) (*ClusterRevision, error) {
+	var rev *ClusterRevision
+	err := s.db.View(func(tx *bolt.Tx) error {
+		revs := tx.Bucket(boltRevisions).Bucket([]byte(clusterID))
+		if revs == nil {
+			return nil
+		}
+		b := revs.Get(revisionKey(revision))
+		if b == nil {
+			return nil
+		}
+		rev = &ClusterRevision{}
+		return json.Unmarshal(b, rev)
+	})
+	return rev, err
+}
+
+func (s *BoltStore) ListClusterRevisions(ctx context.Context, clusterID string) ([]*ClusterRevision, error) {
+	out := []*ClusterRevision{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		revs := tx.Bucket(boltRevisions).Bucket([]byte(clusterID))
+		if revs == nil {
+			return nil
+		}
+		c := revs.Cursor()
+		for k, b := c.Last(); k != nil; k, b = c.Prev() {
+			var rev ClusterRevision
+			if err := json.Unmarshal(b, &rev); err != nil {
+				return err
+			}
+			out = append(out, &rev)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltStore) UpsertHub(ctx context.Context, hub *Hub) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx, boltHubs, hub.ID, hub)
+	})
+}
+
+func (s *BoltStore) ListHubs(ctx context.Context) ([]*Hub, error) {
+	var hubs []*Hub
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltHubs, func() interface{} { return &Hub{} }, func(v interface{}) error {
+			hubs = append(hubs, v.(*Hub))
+			return nil
+		})
+	})
+	return hubs, err
+}
+
+func (s *BoltStore) SaveNodeSession(ctx context.Context, session *NodeSession) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var prev NodeSession
+		found, err := boltGet(tx, boltSessions, session.NodeID, &prev)
+		if err != nil {
+			return err
+		}
+		if found && prev.ConnectedAt.After(session.ConnectedAt) {
+			return nil
+		}
+		return boltPut(tx, boltSessions, session.NodeID, session)
+	})
+}
+
+func (s *BoltStore) DeleteNodeSession(ctx context.Context, nodeID, sessionID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var prev NodeSession
+		found, err := boltGet(tx, boltSessions, nodeID, &prev)
+		if err != nil || !found || prev.SessionID != sessionID {
+			return err
+		}
+		return tx.Bucket(boltSessions).Delete([]byte(nodeID))
+	})
+}
+
+func (s *BoltStore) DeleteHubSessions(ctx context.Context, hubID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var nodeIDs []string
+		err := boltEach(tx, boltSessions, func() interface{} { return &NodeSession{} }, func(v interface{}) error {
+			if ns := v.(*NodeSession); ns.HubID == hubID {
+				nodeIDs = append(nodeIDs, ns.NodeID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range nodeIDs {
+			if err := tx.Bucket(boltSessions).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) ListNodeSessions(ctx context.Context) ([]*NodeSession, error) {
+	var sessions []*NodeSession
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltSessions, func() interface{} { return &NodeSession{} }, func(v interface{}) error {
+			sessions = append(sessions, v.(*NodeSession))
+			return nil
+		})
+	})
+	return sessions, err
+}
+
+func (s *BoltStore) AcquireClusterLease(ctx context.Context, key, holder string, expires time.Time) (bool, error) {
+	acquired := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var prev ClusterLease
+		found, err := boltGet(tx, boltLeases, key, &prev)
+		if err != nil {
+			return err
+		}
+		if found && prev.Holder != holder && prev.ExpiresAt.After(time.Now()) {
+			return nil
+		}
+		acquired = true
+		return boltPut(tx, boltLeases, key, &ClusterLease{Key: key, Holder: holder, ExpiresAt: expires})
+	})
+	return acquired, err
+}
+
+func (s *BoltStore) ReleaseClusterLease(ctx context.Context, key, holder string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var prev ClusterLease
+		found, err := boltGet(tx, boltLeases, key, &prev)
+		if err != nil || !found || prev.Holder != holder {
+			return err
+		}
+		return tx.Bucket(boltLeases).Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) SaveRegistry(ctx context.Context, cred *RegistryCredential) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := boltEach(tx, boltRegistries, func() interface{} { return &RegistryCredential{} }, func(v interface{}) error {
+			if existing := v.(*RegistryCredential); existing.ID != cred.ID && existing.Host == cred.Host {
+				return fmt.Errorf("registry %s already exists", cred.Host)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return boltPut(tx, boltRegistries, cred.ID, cred)
+	})
+}
+
+func (s *BoltStore) GetRegistry(ctx context.Context, id string) (*RegistryCredential, error) {
+	var cred RegistryCredential
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) (err error) {
+		found, err = boltGet(tx, boltRegistries, id, &cred)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+func (s *BoltStore) ListRegistries(ctx context.Context) ([]*RegistryCredential, error) {
+	creds := []*RegistryCredential{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltEach(tx, boltRegistries, func() interface{} { return &RegistryCredential{} }, func(v interface{}) error {
+			creds = append(creds, v.(*RegistryCredential))
+			return nil
+		})
+	})
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Host < creds[j].Host })
+	return creds, err
+}
+
+func (s *BoltStore) DeleteRegistry(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRegistries).Delete([]byte(id))
+	})
+}
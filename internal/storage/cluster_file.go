@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ClusterFileStore keeps the clusters of an in-memory base store in a local
+// file, so they survive a restart without a database. Everything else is
+// passed through to base.
+type ClusterFileStore struct {
+	NodeRepository
+	path string
+
+	// Serializes snapshots so an older one cannot overwrite a newer one.
+	mu sync.Mutex
+}
+
+type clusterFileCluster struct {
+	Cluster
+	Revisions []*ClusterRevision // oldest first
+}
+
+type clusterFilePayload struct {
+	Version  int                   `json:"version"`
+	Clusters []*clusterFileCluster `json:"clusters"`
+}
+
+func NewClusterFileStore(base NodeRepository, path string) *ClusterFileStore {
+	return &ClusterFileStore{NodeRepository: base, path: path}
+}
+
+func (s *ClusterFileStore) Init(ctx context.Context) error {
+	if err := s.NodeRepository.Init(ctx); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var payload clusterFilePayload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return err
+	}
+
+	for _, c := range payload.Clusters {
+		if err := s.NodeRepository.SaveCluster(ctx, &c.Cluster); err != nil {
+			return err
+		}
+		for _, rev := range c.Revisions {
+			restored := *rev
+			if err := s.NodeRepository.AddClusterRevision(ctx, &restored); err != nil {
+				return err
+			}
+			if err := s.NodeRepository.FinishClusterRevision(ctx, &restored); err != nil {
+				return err
+			}
+		}
+		// AddClusterRevision moved the current revision along; put it back.
+		if err := s.NodeRepository.SaveCluster(ctx, &c.Cluster); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ClusterFileStore) SaveCluster(ctx context.Context, cluster *Cluster) error {
+	if err := s.NodeRepository.SaveCluster(ctx, cluster); err != nil {
+		return err
+	}
+	return s.snapshot(ctx)
+}
+
+func (s *ClusterFileStore) DeleteCluster(ctx context.Context, id string) error {
+	if err := s.NodeRepository.DeleteCluster(ctx, id); err != nil {
+		return err
+	}
+	return s.snapshot(ctx)
+}
+
+func (s *ClusterFileStore) AddClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	if err := s.NodeRepository.AddClusterRevision(ctx, rev); err != nil {
+		return err
+	}
+	return s.snapshot(ctx)
+}
+
+func (s *ClusterFileStore) FinishClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	if err := s.NodeRepository.FinishClusterRevision(ctx, rev); err != nil {
+		return err
+	}
+	return s.snapshot(ctx)
+}
+
+// snapshot writes all clusters to the file, replacing it atomically.
+func (s *ClusterFileStore) snapshot(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clusters, err := s.NodeRepository.ListClusters(ctx)
+	if err != nil {
+		return err
+	}
+	payload := clusterFilePayload{Version: 1, Clusters: make([]*clusterFileCluster, 0, len(clusters))}
+	for _, c := range clusters {
+		revs, err := s.NodeRepository.ListClusterRevisions(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		// Newest first from the repository, oldest first in the file.
+		for i, j := 0, len(revs)-1; i < j; i, j = i+1, j-1 {
+			revs[i], revs[j] = revs[j], revs[i]
+		}
+		payload.Clusters = append(payload.Clusters, &clusterFileCluster{Cluster: *c, Revisions: revs})
+	}
+
+	b, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b, 0o600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -2,23 +2,79 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
+// maxStoredJobs bounds the job history of the memory and bolt stores; oldest
+// jobs are dropped first.
+const maxStoredJobs = 10000
+
 type MemoryStore struct {
 	nodes sync.Map // map[string]*Node
+
+	jobsMu sync.RWMutex
+	jobs   map[string]*Job
+	jobIDs []string // insertion order
+
+	usersMu sync.RWMutex
+	users   map[string]*User
+	tokens  map[string]*APIToken // by ID
+
+	enrollMu     sync.Mutex
+	enrollTokens map[string]*EnrollmentToken // by ID
+
+	certsMu sync.RWMutex
+	certs   map[string]*NodeCertificate // by serial
+
+	clustersMu sync.RWMutex
+	clusters   map[string]*Cluster
+	revisions  map[string][]*ClusterRevision // by cluster ID, oldest first
+
+	hubsMu   sync.RWMutex
+	hubs     map[string]*Hub
+	sessions map[string]*NodeSession // by node ID
+	leases   map[string]*ClusterLease
+
+	registriesMu sync.RWMutex
+	registries   map[string]*RegistryCredential
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{}
+	return &MemoryStore{
+		jobs:   make(map[string]*Job),
+		users:  make(map[string]*User),
+		tokens: make(map[string]*APIToken),
+
+		enrollTokens: make(map[string]*EnrollmentToken),
+		certs:        make(map[string]*NodeCertificate),
+
+		clusters:  make(map[string]*Cluster),
+		revisions: make(map[string][]*ClusterRevision),
+
+		hubs:     make(map[string]*Hub),
+		sessions: make(map[string]*NodeSession),
+		leases:   make(map[string]*ClusterLease),
+
+		registries: make(map[string]*RegistryCredential),
+	}
 }
 
 func (s *MemoryStore) Init(ctx context.Context) error { return nil }
 func (s *MemoryStore) Close()                         {}
 
 func (s *MemoryStore) UpsertNode(ctx context.Context, node *Node) error {
-	s.nodes.Store(node.ID, node)
+	n := *node
+	if val, ok := s.nodes.Load(node.ID); ok {
+		prev := val.(*Node)
+		n.Labels, n.Taints = prev.Labels, prev.Taints
+	} else {
+		n.Labels, n.Taints = nil, nil
+	}
+	s.nodes.Store(node.ID, &n)
 	return nil
 }
 
@@ -48,7 +104,543 @@ func (s *MemoryStore) RenameNode(ctx context.Context, id, name string) error {
 	return nil
 }
 
+func (s *MemoryStore) SetNodeLabels(ctx context.Context, id string, labels map[string]string, taints []string) error {
+	if val, ok := s.nodes.Load(id); ok {
+		n := *val.(*Node)
+		n.Labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			n.Labels[k] = v
+		}
+		n.Taints = append([]string(nil), taints...)
+		s.nodes.Store(id, &n)
+	}
+	return nil
+}
+
 func (s *MemoryStore) DeleteNode(ctx context.Context, id string) error {
 	s.nodes.Delete(id)
 	return nil
 }
+
+func (s *MemoryStore) CreateJob(ctx context.Context, job *Job) error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	copied := *job
+	if _, exists := s.jobs[job.ID]; !exists {
+		s.jobIDs = append(s.jobIDs, job.ID)
+	}
+	s.jobs[job.ID] = &copied
+
+	for len(s.jobIDs) > maxStoredJobs {
+		delete(s.jobs, s.jobIDs[0])
+		s.jobIDs = s.jobIDs[1:]
+	}
+	return nil
+}
+
+func (s *MemoryStore) FinishJob(ctx context.Context, job *Job) error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	existing, ok := s.jobs[job.ID]
+	if !ok {
+		return nil
+	}
+	existing.Status = job.Status
+	existing.ExitCode = job.ExitCode
+	existing.Error = job.Error
+	existing.Output = job.Output
+	existing.FinishedAt = job.FinishedAt
+	return nil
+}
+
+func (s *MemoryStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+
+	if job, ok := s.jobs[id]; ok {
+		copied := *job
+		return &copied, nil
+	}
+	return nil, nil // Not found
+}
+
+func (s *MemoryStore) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error) {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+
+	var jobs []*Job
+	for _, job := range s.jobs {
+		if !filter.matches(job) {
+			continue
+		}
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	if filter.Limit > 0 && len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+	return jobs, nil
+}
+
+func (f JobFilter) matches(job *Job) bool {
+	return (f.ParentID == "" || job.ParentID == f.ParentID) &&
+		(f.NodeID == "" || job.NodeID == f.NodeID) &&
+		(f.Type == "" || job.Type == f.Type) &&
+		(f.IssuedBy == "" || job.IssuedBy == f.IssuedBy) &&
+		(f.Status == "" || job.Status == f.Status)
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, user *User) error {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	if _, exists := s.users[user.Username]; exists {
+		return ErrUserExists
+	}
+	copied := *user
+	s.users[user.Username] = &copied
+	return nil
+}
+
+func (s *MemoryStore) UpdateUser(ctx context.Context, user *User) error {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	if existing, ok := s.users[user.Username]; ok {
+		existing.PasswordHash = user.PasswordHash
+		existing.Role = user.Role
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, username string) (*User, error) {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+
+	if user, ok := s.users[username]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return nil, nil // Not found
+}
+
+func (s *MemoryStore) ListUsers(ctx context.Context) ([]*User, error) {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		copied := *user
+		users = append(users, &copied)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, username string) error {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	delete(s.users, username)
+	for id, token := range s.tokens {
+		if token.Username == username {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateToken(ctx context.Context, token *APIToken) error {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	copied := *token
+	s.tokens[token.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStore) GetTokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+
+	for _, token := range s.tokens {
+		if token.TokenHash == hash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, nil // Not found
+}
+
+func (s *MemoryStore) ListTokens(ctx context.Context, username string) ([]*APIToken, error) {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+
+	var tokens []*APIToken
+	for _, token := range s.tokens {
+		if username != "" && token.Username != username {
+			continue
+		}
+		copied := *token
+		tokens = append(tokens, &copied)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (s *MemoryStore) RevokeToken(ctx context.Context, id string) error {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	if token, ok := s.tokens[id]; ok && token.RevokedAt.IsZero() {
+		token.RevokedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredTokens(ctx context.Context, before time.Time) error {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	for id, token := range s.tokens {
+		if !token.ExpiresAt.IsZero() && token.ExpiresAt.Before(before) {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateEnrollmentToken(ctx context.Context, token *EnrollmentToken) error {
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	copied := *token
+	s.enrollTokens[token.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStore) ListEnrollmentTokens(ctx context.Context) ([]*EnrollmentToken, error) {
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	tokens := make([]*EnrollmentToken, 0, len(s.enrollTokens))
+	for _, token := range s.enrollTokens {
+		copied := *token
+		tokens = append(tokens, &copied)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (s *MemoryStore) DeleteEnrollmentToken(ctx context.Context, id string) error {
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	delete(s.enrollTokens, id)
+	return nil
+}
+
+func (s *MemoryStore) ConsumeEnrollmentToken(ctx context.Context, hash, nodeID string, now time.Time) (*EnrollmentToken, error) {
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	for _, token := range s.enrollTokens {
+		if token.TokenHash != hash {
+			continue
+		}
+		if !token.UsedAt.IsZero() || !now.Before(token.ExpiresAt) || (token.NodeID != "" && token.NodeID != nodeID) {
+			return nil, nil
+		}
+		token.UsedAt = now
+		token.UsedBy = nodeID
+		copied := *token
+		return &copied, nil
+	}
+	return nil, nil // Not found
+}
+
+func (s *MemoryStore) SaveCertificate(ctx context.Context, cert *NodeCertificate) error {
+	s.certsMu.Lock()
+	defer s.certsMu.Unlock()
+
+	copied := *cert
+	if existing, ok := s.certs[cert.Serial]; ok && !existing.RevokedAt.IsZero() {
+		copied.RevokedAt = existing.RevokedAt
+	}
+	s.certs[cert.Serial] = &copied
+	return nil
+}
+
+func (s *MemoryStore) ListCertificates(ctx context.Context, nodeID string) ([]*NodeCertificate, error) {
+	s.certsMu.RLock()
+	defer s.certsMu.RUnlock()
+
+	var certs []*NodeCertificate
+	for _, cert := range s.certs {
+		if nodeID != "" && cert.NodeID != nodeID {
+			continue
+		}
+		copied := *cert
+		certs = append(certs, &copied)
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].IssuedAt.After(certs[j].IssuedAt) })
+	return certs, nil
+}
+
+func (s *MemoryStore) RevokeCertificate(ctx context.Context, serial string, at time.Time) error {
+	s.certsMu.Lock()
+	defer s.certsMu.Unlock()
+
+	if cert, ok := s.certs[serial]; ok && cert.RevokedAt.IsZero() {
+		cert.RevokedAt = at
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListClusters(ctx context.Context) ([]*Cluster, error) {
+	s.clustersMu.RLock()
+	defer s.clustersMu.RUnlock()
+
+	clusters := make([]*Cluster, 0, len(s.clusters))
+	for _, c := range s.clusters {
+		clusters = append(clusters, copyCluster(c))
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].CreatedAt.Before(clusters[j].CreatedAt) })
+	return clusters, nil
+}
+
+func (s *MemoryStore) GetCluster(ctx context.Context, id string) (*Cluster, error) {
+	s.clustersMu.RLock()
+	defer s.clustersMu.RUnlock()
+
+	if c, ok := s.clusters[id]; ok {
+		return copyCluster(c), nil
+	}
+	return nil, nil // Not found
+}
+
+func (s *MemoryStore) SaveCluster(ctx context.Context, cluster *Cluster) error {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	s.clusters[cluster.ID] = copyCluster(cluster)
+	return nil
+}
+
+func (s *MemoryStore) DeleteCluster(ctx context.Context, id string) error {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	delete(s.clusters, id)
+	delete(s.revisions, id)
+	return nil
+}
+
+func (s *MemoryStore) AddClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	c, ok := s.clusters[rev.ClusterID]
+	if !ok {
+		return fmt.Errorf("cluster %s not found", rev.ClusterID)
+	}
+	revs := s.revisions[rev.ClusterID]
+	rev.Revision = 1
+	if len(revs) > 0 {
+		rev.Revision = revs[len(revs)-1].Revision + 1
+	}
+	copied := *rev
+	s.revisions[rev.ClusterID] = append(revs, &copied)
+	c.Revision = rev.Revision
+	return nil
+}
+
+func (s *MemoryStore) FinishClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	s.clustersMu.Lock()
+	defer s.clustersMu.Unlock()
+
+	for _, existing := range s.revisions[rev.ClusterID] {
+		if existing.Revision == rev.Revision {
+			existing.JobID = rev.JobID
+			existing.Status = rev.Status
+			existing.Results = append([]RevisionResult(nil), rev.Results...)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetClusterRevision(ctx context.Context, clusterID string, revision int) (*ClusterRevision, error) {
+	s.clustersMu.RLock()
+	defer s.clustersMu.RUnlock()
+
+	for _, rev := range s.revisions[clusterID] {
+		if rev.Revision == revision {
+			copied := *rev
+			return &copied, nil
+		}
+	}
+	return nil, nil // Not found
+}
+
+func (s *MemoryStore) ListClusterRevisions(ctx context.Context, clusterID string) ([]*ClusterRevision, error) {
+	s.clustersMu.RLock()
+	defer s.clustersMu.RUnlock()
+
+	revs := s.revisions[clusterID]
+	out := make([]*ClusterRevision, 0, len(revs))
+	for i := len(revs) - 1; i >= 0; i-- {
+		copied := *revs[i]
+		out = append(out, &copied)
+	}
+	return out, nil
+}
+
+func copyCluster(c *Cluster) *Cluster {
+	copied := *c
+	copied.Nodes = append([]string(nil), c.Nodes...)
+	copied.Sync = make(map[string]NodeSync, len(c.Sync))
+	for k, v := range c.Sync {
+		copied.Sync[k] = v
+	}
+	return &copied
+}
+
+func (s *MemoryStore) UpsertHub(ctx context.Context, hub *Hub) error {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	copied := *hub
+	s.hubs[hub.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStore) ListHubs(ctx context.Context) ([]*Hub, error) {
+	s.hubsMu.RLock()
+	defer s.hubsMu.RUnlock()
+
+	hubs := make([]*Hub, 0, len(s.hubs))
+	for _, hub := range s.hubs {
+		copied := *hub
+		hubs = append(hubs, &copied)
+	}
+	sort.Slice(hubs, func(i, j int) bool { return hubs[i].ID < hubs[j].ID })
+	return hubs, nil
+}
+
+func (s *MemoryStore) SaveNodeSession(ctx context.Context, session *NodeSession) error {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	if prev, ok := s.sessions[session.NodeID]; ok && prev.ConnectedAt.After(session.ConnectedAt) {
+		return nil
+	}
+	copied := *session
+	s.sessions[session.NodeID] = &copied
+	return nil
+}
+
+func (s *MemoryStore) DeleteNodeSession(ctx context.Context, nodeID, sessionID string) error {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	if prev, ok := s.sessions[nodeID]; ok && prev.SessionID == sessionID {
+		delete(s.sessions, nodeID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteHubSessions(ctx context.Context, hubID string) error {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	for nodeID, session := range s.sessions {
+		if session.HubID == hubID {
+			delete(s.sessions, nodeID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListNodeSessions(ctx context.Context) ([]*NodeSession, error) {
+	s.hubsMu.RLock()
+	defer s.hubsMu.RUnlock()
+
+	sessions := make([]*NodeSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		copied := *session
+		sessions = append(sessions, &copied)
+	}
+	return sessions, nil
+}
+
+func (s *MemoryStore) AcquireClusterLease(ctx context.Context, key, holder string, expires time.Time) (bool, error) {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	if prev, ok := s.leases[key]; ok && prev.Holder != holder && prev.ExpiresAt.After(time.Now()) {
+		return false, nil
+	}
+	s.leases[key] = &ClusterLease{Key: key, Holder: holder, ExpiresAt: expires}
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseClusterLease(ctx context.Context, key, holder string) error {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	if prev, ok := s.leases[key]; ok && prev.Holder == holder {
+		delete(s.leases, key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SaveRegistry(ctx context.Context, cred *RegistryCredential) error {
+	s.registriesMu.Lock()
+	defer s.registriesMu.Unlock()
+
+	for _, existing := range s.registries {
+		if existing.ID != cred.ID && existing.Host == cred.Host {
+			return fmt.Errorf("registry %s already exists", cred.Host)
+		}
+	}
+	copied := *cred
+	copied.Secret = append([]byte(nil), cred.Secret...)
+	s.registries[cred.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStore) GetRegistry(ctx context.Context, id string) (*RegistryCredential, error) {
+	s.registriesMu.RLock()
+	defer s.registriesMu.RUnlock()
+
+	cred, ok := s.registries[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *cred
+	return &copied, nil
+}
+
+func (s *MemoryStore) ListRegistries(ctx context.Context) ([]*RegistryCredential, error) {
+	s.registriesMu.RLock()
+	defer s.registriesMu.RUnlock()
+
+	creds := make([]*RegistryCredential, 0, len(s.registries))
+	for _, cred := range s.registries {
+		copied := *cred
+		creds = append(creds, &copied)
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Host < creds[j].Host })
+	return creds, nil
+}
+
+func (s *MemoryStore) DeleteRegistry(ctx context.Context, id string) error {
+	s.registriesMu.Lock()
+	defer s.registriesMu.Unlock()
+
+	delete(s.registries, id)
+	return nil
+}
@@ -0,0 +1,416 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migration is one versioned change of the Postgres schema. Migrations are
+// applied in Version order, each in its own transaction, and recorded in
+// schema_migrations.
+//
+// The first migrations recreate the schema hubs used to set up with CREATE
+// TABLE IF NOT EXISTS, so they are idempotent and adopt existing databases.
+// Later ones must not be edited once released; add a new one instead.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration and when it was applied; AppliedAt is zero
+// while it is pending. Name is empty for versions applied by a newer hub.
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// migrationLockID is the advisory lock taken while migrating, so replicas
+// starting together apply each migration once.
+const migrationLockID = 0x646f636b6c6574 // "docklet"
+
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_nodes",
+		Up: `
+    CREATE TABLE IF NOT EXISTS nodes (
+        id TEXT PRIMARY KEY,
+        name TEXT,
+        machine_id TEXT,
+        version TEXT,
+        remote_addr TEXT,
+        last_seen TIMESTAMP
+    );
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS name TEXT;
+    `,
+		Down: `DROP TABLE IF EXISTS nodes;`,
+	},
+	{
+		Version: 2,
+		Name:    "create_jobs",
+		Up: `
+    CREATE TABLE IF NOT EXISTS jobs (
+        id TEXT PRIMARY KEY,
+        node_id TEXT NOT NULL,
+        type TEXT NOT NULL,
+        args TEXT[],
+        issued_by TEXT,
+        status TEXT NOT NULL,
+        exit_code INTEGER NOT NULL DEFAULT 0,
+        error TEXT,
+        output BYTEA,
+        created_at TIMESTAMP NOT NULL,
+        finished_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS jobs_created_at_idx ON jobs (created_at DESC);
+    CREATE INDEX IF NOT EXISTS jobs_node_id_idx ON jobs (node_id, created_at DESC);
+    `,
+		Down: `DROP TABLE IF EXISTS jobs;`,
+	},
+	{
+		Version: 3,
+		Name:    "node_host_facts",
+		Up: `
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS hostname TEXT;
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS os TEXT;
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS kernel TEXT;
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS arch TEXT;
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS cpu_count INTEGER;
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS memory_bytes BIGINT;
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS docker_version TEXT;
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS storage_driver TEXT;
+    `,
+		Down: `
+    ALTER TABLE nodes DROP COLUMN IF EXISTS hostname;
+    ALTER TABLE nodes DROP COLUMN IF EXISTS os;
+    ALTER TABLE nodes DROP COLUMN IF EXISTS kernel;
+    ALTER TABLE nodes DROP COLUMN IF EXISTS arch;
+    ALTER TABLE nodes DROP COLUMN IF EXISTS cpu_count;
+    ALTER TABLE nodes DROP COLUMN IF EXISTS memory_bytes;
+    ALTER TABLE nodes DROP COLUMN IF EXISTS docker_version;
+    ALTER TABLE nodes DROP COLUMN IF EXISTS storage_driver;
+    `,
+	},
+	{
+		Version: 4,
+		Name:    "create_users",
+		Up: `
+    CREATE TABLE IF NOT EXISTS users (
+        username TEXT PRIMARY KEY,
+        password_hash TEXT NOT NULL,
+        role TEXT NOT NULL,
+        created_at TIMESTAMP NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS api_tokens (
+        id TEXT PRIMARY KEY,
+        username TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+        name TEXT,
+        token_hash TEXT NOT NULL UNIQUE,
+        created_at TIMESTAMP NOT NULL,
+        expires_at TIMESTAMP,
+        revoked_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS api_tokens_username_idx ON api_tokens (username);
+    `,
+		Down: `
+    DROP TABLE IF EXISTS api_tokens;
+    DROP TABLE IF EXISTS users;
+    `,
+	},
+	{
+		Version: 5,
+		Name:    "job_parent_id",
+		Up: `
+    ALTER TABLE jobs ADD COLUMN IF NOT EXISTS parent_id TEXT;
+    CREATE INDEX IF NOT EXISTS jobs_parent_id_idx ON jobs (parent_id);
+    `,
+		Down: `
+    DROP INDEX IF EXISTS jobs_parent_id_idx;
+    ALTER TABLE jobs DROP COLUMN IF EXISTS parent_id;
+    `,
+	},
+	{
+		Version: 6,
+		Name:    "create_enrollment_tokens",
+		Up: `
+    CREATE TABLE IF NOT EXISTS enrollment_tokens (
+        id TEXT PRIMARY KEY,
+        token_hash TEXT NOT NULL UNIQUE,
+        node_id TEXT,
+        created_by TEXT,
+        created_at TIMESTAMP NOT NULL,
+        expires_at TIMESTAMP NOT NULL,
+        used_at TIMESTAMP,
+        used_by TEXT
+    );
+    `,
+		Down: `DROP TABLE IF EXISTS enrollment_tokens;`,
+	},
+	{
+		Version: 7,
+		Name:    "create_node_certificates",
+		Up: `
+    CREATE TABLE IF NOT EXISTS node_certificates (
+        serial TEXT PRIMARY KEY,
+        node_id TEXT NOT NULL,
+        issued_at TIMESTAMP,
+        expires_at TIMESTAMP,
+        revoked_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS node_certificates_node_id_idx ON node_certificates (node_id);
+    `,
+		Down: `DROP TABLE IF EXISTS node_certificates;`,
+	},
+	{
+		Version: 8,
+		Name:    "node_labels",
+		Up: `
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS labels JSONB;
+    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS taints TEXT[];
+    `,
+		Down: `
+    ALTER TABLE nodes DROP COLUMN IF EXISTS labels;
+    ALTER TABLE nodes DROP COLUMN IF EXISTS taints;
+    `,
+	},
+	{
+		Version: 9,
+		Name:    "create_clusters",
+		Up: `
+    CREATE TABLE IF NOT EXISTS clusters (
+        id TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        stack_name TEXT NOT NULL,
+        content TEXT NOT NULL,
+        nodes TEXT[],
+        selector TEXT,
+        revision INTEGER NOT NULL DEFAULT 0,
+        sync JSONB,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS cluster_revisions (
+        cluster_id TEXT NOT NULL REFERENCES clusters (id) ON DELETE CASCADE,
+        revision INTEGER NOT NULL,
+        content TEXT NOT NULL,
+        deployed_by TEXT,
+        rollback_of INTEGER,
+        created_at TIMESTAMP NOT NULL,
+        job_id TEXT,
+        status TEXT,
+        results JSONB,
+        PRIMARY KEY (cluster_id, revision)
+    );
+    `,
+		Down: `
+    DROP TABLE IF EXISTS cluster_revisions;
+    DROP TABLE IF EXISTS clusters;
+    `,
+	},
+	{
+		Version: 10,
+		Name:    "create_hub_replicas",
+		Up: `
+    CREATE TABLE IF NOT EXISTS hub_replicas (
+        id TEXT PRIMARY KEY,
+        addr TEXT NOT NULL,
+        started_at TIMESTAMP NOT NULL,
+        last_seen TIMESTAMP NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS node_sessions (
+        node_id TEXT PRIMARY KEY,
+        session_id TEXT NOT NULL,
+        hub_id TEXT NOT NULL,
+        connected_at TIMESTAMP NOT NULL
+    );
+    `,
+		Down: `
+    DROP TABLE IF EXISTS node_sessions;
+    DROP TABLE IF EXISTS hub_replicas;
+    `,
+	},
+	{
+		Version: 11,
+		Name:    "create_registries",
+		Up: `
+    CREATE TABLE IF NOT EXISTS registries (
+        id TEXT PRIMARY KEY,
+        host TEXT NOT NULL UNIQUE,
+        username TEXT NOT NULL,
+        secret BYTEA NOT NULL,
+        created_by TEXT,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL
+    );
+    `,
+		Down: `
+    DROP TABLE IF EXISTS registries;
+    `,
+	},
+	{
+		Version: 12,
+		Name:    "create_cluster_leases",
+		Up: `
+    CREATE TABLE IF NOT EXISTS cluster_leases (
+        key TEXT PRIMARY KEY,
+        holder TEXT NOT NULL,
+        expires_at TIMESTAMP NOT NULL
+    );
+    `,
+		Down: `
+    DROP TABLE IF EXISTS cluster_leases;
+    `,
+	},
+}
+
+// withMigrationLock runs fn on a connection holding the migration lock, with
+// schema_migrations in place.
+func (s *PostgresStore) withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	query := `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at TIMESTAMP NOT NULL
+    );
+    `
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int]MigrationState, error) {
+	rows, err := conn.Query(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationState)
+	for rows.Next() {
+		var m MigrationState
+		if err := rows.Scan(&m.Version, &m.Name, &m.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[m.Version] = m
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus returns every known migration and every applied one,
+// oldest first.
+func (s *PostgresStore) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	var states []MigrationState
+	err := s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range postgresMigrations {
+			state := MigrationState{Version: m.Version, Name: m.Name}
+			if a, ok := applied[m.Version]; ok {
+				state.AppliedAt = a.AppliedAt
+				delete(applied, m.Version)
+			}
+			states = append(states, state)
+		}
+		for _, a := range applied {
+			a.Name = ""
+			states = append(states, a)
+		}
+		return nil
+	})
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, err
+}
+
+// MigrateUp applies the pending migrations up to version target, or all of
+// them when target is 0, and returns those it applied.
+func (s *PostgresStore) MigrateUp(ctx context.Context, target int) ([]Migration, error) {
+	var done []Migration
+	err := s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range postgresMigrations {
+			if target > 0 && m.Version > target {
+				break
+			}
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`, m.Version, m.Name, time.Now()); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrateDown reverts the applied migrations above version target, newest
+// first, and returns those it reverted.
+func (s *PostgresStore) MigrateDown(ctx context.Context, target int) ([]Migration, error) {
+	var done []Migration
+	err := s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(postgresMigrations) - 1; i >= 0; i-- {
+			m := postgresMigrations[i]
+			if m.Version <= target {
+				break
+			}
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m.Down, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+				return fmt.Errorf("revert migration %d (%s): %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// runMigration runs sql and records it with record in one transaction.
+func runMigration(ctx context.Context, conn *pgxpool.Conn, sql, record string, args ...interface{}) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -35,39 +37,44 @@ func (s *PostgresStore) Close() {
 	s.db.Close()
 }
 
+// Init brings the schema up to date; see migrations.go.
 func (s *PostgresStore) Init(ctx context.Context) error {
-	query := `
-    CREATE TABLE IF NOT EXISTS nodes (
-        id TEXT PRIMARY KEY,
-        name TEXT,
-        machine_id TEXT,
-        version TEXT,
-        remote_addr TEXT,
-        last_seen TIMESTAMP
-    );
-    ALTER TABLE nodes ADD COLUMN IF NOT EXISTS name TEXT;
-    `
-	_, err := s.db.Exec(ctx, query)
+	_, err := s.MigrateUp(ctx, 0)
 	return err
 }
 
 func (s *PostgresStore) UpsertNode(ctx context.Context, node *Node) error {
 	query := `
-    INSERT INTO nodes (id, name, machine_id, version, remote_addr, last_seen)
-    VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+    INSERT INTO nodes (id, name, machine_id, version, remote_addr, last_seen,
+        hostname, os, kernel, arch, cpu_count, memory_bytes, docker_version, storage_driver)
+    VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
     ON CONFLICT (id) DO UPDATE SET
         name = COALESCE(NULLIF(EXCLUDED.name, ''), nodes.name),
         machine_id = EXCLUDED.machine_id,
         version = EXCLUDED.version,
         remote_addr = EXCLUDED.remote_addr,
-        last_seen = EXCLUDED.last_seen;
+        last_seen = EXCLUDED.last_seen,
+        hostname = EXCLUDED.hostname,
+        os = EXCLUDED.os,
+        kernel = EXCLUDED.kernel,
+        arch = EXCLUDED.arch,
+        cpu_count = EXCLUDED.cpu_count,
+        memory_bytes = EXCLUDED.memory_bytes,
+        docker_version = EXCLUDED.docker_version,
+        storage_driver = EXCLUDED.storage_driver;
     `
-	_, err := s.db.Exec(ctx, query, node.ID, node.Name, node.MachineID, node.Version, node.RemoteAddr, node.LastSeen)
+	_, err := s.db.Exec(ctx, query, node.ID, node.Name, node.MachineID, node.Version, node.RemoteAddr, node.LastSeen,
+		node.Hostname, node.OS, node.Kernel, node.Arch, node.CPUCount, node.MemoryBytes, node.DockerVersion, node.StorageDriver)
 	return err
 }
 
+const nodeColumns = `id, COALESCE(name, ''), machine_id, version, remote_addr, last_seen,
+    COALESCE(hostname, ''), COALESCE(os, ''), COALESCE(kernel, ''), COALESCE(arch, ''),
+    COALESCE(cpu_count, 0), COALESCE(memory_bytes, 0), COALESCE(docker_version, ''), COALESCE(storage_driver, ''),
+    COALESCE(labels, '{}'::jsonb), COALESCE(taints, '{}')`
+
 func (s *PostgresStore) ListNodes(ctx context.Context) ([]*Node, error) {
-	query := `SELECT id, COALESCE(name, ''), machine_id, version, remote_addr, last_seen FROM nodes`
+	query := `SELECT ` + nodeColumns + ` FROM nodes`
 	rows, err := s.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
@@ -76,26 +83,35 @@ func (s *PostgresStore) ListNodes(ctx context.Context) ([]*Node, error) {
 
 	var nodes []*Node
 	for rows.Next() {
-		var n Node
-		err := rows.Scan(&n.ID, &n.Name, &n.MachineID, &n.Version, &n.RemoteAddr, &n.LastSeen)
+		n, err := scanNode(rows)
 		if err != nil {
 			return nil, err
 		}
-		nodes = append(nodes, &n)
+		nodes = append(nodes, n)
 	}
 	return nodes, rows.Err()
 }
 
 func (s *PostgresStore) GetNode(ctx context.Context, id string) (*Node, error) {
-	query := `SELECT id, COALESCE(name, ''), machine_id, version, remote_addr, last_seen FROM nodes WHERE id = $1`
-	var n Node
-	err := s.db.QueryRow(ctx, query, id).Scan(&n.ID, &n.Name, &n.MachineID, &n.Version, &n.RemoteAddr, &n.LastSeen)
+	query := `SELECT ` + nodeColumns + ` FROM nodes WHERE id = $1`
+	n, err := scanNode(s.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
+	return n, nil
+}
+
+func scanNode(row pgx.Row) (*Node, error) {
+	var n Node
+	err := row.Scan(&n.ID, &n.Name, &n.MachineID, &n.Version, &n.RemoteAddr, &n.LastSeen,
+		&n.Hostname, &n.OS, &n.Kernel, &n.Arch, &n.CPUCount, &n.MemoryBytes, &n.DockerVersion, &n.StorageDriver,
+		&n.Labels, &n.Taints)
+	if err != nil {
+		return nil, err
+	}
 	return &n, nil
 }
 
@@ -105,7 +121,676 @@ func (s *PostgresStore) RenameNode(ctx context.Context, id, name string) error {
 	return err
 }
 
+func (s *PostgresStore) SetNodeLabels(ctx context.Context, id string, labels map[string]string, taints []string) error {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if taints == nil {
+		taints = []string{}
+	}
+	_, err := s.db.Exec(ctx, `UPDATE nodes SET labels = $2, taints = $3 WHERE id = $1`, id, labels, taints)
+	return err
+}
+
 func (s *PostgresStore) DeleteNode(ctx context.Context, id string) error {
 	_, err := s.db.Exec(ctx, `DELETE FROM nodes WHERE id = $1`, id)
 	return err
 }
+
+func (s *PostgresStore) CreateJob(ctx context.Context, job *Job) error {
+	query := `
+    INSERT INTO jobs (id, parent_id, node_id, type, args, issued_by, status, exit_code, error, output, created_at)
+    VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    `
+	_, err := s.db.Exec(ctx, query, job.ID, job.ParentID, job.NodeID, job.Type, job.Args, job.IssuedBy, job.Status, job.ExitCode, job.Error, job.Output, job.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) FinishJob(ctx context.Context, job *Job) error {
+	query := `UPDATE jobs SET status = $2, exit_code = $3, error = $4, output = $5, finished_at = $6 WHERE id = $1`
+	_, err := s.db.Exec(ctx, query, job.ID, job.Status, job.ExitCode, job.Error, job.Output, job.FinishedAt)
+	return err
+}
+
+const jobColumns = `id, COALESCE(parent_id, ''), node_id, type, COALESCE(args, '{}'), COALESCE(issued_by, ''), status, exit_code, COALESCE(error, ''), output, created_at, finished_at`
+
+func (s *PostgresStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	row := s.db.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id)
+	job, err := scanJob(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *PostgresStore) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error) {
+	var where []string
+	var args []interface{}
+	add := func(column, value string) {
+		if value == "" {
+			return
+		}
+		args = append(args, value)
+		where = append(where, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	add("parent_id", filter.ParentID)
+	add("node_id", filter.NodeID)
+	add("type", filter.Type)
+	add("issued_by", filter.IssuedBy)
+	add("status", filter.Status)
+
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY created_at DESC`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var job Job
+	var finishedAt *time.Time
+	err := row.Scan(&job.ID, &job.ParentID, &job.NodeID, &job.Type, &job.Args, &job.IssuedBy, &job.Status, &job.ExitCode, &job.Error, &job.Output, &job.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt != nil {
+		job.FinishedAt = *finishedAt
+	}
+	return &job, nil
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, user *User) error {
+	query := `
+    INSERT INTO users (username, password_hash, role, created_at)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (username) DO NOTHING
+    `
+	tag, err := s.db.Exec(ctx, query, user.Username, user.PasswordHash, user.Role, user.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserExists
+	}
+	return nil
+}
+
+func (s *PostgresStore) UpdateUser(ctx context.Context, user *User) error {
+	query := `UPDATE users SET password_hash = $2, role = $3 WHERE username = $1`
+	_, err := s.db.Exec(ctx, query, user.Username, user.PasswordHash, user.Role)
+	return err
+}
+
+func (s *PostgresStore) GetUser(ctx context.Context, username string) (*User, error) {
+	query := `SELECT username, password_hash, role, created_at FROM users WHERE username = $1`
+	var u User
+	err := s.db.QueryRow(ctx, query, username).Scan(&u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *PostgresStore) ListUsers(ctx context.Context) ([]*User, error) {
+	query := `SELECT username, password_hash, role, created_at FROM users ORDER BY username`
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+	return users, rows.Err()
+}
+
+func (s *PostgresStore) DeleteUser(ctx context.Context, username string) error {
+	// Tokens go with ON DELETE CASCADE.
+	_, err := s.db.Exec(ctx, `DELETE FROM users WHERE username = $1`, username)
+	return err
+}
+
+func (s *PostgresStore) CreateToken(ctx context.Context, token *APIToken) error {
+	query := `
+    INSERT INTO api_tokens (id, username, name, token_hash, created_at, expires_at)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    `
+	_, err := s.db.Exec(ctx, query, token.ID, token.Username, token.Name, token.TokenHash, token.CreatedAt, nullTime(token.ExpiresAt))
+	return err
+}
+
+const tokenColumns = `id, username, COALESCE(name, ''), token_hash, created_at, expires_at, revoked_at`
+
+func (s *PostgresStore) GetTokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	query := `SELECT ` + tokenColumns + ` FROM api_tokens WHERE token_hash = $1`
+	token, err := scanToken(s.db.QueryRow(ctx, query, hash))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *PostgresStore) ListTokens(ctx context.Context, username string) ([]*APIToken, error) {
+	query := `SELECT ` + tokenColumns + ` FROM api_tokens WHERE ($1 = '' OR username = $1) ORDER BY created_at DESC`
+	rows, err := s.db.Query(ctx, query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *PostgresStore) RevokeToken(ctx context.Context, id string) error {
+	query := `UPDATE api_tokens SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`
+	_, err := s.db.Exec(ctx, query, id, time.Now())
+	return err
+}
+
+func (s *PostgresStore) DeleteExpiredTokens(ctx context.Context, before time.Time) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM api_tokens WHERE expires_at < $1`, before)
+	return err
+}
+
+func scanToken(row pgx.Row) (*APIToken, error) {
+	var token APIToken
+	var expiresAt, revokedAt *time.Time
+	err := row.Scan(&token.ID, &token.Username, &token.Name, &token.TokenHash, &token.CreatedAt, &expiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt != nil {
+		token.ExpiresAt = *expiresAt
+	}
+	if revokedAt != nil {
+		token.RevokedAt = *revokedAt
+	}
+	return &token, nil
+}
+
+func (s *PostgresStore) CreateEnrollmentToken(ctx context.Context, token *EnrollmentToken) error {
+	query := `
+    INSERT INTO enrollment_tokens (id, token_hash, node_id, created_by, created_at, expires_at)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    `
+	_, err := s.db.Exec(ctx, query, token.ID, token.TokenHash, token.NodeID, token.CreatedBy, token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+const enrollmentTokenColumns = `id, token_hash, COALESCE(node_id, ''), COALESCE(created_by, ''), created_at, expires_at, used_at, COALESCE(used_by, '')`
+
+func (s *PostgresStore) ListEnrollmentTokens(ctx context.Context) ([]*EnrollmentToken, error) {
+	query := `SELECT ` + enrollmentTokenColumns + ` FROM enrollment_tokens ORDER BY created_at DESC`
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*EnrollmentToken
+	for rows.Next() {
+		token, err := scanEnrollmentToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *PostgresStore) DeleteEnrollmentToken(ctx context.Context, id string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM enrollment_tokens WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) ConsumeEnrollmentToken(ctx context.Context, hash, nodeID string, now time.Time) (*EnrollmentToken, error) {
+	query := `
+    UPDATE enrollment_tokens SET used_at = $3, used_by = $2
+    WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $3
+      AND (COALESCE(node_id, '') = '' OR node_id = $2)
+    RETURNING ` + enrollmentTokenColumns
+	token, err := scanEnrollmentToken(s.db.QueryRow(ctx, query, hash, nodeID, now))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+func scanEnrollmentToken(row pgx.Row) (*EnrollmentToken, error) {
+	var token EnrollmentToken
+	var usedAt *time.Time
+	err := row.Scan(&token.ID, &token.TokenHash, &token.NodeID, &token.CreatedBy, &token.CreatedAt, &token.ExpiresAt, &usedAt, &token.UsedBy)
+	if err != nil {
+		return nil, err
+	}
+	if usedAt != nil {
+		token.UsedAt = *usedAt
+	}
+	return &token, nil
+}
+
+func (s *PostgresStore) SaveCertificate(ctx context.Context, cert *NodeCertificate) error {
+	query := `
+    INSERT INTO node_certificates (serial, node_id, issued_at, expires_at, revoked_at)
+    VALUES ($1, $2, $3, $4, $5)
+    ON CONFLICT (serial) DO UPDATE SET
+        node_id = EXCLUDED.node_id,
+        issued_at = EXCLUDED.issued_at,
+        expires_at = EXCLUDED.expires_at,
+        revoked_at = COALESCE(node_certificates.revoked_at, EXCLUDED.revoked_at)
+    `
+	_, err := s.db.Exec(ctx, query, cert.Serial, cert.NodeID, nullTime(cert.IssuedAt), nullTime(cert.ExpiresAt), nullTime(cert.RevokedAt))
+	return err
+}
+
+func (s *PostgresStore) ListCertificates(ctx context.Context, nodeID string) ([]*NodeCertificate, error) {
+	query := `
+    SELECT serial, node_id, issued_at, expires_at, revoked_at FROM node_certificates
+    WHERE ($1 = '' OR node_id = $1)
+    ORDER BY issued_at DESC NULLS LAST
+    `
+	rows, err := s.db.Query(ctx, query, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certs []*NodeCertificate
+	for rows.Next() {
+		var cert NodeCertificate
+		var issuedAt, expiresAt, revokedAt *time.Time
+		if err := rows.Scan(&cert.Serial, &cert.NodeID, &issuedAt, &expiresAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if issuedAt != nil {
+			cert.IssuedAt = *issuedAt
+		}
+		if expiresAt != nil {
+			cert.ExpiresAt = *expiresAt
+		}
+		if revokedAt != nil {
+			cert.RevokedAt = *revokedAt
+		}
+		certs = append(certs, &cert)
+	}
+	return certs, rows.Err()
+}
+
+func (s *PostgresStore) RevokeCertificate(ctx context.Context, serial string, at time.Time) error {
+	query := `UPDATE node_certificates SET revoked_at = $2 WHERE serial = $1 AND revoked_at IS NULL`
+	_, err := s.db.Exec(ctx, query, serial, at)
+	return err
+}
+
+const clusterColumns = `id, name, stack_name, content, COALESCE(nodes, '{}'), COALESCE(selector, ''), revision,
+    COALESCE(sync, '{}'::jsonb), created_at, updated_at`
+
+func (s *PostgresStore) ListClusters(ctx context.Context) ([]*Cluster, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+clusterColumns+` FROM clusters ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clusters []*Cluster
+	for rows.Next() {
+		c, err := scanCluster(rows)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, rows.Err()
+}
+
+func (s *PostgresStore) GetCluster(ctx context.Context, id string) (*Cluster, error) {
+	c, err := scanCluster(s.db.QueryRow(ctx, `SELECT `+clusterColumns+` FROM clusters WHERE id = $1`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func scanCluster(row pgx.Row) (*Cluster, error) {
+	var c Cluster
+	err := row.Scan(&c.ID, &c.Name, &c.StackName, &c.Content, &c.Nodes, &c.Selector, &c.Revision,
+		&c.Sync, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *PostgresStore) SaveCluster(ctx context.Context, cluster *Cluster) error {
+	query := `
+    INSERT INTO clusters (id, name, stack_name, content, nodes, selector, revision, sync, created_at, updated_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+    ON CONFLICT (id) DO UPDATE SET
+        name = EXCLUDED.name,
+        stack_name = EXCLUDED.stack_name,
+        content = EXCLUDED.content,
+        nodes = EXCLUDED.nodes,
+        selector = EXCLUDED.selector,
+        revision = EXCLUDED.revision,
+        sync = EXCLUDED.sync,
+        updated_at = EXCLUDED.updated_at
+    `
+	nodes := cluster.Nodes
+	if nodes == nil {
+		nodes = []string{}
+	}
+	sync := cluster.Sync
+	if sync == nil {
+		sync = map[string]NodeSync{}
+	}
+	_, err := s.db.Exec(ctx, query, cluster.ID, cluster.Name, cluster.StackName, cluster.Content, nodes, cluster.Selector,
+		cluster.Revision, sync, cluster.CreatedAt, cluster.UpdatedAt)
+	return err
+}
+
+func (s *PostgresStore) DeleteCluster(ctx context.Context, id string) error {
+	// Revisions go with ON DELETE CASCADE.
+	_, err := s.db.Exec(ctx, `DELETE FROM clusters WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) AddClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the cluster row so concurrent deploys get distinct numbers.
+	var current int
+	err = tx.QueryRow(ctx, `SELECT revision FROM clusters WHERE id = $1 FOR UPDATE`, rev.ClusterID).Scan(&current)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("cluster %s not found", rev.ClusterID)
+		}
+		return err
+	}
+	var next int
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(revision), 0) + 1 FROM cluster_revisions WHERE cluster_id = $1`, rev.ClusterID).Scan(&next); err != nil {
+		return err
+	}
+
+	query := `
+    INSERT INTO cluster_revisions (cluster_id, revision, content, deployed_by, rollback_of, created_at)
+    VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6)
+    `
+	if _, err := tx.Exec(ctx, query, rev.ClusterID, next, rev.Content, rev.DeployedBy, rev.RollbackOf, rev.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE clusters SET revision = $2 WHERE id = $1`, rev.ClusterID, next); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	rev.Revision = next
+	return nil
+}
+
+func (s *PostgresStore) FinishClusterRevision(ctx context.Context, rev *ClusterRevision) error {
+	results := rev.Results
+	if results == nil {
+		results = []RevisionResult{}
+	}
+	query := `UPDATE cluster_revisions SET job_id = $3, status = $4, results = $5 WHERE cluster_id = $1 AND revision = $2`
+	_, err := s.db.Exec(ctx, query, rev.ClusterID, rev.Revision, rev.JobID, rev.Status, results)
+	return err
+}
+
+const clusterRevisionColumns = `cluster_id, revision, content, COALESCE(deployed_by, ''), COALESCE(rollback_of, 0), created_at,
+    COALESCE(job_id, ''), COALESCE(status, ''), COALESCE(results, '[]'::jsonb)`
+
+func (s *PostgresStore) GetClusterRevision(ctx context.Context, clusterID string, revision int) (*ClusterRevision, error) {
+	query := `SELECT ` + clusterRevisionColumns + ` FROM cluster_revisions WHERE cluster_id = $1 AND revision = $2`
+	rev, err := scanClusterRevision(s.db.QueryRow(ctx, query, clusterID, revision))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return rev, nil
+}
+
+func (s *PostgresStore) ListClusterRevisions(ctx context.Context, clusterID string) ([]*ClusterRevision, error) {
+	query := `SELECT ` + clusterRevisionColumns + ` FROM cluster_revisions WHERE cluster_id = $1 ORDER BY revision DESC`
+	rows, err := s.db.Query(ctx, query, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revs []*ClusterRevision
+	for rows.Next() {
+		rev, err := scanClusterRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, rows.Err()
+}
+
+func scanClusterRevision(row pgx.Row) (*ClusterRevision, error) {
+	var rev ClusterRevision
+	err := row.Scan(&rev.ClusterID, &rev.Revision, &rev.Content, &rev.DeployedBy, &rev.RollbackOf, &rev.CreatedAt,
+		&rev.JobID, &rev.Status, &rev.Results)
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// nullTime maps the zero time to SQL NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (s *PostgresStore) UpsertHub(ctx context.Context, hub *Hub) error {
+	query := `
+    INSERT INTO hub_replicas (id, addr, started_at, last_seen)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (id) DO UPDATE SET
+        addr = EXCLUDED.addr,
+        started_at = EXCLUDED.started_at,
+        last_seen = EXCLUDED.last_seen;
+    `
+	_, err := s.db.Exec(ctx, query, hub.ID, hub.Addr, hub.StartedAt, hub.LastSeen)
+	return err
+}
+
+func (s *PostgresStore) ListHubs(ctx context.Context) ([]*Hub, error) {
+	rows, err := s.db.Query(ctx, `SELECT id, addr, started_at, last_seen FROM hub_replicas ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hubs []*Hub
+	for rows.Next() {
+		var hub Hub
+		if err := rows.Scan(&hub.ID, &hub.Addr, &hub.StartedAt, &hub.LastSeen); err != nil {
+			return nil, err
+		}
+		hubs = append(hubs, &hub)
+	}
+	return hubs, rows.Err()
+}
+
+func (s *PostgresStore) SaveNodeSession(ctx context.Context, session *NodeSession) error {
+	query := `
+    INSERT INTO node_sessions (node_id, session_id, hub_id, connected_at)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (node_id) DO UPDATE SET
+        session_id = EXCLUDED.session_id,
+        hub_id = EXCLUDED.hub_id,
+        connected_at = EXCLUDED.connected_at
+    WHERE node_sessions.connected_at <= EXCLUDED.connected_at;
+    `
+	_, err := s.db.Exec(ctx, query, session.NodeID, session.SessionID, session.HubID, session.ConnectedAt)
+	return err
+}
+
+func (s *PostgresStore) DeleteNodeSession(ctx context.Context, nodeID, sessionID string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM node_sessions WHERE node_id = $1 AND session_id = $2`, nodeID, sessionID)
+	return err
+}
+
+func (s *PostgresStore) DeleteHubSessions(ctx context.Context, hubID string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM node_sessions WHERE hub_id = $1`, hubID)
+	return err
+}
+
+func (s *PostgresStore) ListNodeSessions(ctx context.Context) ([]*NodeSession, error) {
+	rows, err := s.db.Query(ctx, `SELECT node_id, session_id, hub_id, connected_at FROM node_sessions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*NodeSession
+	for rows.Next() {
+		var session NodeSession
+		if err := rows.Scan(&session.NodeID, &session.SessionID, &session.HubID, &session.ConnectedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PostgresStore) AcquireClusterLease(ctx context.Context, key, holder string, expires time.Time) (bool, error) {
+	query := `
+    INSERT INTO cluster_leases (key, holder, expires_at)
+    VALUES ($1, $2, $3)
+    ON CONFLICT (key) DO UPDATE SET
+        holder = EXCLUDED.holder,
+        expires_at = EXCLUDED.expires_at
+    WHERE cluster_leases.holder = EXCLUDED.holder OR cluster_leases.expires_at < $4;
+    `
+	tag, err := s.db.Exec(ctx, query, key, holder, expires, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresStore) ReleaseClusterLease(ctx context.Context, key, holder string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM cluster_leases WHERE key = $1 AND holder = $2`, key, holder)
+	return err
+}
+
+const registryColumns = `id, host, username, secret, COALESCE(created_by, ''), created_at, updated_at`
+
+func scanRegistry(row pgx.Row) (*RegistryCredential, error) {
+	var cred RegistryCredential
+	err := row.Scan(&cred.ID, &cred.Host, &cred.Username, &cred.Secret, &cred.CreatedBy, &cred.CreatedAt, &cred.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+func (s *PostgresStore) SaveRegistry(ctx context.Context, cred *RegistryCredential) error {
+	query := `
+    INSERT INTO registries (id, host, username, secret, created_by, created_at, updated_at)
+    VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+    ON CONFLICT (id) DO UPDATE SET
+        host = EXCLUDED.host,
+        username = EXCLUDED.username,
+        secret = EXCLUDED.secret,
+        updated_at = EXCLUDED.updated_at
+    `
+	_, err := s.db.Exec(ctx, query, cred.ID, cred.Host, cred.Username, cred.Secret, cred.CreatedBy, cred.CreatedAt, cred.UpdatedAt)
+	return err
+}
+
+func (s *PostgresStore) GetRegistry(ctx context.Context, id string) (*RegistryCredential, error) {
+	cred, err := scanRegistry(s.db.QueryRow(ctx, `SELECT `+registryColumns+` FROM registries WHERE id = $1`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cred, nil
+}
+
+func (s *PostgresStore) ListRegistries(ctx context.Context) ([]*RegistryCredential, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+registryColumns+` FROM registries ORDER BY host`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []*RegistryCredential{}
+	for rows.Next() {
+		cred, err := scanRegistry(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	return creds, rows.Err()
+}
+
+func (s *PostgresStore) DeleteRegistry(ctx context.Context, id string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM registries WHERE id = $1`, id)
+	return err
+}
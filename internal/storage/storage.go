@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
@@ -12,6 +13,22 @@ type Node struct {
 	Version    string
 	RemoteAddr string
 	LastSeen   time.Time
+
+	// Host facts reported in the agent handshake.
+	Hostname      string
+	OS            string
+	Kernel        string
+	Arch          string
+	CPUCount      int
+	MemoryBytes   int64
+	DockerVersion string
+	StorageDriver string
+
+	// Labels select nodes for deploys and jobs. A node with taints is only
+	// selected when the selector names every taint key. UpsertNode leaves
+	// both untouched; they are changed with SetNodeLabels.
+	Labels map[string]string
+	Taints []string // "key" or "key=value"
 }
 
 type NodeRepository interface {
@@ -20,6 +37,297 @@ type NodeRepository interface {
 	ListNodes(ctx context.Context) ([]*Node, error)
 	GetNode(ctx context.Context, id string) (*Node, error)
 	RenameNode(ctx context.Context, id, name string) error
+	// SetNodeLabels replaces labels and taints of node id.
+	SetNodeLabels(ctx context.Context, id string, labels map[string]string, taints []string) error
 	DeleteNode(ctx context.Context, id string) error
 	Close()
+
+	JobRepository
+	UserRepository
+	EnrollmentRepository
+	CertificateRepository
+	ClusterRepository
+	HubRepository
+	RegistryRepository
+}
+
+// Job statuses
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// Job is the audit record of a single command sent to an agent, or of a
+// hub-side job fanning a command out to several nodes. The per-node commands
+// of such a job reference it through ParentID.
+type Job struct {
+	ID         string
+	ParentID   string
+	NodeID     string
+	Type       string
+	Args       []string
+	IssuedBy   string
+	Status     string
+	ExitCode   int32
+	Error      string
+	Output     []byte // truncated
+	CreatedAt  time.Time
+	FinishedAt time.Time // zero while running
+}
+
+// JobFilter narrows ListJobs. Empty fields match everything.
+type JobFilter struct {
+	ParentID string
+	NodeID   string
+	Type     string
+	IssuedBy string
+	Status   string
+	Limit    int
+}
+
+// JobRepository persists the command history, newest first.
+type JobRepository interface {
+	CreateJob(ctx context.Context, job *Job) error
+	// FinishJob stores the outcome (status, exit code, error, output, finish time) of job.ID.
+	FinishJob(ctx context.Context, job *Job) error
+	GetJob(ctx context.Context, id string) (*Job, error)
+	ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error)
+}
+
+// User roles, from least to most privileged.
+const (
+	RoleViewer   = "viewer"   // read-only: list, inspect, view logs
+	RoleOperator = "operator" // viewer + exec, start/stop/rm, deploy
+	RoleAdmin    = "admin"    // operator + user management
+)
+
+// User is a dashboard/API account.
+type User struct {
+	Username     string
+	PasswordHash string
+	Role         string
+	CreatedAt    time.Time
+}
+
+// APIToken is a bearer token of a user. Only the SHA-256 of the token is stored.
+// Login sessions are tokens too.
+type APIToken struct {
+	ID        string
+	Username  string
+	Name      string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt time.Time // zero while valid
+}
+
+// ErrUserExists is returned by CreateUser for a taken username.
+var ErrUserExists = errors.New("user already exists")
+
+// UserRepository persists users and their API tokens.
+type UserRepository interface {
+	CreateUser(ctx context.Context, user *User) error
+	// UpdateUser stores PasswordHash and Role of user.Username.
+	UpdateUser(ctx context.Context, user *User) error
+	GetUser(ctx context.Context, username string) (*User, error)
+	ListUsers(ctx context.Context) ([]*User, error)
+	// DeleteUser removes the user and all of its tokens.
+	DeleteUser(ctx context.Context, username string) error
+
+	CreateToken(ctx context.Context, token *APIToken) error
+	GetTokenByHash(ctx context.Context, hash string) (*APIToken, error)
+	// ListTokens returns tokens of username, or of all users when username is empty.
+	ListTokens(ctx context.Context, username string) ([]*APIToken, error)
+	RevokeToken(ctx context.Context, id string) error
+	// DeleteExpiredTokens removes tokens that expired before the given time.
+	DeleteExpiredTokens(ctx context.Context, before time.Time) error
+}
+
+// EnrollmentToken lets one agent obtain a certificate from the hub CA.
+// Only the SHA-256 of the token is stored.
+type EnrollmentToken struct {
+	ID        string
+	TokenHash string
+	NodeID    string // if set, only this node may enroll with the token
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    time.Time // zero until used
+	UsedBy    string    // node that enrolled with the token
+}
+
+// EnrollmentRepository persists single-use agent enrollment tokens.
+type EnrollmentRepository interface {
+	CreateEnrollmentToken(ctx context.Context, token *EnrollmentToken) error
+	ListEnrollmentTokens(ctx context.Context) ([]*EnrollmentToken, error)
+	DeleteEnrollmentToken(ctx context.Context, id string) error
+	// ConsumeEnrollmentToken atomically marks the token with hash as used by
+	// nodeID. It returns nil if the token does not exist, is used, expired
+	// at now, or is bound to another node.
+	ConsumeEnrollmentToken(ctx context.Context, hash, nodeID string, now time.Time) (*EnrollmentToken, error)
+}
+
+// NodeCertificate is a certificate the hub CA issued to a node, or a
+// revoked certificate of another origin.
+type NodeCertificate struct {
+	Serial    string // hex
+	NodeID    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	RevokedAt time.Time // zero while valid
+}
+
+// CertificateRepository persists issued node certificates and the revocation list.
+type CertificateRepository interface {
+	// SaveCertificate inserts cert or replaces the record with the same serial.
+	// A revocation already recorded for the serial is kept.
+	SaveCertificate(ctx context.Context, cert *NodeCertificate) error
+	// ListCertificates returns certificates of nodeID, or of all nodes when nodeID is empty, newest first.
+	ListCertificates(ctx context.Context, nodeID string) ([]*NodeCertificate, error)
+	// RevokeCertificate marks the certificate with serial revoked at the given time.
+	RevokeCertificate(ctx context.Context, serial string, at time.Time) error
+}
+
+// Cluster is a compose stack deployed to a set of nodes; it is the desired
+// state the hub keeps them in.
+type Cluster struct {
+	ID        string
+	Name      string
+	StackName string
+	Content   string
+	Nodes     []string
+	Selector  string // nodes were resolved from it, if set
+	Revision  int    // revision Content belongs to; 0 before the first one
+	Sync      map[string]NodeSync
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Per-node sync states of a cluster.
+const (
+	SyncInSync    = "InSync"
+	SyncOutOfSync = "OutOfSync"
+	SyncFailed    = "Failed"
+)
+
+// NodeSync is the last reconciliation outcome of a cluster on one node.
+type NodeSync struct {
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+	Hash      string `json:"hash,omitempty"` // content applied last
+	CheckedAt int64  `json:"checked_at"`
+	SyncedAt  int64  `json:"synced_at,omitempty"` // last successful stack_up
+}
+
+// ClusterRevision is one content a cluster was deployed with. Revisions are
+// append-only; the outcome fields are set once the deploy finished.
+type ClusterRevision struct {
+	ClusterID  string
+	Revision   int
+	Content    string
+	DeployedBy string
+	RollbackOf int // revision whose content was deployed again, if any
+	CreatedAt  time.Time
+
+	JobID   string
+	Status  string
+	Results []RevisionResult
+}
+
+// RevisionResult is the outcome of a revision's deploy on one node.
+type RevisionResult struct {
+	NodeID    string `json:"node_id"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ClusterRepository persists clusters and their revision history.
+type ClusterRepository interface {
+	ListClusters(ctx context.Context) ([]*Cluster, error)
+	GetCluster(ctx context.Context, id string) (*Cluster, error)
+	// SaveCluster inserts cluster or replaces the one with the same ID.
+	SaveCluster(ctx context.Context, cluster *Cluster) error
+	// DeleteCluster removes the cluster and its revisions.
+	DeleteCluster(ctx context.Context, id string) error
+
+	// AddClusterRevision stores rev as the next revision of rev.ClusterID,
+	// sets rev.Revision and makes it the current revision of the cluster.
+	AddClusterRevision(ctx context.Context, rev *ClusterRevision) error
+	// FinishClusterRevision stores JobID, Status and Results of rev.
+	FinishClusterRevision(ctx context.Context, rev *ClusterRevision) error
+	GetClusterRevision(ctx context.Context, clusterID string, revision int) (*ClusterRevision, error)
+	// ListClusterRevisions returns the revisions of clusterID, newest first.
+	ListClusterRevisions(ctx context.Context, clusterID string) ([]*ClusterRevision, error)
+}
+
+// Hub is a hub replica sharing the store with others.
+type Hub struct {
+	ID        string
+	Addr      string // gRPC address the other replicas reach it at
+	StartedAt time.Time
+	LastSeen  time.Time
+}
+
+// NodeSession records which hub replica holds the agent stream of a node.
+type NodeSession struct {
+	NodeID      string
+	SessionID   string
+	HubID       string
+	ConnectedAt time.Time
+}
+
+// HubRepository persists hub replicas and the agent sessions they hold.
+type HubRepository interface {
+	// UpsertHub inserts hub or updates the replica with the same ID.
+	UpsertHub(ctx context.Context, hub *Hub) error
+	ListHubs(ctx context.Context) ([]*Hub, error)
+
+	// SaveNodeSession makes session the session of its node, unless the
+	// node has a session that connected later.
+	SaveNodeSession(ctx context.Context, session *NodeSession) error
+	// DeleteNodeSession removes the session of nodeID if it is still sessionID.
+	DeleteNodeSession(ctx context.Context, nodeID, sessionID string) error
+	// DeleteHubSessions removes every session held by hubID.
+	DeleteHubSessions(ctx context.Context, hubID string) error
+	ListNodeSessions(ctx context.Context) ([]*NodeSession, error)
+
+	// AcquireClusterLease gives holder the lease named key until expires,
+	// unless another holder's lease has not expired yet; a holder extends
+	// its own lease the same way. It reports whether holder has the lease.
+	AcquireClusterLease(ctx context.Context, key, holder string, expires time.Time) (bool, error)
+	// ReleaseClusterLease drops the lease named key if holder has it.
+	ReleaseClusterLease(ctx context.Context, key, holder string) error
+}
+
+// ClusterLease keeps deploys and reconciliation of a cluster to one hub
+// replica at a time.
+type ClusterLease struct {
+	Key       string
+	Holder    string
+	ExpiresAt time.Time
+}
+
+// RegistryCredential is a login for an image registry. Secret is the
+// password or token sealed by the hub; stores never see it in clear.
+type RegistryCredential struct {
+	ID        string
+	Host      string // e.g. "ghcr.io" or "harbor.example.com:8443"
+	Username  string
+	Secret    []byte
+	CreatedBy string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// RegistryRepository persists registry credentials, at most one per host.
+type RegistryRepository interface {
+	// SaveRegistry inserts cred or replaces the one with the same ID.
+	SaveRegistry(ctx context.Context, cred *RegistryCredential) error
+	GetRegistry(ctx context.Context, id string) (*RegistryCredential, error)
+	// ListRegistries returns all credentials sorted by host.
+	ListRegistries(ctx context.Context) ([]*RegistryCredential, error)
+	DeleteRegistry(ctx context.Context, id string) error
 }
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// DefaultRegistry is the host of images without a registry in their name.
+const DefaultRegistry = "docker.io"
+
+// RegistryHost returns the registry an image reference pulls from, e.g.
+// "ghcr.io" for "ghcr.io/org/app:1.0" and "docker.io" for "nginx".
+func RegistryHost(ref string) string {
+	named, err := reference.ParseNormalizedNamed(strings.TrimSpace(ref))
+	if err != nil {
+		return ""
+	}
+	return reference.Domain(named)
+}
+
+// NormalizeRegistryHost turns what users write for a registry, such as
+// "https://ghcr.io/" or "index.docker.io", into the form RegistryHost returns.
+func NormalizeRegistryHost(host string) string {
+	host = strings.TrimSpace(strings.ToLower(host))
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return DefaultRegistry
+	}
+	return host
+}
@@ -70,6 +70,13 @@ function App() {
     }
 
     const handleLogout = () => {
+        if (token) {
+            // Revoke the session server-side; ignore failures (e.g. already expired)
+            fetch('/api/logout', {
+                method: 'POST',
+                headers: { 'Authorization': `Bearer ${token}` }
+            }).catch(() => {})
+        }
         localStorage.removeItem('docklet_token')
         setToken(null)
         setNodes([])
@@ -1098,6 +1105,25 @@ function App() {
                                                             </button>
                                                         </div>
                                                     </div>
+                                                    {c.sync && Object.keys(c.sync).length > 0 && (
+                                                        <div className="mt-2 flex flex-wrap gap-1">
+                                                            {Object.entries(c.sync).map(([nodeId, st]) => (
+                                                                <span
+                                                                    key={nodeId}
+                                                                    title={st.message || st.status}
+                                                                    className={`px-1.5 py-0.5 rounded text-[10px] border ${
+                                                                        st.status === 'InSync'
+                                                                            ? 'bg-green-900/30 text-green-300 border-green-900/50'
+                                                                            : st.status === 'Failed'
+                                                                            ? 'bg-red-900/30 text-red-300 border-red-900/50'
+                                                                            : 'bg-yellow-900/30 text-yellow-300 border-yellow-900/50'
+                                                                    }`}
+                                                                >
+                                                                    {nodeId.slice(0, 8)}: {st.status}
+                                                                </span>
+                                                            ))}
+                                                        </div>
+                                                    )}
                                                     {clusterRenameId === c.id && (
                                                         <div className="mt-3 flex gap-2">
                                                             <input